@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// An Annotation is free text set by an operator on this node (for
+// example "migrating to new rack, expect latency"), so that anyone
+// looking at the node's status or logs knows what's going on.
+type Annotation struct {
+	Text string
+	Set  time.Time
+}
+
+func (a *Annotation) String() string {
+	return fmt.Sprintf("'%s' (set %v ago)", a.Text, time.Now().Sub(a.Set))
+}
+
+var annotation struct {
+	sync.RWMutex
+	current *Annotation
+}
+
+// SetAnnotation replaces the current annotation. An empty text clears
+// the annotation.
+func SetAnnotation(text string) {
+	annotation.Lock()
+	defer annotation.Unlock()
+	if text == "" {
+		if annotation.current != nil {
+			log.Printf("Annotation cleared (was %v)", annotation.current)
+			annotation.current = nil
+		}
+		return
+	}
+	annotation.current = &Annotation{Text: text, Set: time.Now()}
+	log.Printf("Annotation set: '%s'", text)
+}
+
+// CurrentAnnotation returns the current annotation, or nil if there
+// is none.
+func CurrentAnnotation() *Annotation {
+	annotation.RLock()
+	defer annotation.RUnlock()
+	return annotation.current
+}
+
+func AnnotationStatus(sc *StatusConsumer) {
+	if a := CurrentAnnotation(); a != nil {
+		sc.Emit(fmt.Sprintf("Annotation: %v", a))
+	}
+	sc.Join()
+}
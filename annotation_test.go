@@ -0,0 +1,29 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestAnnotation(t *testing.T) {
+	SetAnnotation("")
+	if a := CurrentAnnotation(); a != nil {
+		t.Fatalf("Expected no annotation; got %v", a)
+	}
+
+	SetAnnotation("migrating to new rack")
+	a := CurrentAnnotation()
+	if a == nil || a.Text != "migrating to new rack" {
+		t.Fatalf("Expected annotation to be set; got %v", a)
+	}
+
+	// Replacing the annotation restarts its clock.
+	SetAnnotation("expect latency")
+	if b := CurrentAnnotation(); b == nil || b.Text != "expect latency" || b.Set.Before(a.Set) {
+		t.Fatalf("Expected annotation to be replaced; got %v", b)
+	}
+
+	SetAnnotation("")
+	if a := CurrentAnnotation(); a != nil {
+		t.Fatalf("Expected annotation to be cleared; got %v", a)
+	}
+}
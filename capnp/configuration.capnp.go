@@ -20,9 +20,9 @@ const (
 	CONFIGURATION_STABLE          Configuration_Which = 1
 )
 
-func NewConfiguration(s *C.Segment) Configuration      { return Configuration(s.NewStruct(24, 14)) }
-func NewRootConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewRootStruct(24, 14)) }
-func AutoNewConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewStructAR(24, 14)) }
+func NewConfiguration(s *C.Segment) Configuration      { return Configuration(s.NewStruct(24, 16)) }
+func NewRootConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewRootStruct(24, 16)) }
+func AutoNewConfiguration(s *C.Segment) Configuration  { return Configuration(s.NewStructAR(24, 16)) }
 func ReadRootConfiguration(s *C.Segment) Configuration { return Configuration(s.Root(0).ToStruct()) }
 func (s Configuration) Which() Configuration_Which     { return Configuration_Which(C.Struct(s).Get16(16)) }
 func (s Configuration) ClusterId() string              { return C.Struct(s).GetObject(0).ToText() }
@@ -107,6 +107,14 @@ func (s ConfigurationTransitioningTo) SetPending(v ConditionPair_List) {
 	C.Struct(s).SetObject(13, C.Object(v))
 }
 func (s Configuration) SetStable() { C.Struct(s).Set16(16, 1) }
+func (s Configuration) MaxTxnFanOut() uint16      { return C.Struct(s).Get16(18) }
+func (s Configuration) SetMaxTxnFanOut(v uint16)  { C.Struct(s).Set16(18, v) }
+func (s Configuration) MaxResubmits() uint16      { return C.Struct(s).Get16(20) }
+func (s Configuration) SetMaxResubmits(v uint16)  { C.Struct(s).Set16(20, v) }
+func (s Configuration) Zones() C.TextList         { return C.TextList(C.Struct(s).GetObject(14)) }
+func (s Configuration) SetZones(v C.TextList)     { C.Struct(s).SetObject(14, C.Object(v)) }
+func (s Configuration) Witnesses() C.TextList     { return C.TextList(C.Struct(s).GetObject(15)) }
+func (s Configuration) SetWitnesses(v C.TextList) { C.Struct(s).SetObject(15, C.Object(v)) }
 func (s Configuration) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
 	var err error
@@ -720,6 +728,118 @@ func (s Configuration) WriteJSON(w io.Writer) error {
 			return err
 		}
 	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"maxTxnFanOut\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.MaxTxnFanOut()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"maxResubmits\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.MaxResubmits()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"zones\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Zones()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"witnesses\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Witnesses()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte('}')
 	if err != nil {
 		return err
@@ -1345,6 +1465,118 @@ func (s Configuration) WriteCapLit(w io.Writer) error {
 			return err
 		}
 	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("maxTxnFanOut = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.MaxTxnFanOut()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("maxResubmits = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.MaxResubmits()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("zones = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Zones()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("witnesses = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Witnesses()
+		{
+			err = b.WriteByte('[')
+			if err != nil {
+				return err
+			}
+			for i, s := range s.ToArray() {
+				if i != 0 {
+					_, err = b.WriteString(", ")
+				}
+				if err != nil {
+					return err
+				}
+				buf, err = json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				_, err = b.Write(buf)
+				if err != nil {
+					return err
+				}
+			}
+			err = b.WriteByte(']')
+		}
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte(')')
 	if err != nil {
 		return err
@@ -1361,7 +1593,7 @@ func (s Configuration) MarshalCapLit() ([]byte, error) {
 type Configuration_List C.PointerList
 
 func NewConfigurationList(s *C.Segment, sz int) Configuration_List {
-	return Configuration_List(s.NewCompositeList(24, 14, sz))
+	return Configuration_List(s.NewCompositeList(24, 15, sz))
 }
 func (s Configuration_List) Len() int { return C.PointerList(s).Len() }
 func (s Configuration_List) At(i int) Configuration {
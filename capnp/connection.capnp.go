@@ -42,6 +42,8 @@ func (s HelloServerFromServer) ClusterIdBytes() []byte {
 func (s HelloServerFromServer) SetClusterId(v string)   { C.Struct(s).SetObject(1, s.Segment.NewText(v)) }
 func (s HelloServerFromServer) ClusterUUId() uint64     { return C.Struct(s).Get64(16) }
 func (s HelloServerFromServer) SetClusterUUId(v uint64) { C.Struct(s).Set64(16, v) }
+func (s HelloServerFromServer) Capabilities() uint32     { return C.Struct(s).Get32(12) }
+func (s HelloServerFromServer) SetCapabilities(v uint32) { C.Struct(s).Set32(12, v) }
 func (s HelloServerFromServer) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
 	var err error
@@ -161,6 +163,25 @@ func (s HelloServerFromServer) WriteJSON(w io.Writer) error {
 			return err
 		}
 	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"capabilities\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Capabilities()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte('}')
 	if err != nil {
 		return err
@@ -292,6 +313,25 @@ func (s HelloServerFromServer) WriteCapLit(w io.Writer) error {
 			return err
 		}
 	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("capabilities = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Capabilities()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte(')')
 	if err != nil {
 		return err
@@ -346,6 +386,10 @@ const (
 	MESSAGE_TOPOLOGYCHANGEREQUEST Message_Which = 13
 	MESSAGE_MIGRATION             Message_Which = 14
 	MESSAGE_MIGRATIONCOMPLETE     Message_Which = 15
+	MESSAGE_MIGRATIONBATCHACK     Message_Which = 16
+	MESSAGE_LEARNERONLY           Message_Which = 17
+	MESSAGE_COMPRESSED            Message_Which = 18
+	MESSAGE_HEALTH                Message_Which = 19
 )
 
 func NewMessage(s *C.Segment) Message          { return Message(s.NewStruct(8, 1)) }
@@ -438,6 +482,28 @@ func (s Message) SetMigrationComplete(v MigrationComplete) {
 	C.Struct(s).Set16(0, 15)
 	C.Struct(s).SetObject(0, C.Object(v))
 }
+func (s Message) MigrationBatchAck() MigrationBatchAck {
+	return MigrationBatchAck(C.Struct(s).GetObject(0).ToStruct())
+}
+func (s Message) SetMigrationBatchAck(v MigrationBatchAck) {
+	C.Struct(s).Set16(0, 16)
+	C.Struct(s).SetObject(0, C.Object(v))
+}
+func (s Message) LearnerOnly() bool { return C.Struct(s).Get1(16) }
+func (s Message) SetLearnerOnly(v bool) {
+	C.Struct(s).Set16(0, 17)
+	C.Struct(s).Set1(16, v)
+}
+func (s Message) Compressed() []byte { return C.Struct(s).GetObject(0).ToData() }
+func (s Message) SetCompressed(v []byte) {
+	C.Struct(s).Set16(0, 18)
+	C.Struct(s).SetObject(0, s.Segment.NewData(v))
+}
+func (s Message) Health() uint8 { return C.Struct(s).Get8(2) }
+func (s Message) SetHealth(v uint8) {
+	C.Struct(s).Set16(0, 19)
+	C.Struct(s).Set8(2, v)
+}
 func (s Message) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
 	var err error
@@ -659,6 +725,70 @@ func (s Message) WriteJSON(w io.Writer) error {
 			}
 		}
 	}
+	if s.Which() == MESSAGE_MIGRATIONBATCHACK {
+		_, err = b.WriteString("\"migrationBatchAck\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.MigrationBatchAck()
+			err = s.WriteJSON(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_LEARNERONLY {
+		_, err = b.WriteString("\"learnerOnly\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.LearnerOnly()
+			buf, err = json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			_, err = b.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_COMPRESSED {
+		_, err = b.WriteString("\"compressed\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.Compressed()
+			buf, err = json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			_, err = b.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_HEALTH {
+		_, err = b.WriteString("\"health\":")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.Health()
+			buf, err = json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			_, err = b.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	err = b.WriteByte('}')
 	if err != nil {
 		return err
@@ -892,6 +1022,70 @@ func (s Message) WriteCapLit(w io.Writer) error {
 			}
 		}
 	}
+	if s.Which() == MESSAGE_MIGRATIONBATCHACK {
+		_, err = b.WriteString("migrationBatchAck = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.MigrationBatchAck()
+			err = s.WriteCapLit(b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_LEARNERONLY {
+		_, err = b.WriteString("learnerOnly = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.LearnerOnly()
+			buf, err = json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			_, err = b.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_COMPRESSED {
+		_, err = b.WriteString("compressed = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.Compressed()
+			buf, err = json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			_, err = b.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.Which() == MESSAGE_HEALTH {
+		_, err = b.WriteString("health = ")
+		if err != nil {
+			return err
+		}
+		{
+			s := s.Health()
+			buf, err = json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			_, err = b.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	err = b.WriteByte(')')
 	if err != nil {
 		return err
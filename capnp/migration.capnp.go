@@ -286,6 +286,114 @@ func (s MigrationComplete_List) Set(i int, item MigrationComplete) {
 	C.PointerList(s).Set(i, C.Object(item))
 }
 
+type MigrationBatchAck C.Struct
+
+func NewMigrationBatchAck(s *C.Segment) MigrationBatchAck { return MigrationBatchAck(s.NewStruct(8, 0)) }
+func NewRootMigrationBatchAck(s *C.Segment) MigrationBatchAck {
+	return MigrationBatchAck(s.NewRootStruct(8, 0))
+}
+func AutoNewMigrationBatchAck(s *C.Segment) MigrationBatchAck {
+	return MigrationBatchAck(s.NewStructAR(8, 0))
+}
+func ReadRootMigrationBatchAck(s *C.Segment) MigrationBatchAck {
+	return MigrationBatchAck(s.Root(0).ToStruct())
+}
+func (s MigrationBatchAck) Version() uint32     { return C.Struct(s).Get32(0) }
+func (s MigrationBatchAck) SetVersion(v uint32) { C.Struct(s).Set32(0, v) }
+func (s MigrationBatchAck) WriteJSON(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('{')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"version\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte('}')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s MigrationBatchAck) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteJSON(&b)
+	return b.Bytes(), err
+}
+func (s MigrationBatchAck) WriteCapLit(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	var err error
+	var buf []byte
+	_ = buf
+	err = b.WriteByte('(')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("version = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.Version()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(')')
+	if err != nil {
+		return err
+	}
+	err = b.Flush()
+	return err
+}
+func (s MigrationBatchAck) MarshalCapLit() ([]byte, error) {
+	b := bytes.Buffer{}
+	err := s.WriteCapLit(&b)
+	return b.Bytes(), err
+}
+
+type MigrationBatchAck_List C.PointerList
+
+func NewMigrationBatchAckList(s *C.Segment, sz int) MigrationBatchAck_List {
+	return MigrationBatchAck_List(s.NewCompositeList(8, 0, sz))
+}
+func (s MigrationBatchAck_List) Len() int { return C.PointerList(s).Len() }
+func (s MigrationBatchAck_List) At(i int) MigrationBatchAck {
+	return MigrationBatchAck(C.PointerList(s).At(i).ToStruct())
+}
+func (s MigrationBatchAck_List) ToArray() []MigrationBatchAck {
+	n := s.Len()
+	a := make([]MigrationBatchAck, n)
+	for i := 0; i < n; i++ {
+		a[i] = s.At(i)
+	}
+	return a
+}
+func (s MigrationBatchAck_List) Set(i int, item MigrationBatchAck) {
+	C.PointerList(s).Set(i, C.Object(item))
+}
+
 type MigrationElement C.Struct
 
 func NewMigrationElement(s *C.Segment) MigrationElement { return MigrationElement(s.NewStruct(0, 2)) }
@@ -12,9 +12,9 @@ import (
 
 type Txn C.Struct
 
-func NewTxn(s *C.Segment) Txn                  { return Txn(s.NewStruct(16, 3)) }
-func NewRootTxn(s *C.Segment) Txn              { return Txn(s.NewRootStruct(16, 3)) }
-func AutoNewTxn(s *C.Segment) Txn              { return Txn(s.NewStructAR(16, 3)) }
+func NewTxn(s *C.Segment) Txn                  { return Txn(s.NewStruct(24, 4)) }
+func NewRootTxn(s *C.Segment) Txn              { return Txn(s.NewRootStruct(24, 4)) }
+func AutoNewTxn(s *C.Segment) Txn              { return Txn(s.NewStructAR(24, 4)) }
 func ReadRootTxn(s *C.Segment) Txn             { return Txn(s.Root(0).ToStruct()) }
 func (s Txn) Id() []byte                       { return C.Struct(s).GetObject(0).ToData() }
 func (s Txn) SetId(v []byte)                   { C.Struct(s).SetObject(0, s.Segment.NewData(v)) }
@@ -32,6 +32,10 @@ func (s Txn) FInc() uint8                      { return C.Struct(s).Get8(9) }
 func (s Txn) SetFInc(v uint8)                  { C.Struct(s).Set8(9, v) }
 func (s Txn) TopologyVersion() uint32          { return C.Struct(s).Get32(12) }
 func (s Txn) SetTopologyVersion(v uint32)      { C.Struct(s).Set32(12, v) }
+func (s Txn) ActionsChecksum() uint64          { return C.Struct(s).Get64(16) }
+func (s Txn) SetActionsChecksum(v uint64)      { C.Struct(s).Set64(16, v) }
+func (s Txn) TraceContext() []byte             { return C.Struct(s).GetObject(3).ToData() }
+func (s Txn) SetTraceContext(v []byte)         { C.Struct(s).SetObject(3, s.Segment.NewData(v)) }
 func (s Txn) WriteJSON(w io.Writer) error {
 	b := bufio.NewWriter(w)
 	var err error
@@ -203,6 +207,44 @@ func (s Txn) WriteJSON(w io.Writer) error {
 			return err
 		}
 	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"actionsChecksum\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ActionsChecksum()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.WriteByte(',')
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("\"traceContext\":")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.TraceContext()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte('}')
 	if err != nil {
 		return err
@@ -386,6 +428,44 @@ func (s Txn) WriteCapLit(w io.Writer) error {
 			return err
 		}
 	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("actionsChecksum = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.ActionsChecksum()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = b.WriteString(", ")
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteString("traceContext = ")
+	if err != nil {
+		return err
+	}
+	{
+		s := s.TraceContext()
+		buf, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = b.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = b.WriteByte(')')
 	if err != nil {
 		return err
@@ -401,7 +481,7 @@ func (s Txn) MarshalCapLit() ([]byte, error) {
 
 type Txn_List C.PointerList
 
-func NewTxnList(s *C.Segment, sz int) Txn_List { return Txn_List(s.NewCompositeList(16, 3, sz)) }
+func NewTxnList(s *C.Segment, sz int) Txn_List { return Txn_List(s.NewCompositeList(24, 4, sz)) }
 func (s Txn_List) Len() int                    { return C.PointerList(s).Len() }
 func (s Txn_List) At(i int) Txn                { return Txn(C.PointerList(s).At(i).ToStruct()) }
 func (s Txn_List) ToArray() []Txn {
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
@@ -8,31 +10,52 @@ import (
 	cmsgs "goshawkdb.io/common/capnp"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/db"
 	"goshawkdb.io/server/paxos"
 	eng "goshawkdb.io/server/txnengine"
+	"time"
 )
 
 type ClientTxnCompletionConsumer func(*cmsgs.ClientTxnOutcome, error) error
 
+// A LocalReader reads vUUIds from this RM's copies of them (see
+// eng.VarDispatcher.LocalReads), and passes the reads to callback on
+// the go-routine of the submitter.
+type LocalReader func(vUUIds []*common.VarUUId, callback func([]*eng.LocalRead) error)
+
 type ClientTxnSubmitter struct {
 	*SimpleTxnSubmitter
 	versionCache versionCache
 	txnLive      bool
 	backoff      *server.BinaryBackoffEngine
+	outcomes     *OutcomeRecorder
+	localReader  LocalReader
+	fingerprint  [sha256.Size]byte
+	namespace    []byte
+	txnCount     uint64
+	totalUsage   TxnResourceUsage
 }
 
-func NewClientTxnSubmitter(rmId common.RMId, bootCount uint32, roots map[common.VarUUId]*common.Capability, cm paxos.ConnectionManager) *ClientTxnSubmitter {
+// fingerprint is that of the client's certificate, and namespace is
+// the namespace the client was given for its TxnIds. Read-only txns
+// are served from the reads of localReader when they're current.
+func NewClientTxnSubmitter(rmId common.RMId, bootCount uint32, roots map[common.VarUUId]*common.Capability, cm paxos.ConnectionManager, outcomes *OutcomeRecorder, localReader LocalReader, fingerprint [sha256.Size]byte, namespace []byte) *ClientTxnSubmitter {
 	sts := NewSimpleTxnSubmitter(rmId, bootCount, cm)
 	return &ClientTxnSubmitter{
 		SimpleTxnSubmitter: sts,
 		versionCache:       NewVersionCache(roots),
 		txnLive:            false,
 		backoff:            server.NewBinaryBackoffEngine(sts.rng, server.SubmissionMinSubmitDelay, server.SubmissionMaxSubmitDelay),
+		outcomes:           outcomes,
+		localReader:        localReader,
+		fingerprint:        fingerprint,
+		namespace:          namespace,
 	}
 }
 
 func (cts *ClientTxnSubmitter) Status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("ClientTxnSubmitter: txnLive? %v", cts.txnLive))
+	sc.Emit(fmt.Sprintf("ClientTxnSubmitter: %v txns completed; total usage: %v", cts.txnCount, &cts.totalUsage))
 	cts.SimpleTxnSubmitter.Status(sc.Fork())
 	sc.Join()
 }
@@ -51,7 +74,31 @@ func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 	clientOutcome.SetId(ctxnCap.Id())
 
 	curTxnId := common.MakeTxnId(ctxnCap.Id())
+	origTxnId := common.MakeTxnId(ctxnCap.Id())
+	key := db.MakeClientTxnKey(cts.fingerprint, origTxnId)
+	// A resubmission of a txn which has already committed: most
+	// likely the client lost its connection before the outcome
+	// reached it.
+	if finalTxnId, found := cts.outcomes.Lookup(key); found {
+		debugLog.Log(origTxnId, "Resubmission of committed txn; final id:", finalTxnId)
+		clientOutcome.SetFinalId(finalTxnId[:])
+		clientOutcome.SetCommit()
+		return continuation(&clientOutcome, nil)
+	} else if cts.outcomes.InFlight(key) {
+		return continuation(nil, fmt.Errorf("Txn %v is still in flight; resubmit it once it has finished", origTxnId))
+	}
+	if !bytes.Equal(curTxnId[8:], cts.namespace) {
+		// A resubmission from an earlier connection. Outcomes are
+		// routed to connections by the namespace of the TxnId, so it
+		// must be submitted in our namespace. Client TxnId counters
+		// never reach the top bit, so setting it keeps the TxnId
+		// distinct from those the client allocates itself.
+		copy(curTxnId[8:], cts.namespace)
+		curTxnId[0] |= 0x80
+	}
+
 	cts.backoff.Shrink(server.SubmissionMinSubmitDelay)
+	usage := newTxnResourceUsage()
 
 	var cont TxnCompletionConsumer
 	cont = func(txn *eng.TxnReader, outcome *msgs.Outcome, err error) error {
@@ -59,6 +106,9 @@ func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 			cts.txnLive = false
 			return continuation(nil, err)
 		}
+		start := time.Now()
+		usage.Submissions++
+		usage.TxnBytes += uint64(len(txn.Data))
 		txnId := txn.Id
 		switch outcome.Which() {
 		case msgs.OUTCOME_COMMIT:
@@ -67,6 +117,8 @@ func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 			clientOutcome.SetCommit()
 			cts.addCreatesToCache(txn)
 			cts.txnLive = false
+			usage.ExecutorTime += time.Now().Sub(start)
+			cts.txnFinished(txn, usage)
 			return continuation(&clientOutcome, nil)
 
 		default:
@@ -75,16 +127,29 @@ func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 			if !resubmit {
 				updates := abort.Rerun()
 				validUpdates := cts.versionCache.UpdateFromAbort(&updates)
-				server.Log("Updates:", updates.Len(), "; valid: ", len(validUpdates))
+				debugLog.Log("Updates:", updates.Len(), "; valid: ", len(validUpdates))
 				resubmit = len(validUpdates) == 0
 				if !resubmit {
 					clientOutcome.SetFinalId(txnId[:])
 					clientOutcome.SetAbort(cts.translateUpdates(seg, validUpdates))
 					cts.txnLive = false
+					usage.ExecutorTime += time.Now().Sub(start)
+					cts.txnFinished(txn, usage)
 					return continuation(&clientOutcome, nil)
 				}
 			}
-			server.Log("Resubmitting", txnId, "; orig resubmit?", abort.Which() == msgs.OUTCOMEABORT_RESUBMIT)
+			if limit := cts.topology.MaxResubmits; limit != 0 && usage.Submissions > uint32(limit) {
+				// Give up: an abort with no updates tells the client to
+				// rerun the txn itself.
+				debugLog.Log("Not resubmitting", txnId, "; resubmit limit reached:", limit)
+				clientOutcome.SetFinalId(txnId[:])
+				clientOutcome.SetAbort(cmsgs.NewClientUpdateList(seg, 0))
+				cts.txnLive = false
+				usage.ExecutorTime += time.Now().Sub(start)
+				cts.txnFinished(txn, usage)
+				return continuation(&clientOutcome, nil)
+			}
+			debugLog.Log("Resubmitting", txnId, "; orig resubmit?", abort.Which() == msgs.OUTCOMEABORT_RESUBMIT)
 
 			cts.backoff.Advance()
 			//fmt.Printf("%v ", cts.backoff.Cur)
@@ -98,13 +163,51 @@ func (cts *ClientTxnSubmitter) SubmitClientTransaction(ctxnCap *cmsgs.ClientTxn,
 			newCtxnCap.SetRetry(ctxnCap.Retry())
 			newCtxnCap.SetActions(ctxnCap.Actions())
 
-			return cts.SimpleTxnSubmitter.SubmitClientTransaction(nil, &newCtxnCap, curTxnId, cont, cts.backoff, false, cts.versionCache)
+			usage.ExecutorTime += time.Now().Sub(start)
+			cts.outcomes.Submitted(key, curTxnId)
+			return usage.timed(func() error {
+				return cts.SimpleTxnSubmitter.SubmitClientTransaction(nil, &newCtxnCap, curTxnId, cont, cts.backoff, false, cts.versionCache)
+			})
 		}
 	}
 
-	cts.txnLive = true
-	// fmt.Printf("%v ", delay)
-	return cts.SimpleTxnSubmitter.SubmitClientTransaction(nil, ctxnCap, curTxnId, cont, cts.backoff, false, cts.versionCache)
+	submit := func() error {
+		cts.txnLive = true
+		cts.outcomes.Submitted(key, curTxnId)
+		// fmt.Printf("%v ", delay)
+		return usage.timed(func() error {
+			return cts.SimpleTxnSubmitter.SubmitClientTransaction(nil, ctxnCap, curTxnId, cont, cts.backoff, false, cts.versionCache)
+		})
+	}
+
+	if vUUIds := readOnlyVars(ctxnCap); vUUIds != nil && cts.localReader != nil {
+		// If this RM's copies of the vars are current, and at the
+		// versions the client read, the txn can commit without
+		// consensus, with Serializable isolation. Otherwise it's
+		// submitted as normal: that brings the client up to date if
+		// it's behind, and reads the vars this RM doesn't hold.
+		cts.txnLive = true
+		cts.localReader(vUUIds, func(reads []*eng.LocalRead) error {
+			if !localReadsCurrent(ctxnCap, reads) {
+				return submit()
+			}
+			debugLog.Log(curTxnId, "Read-only txn served from local reads")
+			cts.txnLive = false
+			clientOutcome.SetFinalId(curTxnId[:])
+			clientOutcome.SetCommit()
+			return continuation(&clientOutcome, nil)
+		})
+		return nil
+	}
+	return submit()
+}
+
+func (cts *ClientTxnSubmitter) txnFinished(txn *eng.TxnReader, usage *TxnResourceUsage) {
+	usage.VarsTouched = uint32(txn.Actions(true).Actions().Len())
+	usage.finished()
+	cts.txnCount++
+	cts.totalUsage.add(usage)
+	debugLog.Log(txn.Id, "Client txn resource usage:", usage)
 }
 
 func (cts *ClientTxnSubmitter) addCreatesToCache(txn *eng.TxnReader) {
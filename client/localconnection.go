@@ -15,6 +15,8 @@ import (
 	"sync"
 )
 
+var debugLog = server.NewSubsystem("client")
+
 type LocalConnection struct {
 	sync.Mutex
 	cellTail          *cc.ChanCellTail
@@ -161,7 +163,7 @@ func (lc *LocalConnection) Status(sc *server.StatusConsumer) {
 }
 
 func (lc *LocalConnection) SubmissionOutcomeReceived(sender common.RMId, txn *eng.TxnReader, outcome *msgs.Outcome) {
-	server.Log("LC Received submission outcome for", txn.Id)
+	debugLog.Log("LC Received submission outcome for", txn.Id)
 	lc.enqueueQuery(localConnectionMsgOutcomeReceived{
 		sender:  sender,
 		txn:     txn,
@@ -335,7 +337,7 @@ func (lc *LocalConnection) runClientTransaction(txnQuery *localConnectionMsgRunC
 	txn := txnQuery.txn
 	txnId := lc.getNextTxnId()
 	txn.SetId(txnId[:])
-	server.Log("LC starting client txn", txnId)
+	debugLog.Log("LC starting client txn", txnId)
 	if varPosMap := txnQuery.varPosMap; varPosMap != nil {
 		lc.submitter.EnsurePositions(varPosMap)
 	}
@@ -348,12 +350,21 @@ func (lc *LocalConnection) runTransaction(txnQuery *localConnectionMsgRunTxn) {
 	if txnId == nil {
 		txnId = lc.getNextTxnId()
 		txn.SetId(txnId[:])
-		server.Log("LC starting txn", txnId)
+		debugLog.Log("LC starting txn", txnId)
 	}
 	lc.submitter.SubmitTransaction(txn, txnId, txnQuery.activeRMs, txnQuery.consumer, txnQuery.backoff)
 }
 
+// NextTxnId returns a TxnId which no txn submitted through this
+// connection will ever have, for use as, for example, the id of a var
+// subscription.
+func (lc *LocalConnection) NextTxnId() *common.TxnId {
+	return lc.getNextTxnId()
+}
+
 func (lc *LocalConnection) getNextTxnId() *common.TxnId {
+	lc.Lock()
+	defer lc.Unlock()
 	txnId := common.MakeTxnId(lc.namespace)
 	binary.BigEndian.PutUint64(txnId[0:8], lc.nextTxnNumber)
 	lc.nextTxnNumber++
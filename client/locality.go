@@ -0,0 +1,46 @@
+package client
+
+import (
+	"fmt"
+	"goshawkdb.io/common"
+)
+
+// orderByZone orders the active RMs of a txn by locality. The first
+// 2F+1 allocations of a txn are its acceptors, so this decides where
+// the txn's votes are recorded. Up to F+1 RMs from the submitter's
+// own zone go first, so that a quorum of acceptors can be reached
+// without leaving the zone. The remaining RMs follow, taken from each
+// zone in turn, so that the other acceptors are spread across as
+// many failure domains as possible. RMs with no zone are treated as
+// being in a zone of their own.
+func orderByZone(rmIds []common.RMId, zones map[common.RMId]string, localZone string, fInc int) []common.RMId {
+	if len(zones) == 0 || len(rmIds) < 2 {
+		return rmIds
+	}
+	ordered := make([]common.RMId, 0, len(rmIds))
+	zoneOrder := []string{}
+	byZone := make(map[string][]common.RMId)
+	for _, rmId := range rmIds {
+		zone, found := zones[rmId]
+		if !found {
+			zone = fmt.Sprintf("\x00%v", rmId)
+		}
+		if zone == localZone && len(ordered) < fInc {
+			ordered = append(ordered, rmId)
+			continue
+		}
+		if _, found := byZone[zone]; !found {
+			zoneOrder = append(zoneOrder, zone)
+		}
+		byZone[zone] = append(byZone[zone], rmId)
+	}
+	for len(ordered) < len(rmIds) {
+		for _, zone := range zoneOrder {
+			if rmIdsInZone := byZone[zone]; len(rmIdsInZone) != 0 {
+				ordered = append(ordered, rmIdsInZone[0])
+				byZone[zone] = rmIdsInZone[1:]
+			}
+		}
+	}
+	return ordered
+}
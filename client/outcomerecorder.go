@@ -0,0 +1,169 @@
+package client
+
+import (
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
+	"sync"
+	"time"
+)
+
+// The OutcomeRecorder writes the commits of client txns to the
+// IdempotencyLog. It sees every submission outcome which reaches this
+// RM, whether or not the client connection which submitted the txn
+// still exists, so a txn which commits after its client has gone is
+// still recorded. There is one per RM, shared by every client
+// connection; all methods are safe for concurrent use.
+type OutcomeRecorder struct {
+	lock sync.Mutex
+	log  *db.IdempotencyLog
+	// pending holds the client txns submitted whose outcomes are not
+	// yet known, by the TxnId they were submitted with.
+	pending map[common.TxnId]*pendingClientTxn
+	// inFlight maps each pending client txn's key to the TxnId of its
+	// most recent submission.
+	inFlight map[db.ClientTxnKey]*common.TxnId
+	// recording holds, for each commit being written, the functions
+	// waiting for the write to finish.
+	recording map[common.TxnId][]func(error)
+}
+
+type pendingClientTxn struct {
+	key         *db.ClientTxnKey
+	submitted   time.Time
+	accumulator *paxos.OutcomeAccumulator
+}
+
+func NewOutcomeRecorder(log *db.IdempotencyLog) *OutcomeRecorder {
+	return &OutcomeRecorder{
+		log:       log,
+		pending:   make(map[common.TxnId]*pendingClientTxn),
+		inFlight:  make(map[db.ClientTxnKey]*common.TxnId),
+		recording: make(map[common.TxnId][]func(error)),
+	}
+}
+
+// Lookup returns the final TxnId of the client txn identified by key,
+// if it has committed and been recorded.
+func (rec *OutcomeRecorder) Lookup(key *db.ClientTxnKey) (*common.TxnId, bool) {
+	return rec.log.Lookup(key)
+}
+
+// LookupTxnId returns the final TxnId of the client txn, of any
+// client, first submitted as txnId or which committed as txnId, if
+// it has committed and been recorded.
+func (rec *OutcomeRecorder) LookupTxnId(txnId *common.TxnId) (*common.TxnId, bool) {
+	return rec.log.LookupTxnId(txnId)
+}
+
+// InFlight returns whether the client txn identified by key has been
+// submitted, and its outcome is not yet known.
+func (rec *OutcomeRecorder) InFlight(key *db.ClientTxnKey) bool {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	_, found := rec.inFlight[*key]
+	return found
+}
+
+// Submitted must be called before the client txn identified by key
+// is submitted (or resubmitted) as txnId.
+func (rec *OutcomeRecorder) Submitted(key *db.ClientTxnKey, txnId *common.TxnId) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if prev, found := rec.inFlight[*key]; found {
+		delete(rec.pending, *prev)
+	}
+	txnIdCopy := *txnId
+	rec.inFlight[*key] = &txnIdCopy
+	rec.pending[txnIdCopy] = &pendingClientTxn{key: key, submitted: time.Now()}
+}
+
+// OutcomeReceived must be called with every submission outcome which
+// reaches this RM, before it is passed to the client connection. Once
+// the acceptors agree on the outcome of a pending client txn which
+// committed, the commit is written to the IdempotencyLog.
+func (rec *OutcomeRecorder) OutcomeReceived(sender common.RMId, txn *eng.TxnReader, outcome *msgs.Outcome) {
+	txnId := txn.Id
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	p, found := rec.pending[*txnId]
+	if !found {
+		return
+	}
+	if p.accumulator == nil {
+		p.accumulator = paxos.NewOutcomeAccumulator(int(txn.Txn.FInc()), paxos.GetAcceptorsFromTxn(txn.Txn), debugLog.With("txn", txnId))
+	}
+	if outcome, _ = p.accumulator.BallotOutcomeReceived(sender, outcome); outcome == nil {
+		return
+	}
+	delete(rec.pending, *txnId)
+	if outcome.Which() != msgs.OUTCOME_COMMIT {
+		rec.settled(p.key, txnId)
+		return
+	}
+	// The txn stays in flight until the commit is in the log, so that
+	// a resubmission can't slip between the two and run it again.
+	rec.recording[*txnId] = []func(error){}
+	rec.log.Record(p.key, txnId, func(err error) {
+		rec.lock.Lock()
+		waiting := rec.recording[*txnId]
+		delete(rec.recording, *txnId)
+		rec.settled(p.key, txnId)
+		rec.lock.Unlock()
+		for _, fun := range waiting {
+			fun(err)
+		}
+	})
+}
+
+// settled forgets that the client txn identified by key is in
+// flight, unless it has since been resubmitted. It must be called
+// with the lock held.
+func (rec *OutcomeRecorder) settled(key *db.ClientTxnKey, txnId *common.TxnId) {
+	if cur, found := rec.inFlight[*key]; found && cur.Compare(txnId) == common.EQ {
+		delete(rec.inFlight, *key)
+	}
+}
+
+// AwaitRecorded calls fun once the commit of txnId has been written
+// to the IdempotencyLog, or has failed to be. If the commit of txnId
+// is not being written, fun is called straight away, with nil. fun
+// may be called from another go-routine.
+func (rec *OutcomeRecorder) AwaitRecorded(txnId *common.TxnId, fun func(error)) {
+	rec.lock.Lock()
+	if waiting, found := rec.recording[*txnId]; found {
+		rec.recording[*txnId] = append(waiting, fun)
+		rec.lock.Unlock()
+		return
+	}
+	rec.lock.Unlock()
+	fun(nil)
+}
+
+// Expire forgets client txns submitted more than ClientOutcomeTTL ago
+// whose outcomes have still not arrived, and expires the
+// IdempotencyLog. It is intended to be run periodically.
+func (rec *OutcomeRecorder) Expire() {
+	horizon := time.Now().Add(-server.ClientOutcomeTTL)
+	rec.lock.Lock()
+	for txnId, p := range rec.pending {
+		if p.submitted.Before(horizon) {
+			delete(rec.pending, txnId)
+			rec.settled(p.key, &txnId)
+		}
+	}
+	rec.lock.Unlock()
+	rec.log.Expire()
+}
+
+func (rec *OutcomeRecorder) Status(sc *server.StatusConsumer) {
+	rec.lock.Lock()
+	sc.Emit(fmt.Sprintf("Client txns awaiting outcome: %v; commits being recorded: %v", len(rec.pending), len(rec.recording)))
+	rec.lock.Unlock()
+	rec.log.Status(sc.Fork())
+	sc.Join()
+}
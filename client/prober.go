@@ -0,0 +1,172 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	eng "goshawkdb.io/server/txnengine"
+	"log"
+	"sync"
+	"time"
+)
+
+// A Prober continuously verifies that txns submitted from this RM
+// can commit. Each probe writes the current time to a var owned by
+// this RM, and then reads it back at the version just written. Both
+// txns must commit for the probe to succeed. This catches routing or
+// consensus breakage between real client requests.
+//
+// The probe var is created by the first probe after each boot: the
+// positions of a var are only known to the RM that created it, and
+// are not persisted.
+type Prober struct {
+	lc        *LocalConnection
+	vUUId     *common.VarUUId
+	positions *common.Positions
+	version   *common.TxnId
+	lock      sync.Mutex
+	report    ProbeReport
+}
+
+// ProbeReport summarises the probes run so far.
+type ProbeReport struct {
+	Successes   uint64
+	Failures    uint64
+	LastRun     time.Time
+	LastLatency time.Duration
+	LastError   error
+}
+
+func (pr ProbeReport) String() string {
+	return fmt.Sprintf("successes: %v; failures: %v; last run: %v; last latency: %v; last error: %v",
+		pr.Successes, pr.Failures, pr.LastRun, pr.LastLatency, pr.LastError)
+}
+
+func NewProber(lc *LocalConnection) *Prober {
+	return &Prober{lc: lc}
+}
+
+// Probe runs a single probe. It is intended to be run as a scheduler
+// job, so it is never run concurrently with itself.
+func (p *Prober) Probe() {
+	start := time.Now()
+	err := p.probe(start)
+	elapsed := time.Now().Sub(start)
+	if err != nil {
+		log.Printf("Warning: Txn probe failed after %v: %v", elapsed, err)
+	} else {
+		debugLog.Log("Txn probe succeeded in", elapsed)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err == nil {
+		p.report.Successes++
+	} else {
+		p.report.Failures++
+	}
+	p.report.LastRun = start
+	p.report.LastLatency = elapsed
+	p.report.LastError = err
+}
+
+func (p *Prober) Report() ProbeReport {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.report
+}
+
+func (p *Prober) Status(sc *server.StatusConsumer) {
+	sc.Emit(fmt.Sprintf("Txn Probe: %v", p.Report()))
+	sc.Join()
+}
+
+func (p *Prober) probe(now time.Time) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(now.UnixNano()))
+
+	if p.vUUId == nil {
+		return p.create(value)
+	}
+
+	txn, err := p.run(func(action *cmsgs.ClientAction, seg *capn.Segment) {
+		action.SetWrite()
+		write := action.Write()
+		write.SetValue(value)
+		write.SetReferences(cmsgs.NewClientVarIdPosList(seg, 0))
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("write of %v: %v", p.vUUId, err)
+	}
+	p.version = txn.Id
+
+	_, err = p.run(func(action *cmsgs.ClientAction, seg *capn.Segment) {
+		action.SetRead()
+		action.Read().SetVersion(p.version[:])
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("read of %v at %v: %v", p.vUUId, p.version, err)
+	}
+	return nil
+}
+
+func (p *Prober) create(value []byte) error {
+	vUUId := p.lc.NextVarUUId()
+	var positions *common.Positions
+	p.vUUId = vUUId
+	txn, err := p.run(func(action *cmsgs.ClientAction, seg *capn.Segment) {
+		action.SetCreate()
+		create := action.Create()
+		create.SetValue(value)
+		create.SetReferences(cmsgs.NewClientVarIdPosList(seg, 0))
+	}, func(clientAction *cmsgs.ClientAction, action *msgs.Action, hashCodes []common.RMId, connections map[common.RMId]bool) error {
+		if action.Which() == msgs.ACTION_CREATE {
+			pos := common.Positions(action.Create().Positions())
+			positions = &pos
+		}
+		return nil
+	})
+	if err != nil || positions == nil {
+		p.vUUId = nil
+		if err == nil {
+			err = errors.New("no positions chosen")
+		}
+		return fmt.Errorf("creation of %v: %v", vUUId, err)
+	}
+	p.positions = positions
+	p.version = txn.Id
+	debugLog.Log("Txn probe created", vUUId)
+	return nil
+}
+
+func (p *Prober) run(setAction func(*cmsgs.ClientAction, *capn.Segment), translationCallback eng.TranslationCallback) (*eng.TxnReader, error) {
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	ctxn.SetRetry(false)
+	actions := cmsgs.NewClientActionList(seg, 1)
+	ctxn.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(p.vUUId[:])
+	setAction(&action, seg)
+
+	var varPosMap map[common.VarUUId]*common.Positions
+	if p.positions != nil {
+		varPosMap = map[common.VarUUId]*common.Positions{*p.vUUId: p.positions}
+	}
+	txn, outcome, err := p.lc.RunClientTransaction(&ctxn, varPosMap, translationCallback)
+	switch {
+	case err != nil:
+		return nil, err
+	case outcome == nil:
+		return nil, errors.New("Shutting down.")
+	case outcome.Which() != msgs.OUTCOME_COMMIT:
+		return nil, fmt.Errorf("txn %v aborted (%v)", txn.Id, outcome.Abort().Which())
+	default:
+		return txn, nil
+	}
+}
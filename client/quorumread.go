@@ -0,0 +1,141 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	msgs "goshawkdb.io/server/capnp"
+	eng "goshawkdb.io/server/txnengine"
+)
+
+// QuorumReadResult is the current state of a var as agreed by a
+// quorum of its acceptors.
+type QuorumReadResult struct {
+	VarUUId    *common.VarUUId
+	TxnId      *common.TxnId
+	ClockElem  uint64
+	Value      []byte
+	References []QuorumReadReference
+	// Missing is set if the voters know a write happened at TxnId,
+	// but not what was written; Value and References are then nil.
+	Missing bool
+	// Agreed lists the RMs whose votes formed the outcome.
+	Agreed     common.RMIds
+	references *msgs.VarIdPos_List
+}
+
+func (qrr *QuorumReadResult) String() string {
+	return fmt.Sprintf("%v@%v (clock elem: %v; value: %v bytes; references: %v; missing: %v; agreed by: %v)",
+		qrr.VarUUId, qrr.TxnId, qrr.ClockElem, len(qrr.Value), qrr.References, qrr.Missing, qrr.Agreed)
+}
+
+// A QuorumReadReference is a reference held by a var read by
+// QuorumRead.
+type QuorumReadReference struct {
+	VarUUId    *common.VarUUId
+	Positions  *common.Positions
+	Capability *common.Capability
+}
+
+var QuorumReadResubmit = errors.New("Quorum read was asked to resubmit; try again.")
+
+// QuorumRead performs a fresh read of vUUId, confirmed by a quorum of
+// the RMs which hold it. It ignores any version of vUUId this RM
+// knows of: it reads at version zero, so unless the var has never
+// been written, every voter votes BadRead, and the abort carries the
+// current value. positions must be vUUId's positions.
+func (lc *LocalConnection) QuorumRead(vUUId *common.VarUUId, positions *common.Positions) (*QuorumReadResult, error) {
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	ctxn.SetRetry(false)
+	actions := cmsgs.NewClientActionList(seg, 1)
+	ctxn.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(vUUId[:])
+	action.SetRead()
+	action.Read().SetVersion(common.VersionZero[:])
+
+	varPosMap := map[common.VarUUId]*common.Positions{*vUUId: positions}
+	txn, outcome, err := lc.RunClientTransaction(&ctxn, varPosMap, nil)
+	if err != nil {
+		return nil, err
+	} else if outcome == nil {
+		return nil, errors.New("Shutting down.")
+	}
+
+	result := &QuorumReadResult{VarUUId: vUUId}
+	ids := outcome.Id()
+	for idx, l := 0, ids.Len(); idx < l; idx++ {
+		instances := ids.At(idx).AcceptedInstances()
+		for idy, m := 0, instances.Len(); idy < m; idy++ {
+			result.Agreed = append(result.Agreed, common.RMId(instances.At(idy).RmId()))
+		}
+	}
+
+	switch outcome.Which() {
+	case msgs.OUTCOME_COMMIT:
+		// never written since creation.
+		result.TxnId = common.VersionZero
+		clock := eng.VectorClockFromData(outcome.Commit(), false)
+		result.ClockElem = clock.At(vUUId)
+		return result, nil
+
+	default:
+		abort := outcome.Abort()
+		if abort.Which() == msgs.OUTCOMEABORT_RESUBMIT {
+			return nil, QuorumReadResubmit
+		}
+		// Different voters may know of different versions: the most
+		// recent is the one with the greatest clock elem.
+		found := false
+		updates := abort.Rerun()
+		for idx, l := 0, updates.Len(); idx < l; idx++ {
+			update := updates.At(idx)
+			txnId := common.MakeTxnId(update.TxnId())
+			clockElem := eng.VectorClockFromData(update.Clock(), true).At(vUUId)
+			updateActions := eng.TxnActionsFromData(update.Actions(), true).Actions()
+			for idy, m := 0, updateActions.Len(); idy < m; idy++ {
+				updateAction := updateActions.At(idy)
+				if common.MakeVarUUId(updateAction.VarId()).Compare(vUUId) != common.EQ {
+					continue
+				}
+				if found && (clockElem < result.ClockElem || (clockElem == result.ClockElem && txnId.Compare(result.TxnId) != common.GT)) {
+					break
+				}
+				switch updateAction.Which() {
+				case msgs.ACTION_WRITE:
+					write := updateAction.Write()
+					refs := write.References()
+					result.Value = write.Value()
+					result.Missing = false
+					result.references = &refs
+					result.References = make([]QuorumReadReference, refs.Len())
+					for idz := range result.References {
+						ref := refs.At(idz)
+						positions := common.Positions(ref.Positions())
+						result.References[idz] = QuorumReadReference{
+							VarUUId:    common.MakeVarUUId(ref.Id()),
+							Positions:  &positions,
+							Capability: common.NewCapability(ref.Capability()),
+						}
+					}
+				case msgs.ACTION_MISSING:
+					result.Value, result.References, result.references = nil, nil, nil
+					result.Missing = true
+				default:
+					return nil, fmt.Errorf("Quorum read of %v: unexpected update action %v", vUUId, updateAction.Which())
+				}
+				found = true
+				result.TxnId = txnId
+				result.ClockElem = clockElem
+				break
+			}
+		}
+		if found {
+			return result, nil
+		}
+		return nil, fmt.Errorf("Quorum read of %v (txn %v): abort contained no update for the var", vUUId, txn.Id)
+	}
+}
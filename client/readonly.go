@@ -0,0 +1,182 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	eng "goshawkdb.io/server/txnengine"
+	"time"
+)
+
+var (
+	ReadOnlyConflict    = errors.New("Read-only txn conflicted with a write; try again.")
+	SnapshotUnavailable = errors.New("Unable to find a consistent snapshot of the vars; try again.")
+)
+
+// Isolation is the isolation a read-only txn is run with.
+type Isolation uint8
+
+const (
+	// Serializable reads are served from this RM's copies of the vars
+	// when they form a snapshot with no writes in progress (see
+	// eng.LocalReadsConsistent), and are confirmed through consensus
+	// otherwise. They may miss writes this RM was passive for, so they
+	// are not strictly serializable.
+	Serializable Isolation = iota
+	// Snapshot reads are served from this RM's copies of the vars,
+	// never through consensus. They are consistent with each other,
+	// but may miss recent writes, including those in progress.
+	Snapshot
+)
+
+// ParseIsolation returns the Isolation named str: "serializable" or
+// "snapshot".
+func ParseIsolation(str string) (Isolation, error) {
+	switch str {
+	case "serializable":
+		return Serializable, nil
+	case "snapshot":
+		return Snapshot, nil
+	default:
+		return Serializable, fmt.Errorf("Unknown isolation: %v", str)
+	}
+}
+
+// ReadOnly reads every var in varPosMap as a single read-only txn.
+// With Serializable isolation, if this RM's copies of the vars form a
+// consistent snapshot (see eng.LocalReadsConsistent) then they are
+// returned straight away, without running the txn through consensus.
+// Otherwise, any var of which this RM has no copy is fetched with a
+// QuorumRead, and then a txn reading every var at the version found
+// is submitted as normal. The reads are returned only if it commits.
+// If it aborts, some var has been written since, and ReadOnlyConflict
+// is returned.
+//
+// With Snapshot isolation, consensus is never used. Instead, the
+// local reads are retried (up to SnapshotReadAttempts times) until
+// they form a snapshot (see eng.LocalReadsSnapshot). If they never
+// do, SnapshotUnavailable is returned.
+func (lc *LocalConnection) ReadOnly(vd *eng.VarDispatcher, varPosMap map[common.VarUUId]*common.Positions, isolation Isolation) ([]*eng.LocalRead, error) {
+	vUUIds := make([]*common.VarUUId, 0, len(varPosMap))
+	for vUUId := range varPosMap {
+		vUUIdCopy := vUUId
+		vUUIds = append(vUUIds, &vUUIdCopy)
+	}
+	if isolation == Snapshot {
+		return lc.snapshotRead(vd, vUUIds)
+	}
+	reads, err := lc.localReads(vd, vUUIds)
+	if err != nil {
+		return nil, err
+	} else if eng.LocalReadsConsistent(reads) {
+		return reads, nil
+	}
+
+	for idx, read := range reads {
+		if read != nil && read.TxnId != nil {
+			continue
+		}
+		vUUId := vUUIds[idx]
+		fetched, err := lc.QuorumRead(vUUId, varPosMap[*vUUId])
+		if err != nil {
+			return nil, err
+		} else if fetched.Missing {
+			return nil, ReadOnlyConflict
+		}
+		reads[idx] = &eng.LocalRead{
+			VarUUId:    vUUId,
+			TxnId:      fetched.TxnId,
+			Value:      fetched.Value,
+			References: fetched.references,
+		}
+	}
+
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	ctxn.SetRetry(false)
+	actions := cmsgs.NewClientActionList(seg, len(reads))
+	ctxn.SetActions(actions)
+	for idx, read := range reads {
+		action := actions.At(idx)
+		action.SetVarId(read.VarUUId[:])
+		action.SetRead()
+		action.Read().SetVersion(read.TxnId[:])
+	}
+
+	_, outcome, err := lc.RunClientTransaction(&ctxn, varPosMap, nil)
+	if err != nil {
+		return nil, err
+	} else if outcome == nil {
+		return nil, errors.New("Shutting down.")
+	} else if outcome.Which() != msgs.OUTCOME_COMMIT {
+		return nil, ReadOnlyConflict
+	}
+	return reads, nil
+}
+
+// readOnlyVars returns the vars read by ctxn if it is not a retry and
+// every action in it is a read, and nil otherwise.
+func readOnlyVars(ctxn *cmsgs.ClientTxn) []*common.VarUUId {
+	if ctxn.Retry() {
+		return nil
+	}
+	actions := ctxn.Actions()
+	vUUIds := make([]*common.VarUUId, actions.Len())
+	for idx := range vUUIds {
+		action := actions.At(idx)
+		if action.Which() != cmsgs.CLIENTACTION_READ {
+			return nil
+		}
+		vUUIds[idx] = common.MakeVarUUId(action.VarId())
+	}
+	return vUUIds
+}
+
+// localReadsCurrent reports whether ctxn, a read-only txn, can be
+// committed without consensus given this RM's reads of its vars (in
+// the same order as its actions): they must be consistent (see
+// eng.LocalReadsConsistent), and at the versions ctxn read.
+func localReadsCurrent(ctxn *cmsgs.ClientTxn, reads []*eng.LocalRead) bool {
+	if !eng.LocalReadsConsistent(reads) {
+		return false
+	}
+	actions := ctxn.Actions()
+	for idx, read := range reads {
+		if read.TxnId.Compare(common.MakeTxnId(actions.At(idx).Read().Version())) != common.EQ {
+			return false
+		}
+	}
+	return true
+}
+
+func (lc *LocalConnection) snapshotRead(vd *eng.VarDispatcher, vUUIds []*common.VarUUId) ([]*eng.LocalRead, error) {
+	delay := server.SubmissionMinSubmitDelay
+	for attempt := 0; attempt < server.SnapshotReadAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay += delay
+		}
+		reads, err := lc.localReads(vd, vUUIds)
+		if err != nil {
+			return nil, err
+		} else if eng.LocalReadsSnapshot(reads) {
+			return reads, nil
+		}
+	}
+	return nil, SnapshotUnavailable
+}
+
+func (lc *LocalConnection) localReads(vd *eng.VarDispatcher, vUUIds []*common.VarUUId) ([]*eng.LocalRead, error) {
+	resultChan := make(chan []*eng.LocalRead, 1)
+	vd.LocalReads(vUUIds, func(reads []*eng.LocalRead) { resultChan <- reads })
+	select {
+	case reads := <-resultChan:
+		return reads, nil
+	case <-lc.cellTail.Terminated:
+		return nil, errors.New("Shutting down.")
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// TxnResourceUsage summarises what a client txn cost us, from its
+// first submission to the outcome handed back to the client,
+// including every resubmission in between.
+type TxnResourceUsage struct {
+	// Submissions is the number of paxos rounds the txn required: 1
+	// plus the number of times it was resubmitted.
+	Submissions uint32
+	// VarsTouched is the number of actions in the txn.
+	VarsTouched uint32
+	// TxnBytes is the total size of the txns sent to acceptors (and
+	// persisted by them), across all submissions.
+	TxnBytes uint64
+	// ExecutorTime is the time spent by the submitter's executor
+	// translating the txn and processing its outcomes.
+	ExecutorTime time.Duration
+	// Elapsed is the wall clock time from first submission to outcome.
+	Elapsed time.Duration
+	started time.Time
+}
+
+func newTxnResourceUsage() *TxnResourceUsage {
+	return &TxnResourceUsage{started: time.Now()}
+}
+
+// timed runs fun, attributing the time it takes to the executor.
+func (tru *TxnResourceUsage) timed(fun func() error) error {
+	start := time.Now()
+	err := fun()
+	tru.ExecutorTime += time.Now().Sub(start)
+	return err
+}
+
+func (tru *TxnResourceUsage) finished() {
+	tru.Elapsed = time.Now().Sub(tru.started)
+}
+
+func (tru *TxnResourceUsage) add(other *TxnResourceUsage) {
+	tru.Submissions += other.Submissions
+	tru.VarsTouched += other.VarsTouched
+	tru.TxnBytes += other.TxnBytes
+	tru.ExecutorTime += other.ExecutorTime
+	tru.Elapsed += other.Elapsed
+}
+
+func (tru *TxnResourceUsage) String() string {
+	return fmt.Sprintf("submissions: %v; vars touched: %v; txn bytes: %v; executor time: %v; elapsed: %v",
+		tru.Submissions, tru.VarsTouched, tru.TxnBytes, tru.ExecutorTime, tru.Elapsed)
+}
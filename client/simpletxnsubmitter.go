@@ -28,6 +28,8 @@ type SimpleTxnSubmitter struct {
 	resolver            *ch.Resolver
 	hashCache           *ch.ConsistentHashCache
 	topology            *configuration.Topology
+	zones               map[common.RMId]string
+	witnesses           common.RMIds
 	rng                 *rand.Rand
 	bufferedSubmissions []func() error
 }
@@ -89,7 +91,7 @@ func (sts *SimpleTxnSubmitter) SubmitTransaction(txnCap *msgs.Txn, txnId *common
 	msg := msgs.NewRootMessage(seg)
 	msg.SetTxnSubmission(server.SegToBytes(txnCap.Segment))
 
-	server.Log(txnId, "Submitting txn with actives:", activeRMs)
+	debugLog.Log(txnId, "Submitting txn with actives:", activeRMs)
 	txnSender := paxos.NewRepeatingSender(server.SegToBytes(seg), activeRMs...)
 	sleeping := delay != nil && delay.Cur > 0
 	var removeSenderCh chan chan server.EmptyStruct
@@ -135,7 +137,7 @@ func (sts *SimpleTxnSubmitter) SubmitTransaction(txnCap *msgs.Txn, txnId *common
 	shutdownFunPtr := &shutdownFun
 	sts.onShutdown[shutdownFunPtr] = server.EmptyStructVal
 
-	outcomeAccumulator := paxos.NewOutcomeAccumulator(int(txnCap.FInc()), acceptors)
+	outcomeAccumulator := paxos.NewOutcomeAccumulator(int(txnCap.FInc()), acceptors, debugLog.With("rm", sts.rmId, "txn", txnId))
 	consumer := func(sender common.RMId, txn *eng.TxnReader, outcome *msgs.Outcome) error {
 		if outcome, _ = outcomeAccumulator.BallotOutcomeReceived(sender, outcome); outcome != nil {
 			delete(sts.onShutdown, shutdownFunPtr)
@@ -168,23 +170,35 @@ func (sts *SimpleTxnSubmitter) SubmitClientTransaction(translationCallback eng.T
 	if next := sts.topology.Next(); next != nil && useNextVersion {
 		version = next.Version
 	}
+	span := server.StartSpan("submit", txnId, nil)
 	txnCap, activeRMs, _, err := sts.clientToServerTxn(translationCallback, ctxnCap, version, vc)
 	if err != nil {
+		span.LogEvent(err.Error())
+		span.Finish()
 		return continuation(nil, nil, err)
 	}
-	sts.SubmitTransaction(txnCap, txnId, activeRMs, continuation, delay)
+	if traceContext := span.Context(); len(traceContext) != 0 {
+		txnCap.SetTraceContext(traceContext)
+	}
+	traced := func(txn *eng.TxnReader, outcome *msgs.Outcome, err error) error {
+		span.Finish()
+		return continuation(txn, outcome, err)
+	}
+	sts.SubmitTransaction(txnCap, txnId, activeRMs, traced, delay)
 	return nil
 }
 
 func (sts *SimpleTxnSubmitter) TopologyChanged(topology *configuration.Topology) error {
-	server.Log("STS Topology Changed", topology)
+	debugLog.Log("STS Topology Changed", topology)
 	if topology.IsBlank() {
 		// topology is needed for client txns. As we're booting up, we
 		// just don't care.
 		return nil
 	}
 	sts.topology = topology
-	sts.resolver = ch.NewResolver(topology.RMs(), topology.TwoFInc)
+	sts.zones = topology.RMZones()
+	sts.witnesses = topology.WitnessRMs()
+	sts.resolver = ch.NewResolver(topology.DataRMs(), topology.TwoFInc)
 	sts.hashCache.SetResolver(sts.resolver)
 	if topology.Roots != nil {
 		for _, root := range topology.Roots {
@@ -195,7 +209,7 @@ func (sts *SimpleTxnSubmitter) TopologyChanged(topology *configuration.Topology)
 }
 
 func (sts *SimpleTxnSubmitter) ServerConnectionsChanged(servers map[common.RMId]paxos.Connection) error {
-	server.Log("STS ServerConnectionsChanged", servers)
+	debugLog.Log("STS ServerConnectionsChanged", servers)
 	sts.connections = servers
 	sts.connectionsBool = make(map[common.RMId]bool, len(servers))
 	for k := range servers {
@@ -212,11 +226,11 @@ func (sts *SimpleTxnSubmitter) calculateDisabledHashcodes() error {
 	for _, rmId := range sts.topology.RMs() {
 		if rmId == common.RMIdEmpty {
 			continue
-		} else if _, found := sts.connections[rmId]; !found {
+		} else if conn, found := sts.connections[rmId]; !found || conn.LearnerOnly() {
 			sts.disabledHashCodes[rmId] = server.EmptyStructVal
 		}
 	}
-	server.Log("STS disabled hash codes", sts.disabledHashCodes)
+	debugLog.Log("STS disabled hash codes", sts.disabledHashCodes)
 	// need to wait until we've updated disabledHashCodes before
 	// starting up any buffered txns.
 	if !sts.topology.IsBlank() && sts.bufferedSubmissions != nil {
@@ -249,6 +263,12 @@ func (sts *SimpleTxnSubmitter) clientToServerTxn(translationCallback eng.Transla
 	txnCap.SetTopologyVersion(topologyVersion)
 
 	clientActions := clientTxnCap.Actions()
+	if err := validateClientActions(&clientActions); err != nil {
+		return nil, nil, nil, err
+	}
+	// Checksum the actions as the client sent them, before they are
+	// translated, so that translation is covered too.
+	txnCap.SetActionsChecksum(eng.ClientActionsChecksum(&clientActions))
 	actionsListSeg := capn.NewBuffer(nil)
 	actionsWrapper := msgs.NewRootActionListWrapper(actionsListSeg)
 	actions := msgs.NewActionList(actionsListSeg, clientActions.Len())
@@ -259,8 +279,12 @@ func (sts *SimpleTxnSubmitter) clientToServerTxn(translationCallback eng.Transla
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	if err = validateFanOut(sts.topology.MaxTxnFanOut, rmIdToActionIndices, &actions); err != nil {
+		return nil, nil, nil, err
+	}
 
-	txnCap.SetActions(server.SegToBytes(actionsListSeg))
+	actionsBytes := server.SegToBytes(actionsListSeg)
+	txnCap.SetActions(actionsBytes)
 	// NB: we're guaranteed that activeRMs and passiveRMs are
 	// disjoint. Thus there is no RM that has some active and some
 	// passive actions.
@@ -268,6 +292,16 @@ func (sts *SimpleTxnSubmitter) clientToServerTxn(translationCallback eng.Transla
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	activeRMs = orderByZone(activeRMs, sts.zones, sts.zones[sts.rmId], int(sts.topology.FInc))
+	// Witnesses hold no vars, so have no actions, but they are
+	// acceptors. The acceptors are the first 2F+1 allocations, so the
+	// witnesses go straight after the active RMs.
+	if len(sts.witnesses) != 0 {
+		for _, rmId := range sts.witnesses {
+			rmIdToActionIndices[rmId] = &[]int{}
+		}
+		passiveRMs = append(append([]common.RMId{}, sts.witnesses...), passiveRMs...)
+	}
 	allocations := msgs.NewAllocationList(outgoingSeg, len(activeRMs)+len(passiveRMs))
 	txnCap.SetAllocations(allocations)
 	sts.setAllocations(0, rmIdToActionIndices, &allocations, outgoingSeg, true, activeRMs)
@@ -301,6 +335,7 @@ func (sts *SimpleTxnSubmitter) translateActions(translationCallback eng.Translat
 	referencesInNeedOfPositions := []*msgs.VarIdPos{}
 	rmIdToActionIndices := make(map[common.RMId]*[]int)
 	createdPositions := make(map[common.VarUUId]*common.Positions)
+	degradedUpdated := false
 
 	for idx, l := 0, clientActions.Len(); idx < l; idx++ {
 		clientAction := clientActions.At(idx)
@@ -322,6 +357,10 @@ func (sts *SimpleTxnSubmitter) translateActions(translationCallback eng.Translat
 			err = sts.translateReadWrite(vc, outgoingSeg, &referencesInNeedOfPositions, vUUId, &action, clientAction.Readwrite())
 
 		case cmsgs.CLIENTACTION_CREATE:
+			if !degradedUpdated {
+				sts.updateDegraded()
+				degradedUpdated = true
+			}
 			var positions *common.Positions
 			positions, hashCodes, err = sts.translateCreate(vc, outgoingSeg, &referencesInNeedOfPositions, vUUId, &action, clientAction.Create())
 			createdPositions[*vUUId] = positions
@@ -330,7 +369,9 @@ func (sts *SimpleTxnSubmitter) translateActions(translationCallback eng.Translat
 			err = sts.translateRoll(vc, outgoingSeg, &referencesInNeedOfPositions, &action, clientAction.Roll())
 
 		default:
-			panic(fmt.Sprintf("Unexpected action type: %v", clientAction.Which()))
+			// Most likely a newer client: reject the txn rather than
+			// the whole connection.
+			err = fmt.Errorf("Unknown action type: %v", clientAction.Which())
 		}
 
 		if err != nil {
@@ -384,6 +425,20 @@ func (sts *SimpleTxnSubmitter) translateActions(translationCallback eng.Translat
 	return rmIdToActionIndices, nil
 }
 
+// updateDegraded biases the placement of new vars away from RMs we
+// can't currently use, and from RMs which report themselves as
+// degraded. Health changes too often to be republished with the
+// connections, so it is sampled whenever a txn creates vars.
+func (sts *SimpleTxnSubmitter) updateDegraded() {
+	degraded := make(map[common.RMId]server.EmptyStruct)
+	for rmId, conn := range sts.connections {
+		if conn.Degraded() {
+			degraded[rmId] = server.EmptyStructVal
+		}
+	}
+	sts.hashCache.SetDegraded(sts.disabledHashCodes, degraded)
+}
+
 func (sts *SimpleTxnSubmitter) translateRead(action *msgs.Action, clientRead cmsgs.ClientActionRead) error {
 	action.SetRead()
 	read := action.Read()
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"fmt"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"sort"
+)
+
+// TxnValidationError describes why a client txn has been rejected
+// before it was translated and submitted. ActionIndex is -1 if the
+// problem is with the txn as a whole rather than one action.
+type TxnValidationError struct {
+	ActionIndex int
+	VarUUId     *common.VarUUId
+	Reason      string
+}
+
+func (tve *TxnValidationError) Error() string {
+	if tve.ActionIndex < 0 {
+		return fmt.Sprintf("Invalid transaction: %s", tve.Reason)
+	}
+	return fmt.Sprintf("Invalid transaction: action %d (%v): %s", tve.ActionIndex, tve.VarUUId, tve.Reason)
+}
+
+// ValidateClientTxn checks that a client txn meets the server's
+// expectations, as they would be checked on submission.
+func ValidateClientTxn(ctxn *cmsgs.ClientTxn) error {
+	if l := len(ctxn.Id()); l != common.KeyLen {
+		return &TxnValidationError{ActionIndex: -1, Reason: fmt.Sprintf("Id has length %d; expected %d", l, common.KeyLen)}
+	}
+	actions := ctxn.Actions()
+	return validateClientActions(&actions)
+}
+
+// validateClientActions checks the structural assumptions that the
+// txnengine makes about a txn's actions. Violating these would
+// otherwise only be discovered deep inside the txnengine, well after
+// the txn has been submitted to other RMs.
+func validateClientActions(clientActions *cmsgs.ClientAction_List) error {
+	l := clientActions.Len()
+	if l == 0 {
+		return &TxnValidationError{ActionIndex: -1, Reason: "no actions"}
+	}
+	seen := make(map[common.VarUUId]int, l)
+	for idx := 0; idx < l; idx++ {
+		clientAction := clientActions.At(idx)
+		varId := clientAction.VarId()
+		if len(varId) != common.KeyLen {
+			return &TxnValidationError{ActionIndex: idx, Reason: fmt.Sprintf("VarId has length %d; expected %d", len(varId), common.KeyLen)}
+		}
+		vUUId := common.MakeVarUUId(varId)
+		if prev, found := seen[*vUUId]; found {
+			return &TxnValidationError{ActionIndex: idx, VarUUId: vUUId, Reason: fmt.Sprintf("var already used by action %d; each var may appear at most once per txn", prev)}
+		}
+		seen[*vUUId] = idx
+
+		var version []byte
+		var references cmsgs.ClientVarIdPos_List
+		hasVersion, hasReferences := true, true
+		switch clientAction.Which() {
+		case cmsgs.CLIENTACTION_READ:
+			version, hasReferences = clientAction.Read().Version(), false
+		case cmsgs.CLIENTACTION_WRITE:
+			references, hasVersion = clientAction.Write().References(), false
+		case cmsgs.CLIENTACTION_READWRITE:
+			rw := clientAction.Readwrite()
+			version, references = rw.Version(), rw.References()
+		case cmsgs.CLIENTACTION_CREATE:
+			references, hasVersion = clientAction.Create().References(), false
+		case cmsgs.CLIENTACTION_ROLL:
+			roll := clientAction.Roll()
+			version, references = roll.Version(), roll.References()
+		default:
+			return &TxnValidationError{ActionIndex: idx, VarUUId: vUUId, Reason: fmt.Sprintf("unknown action type %v", clientAction.Which())}
+		}
+		if hasVersion && len(version) != common.KeyLen {
+			return &TxnValidationError{ActionIndex: idx, VarUUId: vUUId, Reason: fmt.Sprintf("version has length %d; expected %d", len(version), common.KeyLen)}
+		}
+		if hasReferences {
+			for idy, m := 0, references.Len(); idy < m; idy++ {
+				if refId := references.At(idy).VarId(); len(refId) != common.KeyLen {
+					return &TxnValidationError{ActionIndex: idx, VarUUId: vUUId, Reason: fmt.Sprintf("reference %d has VarId of length %d; expected %d", idy, len(refId), common.KeyLen)}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// TxnFanOutError describes a client txn rejected because its actions
+// would be allocated to more RMs than the configured MaxTxnFanOut.
+// VarUUIds are the vars which forced the spread: each is the only var
+// in the txn allocated to at least one of the RMs. Ultra wide txns
+// dominate tail latency, and are usually a bug in the application.
+type TxnFanOutError struct {
+	FanOut    int
+	MaxFanOut int
+	VarUUIds  []*common.VarUUId
+}
+
+func (tfe *TxnFanOutError) Error() string {
+	return fmt.Sprintf("Invalid transaction: actions span %d RMs; at most %d allowed. Vars forcing the spread: %v", tfe.FanOut, tfe.MaxFanOut, tfe.VarUUIds)
+}
+
+// validateFanOut checks the number of RMs a txn's actions have been
+// allocated to against maxFanOut. A maxFanOut of 0 means unlimited.
+func validateFanOut(maxFanOut uint16, rmIdToActionIndices map[common.RMId]*[]int, actions *msgs.Action_List) error {
+	if maxFanOut == 0 || len(rmIdToActionIndices) <= int(maxFanOut) {
+		return nil
+	}
+	exclusive := make(map[int]server.EmptyStruct)
+	for _, actionIndices := range rmIdToActionIndices {
+		if len(*actionIndices) == 1 {
+			exclusive[(*actionIndices)[0]] = server.EmptyStructVal
+		}
+	}
+	actionIndices := make([]int, 0, len(exclusive))
+	for idx := range exclusive {
+		actionIndices = append(actionIndices, idx)
+	}
+	sort.Ints(actionIndices)
+	vUUIds := make([]*common.VarUUId, len(actionIndices))
+	for idx, actionIdx := range actionIndices {
+		vUUIds[idx] = common.MakeVarUUId(actions.At(actionIdx).VarId())
+	}
+	return &TxnFanOutError{
+		FanOut:    len(rmIdToActionIndices),
+		MaxFanOut: int(maxFanOut),
+		VarUUIds:  vUUIds,
+	}
+}
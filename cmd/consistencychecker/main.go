@@ -3,12 +3,10 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"flag"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
-	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/configuration"
 	ch "goshawkdb.io/server/consistenthash"
@@ -18,11 +16,11 @@ import (
 	"log"
 	"os"
 	"runtime"
-	"time"
 )
 
 type store struct {
 	dir      string
+	keys     *db.Keyring
 	db       *db.Databases
 	rmId     common.RMId
 	topology *configuration.Topology
@@ -35,7 +33,19 @@ func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 	log.Println(os.Args)
 
-	dirs := os.Args[1:]
+	var keyFile string
+	flag.StringVar(&keyFile, "keyfile", "", "`Path` to the file of keys with which the data directories are encrypted.")
+	flag.Parse()
+
+	var keys *db.Keyring
+	if keyFile != "" {
+		var err error
+		if keys, err = db.LoadKeyring(keyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	dirs := flag.Args()
 	if len(dirs) == 0 {
 		log.Fatal("No dirs supplied")
 	}
@@ -46,7 +56,7 @@ func main() {
 	defer stores.Shutdown()
 	for _, dir := range dirs {
 		log.Printf("...loading from %v\n", dir)
-		store := &store{dir: dir}
+		store := &store{dir: dir, keys: keys}
 		var err error
 		if err = store.LoadRMId(); err == nil {
 			if err = store.StartDisk(); err == nil {
@@ -79,7 +89,7 @@ type locationChecker struct {
 }
 
 func newLocationChecker(stores stores) *locationChecker {
-	resolver := ch.NewResolver(stores[0].topology.RMs(), stores[0].topology.TwoFInc)
+	resolver := ch.NewResolver(stores[0].topology.DataRMs(), stores[0].topology.TwoFInc)
 	m := make(map[common.RMId]*store, len(stores))
 	for _, s := range stores {
 		m[s.rmId] = s
@@ -97,7 +107,7 @@ func (lc *locationChecker) locationCheck(cell *varWrapperCell) error {
 	fmt.Printf("%v %v\n", foundIn, vUUId)
 	txnId := common.MakeTxnId(varCap.WriteTxnId())
 
-	res, err := foundIn.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+	res, err := foundIn.db.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
 		return foundIn.db.ReadTxnBytesFromDisk(rtxn, txnId)
 	}).ResultError()
 	if err != nil {
@@ -129,9 +139,9 @@ func (lc *locationChecker) locationCheck(cell *varWrapperCell) error {
 		if rmId == foundIn.rmId {
 			continue
 		} else if remote, found := lc.stores[rmId]; found {
-			res, err := remote.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+			res, err := remote.db.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
 				bites, err := rtxn.Get(remote.db.Vars, vUUId[:])
-				if err == mdb.NotFound {
+				if err == db.NotFound {
 					return nil
 				} else if err == nil {
 					return bites
@@ -214,16 +224,16 @@ func (s *store) LoadRMId() error {
 
 func (s *store) StartDisk() error {
 	log.Printf("Starting disk server on %v", s.dir)
-	disk, err := mdbs.NewMDBServer(s.dir, 0, 0600, server.MDBInitialSize, 2, 10*time.Millisecond, db.DB)
+	dbs, err := db.Open(db.DefaultStorageEngine, s.dir, &db.Options{Concurrency: 2, Keys: s.keys})
 	if err != nil {
 		return err
 	}
-	s.db = disk.(*db.Databases)
+	s.db = dbs
 	return nil
 }
 
 func (s *store) LoadTopology() error {
-	res, err := s.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+	res, err := s.db.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
 		bites, err := rtxn.Get(s.db.Vars, configuration.TopologyVarUUId[:])
 		if err != nil {
 			rtxn.Error(err)
@@ -314,36 +324,30 @@ func (vw *varWrapper) start() {
 	c1.other, c2.other = c2, c1
 
 	curCell := c1
-	_, err := vw.store.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		rtxn.WithCursor(vw.store.db.Vars, func(cursor *mdbs.Cursor) interface{} {
-			vUUIdBytes, varBytes, err := cursor.Get(nil, nil, mdb.FIRST)
-			if err != nil {
-				cursor.Error(fmt.Errorf("Err on finding first var in %v: %v", vw.store, err))
-				return nil
-			}
-			if !bytes.Equal(vUUIdBytes, configuration.TopologyVarUUId[:]) {
-				vUUId := common.MakeVarUUId(vUUIdBytes)
-				cursor.Error(fmt.Errorf("Err on finding first var in %v: expected to find topology var, but found %v instead! (%v)", vw.store, vUUId, varBytes))
-				return nil
+	_, err := vw.store.db.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
+		count := 0
+		rtxn.Iterate(vw.store.db.Vars, func(vUUIdBytes, varBytes []byte) bool {
+			count++
+			vUUId := common.MakeVarUUId(vUUIdBytes)
+			if count == 1 && !bytes.Equal(vUUIdBytes, configuration.TopologyVarUUId[:]) {
+				rtxn.Error(fmt.Errorf("Err on finding first var in %v: expected to find topology var, but found %v instead! (%v)", vw.store, vUUId, varBytes))
+				return false
 			}
-			for ; err == nil; vUUIdBytes, varBytes, err = cursor.Get(nil, nil, mdb.NEXT) {
-				vUUId := common.MakeVarUUId(vUUIdBytes)
-				seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
-				if err != nil {
-					cursor.Error(fmt.Errorf("Err on decoding %v in %v: %v (%v)", vUUId, vw.store, err, varBytes))
-					return nil
-				}
-				varCap := msgs.ReadRootVar(seg)
-				curCell.vUUId = vUUId
-				curCell.varCap = &varCap
-				vw.c <- curCell
-				curCell = curCell.other
-			}
-			if err != nil && err != mdb.NotFound {
-				cursor.Error(err)
+			seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
+			if err != nil {
+				rtxn.Error(fmt.Errorf("Err on decoding %v in %v: %v (%v)", vUUId, vw.store, err, varBytes))
+				return false
 			}
-			return nil
+			varCap := msgs.ReadRootVar(seg)
+			curCell.vUUId = vUUId
+			curCell.varCap = &varCap
+			vw.c <- curCell
+			curCell = curCell.other
+			return true
 		})
+		if count == 0 {
+			rtxn.Error(fmt.Errorf("Err on finding first var in %v: %v", vw.store, db.NotFound))
+		}
 		return nil
 	}).ResultError()
 	if err != nil {
@@ -0,0 +1,853 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"goshawkdb.io/common"
+	goshawk "goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/network"
+	"goshawkdb.io/server/paxos"
+	"goshawkdb.io/server/scheduler"
+	eng "goshawkdb.io/server/txnengine"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The admin service is a small HTTP/JSON API for inspecting and
+// controlling a node, enabled with -admin. It has no authentication
+// of its own, so it refuses to listen on anything but a loopback
+// address.
+//
+//	GET  /status             the full status of the node, as text,
+//	                         or as JSON with ?format=json
+//	GET  /proposers          every live proposer
+//	GET  /vars               every active var
+//	GET  /vars/hot?limit=    the hottest active vars, with advice
+//	                         for those which are contended
+//	GET  /vars/unreachable?limit=
+//	                         the vars held by this node which can't
+//	                         be reached from any root
+//	GET  /usage              the vars, and bytes, reachable from each
+//	                         root and from each account's roots
+//	GET  /txns/outcome?txnId=
+//	                         what happened to txnId: committed (with
+//	                         its final TxnId, or its clock), aborted,
+//	                         in progress, or unknown
+//	POST /txns/abort?txnId=  make the local proposer vote to abort txnId
+//	GET  /topology           the progress of the topology change
+//	                         in progress, if any
+//	POST /topology           request a topology change to the
+//	                         configuration in the body, or if the
+//	                         body is empty, to the configuration file
+//	GET  /migrations         the report of every completed topology
+//	                         change, oldest first
+//	GET  /emigration         the limits on sending vars to other
+//	                         nodes during topology changes
+//	POST /emigration         set the limits to those in the body
+//	GET  /antientropy?peer=  a summary of the frontiers of the vars
+//	                         held by both this node and peer (an
+//	                         RMId, in hex)
+//	POST /antientropy?limit= compare the summary, taken on another
+//	                         node of this one, in the body with this
+//	                         node's, and list the divergent vars
+//	GET  /scrub              the report of the most recent scrub of
+//	                         the var store against its checksums
+//	POST /scrub              scrub the var store now
+//	GET  /logging            whether the debug logging of each
+//	                         subsystem is on
+//	POST /logging            turn the debug logging of the
+//	                         subsystems in the body on or off
+//	GET  /logging/sinks      where the log is written
+//	POST /logging/sinks      replace the log sinks with those in the
+//	                         body
+//	POST /trace?duration=    capture an execution trace for duration
+//	                         (default 5s) to a temporary file, noting
+//	                         the node's throughput alongside it
+//	POST /certificate        reload the certificate file
+//	POST /encryption         rewrite every record not encrypted with
+//	                         the current key under it
+//	GET  /faults             the faults being injected into server
+//	                         connections, and what they've affected
+//	POST /faults             inject the faults in the body
+//	DELETE /faults           stop injecting faults
+//	POST /learner            with true in the body, demote this node
+//	                         to a learner, so it stops voting; with
+//	                         false, make it a voter again
+//	GET  /annotation         the operator's note on this node, if any
+//	POST /annotation         set the note to the Text in the body
+//	DELETE /annotation       clear the note
+//	GET  /jobs               every scheduled job, with its
+//	                         configuration and its most recent run
+//	POST /jobs?name=         enable or disable the job, and change
+//	                         its interval, as given in the body
+//	GET  /audit?from=&limit= entries from the audit log
+//	GET  /audit/verify       check the audit log's hash chain
+//	POST /import             write the vars in the dump in the body
+//	                         which this node holds straight into the
+//	                         var store, bypassing consensus; every
+//	                         node must be sent the same dump
+//	POST /export?path=       write a dump of every var reachable
+//	                         from the roots to a new file at path
+type adminServer struct {
+	s        *server
+	listener net.Listener
+}
+
+type adminProposer struct {
+	TxnId     string
+	Mode      string
+	State     string
+	Outcome   string
+	Acceptors common.RMIds
+}
+
+type adminTxnOutcome struct {
+	TxnId       string
+	Outcome     string
+	FinalTxnId  string            `json:",omitempty"`
+	Clock       map[string]uint64 `json:",omitempty"`
+	AbortReason string            `json:",omitempty"`
+	// Source is where the answer came from: the ClientOutcomes log,
+	// which is on disk, or the local proposer.
+	Source string
+}
+
+type adminHotVar struct {
+	VarUUId      string
+	Arrivals     uint64
+	Commits      uint64
+	Aborts       uint64
+	Deadlocks    uint64
+	Rate         float64
+	AbortRatio   float64
+	DeadlockLoop bool
+	Advice       string `json:",omitempty"`
+}
+
+// adminJobConfig is the body of POST /jobs. An Interval of "" leaves
+// the interval unchanged.
+type adminJobConfig struct {
+	Enabled  bool
+	Interval string
+}
+
+type adminVar struct {
+	VarUUId     string
+	FrameTxnId  string
+	Subscribers int
+	Idle        bool
+	OnDisk      bool
+}
+
+func newAdminServer(s *server, addr string) (*adminServer, error) {
+	listener, err := listenLoopback("Admin service", addr)
+	if err != nil {
+		return nil, err
+	}
+	as := &adminServer{
+		s:        s,
+		listener: listener,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", as.getStatus)
+	mux.HandleFunc("/proposers", as.listProposers)
+	mux.HandleFunc("/vars", as.listVars)
+	mux.HandleFunc("/vars/hot", as.listHotVars)
+	mux.HandleFunc("/vars/unreachable", as.listUnreachableVars)
+	mux.HandleFunc("/usage", as.storageUsage)
+	mux.HandleFunc("/txns/outcome", as.txnOutcome)
+	mux.HandleFunc("/txns/abort", as.abortTxn)
+	mux.HandleFunc("/topology", as.topology)
+	mux.HandleFunc("/migrations", as.listMigrations)
+	mux.HandleFunc("/emigration", as.emigrationLimits)
+	mux.HandleFunc("/antientropy", as.antiEntropy)
+	mux.HandleFunc("/scrub", as.scrub)
+	mux.HandleFunc("/logging", as.logging)
+	mux.HandleFunc("/logging/sinks", as.logSinks)
+	mux.HandleFunc("/trace", as.captureTrace)
+	mux.HandleFunc("/certificate", as.reloadCertificate)
+	mux.HandleFunc("/encryption", as.reencrypt)
+	mux.HandleFunc("/faults", as.faults)
+	mux.HandleFunc("/learner", as.learnerOnly)
+	mux.HandleFunc("/annotation", as.annotation)
+	mux.HandleFunc("/jobs", as.jobs)
+	mux.HandleFunc("/audit", as.listAudit)
+	mux.HandleFunc("/audit/verify", as.verifyAudit)
+	mux.HandleFunc("/import", as.importDump)
+	mux.HandleFunc("/export", as.exportDump)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+			log.Printf("Error: Admin service stopped: %v", err)
+		}
+	}()
+	log.Printf("Admin service listening on %v", listener.Addr())
+	return as, nil
+}
+
+func (as *adminServer) Shutdown() {
+	as.listener.Close()
+}
+
+func (as *adminServer) getStatus(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "GET") {
+		return
+	}
+	asJSON := r.URL.Query().Get("format") == "json"
+	resultChan := make(chan interface{}, 1)
+	ok := as.s.collectStatus(func(sc *goshawk.StatusConsumer, done func()) {
+		if asJSON {
+			sc.ConsumeJSON(func(bites []byte, err error) {
+				if err == nil {
+					resultChan <- json.RawMessage(bites)
+				} else {
+					resultChan <- err
+				}
+				done()
+			})
+		} else {
+			sc.Consume(func(str string) {
+				resultChan <- str
+				done()
+			})
+		}
+	})
+	if !ok {
+		http.Error(w, "Status collection already in progress.", http.StatusServiceUnavailable)
+		return
+	}
+	result := <-resultChan
+	if err, isErr := result.(error); isErr {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	as.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"RMId":   as.s.rmId,
+		"Status": result,
+	})
+}
+
+func (as *adminServer) listProposers(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "GET") {
+		return
+	}
+	summaries := as.s.connectionManager.Dispatchers.ProposerDispatcher.ListProposers()
+	proposers := make([]adminProposer, len(summaries))
+	for idx, summary := range summaries {
+		proposers[idx] = adminProposer{
+			TxnId:     hex.EncodeToString(summary.TxnId[:]),
+			Mode:      summary.Mode.String(),
+			State:     summary.State,
+			Outcome:   summary.Outcome.String(),
+			Acceptors: summary.Acceptors,
+		}
+	}
+	as.writeJSON(w, http.StatusOK, proposers)
+}
+
+func (as *adminServer) listVars(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "GET") {
+		return
+	}
+	summaries := as.s.connectionManager.Dispatchers.VarDispatcher.ListVars()
+	vars := make([]adminVar, len(summaries))
+	for idx, summary := range summaries {
+		vars[idx] = adminVar{
+			VarUUId:     hex.EncodeToString(summary.VarUUId[:]),
+			Subscribers: summary.Subscribers,
+			Idle:        summary.Idle,
+			OnDisk:      summary.OnDisk,
+		}
+		if summary.FrameTxnId != nil {
+			vars[idx].FrameTxnId = hex.EncodeToString(summary.FrameTxnId[:])
+		}
+	}
+	as.writeJSON(w, http.StatusOK, vars)
+}
+
+func (as *adminServer) listHotVars(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "GET") {
+		return
+	}
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 0 {
+			http.Error(w, "limit must be a non-negative integer.", http.StatusBadRequest)
+			return
+		}
+	}
+	hotVars := as.s.connectionManager.Dispatchers.VarDispatcher.HotVars(limit)
+	vars := make([]adminHotVar, len(hotVars))
+	for idx, hv := range hotVars {
+		vars[idx] = adminHotVar{
+			VarUUId:      hex.EncodeToString(hv.VarUUId[:]),
+			Arrivals:     hv.Arrivals,
+			Commits:      hv.Commits,
+			Aborts:       hv.Aborts,
+			Deadlocks:    hv.Deadlocks,
+			Rate:         hv.Rate,
+			AbortRatio:   hv.AbortRatio,
+			DeadlockLoop: hv.DeadlockLoop,
+			Advice:       hv.Advice,
+		}
+	}
+	as.writeJSON(w, http.StatusOK, vars)
+}
+
+func (as *adminServer) listUnreachableVars(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "GET") {
+		return
+	}
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 0 {
+			http.Error(w, "limit must be a non-negative integer.", http.StatusBadRequest)
+			return
+		}
+	}
+	report, err := as.s.transmogrifier.UnreachableVars(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	unreachable := make([]string, len(report.Unreachable))
+	for idx, vUUId := range report.Unreachable {
+		unreachable[idx] = hex.EncodeToString(vUUId[:])
+	}
+	as.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"Held":             report.Held,
+		"Reachable":        report.Reachable,
+		"UnreachableCount": report.UnreachableCount,
+		"Unreachable":      unreachable,
+	})
+}
+
+func (as *adminServer) storageUsage(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "GET") {
+		return
+	}
+	report, err := as.s.transmogrifier.StorageUsage()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	as.writeJSON(w, http.StatusOK, report)
+}
+
+// txnOutcome lets a client which lost its connection before learning
+// the outcome of a txn find out what happened to it. Commits of
+// client txns are answered from the ClientOutcomes log, which is on
+// disk and so survives restarts, for ClientOutcomeTTL. Otherwise, the
+// local proposer is asked, which only knows of the txns it is
+// working on, or has recently finished.
+func (as *adminServer) txnOutcome(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "GET") {
+		return
+	}
+	txnIdBytes, err := hex.DecodeString(r.URL.Query().Get("txnId"))
+	if err != nil || len(txnIdBytes) != common.KeyLen {
+		http.Error(w, fmt.Sprintf("txnId must be %v hex-encoded bytes.", common.KeyLen), http.StatusBadRequest)
+		return
+	}
+	txnId := common.MakeTxnId(txnIdBytes)
+	result := &adminTxnOutcome{TxnId: hex.EncodeToString(txnId[:])}
+	if finalTxnId, found := as.s.connectionManager.ClientOutcomes.LookupTxnId(txnId); found {
+		result.Outcome = paxos.TxnOutcomeCommitted.String()
+		result.FinalTxnId = hex.EncodeToString(finalTxnId[:])
+		result.Source = "ClientOutcomes"
+		as.writeJSON(w, http.StatusOK, result)
+		return
+	}
+	done := make(chan struct{})
+	looked := as.s.connectionManager.Dispatchers.ProposerDispatcher.TxnOutcome(txnId, func(status paxos.TxnOutcomeStatus, outcome *msgs.Outcome) {
+		defer close(done)
+		result.Outcome = status.String()
+		result.Source = "Proposer"
+		switch {
+		case outcome == nil:
+		case outcome.Which() == msgs.OUTCOME_COMMIT:
+			result.Clock = make(map[string]uint64)
+			eng.VectorClockFromData(outcome.Commit(), true).ForEach(func(vUUId *common.VarUUId, version uint64) bool {
+				result.Clock[hex.EncodeToString(vUUId[:])] = version
+				return true
+			})
+		case outcome.Abort().Which() == msgs.OUTCOMEABORT_RESUBMIT:
+			result.AbortReason = "Resubmit"
+		default:
+			result.AbortReason = "Rerun"
+		}
+	})
+	if !looked {
+		http.Error(w, "Shutting down.", http.StatusServiceUnavailable)
+		return
+	}
+	<-done
+	as.writeJSON(w, http.StatusOK, result)
+}
+
+func (as *adminServer) abortTxn(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "POST") {
+		return
+	}
+	txnIdBytes, err := hex.DecodeString(r.URL.Query().Get("txnId"))
+	if err != nil || len(txnIdBytes) != common.KeyLen {
+		http.Error(w, fmt.Sprintf("txnId must be %v hex-encoded bytes.", common.KeyLen), http.StatusBadRequest)
+		return
+	}
+	txnId := common.MakeTxnId(txnIdBytes)
+	if !as.s.connectionManager.Dispatchers.ProposerDispatcher.AbortTxn(txnId) {
+		http.Error(w, "No proposer for txn is awaiting ballots.", http.StatusConflict)
+		return
+	}
+	as.s.databases.Audit.Record("abort", "Forced abort of txn %v (admin, from %v)", txnId, r.RemoteAddr)
+	as.writeJSON(w, http.StatusOK, map[string]interface{}{"Aborting": hex.EncodeToString(txnId[:])})
+}
+
+func (as *adminServer) topology(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		as.topologyProgress(w, r)
+	case "POST":
+		as.triggerTopologyChange(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method must be GET or POST.", http.StatusMethodNotAllowed)
+	}
+}
+
+func (as *adminServer) topologyProgress(w http.ResponseWriter, r *http.Request) {
+	progress, err := as.s.transmogrifier.TopologyProgress()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	as.writeJSON(w, http.StatusOK, progress)
+}
+
+func (as *adminServer) triggerTopologyChange(w http.ResponseWriter, r *http.Request) {
+	var config *configuration.Configuration
+	var err error
+	if r.ContentLength == 0 {
+		if as.s.configFile == "" {
+			http.Error(w, "No configuration supplied, and no path to configuration provided on command line.", http.StatusBadRequest)
+			return
+		}
+		config, err = configuration.LoadConfigurationFromPath(as.s.configFile)
+	} else {
+		config, err = configuration.LoadConfigurationFromReader(r.Body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	as.s.databases.Audit.Record("configuration", "Configuration version %v requested (admin, from %v): %v client fingerprints",
+		config.Version, r.RemoteAddr, len(config.Fingerprints()))
+	as.s.transmogrifier.RequestConfigurationChange(config)
+	as.writeJSON(w, http.StatusAccepted, map[string]interface{}{"Requested": config.Version})
+}
+
+func (as *adminServer) listMigrations(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "GET") {
+		return
+	}
+	reports, err := network.ReadMigrationReports(as.s.databases)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if reports == nil {
+		http.Error(w, "Shutting down.", http.StatusServiceUnavailable)
+		return
+	}
+	as.writeJSON(w, http.StatusOK, reports)
+}
+
+func (as *adminServer) emigrationLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		limits := network.EmigrationLimits{}
+		if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err := as.s.transmogrifier.SetEmigrationLimits(limits); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Emigration limits set to %+v", limits)
+		as.s.databases.Audit.Record("emigration", "Emigration limits set (admin, from %v): %+v", r.RemoteAddr, limits)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method must be GET or POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	as.writeJSON(w, http.StatusOK, as.s.transmogrifier.EmigrationLimits())
+}
+
+func (as *adminServer) antiEntropy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		peer, err := strconv.ParseUint(r.URL.Query().Get("peer"), 16, 32)
+		if err != nil {
+			http.Error(w, "peer must be an RMId, in hex.", http.StatusBadRequest)
+			return
+		}
+		summary, err := as.s.transmogrifier.FrontierSummary(common.RMId(peer))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		as.writeJSON(w, http.StatusOK, summary)
+	case "POST":
+		limit := 100
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			var err error
+			if limit, err = strconv.Atoi(limitStr); err != nil || limit < 0 {
+				http.Error(w, "limit must be a non-negative integer.", http.StatusBadRequest)
+				return
+			}
+		}
+		remote := &network.FrontierSummary{}
+		if err := json.NewDecoder(r.Body).Decode(remote); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		divergence, err := as.s.transmogrifier.CompareFrontiers(remote, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if len(divergence.Buckets) != 0 {
+			log.Printf("Warning: Frontiers of vars held with %v diverge in %v buckets (%v vars here).",
+				remote.RMId, len(divergence.Buckets), divergence.FrontiersCount)
+		}
+		as.writeJSON(w, http.StatusOK, divergence)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method must be GET or POST.", http.StatusMethodNotAllowed)
+	}
+}
+
+func (as *adminServer) scrub(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		as.writeJSON(w, http.StatusOK, as.s.scrubber.Report())
+	case "POST":
+		as.s.databases.Audit.Record("scrub", "Scrub started (admin, from %v)", r.RemoteAddr)
+		report, err := as.s.scrubber.Run()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		as.s.databases.Audit.Record("scrub", "Scrub finished: %v vars checked, %v corrupt", report.Checked, report.CorruptCount)
+		as.writeJSON(w, http.StatusOK, report)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method must be GET or POST.", http.StatusMethodNotAllowed)
+	}
+}
+
+func (as *adminServer) logging(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		logging := make(map[string]bool)
+		if err := json.NewDecoder(r.Body).Decode(&logging); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		current := goshawk.SubsystemLogging()
+		for name := range logging {
+			if _, found := current[name]; !found {
+				http.Error(w, fmt.Sprintf("No such subsystem: %v", name), http.StatusBadRequest)
+				return
+			}
+		}
+		for name, enabled := range logging {
+			goshawk.SetSubsystemLogging(name, enabled)
+		}
+		as.s.databases.Audit.Record("logging", "Debug logging set (admin, from %v): %v", r.RemoteAddr, logging)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method must be GET or POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	as.writeJSON(w, http.StatusOK, goshawk.SubsystemLogging())
+}
+
+func (as *adminServer) logSinks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		configs := []goshawk.LogSinkConfig{}
+		if err := json.NewDecoder(r.Body).Decode(&configs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err := goshawk.SetLogSinks(configs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		as.s.databases.Audit.Record("logging", "Log sinks set (admin, from %v): %v", r.RemoteAddr, configs)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method must be GET or POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	as.writeJSON(w, http.StatusOK, goshawk.LogSinks())
+}
+
+func (as *adminServer) captureTrace(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "POST") {
+		return
+	}
+	duration := goshawk.TraceCaptureDefaultDuration
+	if durationStr := r.URL.Query().Get("duration"); durationStr != "" {
+		var err error
+		if duration, err = time.ParseDuration(durationStr); err != nil {
+			http.Error(w, "duration must be a duration, for example 10s.", http.StatusBadRequest)
+			return
+		}
+	}
+	capture, err := as.s.captureTrace(duration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	as.s.databases.Audit.Record("trace", "Trace of %v captured to %v (admin, from %v)", capture.Duration, capture.Path, r.RemoteAddr)
+	as.writeJSON(w, http.StatusOK, capture)
+}
+
+func (as *adminServer) reloadCertificate(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "POST") {
+		return
+	}
+	if err := as.s.reloadCertificate(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	as.writeJSON(w, http.StatusOK, map[string]interface{}{"Reloaded": as.s.certFile})
+}
+
+func (as *adminServer) reencrypt(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "POST") {
+		return
+	}
+	as.s.databases.Audit.Record("encryption", "Reencryption started (admin, from %v)", r.RemoteAddr)
+	rewritten, err := as.s.databases.Reencrypt()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	as.s.databases.Audit.Record("encryption", "Reencryption finished: %v records rewritten", rewritten)
+	as.writeJSON(w, http.StatusOK, map[string]interface{}{"Rewritten": rewritten})
+}
+
+func (as *adminServer) faults(w http.ResponseWriter, r *http.Request) {
+	injector := as.s.connectionManager.Faults
+	switch r.Method {
+	case "GET":
+	case "POST":
+		faults := &network.Faults{}
+		if err := json.NewDecoder(r.Body).Decode(faults); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err := injector.Set(faults); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Warning: Injecting faults into server connections: %+v", faults)
+		as.s.databases.Audit.Record("faults", "Injecting faults (admin, from %v): %+v", r.RemoteAddr, faults)
+	case "DELETE":
+		injector.Clear()
+		as.s.databases.Audit.Record("faults", "Stopped injecting faults (admin, from %v)", r.RemoteAddr)
+		log.Printf("No longer injecting faults into server connections.")
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method must be GET, POST or DELETE.", http.StatusMethodNotAllowed)
+		return
+	}
+	faults, stats := injector.Get()
+	as.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"Faults": faults,
+		"Stats":  stats,
+	})
+}
+
+func (as *adminServer) learnerOnly(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "POST") {
+		return
+	}
+	var learnerOnly bool
+	if err := json.NewDecoder(r.Body).Decode(&learnerOnly); err != nil {
+		http.Error(w, "Body must be true or false.", http.StatusBadRequest)
+		return
+	}
+	if err := as.s.connectionManager.SetLearnerOnly(learnerOnly); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	as.s.databases.Audit.Record("learner", "Learner only set to %v (admin, from %v)", learnerOnly, r.RemoteAddr)
+	as.writeJSON(w, http.StatusOK, map[string]interface{}{"LearnerOnly": learnerOnly})
+}
+
+func (as *adminServer) annotation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		annotation := goshawk.Annotation{}
+		if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if annotation.Text == "" {
+			http.Error(w, "Text must be given; use DELETE to clear the annotation.", http.StatusBadRequest)
+			return
+		}
+		goshawk.SetAnnotation(annotation.Text)
+		as.s.databases.Audit.Record("annotation", "Annotation set (admin, from %v): '%s'", r.RemoteAddr, annotation.Text)
+	case "DELETE":
+		goshawk.SetAnnotation("")
+		as.s.databases.Audit.Record("annotation", "Annotation cleared (admin, from %v)", r.RemoteAddr)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method must be GET, POST or DELETE.", http.StatusMethodNotAllowed)
+		return
+	}
+	as.writeJSON(w, http.StatusOK, goshawk.CurrentAnnotation())
+}
+
+func (as *adminServer) jobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "name must be given.", http.StatusBadRequest)
+			return
+		}
+		config := adminJobConfig{}
+		interval := time.Duration(0)
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if config.Interval != "" {
+			if interval, err = time.ParseDuration(config.Interval); err != nil || interval <= 0 {
+				http.Error(w, "Interval must be a positive duration, for example 10s.", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := as.s.scheduler.Configure(name, config.Enabled, interval); err == scheduler.JobNotFound {
+			http.Error(w, fmt.Sprintf("No such job: %v", name), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Job %v configured: %+v", name, config)
+		as.s.databases.Audit.Record("jobs", "Job %v configured (admin, from %v): %+v", name, r.RemoteAddr, config)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method must be GET or POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	as.writeJSON(w, http.StatusOK, as.s.scheduler.Reports())
+}
+
+func (as *adminServer) listAudit(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "GET") {
+		return
+	}
+	from, limit := uint64(1), 100
+	query := r.URL.Query()
+	if fromStr := query.Get("from"); fromStr != "" {
+		var err error
+		if from, err = strconv.ParseUint(fromStr, 10, 64); err != nil {
+			http.Error(w, "from must be a non-negative integer.", http.StatusBadRequest)
+			return
+		}
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 0 {
+			http.Error(w, "limit must be a non-negative integer.", http.StatusBadRequest)
+			return
+		}
+	}
+	entries, err := as.s.databases.Audit.Entries(from, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	as.writeJSON(w, http.StatusOK, entries)
+}
+
+func (as *adminServer) verifyAudit(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "GET") {
+		return
+	}
+	count, err := as.s.databases.Audit.Verify()
+	result := map[string]interface{}{"Verified": count, "Intact": err == nil}
+	if err != nil {
+		result["Error"] = err.Error()
+	}
+	as.writeJSON(w, http.StatusOK, result)
+}
+
+func (as *adminServer) importDump(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "POST") {
+		return
+	}
+	as.s.databases.Audit.Record("import", "Import of dump started (admin, from %v)", r.RemoteAddr)
+	applied, err := as.s.transmogrifier.Import(r.Body)
+	if err != nil {
+		as.s.databases.Audit.Record("import", "Import of dump failed after %v vars: %v", applied, err)
+		http.Error(w, fmt.Sprintf("Imported %v vars before failing: %v", applied, err), http.StatusBadRequest)
+		return
+	}
+	as.s.databases.Audit.Record("import", "Import of dump finished: %v vars", applied)
+	as.writeJSON(w, http.StatusOK, map[string]interface{}{"Imported": applied})
+}
+
+func (as *adminServer) exportDump(w http.ResponseWriter, r *http.Request) {
+	if !as.requireMethod(w, r, "POST") {
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path must be given.", http.StatusBadRequest)
+		return
+	}
+	count, err := as.s.transmogrifier.ExportToFile(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	as.s.databases.Audit.Record("export", "Exported %v vars to %v (admin, from %v)", count, path, r.RemoteAddr)
+	as.writeJSON(w, http.StatusOK, map[string]interface{}{"Exported": count, "Path": path})
+}
+
+func (as *adminServer) requireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, fmt.Sprintf("Method must be %v.", method), http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func (as *adminServer) writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	bites, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(bites)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"goshawkdb.io/common/certs"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certificateWatcher notices when the cluster certificate file has
+// been changed, so that the node certificate can be reloaded without
+// a restart. It is also reloaded on SIGHUP and from the admin
+// service.
+type certificateWatcher struct {
+	sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// reloadCertificate reads the cluster certificate and key from the
+// certificate file, and replaces the node certificate with one
+// generated from it. Existing connections are unaffected.
+func (s *server) reloadCertificate() error {
+	s.certWatcher.Lock()
+	defer s.certWatcher.Unlock()
+	info, err := os.Stat(s.certFile)
+	if err != nil {
+		return err
+	}
+	certificate, err := ioutil.ReadFile(s.certFile)
+	if err != nil {
+		return err
+	}
+	nodeCertPrivKeyPair, err := certs.GenerateNodeCertificatePrivateKeyPair(certificate)
+	for idx := range certificate {
+		certificate[idx] = 0
+	}
+	if err != nil {
+		return err
+	}
+	s.connectionManager.SetNodeCertificate(nodeCertPrivKeyPair)
+	s.databases.Audit.Record("certificate", "Certificate reloaded from %v", s.certFile)
+	s.certWatcher.modTime, s.certWatcher.size = info.ModTime(), info.Size()
+	log.Printf("Reloaded certificate from %v", s.certFile)
+	return nil
+}
+
+// watchCertificate is run by the scheduler. It reloads the certificate
+// if the certificate file has changed since it was last loaded.
+func (s *server) watchCertificate() {
+	info, err := os.Stat(s.certFile)
+	if err != nil {
+		log.Printf("Warning: Unable to check certificate file %v: %v", s.certFile, err)
+		return
+	}
+	s.certWatcher.Lock()
+	changed := !info.ModTime().Equal(s.certWatcher.modTime) || info.Size() != s.certWatcher.size
+	s.certWatcher.Unlock()
+	if changed {
+		if err := s.reloadCertificate(); err != nil {
+			log.Printf("Warning: Unable to reload certificate from %v: %v", s.certFile, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"goshawkdb.io/common"
+	goshawk "goshawkdb.io/server"
+	"log"
+	"time"
+)
+
+// drain is the first step of a clean shutdown. It stops this node
+// accepting client txns, demotes it to a learner so that other RMs
+// stop making it active in new txns, waits (for up to drainTimeout)
+// for the proposers which were live when the drain began to finish,
+// and finally forces everything written so far to disk. Without it,
+// a shutdown mid-ballot relies on the other RMs' recovery paths.
+func (s *server) drain() {
+	cm := s.connectionManager
+	if s.drainTimeout <= 0 || cm == nil {
+		return
+	}
+	log.Printf("Draining for up to %v.", s.drainTimeout)
+	deadline := time.Now().Add(s.drainTimeout)
+
+	cm.SetDraining()
+	if err := cm.SetLearnerOnly(true); err != nil {
+		log.Printf("Warning: Unable to become learner only whilst draining: %v", err)
+	}
+
+	pd := cm.Dispatchers.ProposerDispatcher
+	pending := make(map[common.TxnId]goshawk.EmptyStruct)
+	for _, summary := range pd.ListProposers() {
+		pending[*summary.TxnId] = goshawk.EmptyStructVal
+	}
+	for len(pending) != 0 && time.Now().Before(deadline) {
+		time.Sleep(goshawk.DrainPollInterval)
+		live := make(map[common.TxnId]goshawk.EmptyStruct)
+		for _, summary := range pd.ListProposers() {
+			live[*summary.TxnId] = goshawk.EmptyStructVal
+		}
+		for txnId := range pending {
+			if _, found := live[txnId]; !found {
+				delete(pending, txnId)
+			}
+		}
+	}
+	if len(pending) != 0 {
+		log.Printf("Warning: Drain timed out with %v proposers still live.", len(pending))
+	}
+
+	if s.databases != nil {
+		if _, err := s.databases.Flush().ResultError(); err != nil {
+			log.Printf("Warning: Unable to flush to disk whilst draining: %v", err)
+		}
+	}
+	log.Println("Drained.")
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	goshawk "goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/client"
+	"goshawkdb.io/server/configuration"
+	eng "goshawkdb.io/server/txnengine"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The gateway is a small HTTP/JSON API for reading and writing vars
+// without a client library, which is handy for scripting and
+// debugging. It is enabled with -gateway. Every request is run as a
+// single-action txn. Like the admin service, it has no
+// authentication of its own and has full access to every root, so it
+// refuses to listen on anything but a loopback address.
+//
+//	GET /roots/{name}[/{ref}...][?isolation=]
+//	                                 read the named root, or the var
+//	                                 reached by following, in turn,
+//	                                 the references with the given
+//	                                 indices from it
+//	PUT /roots/{name}[/{ref}...]     write the var
+//	GET /vars/{varUUId}?positions=[&isolation=]
+//	                                 read the var, given its positions
+//	PUT /vars/{varUUId}?positions=   write the var
+//	GET /watch?vars={varUUId},...    stream the committed writes to
+//	                                 the vars
+//
+// A GET is a read-only txn (see client.LocalConnection.ReadOnly) with
+// the given isolation: serializable (the default), which is served
+// from this node's copy of the var when it has no write in progress,
+// and confirmed by consensus otherwise; or snapshot, which is always
+// served from this node's copy. A PUT first reads
+// the var with a quorum read (see client.QuorumRead). The result of a
+// read is a gatewayVar, and its ETag is the version read. The body of
+// a PUT is a gatewayWrite; the var's references are left unchanged. A
+// PUT with If-Match is only applied if the var is still at that
+// version, otherwise it fails with 412. A PUT without If-Match is
+// applied to the version current when the request arrived, and fails
+// with 409 if the var is written in the meantime.
+//
+// A watch is a stream of gatewayWatchEvents, one line of JSON for
+// each txn which writes any of the vars, holding all of that txn's
+// writes to them. Only the vars of which this node holds a copy are
+// watched, so watch a var on a node which holds it. If the watcher
+// falls too far behind, the stream is ended.
+type gatewayServer struct {
+	s        *server
+	lc       *client.LocalConnection
+	listener net.Listener
+	topology configuration.AtomicTopology
+	shutdown chan struct{}
+}
+
+type gatewayReference struct {
+	VarUUId   string
+	Positions string
+}
+
+type gatewayVar struct {
+	VarUUId    string
+	Positions  string
+	Version    string
+	Value      []byte
+	References []gatewayReference
+}
+
+type gatewayWrite struct {
+	Value []byte
+}
+
+type gatewayWatchEvent struct {
+	Version string
+	Vars    []gatewayUpdate
+}
+
+type gatewayUpdate struct {
+	VarUUId    string
+	Value      []byte
+	References []gatewayReference
+}
+
+func newGatewayServer(s *server, addr string) (*gatewayServer, error) {
+	listener, err := listenLoopback("Gateway", addr)
+	if err != nil {
+		return nil, err
+	}
+	gs := &gatewayServer{
+		s:        s,
+		lc:       s.connectionManager.LocalConnection,
+		listener: listener,
+		shutdown: make(chan struct{}),
+	}
+	gs.topology.Store(s.connectionManager.AddTopologySubscriber(eng.ConnectionSubscriber, gs))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/roots/", gs.serveRoot)
+	mux.HandleFunc("/vars/", gs.serveVar)
+	mux.HandleFunc("/watch", gs.watch)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+			log.Printf("Error: Gateway stopped: %v", err)
+		}
+	}()
+	log.Printf("Gateway listening on %v", listener.Addr())
+	return gs, nil
+}
+
+func (gs *gatewayServer) Shutdown() {
+	gs.s.connectionManager.RemoveTopologySubscriberAsync(eng.ConnectionSubscriber, gs)
+	gs.listener.Close()
+	close(gs.shutdown)
+}
+
+func (gs *gatewayServer) TopologyChanged(topology *configuration.Topology, done func(bool)) {
+	gs.topology.Store(topology)
+	done(true)
+}
+
+func (gs *gatewayServer) serveRoot(w http.ResponseWriter, r *http.Request) {
+	if !gs.allowedMethod(w, r) {
+		return
+	}
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/roots/"), "/")
+	topology := gs.topology.Load()
+	if topology == nil || len(topology.Roots) == 0 {
+		http.Error(w, "No roots: cluster not yet formed.", http.StatusServiceUnavailable)
+		return
+	}
+	var vUUId *common.VarUUId
+	var positions *common.Positions
+	for idx, name := range topology.RootNames() {
+		if name == path[0] && idx < len(topology.Roots) {
+			vUUId, positions = topology.Roots[idx].VarUUId, topology.Roots[idx].Positions
+			break
+		}
+	}
+	if vUUId == nil {
+		http.Error(w, fmt.Sprintf("No root named %v.", path[0]), http.StatusNotFound)
+		return
+	}
+
+	readFun, ok := gs.readFun(w, r)
+	if !ok {
+		return
+	}
+	read, err := readFun(vUUId, positions)
+	for _, refStr := range path[1:] {
+		if err != nil {
+			break
+		}
+		refIdx, convErr := strconv.Atoi(refStr)
+		if convErr != nil || refIdx < 0 || refIdx >= len(read.References) {
+			http.Error(w, fmt.Sprintf("%v has no reference %v.", read.VarUUId, refStr), http.StatusNotFound)
+			return
+		}
+		ref := read.References[refIdx]
+		positions = ref.Positions
+		read, err = readFun(ref.VarUUId, positions)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	gs.serve(w, r, read, positions)
+}
+
+func (gs *gatewayServer) serveVar(w http.ResponseWriter, r *http.Request) {
+	if !gs.allowedMethod(w, r) {
+		return
+	}
+	vUUIdBytes, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/vars/"))
+	if err != nil || len(vUUIdBytes) != common.KeyLen {
+		http.Error(w, fmt.Sprintf("VarUUId must be %v hex-encoded bytes.", common.KeyLen), http.StatusBadRequest)
+		return
+	}
+	positionsBytes, err := hex.DecodeString(r.URL.Query().Get("positions"))
+	if err != nil || len(positionsBytes) == 0 {
+		http.Error(w, "positions must be the hex-encoded positions of the var.", http.StatusBadRequest)
+		return
+	}
+	positionsCap := capn.NewBuffer(nil).NewUInt8List(len(positionsBytes))
+	for idx, b := range positionsBytes {
+		positionsCap.Set(idx, b)
+	}
+	positions := (*common.Positions)(&positionsCap)
+	readFun, ok := gs.readFun(w, r)
+	if !ok {
+		return
+	}
+	read, err := readFun(common.MakeVarUUId(vUUIdBytes), positions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	gs.serve(w, r, read, positions)
+}
+
+func (gs *gatewayServer) watch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method must be GET.", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+	vUUIds := []*common.VarUUId{}
+	for _, vUUIdStr := range strings.Split(r.URL.Query().Get("vars"), ",") {
+		vUUIdBytes, err := hex.DecodeString(vUUIdStr)
+		if err != nil || len(vUUIdBytes) != common.KeyLen {
+			http.Error(w, fmt.Sprintf("vars must be a comma-separated list of VarUUIds, each %v hex-encoded bytes.", common.KeyLen), http.StatusBadRequest)
+			return
+		}
+		vUUIds = append(vUUIds, common.MakeVarUUId(vUUIdBytes))
+	}
+
+	// The subscription's VarWriteBatcher hands us all of a txn's
+	// writes at once, so each txn is one line, however many of the
+	// vars it writes. deliver is called from the vars' executors, so
+	// it must not block: if we can't keep up, we give up.
+	events := make(chan []byte, goshawk.GatewayWatchQueueLength)
+	overflowed := make(chan struct{})
+	var overflow sync.Once
+	deliver := func(txn *eng.Txn, updates []*eng.VarWriteUpdate) {
+		event, err := json.Marshal(newGatewayWatchEvent(txn.Id, updates))
+		if err != nil {
+			log.Printf("Error: Gateway unable to encode writes of %v: %v", txn.Id, err)
+			return
+		}
+		select {
+		case events <- append(event, '\n'):
+		default:
+			overflow.Do(func() { close(overflowed) })
+		}
+	}
+	sub := gs.s.connectionManager.Dispatchers.VarDispatcher.Subscribe(gs.lc.NextTxnId(), vUUIds, nil, deliver)
+	defer sub.Cancel()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case event := <-events:
+			if _, err := w.Write(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-overflowed:
+			return
+		case <-r.Context().Done():
+			return
+		case <-gs.shutdown:
+			return
+		}
+	}
+}
+
+func newGatewayWatchEvent(version *common.TxnId, updates []*eng.VarWriteUpdate) *gatewayWatchEvent {
+	event := &gatewayWatchEvent{
+		Version: hex.EncodeToString(version[:]),
+		Vars:    make([]gatewayUpdate, len(updates)),
+	}
+	for idx, update := range updates {
+		gu := gatewayUpdate{
+			VarUUId: hex.EncodeToString(update.VarUUId[:]),
+			Value:   update.Value,
+		}
+		if update.References != nil {
+			refs := update.References
+			gu.References = make([]gatewayReference, refs.Len())
+			for idy := range gu.References {
+				ref := refs.At(idy)
+				positions := ref.Positions()
+				gu.References[idy] = gatewayReference{
+					VarUUId:   hex.EncodeToString(ref.Id()),
+					Positions: hex.EncodeToString(positions.ToArray()),
+				}
+			}
+		}
+		event.Vars[idx] = gu
+	}
+	return event
+}
+
+func (gs *gatewayServer) quorumRead(vUUId *common.VarUUId, positions *common.Positions) (*client.QuorumReadResult, error) {
+	read, err := gs.lc.QuorumRead(vUUId, positions)
+	if err != nil {
+		return nil, err
+	} else if read.Missing {
+		return nil, fmt.Errorf("The value of %v at %v is not yet known; try again.", vUUId, read.TxnId)
+	}
+	return read, nil
+}
+
+type gatewayReadFun func(*common.VarUUId, *common.Positions) (*client.QuorumReadResult, error)
+
+// readFun returns how the vars of r are to be read: as a read-only
+// txn for a GET, and with a quorum read for a PUT.
+func (gs *gatewayServer) readFun(w http.ResponseWriter, r *http.Request) (gatewayReadFun, bool) {
+	if r.Method != "GET" {
+		return gs.quorumRead, true
+	}
+	isolation := client.Serializable
+	if isolationStr := r.URL.Query().Get("isolation"); isolationStr != "" {
+		var err error
+		if isolation, err = client.ParseIsolation(isolationStr); err != nil {
+			http.Error(w, "isolation must be serializable or snapshot.", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	return func(vUUId *common.VarUUId, positions *common.Positions) (*client.QuorumReadResult, error) {
+		return gs.readOnly(vUUId, positions, isolation)
+	}, true
+}
+
+// readOnly reads the var as a read-only txn. The result is in the
+// same form as that of a quorum read, which is all serve needs.
+func (gs *gatewayServer) readOnly(vUUId *common.VarUUId, positions *common.Positions, isolation client.Isolation) (*client.QuorumReadResult, error) {
+	varPosMap := map[common.VarUUId]*common.Positions{*vUUId: positions}
+	reads, err := gs.lc.ReadOnly(gs.s.connectionManager.Dispatchers.VarDispatcher, varPosMap, isolation)
+	if err != nil {
+		return nil, err
+	}
+	read := reads[0]
+	result := &client.QuorumReadResult{VarUUId: read.VarUUId, TxnId: read.TxnId, Value: read.Value}
+	if refs := read.References; refs != nil {
+		result.References = make([]client.QuorumReadReference, refs.Len())
+		for idx := range result.References {
+			ref := refs.At(idx)
+			positions := common.Positions(ref.Positions())
+			result.References[idx] = client.QuorumReadReference{
+				VarUUId:    common.MakeVarUUId(ref.Id()),
+				Positions:  &positions,
+				Capability: common.NewCapability(ref.Capability()),
+			}
+		}
+	}
+	return result, nil
+}
+
+func (gs *gatewayServer) serve(w http.ResponseWriter, r *http.Request, read *client.QuorumReadResult, positions *common.Positions) {
+	if r.Method == "GET" {
+		w.Header().Set("ETag", etag(read.TxnId))
+		gs.writeJSON(w, http.StatusOK, newGatewayVar(read.VarUUId, positions, read.TxnId, read.Value, read.References))
+		return
+	}
+
+	write := &gatewayWrite{}
+	if err := json.NewDecoder(r.Body).Decode(write); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && ifMatch != "*" && ifMatch != etag(read.TxnId) {
+		http.Error(w, fmt.Sprintf("%v is at version %v.", read.VarUUId, read.TxnId), http.StatusPreconditionFailed)
+		return
+	}
+	txnId, err := gs.readWrite(read, positions, write.Value)
+	switch {
+	case err == errGatewayConflict && ifMatch != "":
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case err == errGatewayConflict:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		w.Header().Set("ETag", etag(txnId))
+		gs.writeJSON(w, http.StatusOK, newGatewayVar(read.VarUUId, positions, txnId, write.Value, read.References))
+	}
+}
+
+var errGatewayConflict = errors.New("Var was written concurrently.")
+
+// readWrite writes value to the var read, keeping its references,
+// provided the var is still at the version read.
+func (gs *gatewayServer) readWrite(read *client.QuorumReadResult, positions *common.Positions, value []byte) (*common.TxnId, error) {
+	seg := capn.NewBuffer(nil)
+	ctxn := cmsgs.NewClientTxn(seg)
+	ctxn.SetRetry(false)
+	actions := cmsgs.NewClientActionList(seg, 1)
+	ctxn.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(read.VarUUId[:])
+	action.SetReadwrite()
+	readWrite := action.Readwrite()
+	readWrite.SetVersion(read.TxnId[:])
+	readWrite.SetValue(value)
+	varPosMap := map[common.VarUUId]*common.Positions{*read.VarUUId: positions}
+	refs := cmsgs.NewClientVarIdPosList(seg, len(read.References))
+	for idx, ref := range read.References {
+		clientRef := refs.At(idx)
+		clientRef.SetVarId(ref.VarUUId[:])
+		clientRef.SetCapability(ref.Capability.Capability)
+		varPosMap[*ref.VarUUId] = ref.Positions
+	}
+	readWrite.SetReferences(refs)
+
+	txn, outcome, err := gs.lc.RunClientTransaction(&ctxn, varPosMap, nil)
+	switch {
+	case err != nil:
+		return nil, err
+	case outcome == nil:
+		return nil, errors.New("Shutting down.")
+	case outcome.Which() != msgs.OUTCOME_COMMIT:
+		return nil, errGatewayConflict
+	default:
+		return txn.Id, nil
+	}
+}
+
+func newGatewayVar(vUUId *common.VarUUId, positions *common.Positions, version *common.TxnId, value []byte, refs []client.QuorumReadReference) *gatewayVar {
+	gv := &gatewayVar{
+		VarUUId:    hex.EncodeToString(vUUId[:]),
+		Positions:  hex.EncodeToString((*capn.UInt8List)(positions).ToArray()),
+		Version:    hex.EncodeToString(version[:]),
+		Value:      value,
+		References: make([]gatewayReference, len(refs)),
+	}
+	for idx, ref := range refs {
+		gv.References[idx] = gatewayReference{
+			VarUUId:   hex.EncodeToString(ref.VarUUId[:]),
+			Positions: hex.EncodeToString((*capn.UInt8List)(ref.Positions).ToArray()),
+		}
+	}
+	return gv
+}
+
+func etag(version *common.TxnId) string {
+	return `"` + hex.EncodeToString(version[:]) + `"`
+}
+
+func (gs *gatewayServer) allowedMethod(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "PUT" {
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "Method must be GET or PUT.", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func (gs *gatewayServer) writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	bites, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(bites)
+}
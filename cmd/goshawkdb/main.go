@@ -7,14 +7,16 @@ import (
 	"flag"
 	"fmt"
 	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/common/certs"
 	goshawk "goshawkdb.io/server"
+	"goshawkdb.io/server/client"
 	"goshawkdb.io/server/configuration"
 	"goshawkdb.io/server/db"
 	"goshawkdb.io/server/network"
 	"goshawkdb.io/server/paxos"
+	"goshawkdb.io/server/scheduler"
+	eng "goshawkdb.io/server/txnengine"
 	"io/ioutil"
 	"log"
 	"math/rand"
@@ -31,6 +33,7 @@ import (
 func main() {
 	log.SetPrefix(common.ProductName + " ")
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	log.SetOutput(goshawk.LogOutput)
 	log.Printf("GoshawkDB Version %s with %s; %v", goshawk.ServerVersion, mdb.Version(), os.Args)
 
 	if s, err := newServer(); err != nil {
@@ -44,14 +47,27 @@ func main() {
 }
 
 func newServer() (*server, error) {
-	var configFile, dataDir, certFile string
-	var port int
-	var version, genClusterCert, genClientCert bool
+	var configFile, dataDir, certFile, keyFile, storageEngine, adminAddr, gatewayAddr, pprofAddr string
+	var port, consensusShards, executors, txnChunkBytes int
+	var version, genClusterCert, genClientCert, compression bool
+	var proposerCompactionHorizon, drainTimeout, maxCommitLatency time.Duration
 
 	flag.StringVar(&configFile, "config", "", "`Path` to configuration file (required to start server).")
 	flag.StringVar(&dataDir, "dir", "", "`Path` to data directory (required to run server).")
 	flag.StringVar(&certFile, "cert", "", "`Path` to cluster certificate and key file (required to run server).")
 	flag.IntVar(&port, "port", common.DefaultPort, "Port to listen on (required if non-default).")
+	flag.StringVar(&storageEngine, "storage", db.DefaultStorageEngine, fmt.Sprintf("Storage engine to use. One of: %v.", db.StorageEngines()))
+	flag.IntVar(&executors, "executors", 0, "Number of executors over which vars, proposers and acceptors are each spread (0 uses one per CPU).")
+	flag.IntVar(&consensusShards, "consensus-shards", 0, "Number of storage environments to spread acceptor and proposer state over (0 keeps the number already in the data directory).")
+	flag.StringVar(&keyFile, "keyfile", "", "`Path` to the file of keys with which to encrypt the data directory. Unencrypted if empty.")
+	flag.BoolVar(&compression, "compress", false, "Compress txns as they are written to disk, and large messages sent to other RMs. Txns already on disk are read whether compressed or not.")
+	flag.IntVar(&txnChunkBytes, "txn-chunk-bytes", goshawk.DBTxnChunkBytes, "Txns longer than this many bytes, as written to disk, are split into chunks of this size (negative disables).")
+	flag.DurationVar(&maxCommitLatency, "max-commit-latency", goshawk.DBMaxCommitLatency, "How long writes may wait to be batched together into a single commit and sync to disk.")
+	flag.DurationVar(&proposerCompactionHorizon, "proposer-compaction-horizon", goshawk.ProposerCompactionHorizon, "How long a proposer record must be orphaned before it is deleted from disk (0 disables).")
+	flag.DurationVar(&drainTimeout, "drain-timeout", goshawk.DrainTimeout, "On shutdown, how long to wait for in-flight txns to finish (0 disables draining).")
+	flag.StringVar(&adminAddr, "admin", "", "`Address` (host:port) for the admin service to listen on. It has no authentication, so must be a loopback address. Disabled if empty.")
+	flag.StringVar(&pprofAddr, "pprof", "", "`Address` (host:port) for net/http/pprof to listen on. Must be a loopback address. Disabled if empty.")
+	flag.StringVar(&gatewayAddr, "gateway", "", "`Address` (host:port) for the HTTP/JSON gateway to listen on. It has no authentication, so must be a loopback address. Disabled if empty.")
 	flag.BoolVar(&version, "version", false, "Display version and exit.")
 	flag.BoolVar(&genClusterCert, "gen-cluster-cert", false, "Generate new cluster certificate key pair.")
 	flag.BoolVar(&genClientCert, "gen-client-cert", false, "Generate client certificate key pair.")
@@ -113,13 +129,30 @@ func newServer() (*server, error) {
 		return nil, fmt.Errorf("Supplied port is illegal (%v). Port must be > 0 and < 65536", port)
 	}
 
+	if !(0 <= executors && executors < 256) {
+		return nil, fmt.Errorf("Supplied executor count is illegal (%v). It must be >= 0 and < 256", executors)
+	}
+
 	s := &server{
-		configFile:   configFile,
-		certificate:  certificate,
-		dataDir:      dataDir,
-		port:         uint16(port),
-		onShutdown:   []func(){},
-		shutdownChan: make(chan goshawk.EmptyStruct),
+		configFile:        configFile,
+		certFile:          certFile,
+		certificate:       certificate,
+		dataDir:           dataDir,
+		storageEngine:     storageEngine,
+		consensusShards:   consensusShards,
+		executors:         executors,
+		maxCommitLatency:  maxCommitLatency,
+		compression:       compression,
+		txnChunkBytes:     txnChunkBytes,
+		keyFile:           keyFile,
+		port:              uint16(port),
+		compactionHorizon: proposerCompactionHorizon,
+		drainTimeout:      drainTimeout,
+		adminAddr:         adminAddr,
+		gatewayAddr:       gatewayAddr,
+		pprofAddr:         pprofAddr,
+		onShutdown:        []func(){},
+		shutdownChan:      make(chan goshawk.EmptyStruct),
 	}
 
 	if err = s.ensureRMId(); err != nil {
@@ -134,13 +167,31 @@ func newServer() (*server, error) {
 
 type server struct {
 	configFile        string
+	certFile          string
 	certificate       []byte
+	certWatcher       certificateWatcher
 	dataDir           string
+	storageEngine     string
+	consensusShards   int
+	executors         int
+	maxCommitLatency  time.Duration
+	compression       bool
+	txnChunkBytes     int
+	keyFile           string
 	port              uint16
+	compactionHorizon time.Duration
+	drainTimeout      time.Duration
+	adminAddr         string
+	gatewayAddr       string
+	pprofAddr         string
 	rmId              common.RMId
 	bootCount         uint32
+	databases         *db.Databases
 	connectionManager *network.ConnectionManager
 	transmogrifier    *network.TopologyTransmogrifier
+	scheduler         *scheduler.Scheduler
+	prober            *client.Prober
+	scrubber          *eng.Scrubber
 	profileFile       *os.File
 	traceFile         *os.File
 	onShutdown        []func()
@@ -160,6 +211,11 @@ func (s *server) start() {
 	commandLineConfig, err := s.commandLineConfig()
 	s.maybeShutdown(err)
 
+	s.scheduler = scheduler.NewScheduler()
+
+	if info, err := os.Stat(s.certFile); err == nil {
+		s.certWatcher.modTime, s.certWatcher.size = info.ModTime(), info.Size()
+	}
 	nodeCertPrivKeyPair, err := certs.GenerateNodeCertificatePrivateKeyPair(s.certificate)
 	for idx := range s.certificate {
 		s.certificate[idx] = 0
@@ -167,22 +223,63 @@ func (s *server) start() {
 	s.certificate = nil
 	s.maybeShutdown(err)
 
-	disk, err := mdbs.NewMDBServer(s.dataDir, 0, 0600, goshawk.MDBInitialSize, procs/2, time.Millisecond, db.DB)
+	var keys *db.Keyring
+	if s.keyFile != "" {
+		keys, err = db.LoadKeyring(s.keyFile)
+		s.maybeShutdown(err)
+	}
+	db, err := db.Open(s.storageEngine, s.dataDir, &db.Options{
+		Concurrency:      procs / 2,
+		ConsensusShards:  s.consensusShards,
+		MaxCommitLatency: s.maxCommitLatency,
+		Compression:      s.compression,
+		TxnChunkBytes:    s.txnChunkBytes,
+		Keys:             keys,
+	})
 	s.maybeShutdown(err)
-	db := disk.(*db.Databases)
 	s.addOnShutdown(db.Shutdown)
+	s.databases = db
 
-	cm, transmogrifier := network.NewConnectionManager(s.rmId, s.bootCount, procs, db, nodeCertPrivKeyPair, s.port, s, commandLineConfig)
+	executors := s.executors
+	if executors == 0 {
+		executors = procs
+	}
+	cm, transmogrifier := network.NewConnectionManager(s.rmId, s.bootCount, executors, db, nodeCertPrivKeyPair, s.port, s, commandLineConfig)
 	s.addOnShutdown(func() { cm.Shutdown(paxos.Sync) })
 	s.addOnShutdown(transmogrifier.Shutdown)
 	s.connectionManager = cm
 	s.transmogrifier = transmogrifier
+	s.prober = client.NewProber(cm.LocalConnection)
+	s.maybeShutdown(s.scheduler.Add("TxnProbe", goshawk.TxnProbeInterval, true, s.prober.Probe))
+	s.maybeShutdown(s.scheduler.Add("ProposerCompaction", goshawk.ProposerCompactionInterval, s.compactionHorizon > 0, s.compactProposers))
+	s.maybeShutdown(s.scheduler.Add("CertificateWatch", goshawk.CertificateWatchInterval, true, s.watchCertificate))
+	s.scrubber = eng.NewScrubber(db)
+	s.maybeShutdown(s.scheduler.Add("Scrub", goshawk.ScrubInterval, true, s.scrubber.Scrub))
+	s.maybeShutdown(s.scheduler.Add("ClientOutcomeExpiry", goshawk.ClientOutcomeExpiryInterval, true, cm.ClientOutcomes.Expire))
+	s.maybeShutdown(s.scheduler.Add("HealthCheck", goshawk.HealthCheckInterval, true, func() { cm.CheckHealth(s.dataDir) }))
 
 	go s.signalHandler()
 
 	listener, err := network.NewListener(s.port, cm)
 	s.maybeShutdown(err)
 	s.addOnShutdown(listener.Shutdown)
+	if s.adminAddr != "" {
+		admin, err := newAdminServer(s, s.adminAddr)
+		s.maybeShutdown(err)
+		s.addOnShutdown(admin.Shutdown)
+	}
+	if s.gatewayAddr != "" {
+		gateway, err := newGatewayServer(s, s.gatewayAddr)
+		s.maybeShutdown(err)
+		s.addOnShutdown(gateway.Shutdown)
+	}
+	if s.pprofAddr != "" {
+		profiling, err := newProfilingServer(s.pprofAddr)
+		s.maybeShutdown(err)
+		s.addOnShutdown(profiling.Shutdown)
+	}
+	// jobs may well use everything else, so stop them first.
+	s.addOnShutdown(s.scheduler.Shutdown)
 
 	defer s.shutdown(nil)
 	<-s.shutdownChan
@@ -195,9 +292,14 @@ func (s *server) addOnShutdown(f func()) {
 }
 
 func (s *server) shutdown(err error) {
+	goshawk.LifecyclePhaseReached(goshawk.PreDrain)
+	if err == nil {
+		s.drain()
+	}
 	for idx := len(s.onShutdown) - 1; idx >= 0; idx-- {
 		s.onShutdown[idx]()
 	}
+	goshawk.LifecyclePhaseReached(goshawk.PostShutdown)
 	if err == nil {
 		log.Println("Shutdown.")
 	} else {
@@ -211,6 +313,12 @@ func (s *server) maybeShutdown(err error) {
 	}
 }
 
+func (s *server) compactProposers() {
+	if err := s.connectionManager.Dispatchers.ProposerDispatcher.Compact(s.compactionHorizon); err != nil {
+		log.Printf("Error: Proposer compaction failed: %v", err)
+	}
+}
+
 func (s *server) ensureRMId() error {
 	path := s.dataDir + "/rmid"
 	if b, err := ioutil.ReadFile(path); err == nil {
@@ -256,14 +364,43 @@ func (s *server) SignalShutdown() {
 }
 
 func (s *server) signalStatus() {
-	sc := goshawk.NewStatusConsumer()
-	go sc.Consume(func(str string) {
-		log.Printf("System Status for %v\n%v\nStatus End\n", s.rmId, str)
+	ok := s.collectStatus(func(sc *goshawk.StatusConsumer, done func()) {
+		sc.Consume(func(str string) {
+			log.Printf("System Status for %v\n%v\nStatus End\n", s.rmId, str)
+			done()
+		})
 	})
+	if !ok {
+		log.Println("Status collection already in progress; ignoring request.")
+	}
+}
+
+// collectStatus collects the status of every part of the server into
+// a new StatusConsumer, which is passed to consume on a new
+// go-routine. consume must call done once it has consumed the
+// status. collectStatus returns false if too many status collections
+// are already in progress.
+func (s *server) collectStatus(consume func(sc *goshawk.StatusConsumer, done func())) bool {
+	done, ok := goshawk.StartStatusCollection()
+	if !ok {
+		return false
+	}
+	sc := goshawk.NewStatusConsumer()
+	go consume(sc, done)
 	sc.Emit(fmt.Sprintf("Configuration File: %v", s.configFile))
 	sc.Emit(fmt.Sprintf("Data Directory: %v", s.dataDir))
 	sc.Emit(fmt.Sprintf("Port: %v", s.port))
+	goshawk.AnnotationStatus(sc.Fork())
+	goshawk.SubsystemLoggingStatus(sc.Fork())
+	goshawk.LogSinksStatus(sc.Fork())
+	db.Stats.Status(sc.Fork())
+	s.scheduler.Status(sc.Fork())
+	s.prober.Status(sc.Fork())
+	s.scrubber.Status(sc.Fork())
+	s.connectionManager.ClientOutcomes.Status(sc.Fork())
+	s.databases.Keys.Status(sc.Fork())
 	s.connectionManager.Status(sc)
+	return true
 }
 
 func (s *server) signalReloadConfig() {
@@ -276,6 +413,7 @@ func (s *server) signalReloadConfig() {
 		log.Println("Cannot reload config due to error:", err)
 		return
 	}
+	s.databases.Audit.Record("configuration", "Configuration version %v reloaded from %v (signal)", config.Version, s.configFile)
 	s.transmogrifier.RequestConfigurationChange(config)
 }
 
@@ -361,6 +499,9 @@ func (s *server) signalHandler() {
 			s.SignalShutdown()
 		case syscall.SIGHUP:
 			s.signalReloadConfig()
+			if err := s.reloadCertificate(); err != nil {
+				log.Println("Cannot reload certificate due to error:", err)
+			}
 		case syscall.SIGQUIT:
 			s.signalDumpStacks()
 		case syscall.SIGUSR1:
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"goshawkdb.io/common"
+	goshawk "goshawkdb.io/server"
+	"goshawkdb.io/server/db"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	httppprof "net/http/pprof"
+	"os"
+	"runtime/trace"
+	"strings"
+	"time"
+)
+
+// The profiling service serves net/http/pprof, enabled with
+// -pprof. Like the admin service, it refuses to listen on anything
+// but a loopback address: profiles and traces expose a great deal
+// about the process, and a CPU profile or trace slows it down for as
+// long as it runs.
+type profilingServer struct {
+	listener net.Listener
+}
+
+// listenLoopback listens on addr, which must be a loopback address,
+// for the named service.
+func listenLoopback(service, addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%v must listen on a loopback address, not %v", service, addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func newProfilingServer(addr string) (*profilingServer, error) {
+	listener, err := listenLoopback("Profiling service", addr)
+	if err != nil {
+		return nil, err
+	}
+	ps := &profilingServer{listener: listener}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+			log.Printf("Error: Profiling service stopped: %v", err)
+		}
+	}()
+	log.Printf("Profiling service listening on %v", listener.Addr())
+	return ps, nil
+}
+
+func (ps *profilingServer) Shutdown() {
+	ps.listener.Close()
+}
+
+// TraceCapture describes an execution trace captured by
+// captureTrace. The throughput of each table over the same window is
+// recorded alongside, to show how busy the node was: Transactions
+// writes are txns applied to vars, BallotOutcomes writes are txns
+// voted on by this node's acceptors.
+type TraceCapture struct {
+	Path       string
+	Started    time.Time
+	Duration   time.Duration
+	Throughput map[string]*TableThroughput
+}
+
+type TableThroughput struct {
+	Writes          uint64
+	Deletes         uint64
+	WritesPerSecond float64
+}
+
+func tableMetrics() map[string]*db.DBIMetrics {
+	return map[string]*db.DBIMetrics{
+		"Vars":           &db.Stats.Vars,
+		"Proposers":      &db.Stats.Proposers,
+		"BallotOutcomes": &db.Stats.BallotOutcomes,
+		"Transactions":   &db.Stats.Transactions,
+	}
+}
+
+// captureTrace captures an execution trace of the given duration to a
+// new temporary file, and writes the TraceCapture, as JSON, to a file
+// alongside it. Only one trace can run at a time, including one
+// started by SIGUSR2.
+func (s *server) captureTrace(duration time.Duration) (*TraceCapture, error) {
+	if duration <= 0 || duration > goshawk.TraceCaptureMaxDuration {
+		return nil, fmt.Errorf("Duration must be greater than 0 and at most %v.", goshawk.TraceCaptureMaxDuration)
+	}
+	traceFile, err := ioutil.TempFile("", common.ProductName+"_Trace_")
+	if err != nil {
+		return nil, err
+	}
+	metrics := tableMetrics()
+	before := make(map[string]db.DBIMetricsSnapshot, len(metrics))
+	for name, m := range metrics {
+		before[name] = m.Snapshot()
+	}
+	capture := &TraceCapture{
+		Path:       traceFile.Name(),
+		Started:    time.Now(),
+		Throughput: make(map[string]*TableThroughput, len(metrics)),
+	}
+	if err = trace.Start(traceFile); err != nil {
+		traceFile.Close()
+		os.Remove(traceFile.Name())
+		return nil, err
+	}
+	log.Printf("Tracing started in %v for %v.", capture.Path, duration)
+	time.Sleep(duration)
+	trace.Stop()
+	capture.Duration = time.Now().Sub(capture.Started)
+	if err = traceFile.Close(); err != nil {
+		return nil, err
+	}
+	log.Printf("Tracing stopped in %v.", capture.Path)
+
+	for name, m := range metrics {
+		after := m.Snapshot()
+		writes := after.Writes - before[name].Writes
+		capture.Throughput[name] = &TableThroughput{
+			Writes:          writes,
+			Deletes:         after.Deletes - before[name].Deletes,
+			WritesPerSecond: float64(writes) / capture.Duration.Seconds(),
+		}
+	}
+	annotation, err := json.MarshalIndent(capture, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(capture.Path+".json", annotation, 0640)
+	}
+	return capture, err
+}
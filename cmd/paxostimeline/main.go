@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"goshawkdb.io/common"
+	"html"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// paxostimeline reads the logs of several RMs, written with the
+// paxos subsystem's debug logging enabled, and renders the messages
+// each RM received for each txn (1A, 1B, 2A, 2B, outcomes, TLC, TGC,
+// TSC and TSA) as a message sequence diagram, in an HTML file with
+// an SVG per txn.
+//
+// Each log must be labelled with the RMId of the RM which wrote it,
+// as that RMId appears in the logs (the sender of each message is
+// taken from the logs):
+//
+//	paxostimeline -out timeline.html RM:1=rm1.log RM:2=rm2.log
+//
+// Only received messages are logged with their sender, so each arrow
+// is placed at the time of its receipt. The RMs' clocks are not
+// synchronised, so events on different RMs are ordered by their local
+// timestamps, which may not be the order in which they happened.
+
+func main() {
+	log.SetPrefix(common.ProductName + "PaxosTimeline ")
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+
+	var out, txnFilter string
+	flag.StringVar(&out, "out", "timeline.html", "`Path` of the HTML file to write.")
+	flag.StringVar(&txnFilter, "txn", "", "Only render the txn with this `TxnId`, as it appears in the logs.")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal("No logs supplied. Each must be given as RMId=path.")
+	}
+
+	tl := newTimeline()
+	for _, arg := range flag.Args() {
+		idx := strings.Index(arg, "=")
+		if idx <= 0 {
+			log.Fatalf("Log %v must be given as RMId=path.", arg)
+		}
+		rm, path := arg[:idx], arg[idx+1:]
+		if err := tl.loadFile(rm, path, txnFilter); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = tl.render(f); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Rendered %v txns to %v.", len(tl.txns), out)
+}
+
+const logTimeLayout = "2006/01/02 15:04:05.000000"
+
+// Matches the lines logged by the proposer and acceptor managers on
+// receipt of a message, which start with the time, the subsystem
+// name (absent from lines logged by debug builds), and the rm and txn
+// fields (older logs have just the TxnId), and end with the message's
+// sender.
+var receivedRegexp = regexp.MustCompile(
+	`^` + common.ProductName + ` (\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}\.\d{6}) (?:paxos: )?(?:rm=\S+ )?(?:txn=)?(\S+) (1A|1B|2A|2B outcome|2B|TLC|TGC|TSC|TSA) received from (\S+)`)
+
+type event struct {
+	time     time.Time
+	kind     string
+	sender   string
+	receiver string
+}
+
+type events []*event
+
+func (es events) Len() int           { return len(es) }
+func (es events) Less(i, j int) bool { return es[i].time.Before(es[j].time) }
+func (es events) Swap(i, j int)      { es[i], es[j] = es[j], es[i] }
+
+// txnOrder orders txns by their first events, which must be sorted.
+type txnOrder struct {
+	txnIds []string
+	txns   map[string]events
+}
+
+func (to *txnOrder) Len() int { return len(to.txnIds) }
+func (to *txnOrder) Less(i, j int) bool {
+	return to.txns[to.txnIds[i]][0].time.Before(to.txns[to.txnIds[j]][0].time)
+}
+func (to *txnOrder) Swap(i, j int) { to.txnIds[i], to.txnIds[j] = to.txnIds[j], to.txnIds[i] }
+
+type timeline struct {
+	rms    []string
+	rmSet  map[string]bool
+	txns   map[string]events
+	txnIds []string
+}
+
+func newTimeline() *timeline {
+	return &timeline{
+		rmSet: make(map[string]bool),
+		txns:  make(map[string]events),
+	}
+}
+
+func (tl *timeline) addRM(rm string) {
+	if !tl.rmSet[rm] {
+		tl.rmSet[rm] = true
+		tl.rms = append(tl.rms, rm)
+	}
+}
+
+func (tl *timeline) loadFile(rm, path, txnFilter string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	count, err := tl.load(rm, f, txnFilter)
+	if err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	log.Printf("Loaded %v events received by %v from %v.", count, rm, path)
+	return nil
+}
+
+func (tl *timeline) load(rm string, r io.Reader, txnFilter string) (int, error) {
+	tl.addRM(rm)
+	count := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		match := receivedRegexp.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		txnId := match[2]
+		if txnFilter != "" && txnId != txnFilter {
+			continue
+		}
+		t, err := time.Parse(logTimeLayout, match[1])
+		if err != nil {
+			return count, err
+		}
+		sender := match[4]
+		tl.addRM(sender)
+		if _, found := tl.txns[txnId]; !found {
+			tl.txnIds = append(tl.txnIds, txnId)
+		}
+		tl.txns[txnId] = append(tl.txns[txnId], &event{
+			time:     t,
+			kind:     match[3],
+			sender:   sender,
+			receiver: rm,
+		})
+		count++
+	}
+	return count, scanner.Err()
+}
+
+const (
+	columnWidth = 160
+	rowHeight   = 28
+	headerRows  = 2
+)
+
+var kindColours = map[string]string{
+	"1A":         "#1f77b4",
+	"1B":         "#aec7e8",
+	"2A":         "#2ca02c",
+	"2B":         "#98df8a",
+	"2B outcome": "#d62728",
+	"TLC":        "#9467bd",
+	"TGC":        "#8c564b",
+	"TSC":        "#e377c2",
+	"TSA":        "#7f7f7f",
+}
+
+// render writes an HTML page with a diagram for each txn, in order of
+// each txn's first event.
+func (tl *timeline) render(w io.Writer) error {
+	for _, txnId := range tl.txnIds {
+		sort.Stable(tl.txns[txnId])
+	}
+	sort.Stable(&txnOrder{txnIds: tl.txnIds, txns: tl.txns})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Paxos timeline</title></head><body style=\"font-family: sans-serif\">")
+	for _, txnId := range tl.txnIds {
+		tl.renderTxn(bw, txnId, tl.txns[txnId])
+	}
+	fmt.Fprintln(bw, "</body></html>")
+	return bw.Flush()
+}
+
+func (tl *timeline) renderTxn(w io.Writer, txnId string, es events) {
+	columns := make(map[string]int, len(tl.rms))
+	for idx, rm := range tl.rms {
+		columns[rm] = idx
+	}
+	width := columnWidth * len(tl.rms)
+	height := rowHeight * (len(es) + headerRows + 1)
+	x := func(rm string) int { return columnWidth*columns[rm] + columnWidth/2 }
+
+	fmt.Fprintf(w, "<h2>%v</h2>\n", html.EscapeString(txnId))
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%v\" height=\"%v\" font-size=\"12\">\n", width+columnWidth, height)
+	fmt.Fprintln(w, "<defs><marker id=\"arrow\" markerWidth=\"10\" markerHeight=\"10\" refX=\"9\" refY=\"3\" orient=\"auto\"><path d=\"M0,0 L0,6 L9,3 z\"/></marker></defs>")
+	for _, rm := range tl.rms {
+		fmt.Fprintf(w, "<text x=\"%v\" y=\"%v\" text-anchor=\"middle\" font-weight=\"bold\">%v</text>\n", x(rm), rowHeight, html.EscapeString(rm))
+		fmt.Fprintf(w, "<line x1=\"%v\" y1=\"%v\" x2=\"%v\" y2=\"%v\" stroke=\"#ccc\"/>\n", x(rm), rowHeight+6, x(rm), height)
+	}
+	start := es[0].time
+	for idx, e := range es {
+		y := rowHeight * (idx + headerRows + 1)
+		colour := kindColours[e.kind]
+		label := fmt.Sprintf("%v +%v", e.kind, e.time.Sub(start))
+		from, to := x(e.sender), x(e.receiver)
+		if from == to {
+			fmt.Fprintf(w, "<circle cx=\"%v\" cy=\"%v\" r=\"4\" fill=\"%v\"/>\n", to, y, colour)
+			fmt.Fprintf(w, "<text x=\"%v\" y=\"%v\" fill=\"%v\">%v</text>\n", to+8, y+4, colour, html.EscapeString(label))
+			continue
+		}
+		fmt.Fprintf(w, "<line x1=\"%v\" y1=\"%v\" x2=\"%v\" y2=\"%v\" stroke=\"%v\" marker-end=\"url(#arrow)\"/>\n", from, y, to, y, colour)
+		fmt.Fprintf(w, "<text x=\"%v\" y=\"%v\" text-anchor=\"middle\" fill=\"%v\">%v</text>\n", (from+to)/2, y-4, colour, html.EscapeString(label))
+	}
+	fmt.Fprintln(w, "</svg>")
+}
@@ -12,6 +12,7 @@ import (
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	ch "goshawkdb.io/server/consistenthash"
+	"io"
 	"math/rand"
 	"net"
 	"os"
@@ -28,6 +29,12 @@ type Configuration struct {
 	MaxRMCount                    uint16
 	NoSync                        bool
 	ClientCertificateFingerprints map[string]map[string]*RootCapability
+	Accounts                      map[string]map[string]*RootCapability
+	ClientCertificateAccounts     map[string]string
+	MaxTxnFanOut                  uint16
+	MaxResubmits                  uint16
+	Zones                         map[string]string
+	Witnesses                     []string
 	clusterUUId                   uint64
 	roots                         []string
 	rms                           common.RMIds
@@ -150,7 +157,11 @@ func LoadConfigurationFromPath(path string) (*Configuration, error) {
 		return nil, err
 	}
 	defer file.Close()
-	decoder := json.NewDecoder(file)
+	return LoadConfigurationFromReader(file)
+}
+
+func LoadConfigurationFromReader(r io.Reader) (*Configuration, error) {
+	decoder := json.NewDecoder(r)
 	config, err := decodeConfiguration(decoder)
 	if err != nil {
 		return nil, err
@@ -178,28 +189,70 @@ func decodeConfiguration(decoder *json.Decoder) (*Configuration, error) {
 		return nil, fmt.Errorf("F given as %v, requires minimum 2F+1=%v hosts but only %v hosts specified.",
 			config.F, twoFInc, len(config.Hosts))
 	}
+	if config.MaxTxnFanOut != 0 && int(config.MaxTxnFanOut) < twoFInc {
+		return nil, fmt.Errorf("MaxTxnFanOut given as %v but must be 0 (unlimited) or at least 2F+1=%v.", config.MaxTxnFanOut, twoFInc)
+	}
 	if int(config.MaxRMCount) < len(config.Hosts) {
 		return nil, fmt.Errorf("MaxRMCount given as %v but must be at least the number of hosts (%v).", config.MaxRMCount, len(config.Hosts))
 	}
+	hosts := make(map[string]server.EmptyStruct, len(config.Hosts))
 	for idx, hostPort := range config.Hosts {
-		port := common.DefaultPort
-		hostOnly := hostPort
-		if host, portStr, err := net.SplitHostPort(hostPort); err == nil {
-			portInt64, err := strconv.ParseUint(portStr, 0, 16)
-			if err != nil {
-				return nil, err
-			}
-			port = int(portInt64)
-			hostOnly = host
+		hostPort, err := normaliseHostPort(hostPort)
+		if err != nil {
+			return nil, err
 		}
-		hostPort = net.JoinHostPort(hostOnly, fmt.Sprint(port))
 		config.Hosts[idx] = hostPort
+		hosts[hostPort] = server.EmptyStructVal
 		if _, err := net.ResolveTCPAddr("tcp", hostPort); err != nil {
 			return nil, err
 		}
 	}
+	if len(config.Zones) != 0 {
+		zones := make(map[string]string, len(config.Zones))
+		for hostPort, zone := range config.Zones {
+			hostPort, err := normaliseHostPort(hostPort)
+			if err != nil {
+				return nil, err
+			}
+			if _, found := hosts[hostPort]; !found {
+				return nil, fmt.Errorf("Zones: host %s is not in Hosts.", hostPort)
+			} else if zone == "" {
+				return nil, fmt.Errorf("Zones: host %s has an empty zone.", hostPort)
+			}
+			zones[hostPort] = zone
+		}
+		config.Zones = zones
+	} else {
+		config.Zones = nil
+	}
+	if len(config.Witnesses) != 0 {
+		witnesses := make(map[string]server.EmptyStruct, len(config.Witnesses))
+		for idx, hostPort := range config.Witnesses {
+			hostPort, err := normaliseHostPort(hostPort)
+			if err != nil {
+				return nil, err
+			}
+			if _, found := hosts[hostPort]; !found {
+				return nil, fmt.Errorf("Witnesses: host %s is not in Hosts.", hostPort)
+			} else if _, found := witnesses[hostPort]; found {
+				return nil, fmt.Errorf("Witnesses: host %s is listed more than once.", hostPort)
+			}
+			witnesses[hostPort] = server.EmptyStructVal
+			config.Witnesses[idx] = hostPort
+		}
+		if dataHosts := len(config.Hosts) - len(witnesses); twoFInc > dataHosts {
+			return nil, fmt.Errorf("F given as %v, requires minimum 2F+1=%v hosts which are not witnesses, but only %v such hosts specified.",
+				config.F, twoFInc, dataHosts)
+		}
+		sort.Strings(config.Witnesses)
+	} else {
+		config.Witnesses = nil
+	}
+	if err := config.expandAccounts(); err != nil {
+		return nil, err
+	}
 	if len(config.ClientCertificateFingerprints) == 0 {
-		return nil, errors.New("No ClientCertificateFingerprints defined")
+		return nil, errors.New("No ClientCertificateFingerprints or ClientCertificateAccounts defined")
 	} else {
 		rootsMap := make(map[string]server.EmptyStruct)
 		rootsName := []string{}
@@ -256,15 +309,72 @@ func decodeConfiguration(decoder *json.Decoder) (*Configuration, error) {
 	return &config, err
 }
 
+func normaliseHostPort(hostPort string) (string, error) {
+	port := common.DefaultPort
+	hostOnly := hostPort
+	if host, portStr, err := net.SplitHostPort(hostPort); err == nil {
+		portInt64, err := strconv.ParseUint(portStr, 0, 16)
+		if err != nil {
+			return "", err
+		}
+		port = int(portInt64)
+		hostOnly = host
+	}
+	return net.JoinHostPort(hostOnly, fmt.Sprint(port)), nil
+}
+
+// expandAccounts grants every client certificate in
+// ClientCertificateAccounts the capabilities of its account, by adding
+// it to ClientCertificateFingerprints. So accounts are only a
+// convenience of the configuration file: once validated, a
+// configuration knows only of fingerprints and their capabilities.
+func (config *Configuration) expandAccounts() error {
+	for account, rootsCapability := range config.Accounts {
+		if len(rootsCapability) == 0 {
+			return fmt.Errorf("No roots configured for account %v; at least 1 needed", account)
+		}
+	}
+	if len(config.ClientCertificateAccounts) > 0 && config.ClientCertificateFingerprints == nil {
+		config.ClientCertificateFingerprints = make(map[string]map[string]*RootCapability, len(config.ClientCertificateAccounts))
+	}
+	for fingerprint, account := range config.ClientCertificateAccounts {
+		rootsCapability, found := config.Accounts[account]
+		if !found {
+			return fmt.Errorf("Client fingerprint %v: unknown account %v", fingerprint, account)
+		} else if _, found := config.ClientCertificateFingerprints[fingerprint]; found {
+			return fmt.Errorf("Client fingerprint %v is given both an account and roots of its own", fingerprint)
+		}
+		config.ClientCertificateFingerprints[fingerprint] = rootsCapability
+	}
+	config.Accounts = nil
+	config.ClientCertificateAccounts = nil
+	return nil
+}
+
 func ConfigurationFromCap(config *msgs.Configuration) *Configuration {
 	c := &Configuration{
-		ClusterId:   config.ClusterId(),
-		clusterUUId: config.ClusterUUId(),
-		Version:     config.Version(),
-		Hosts:       config.Hosts().ToArray(),
-		F:           config.F(),
-		MaxRMCount:  config.MaxRMCount(),
-		NoSync:      config.NoSync(),
+		ClusterId:    config.ClusterId(),
+		clusterUUId:  config.ClusterUUId(),
+		Version:      config.Version(),
+		Hosts:        config.Hosts().ToArray(),
+		F:            config.F(),
+		MaxRMCount:   config.MaxRMCount(),
+		NoSync:       config.NoSync(),
+		MaxTxnFanOut: config.MaxTxnFanOut(),
+		MaxResubmits: config.MaxResubmits(),
+	}
+
+	if witnesses := config.Witnesses(); witnesses.Len() != 0 {
+		c.Witnesses = witnesses.ToArray()
+	}
+
+	if zones := config.Zones(); zones.Len() != 0 {
+		c.Zones = make(map[string]string, zones.Len())
+		for idx, zone := range zones.ToArray() {
+			if zone != "" && idx < len(c.Hosts) {
+				c.Zones[c.Hosts[idx]] = zone
+			}
+		}
 	}
 
 	rms := config.Rms()
@@ -364,7 +474,7 @@ func (a *Configuration) Equal(b *Configuration) bool {
 	if a == nil || b == nil {
 		return a == b
 	}
-	if !(a.ClusterId == b.ClusterId && a.clusterUUId == b.clusterUUId && a.Version == b.Version && a.F == b.F && a.MaxRMCount == b.MaxRMCount && a.NoSync == b.NoSync && len(a.Hosts) == len(b.Hosts) && len(a.fingerprints) == len(b.fingerprints) && len(a.rms) == len(b.rms) && len(a.rmsRemoved) == len(b.rmsRemoved)) {
+	if !(a.ClusterId == b.ClusterId && a.clusterUUId == b.clusterUUId && a.Version == b.Version && a.F == b.F && a.MaxRMCount == b.MaxRMCount && a.NoSync == b.NoSync && a.MaxTxnFanOut == b.MaxTxnFanOut && a.MaxResubmits == b.MaxResubmits && len(a.Hosts) == len(b.Hosts) && len(a.fingerprints) == len(b.fingerprints) && len(a.rms) == len(b.rms) && len(a.rmsRemoved) == len(b.rmsRemoved) && len(a.Zones) == len(b.Zones) && len(a.Witnesses) == len(b.Witnesses)) {
 		return false
 	}
 	for idx, aHost := range a.Hosts {
@@ -372,6 +482,16 @@ func (a *Configuration) Equal(b *Configuration) bool {
 			return false
 		}
 	}
+	for idx, aWitness := range a.Witnesses {
+		if aWitness != b.Witnesses[idx] {
+			return false
+		}
+	}
+	for host, aZone := range a.Zones {
+		if bZone, found := b.Zones[host]; !found || aZone != bZone {
+			return false
+		}
+	}
 	for idx, aRM := range a.rms {
 		if aRM != b.rms[idx] {
 			return false
@@ -397,8 +517,8 @@ func (a *Configuration) Equal(b *Configuration) bool {
 }
 
 func (config *Configuration) String() string {
-	return fmt.Sprintf("Configuration{ClusterId: %v(%v), Version: %v, Hosts: %v, F: %v, MaxRMCount: %v, NoSync: %v, RMs: %v, Removed: %v, RootNames: %v, %v}",
-		config.ClusterId, config.clusterUUId, config.Version, config.Hosts, config.F, config.MaxRMCount, config.NoSync, config.rms, config.rmsRemoved, config.roots, config.nextConfiguration)
+	return fmt.Sprintf("Configuration{ClusterId: %v(%v), Version: %v, Hosts: %v, F: %v, MaxRMCount: %v, NoSync: %v, MaxTxnFanOut: %v, MaxResubmits: %v, Zones: %v, Witnesses: %v, RMs: %v, Removed: %v, RootNames: %v, %v}",
+		config.ClusterId, config.clusterUUId, config.Version, config.Hosts, config.F, config.MaxRMCount, config.NoSync, config.MaxTxnFanOut, config.MaxResubmits, config.Zones, config.Witnesses, config.rms, config.rmsRemoved, config.roots, config.nextConfiguration)
 }
 
 func (config *Configuration) ClusterUUId() uint64 {
@@ -466,6 +586,63 @@ func (config *Configuration) SetRMs(rms common.RMIds) {
 	config.rms = rms
 }
 
+// RMZones returns the zone of every RM which has one, or nil if no
+// zones are configured. It relies on the non-empty RMs being in the
+// same order as Hosts.
+func (config *Configuration) RMZones() map[common.RMId]string {
+	if len(config.Zones) == 0 {
+		return nil
+	}
+	rmIds := config.rms.NonEmpty()
+	zones := make(map[common.RMId]string, len(config.Zones))
+	for idx, host := range config.Hosts {
+		if zone, found := config.Zones[host]; found && idx < len(rmIds) {
+			zones[rmIds[idx]] = zone
+		}
+	}
+	return zones
+}
+
+// WitnessRMs returns the RMs of the Witnesses. A witness is an
+// acceptor for every txn, but never holds vars.
+func (config *Configuration) WitnessRMs() common.RMIds {
+	if len(config.Witnesses) == 0 {
+		return nil
+	}
+	witnesses := make(map[string]server.EmptyStruct, len(config.Witnesses))
+	for _, host := range config.Witnesses {
+		witnesses[host] = server.EmptyStructVal
+	}
+	rmIds := config.rms.NonEmpty()
+	witnessRMs := make([]common.RMId, 0, len(config.Witnesses))
+	for idx, host := range config.Hosts {
+		if _, found := witnesses[host]; found && idx < len(rmIds) {
+			witnessRMs = append(witnessRMs, rmIds[idx])
+		}
+	}
+	return witnessRMs
+}
+
+// DataRMs returns RMs() with every witness replaced by RMIdEmpty, so
+// that a resolver built from it never places vars on witnesses.
+func (config *Configuration) DataRMs() common.RMIds {
+	witnessRMs := config.WitnessRMs()
+	if len(witnessRMs) == 0 {
+		return config.rms
+	}
+	rmIds := make([]common.RMId, len(config.rms))
+	copy(rmIds, config.rms)
+	for idx, rmId := range rmIds {
+		for _, witness := range witnessRMs {
+			if rmId == witness {
+				rmIds[idx] = common.RMIdEmpty
+				break
+			}
+		}
+	}
+	return rmIds
+}
+
 func (config *Configuration) RMsRemoved() map[common.RMId]server.EmptyStruct {
 	return config.rmsRemoved
 }
@@ -483,6 +660,8 @@ func (config *Configuration) Clone() *Configuration {
 		F:           config.F,
 		MaxRMCount:  config.MaxRMCount,
 		NoSync:      config.NoSync,
+		MaxTxnFanOut:                  config.MaxTxnFanOut,
+		MaxResubmits:                  config.MaxResubmits,
 		ClientCertificateFingerprints: nil,
 		roots:             make([]string, len(config.roots)),
 		rms:               make([]common.RMId, len(config.rms)),
@@ -492,12 +671,34 @@ func (config *Configuration) Clone() *Configuration {
 	}
 
 	copy(clone.Hosts, config.Hosts)
+	if config.Witnesses != nil {
+		clone.Witnesses = make([]string, len(config.Witnesses))
+		copy(clone.Witnesses, config.Witnesses)
+	}
+	if config.Zones != nil {
+		clone.Zones = make(map[string]string, len(config.Zones))
+		for k, v := range config.Zones {
+			clone.Zones[k] = v
+		}
+	}
 	if config.ClientCertificateFingerprints != nil {
 		clone.ClientCertificateFingerprints = make(map[string]map[string]*RootCapability, len(config.ClientCertificateFingerprints))
 		for k, v := range config.ClientCertificateFingerprints {
 			clone.ClientCertificateFingerprints[k] = v
 		}
 	}
+	if config.Accounts != nil {
+		clone.Accounts = make(map[string]map[string]*RootCapability, len(config.Accounts))
+		for k, v := range config.Accounts {
+			clone.Accounts[k] = v
+		}
+	}
+	if config.ClientCertificateAccounts != nil {
+		clone.ClientCertificateAccounts = make(map[string]string, len(config.ClientCertificateAccounts))
+		for k, v := range config.ClientCertificateAccounts {
+			clone.ClientCertificateAccounts[k] = v
+		}
+	}
 	copy(clone.roots, config.roots)
 	copy(clone.rms, config.rms)
 	for k, v := range config.rmsRemoved {
@@ -524,6 +725,24 @@ func (config *Configuration) AddToSegAutoRoot(seg *capn.Segment) msgs.Configurat
 	cap.SetF(config.F)
 	cap.SetMaxRMCount(config.MaxRMCount)
 	cap.SetNoSync(config.NoSync)
+	cap.SetMaxTxnFanOut(config.MaxTxnFanOut)
+	cap.SetMaxResubmits(config.MaxResubmits)
+
+	if len(config.Zones) != 0 {
+		zones := seg.NewTextList(len(config.Hosts))
+		cap.SetZones(zones)
+		for idx, host := range config.Hosts {
+			zones.Set(idx, config.Zones[host])
+		}
+	}
+
+	if len(config.Witnesses) != 0 {
+		witnesses := seg.NewTextList(len(config.Witnesses))
+		cap.SetWitnesses(witnesses)
+		for idx, host := range config.Witnesses {
+			witnesses.Set(idx, host)
+		}
+	}
 
 	rms := seg.NewUInt32List(len(config.rms))
 	cap.SetRms(rms)
@@ -917,11 +1136,11 @@ func (g *Generator) String() string {
 }
 
 func (g *Generator) SatisfiedBy(topology *Topology, positions *common.Positions) (bool, error) {
-	rms := topology.RMs()
+	rms := topology.DataRMs()
 	twoFInc := topology.TwoFInc
 	if g.UseNext {
 		next := topology.Next()
-		rms = next.RMs()
+		rms = next.DataRMs()
 		twoFInc = (uint16(next.F) * 2) + 1
 	}
 	server.Log("Generator:SatisfiedBy:NewResolver:", rms, twoFInc)
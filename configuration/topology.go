@@ -5,6 +5,7 @@ import (
 	capn "github.com/glycerine/go-capnproto"
 	"goshawkdb.io/common"
 	msgs "goshawkdb.io/server/capnp"
+	"sync/atomic"
 )
 
 var (
@@ -20,6 +21,30 @@ type Topology struct {
 	Roots     Roots
 }
 
+// AtomicTopology holds the current topology such that it can be
+// swapped by its owner and read from any goroutine without
+// locking. This relies on topologies being immutable once published:
+// to change a topology, Clone it and Store the clone. The zero value
+// holds a nil topology.
+type AtomicTopology struct {
+	value atomic.Value
+}
+
+type atomicTopologyBox struct {
+	*Topology
+}
+
+func (at *AtomicTopology) Load() *Topology {
+	if box, ok := at.value.Load().(atomicTopologyBox); ok {
+		return box.Topology
+	}
+	return nil
+}
+
+func (at *AtomicTopology) Store(topology *Topology) {
+	at.value.Store(atomicTopologyBox{Topology: topology})
+}
+
 type Roots []Root
 
 func (r Roots) String() string {
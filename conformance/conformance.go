@@ -0,0 +1,227 @@
+// Package conformance provides sample encodings of the messages a
+// client sends to the server, and the server's validation of them, so
+// that client implementations can check their encodings against the
+// server programmatically.
+//
+// All messages are capnp messages in the standard (unpacked) stream
+// framing, exactly as they are sent over the connection.
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	"goshawkdb.io/server/client"
+)
+
+// MessageKind identifies where in the connection a message is sent.
+type MessageKind uint8
+
+const (
+	// Hello is the first message a client sends.
+	Hello MessageKind = iota
+	// ClientMessage is every message a client sends after the
+	// handshake.
+	ClientMessage MessageKind = iota
+)
+
+func (mk MessageKind) String() string {
+	switch mk {
+	case Hello:
+		return "Hello"
+	case ClientMessage:
+		return "ClientMessage"
+	default:
+		return fmt.Sprintf("MessageKind(%d)", mk)
+	}
+}
+
+// A Vector is a sample message. If Valid is false, the server rejects
+// it, and Validate returns an error for it.
+type Vector struct {
+	Name  string
+	Kind  MessageKind
+	Valid bool
+	Data  []byte
+}
+
+func (v *Vector) String() string {
+	return fmt.Sprintf("%v (%v; valid: %v; %d bytes)", v.Name, v.Kind, v.Valid, len(v.Data))
+}
+
+// Validate decodes data as a message of the given kind, and checks it
+// as the server would. Any panic from decoding malformed data is
+// turned into an error.
+func Validate(kind MessageKind, data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Unable to decode %v: %v", kind, r)
+		}
+	}()
+	seg, _, err := capn.ReadFromMemoryZeroCopy(data)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case Hello:
+		return validateHello(cmsgs.ReadRootHello(seg))
+	case ClientMessage:
+		return validateClientMessage(cmsgs.ReadRootClientMessage(seg))
+	default:
+		return fmt.Errorf("Unknown message kind: %v", kind)
+	}
+}
+
+func validateHello(hello cmsgs.Hello) error {
+	switch {
+	case hello.Product() != common.ProductName:
+		return fmt.Errorf("Hello has product '%s'; expected '%s'", hello.Product(), common.ProductName)
+	case hello.Version() != common.ProductVersion:
+		return fmt.Errorf("Hello has version '%s'; expected '%s'", hello.Version(), common.ProductVersion)
+	case !hello.IsClient():
+		return errors.New("Hello does not have isClient set")
+	default:
+		return nil
+	}
+}
+
+func validateClientMessage(msg cmsgs.ClientMessage) error {
+	switch which := msg.Which(); which {
+	case cmsgs.CLIENTMESSAGE_HEARTBEAT:
+		return nil
+	case cmsgs.CLIENTMESSAGE_CLIENTTXNSUBMISSION:
+		ctxn := msg.ClientTxnSubmission()
+		return client.ValidateClientTxn(&ctxn)
+	default:
+		return fmt.Errorf("Unexpected message type received from client: %v", which)
+	}
+}
+
+// Vectors returns a fresh set of sample messages, covering every
+// message and action type a client may send, and the most common
+// mistakes.
+func Vectors() []*Vector {
+	varA := common.MakeVarUUId([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+	varB := common.MakeVarUUId([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2})
+	txnId := common.MakeTxnId([]byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1})
+	value := []byte("hello")
+
+	return []*Vector{
+		{Name: "hello", Kind: Hello, Valid: true, Data: makeHello(common.ProductName, common.ProductVersion, true)},
+		{Name: "hello with wrong product", Kind: Hello, Valid: false, Data: makeHello("NotGoshawkDB", common.ProductVersion, true)},
+		{Name: "hello with wrong version", Kind: Hello, Valid: false, Data: makeHello(common.ProductName, "0.0.0", true)},
+		{Name: "hello from server", Kind: Hello, Valid: false, Data: makeHello(common.ProductName, common.ProductVersion, false)},
+
+		{Name: "heartbeat", Kind: ClientMessage, Valid: true, Data: makeHeartbeat()},
+
+		{Name: "txn with read", Kind: ClientMessage, Valid: true, Data: makeTxn(txnId[:], func(seg *capn.Segment, actions cmsgs.ClientAction_List) {
+			action := actions.At(0)
+			action.SetVarId(varA[:])
+			action.SetRead()
+			action.Read().SetVersion(common.VersionZero[:])
+		}, 1)},
+		{Name: "txn with write", Kind: ClientMessage, Valid: true, Data: makeTxn(txnId[:], func(seg *capn.Segment, actions cmsgs.ClientAction_List) {
+			action := actions.At(0)
+			action.SetVarId(varA[:])
+			action.SetWrite()
+			write := action.Write()
+			write.SetValue(value)
+			write.SetReferences(cmsgs.NewClientVarIdPosList(seg, 0))
+		}, 1)},
+		{Name: "txn with readwrite", Kind: ClientMessage, Valid: true, Data: makeTxn(txnId[:], func(seg *capn.Segment, actions cmsgs.ClientAction_List) {
+			action := actions.At(0)
+			action.SetVarId(varA[:])
+			action.SetReadwrite()
+			rw := action.Readwrite()
+			rw.SetVersion(common.VersionZero[:])
+			rw.SetValue(value)
+			rw.SetReferences(cmsgs.NewClientVarIdPosList(seg, 0))
+		}, 1)},
+		{Name: "txn with create and write", Kind: ClientMessage, Valid: true, Data: makeTxn(txnId[:], func(seg *capn.Segment, actions cmsgs.ClientAction_List) {
+			action := actions.At(0)
+			action.SetVarId(varA[:])
+			action.SetCreate()
+			create := action.Create()
+			create.SetValue(value)
+			create.SetReferences(cmsgs.NewClientVarIdPosList(seg, 0))
+			action = actions.At(1)
+			action.SetVarId(varB[:])
+			action.SetWrite()
+			write := action.Write()
+			write.SetValue(value)
+			refs := cmsgs.NewClientVarIdPosList(seg, 1)
+			refs.At(0).SetVarId(varA[:])
+			write.SetReferences(refs)
+		}, 2)},
+
+		{Name: "txn with short id", Kind: ClientMessage, Valid: false, Data: makeTxn(txnId[:8], func(seg *capn.Segment, actions cmsgs.ClientAction_List) {
+			action := actions.At(0)
+			action.SetVarId(varA[:])
+			action.SetRead()
+			action.Read().SetVersion(common.VersionZero[:])
+		}, 1)},
+		{Name: "txn with no actions", Kind: ClientMessage, Valid: false, Data: makeTxn(txnId[:], func(*capn.Segment, cmsgs.ClientAction_List) {}, 0)},
+		{Name: "txn with short var id", Kind: ClientMessage, Valid: false, Data: makeTxn(txnId[:], func(seg *capn.Segment, actions cmsgs.ClientAction_List) {
+			action := actions.At(0)
+			action.SetVarId(varA[:8])
+			action.SetRead()
+			action.Read().SetVersion(common.VersionZero[:])
+		}, 1)},
+		{Name: "txn with short version", Kind: ClientMessage, Valid: false, Data: makeTxn(txnId[:], func(seg *capn.Segment, actions cmsgs.ClientAction_List) {
+			action := actions.At(0)
+			action.SetVarId(varA[:])
+			action.SetRead()
+			action.Read().SetVersion(common.VersionZero[:8])
+		}, 1)},
+		{Name: "txn with duplicate var", Kind: ClientMessage, Valid: false, Data: makeTxn(txnId[:], func(seg *capn.Segment, actions cmsgs.ClientAction_List) {
+			for idx := 0; idx < 2; idx++ {
+				action := actions.At(idx)
+				action.SetVarId(varA[:])
+				action.SetRead()
+				action.Read().SetVersion(common.VersionZero[:])
+			}
+		}, 2)},
+		{Name: "txn with short reference", Kind: ClientMessage, Valid: false, Data: makeTxn(txnId[:], func(seg *capn.Segment, actions cmsgs.ClientAction_List) {
+			action := actions.At(0)
+			action.SetVarId(varA[:])
+			action.SetWrite()
+			write := action.Write()
+			write.SetValue(value)
+			refs := cmsgs.NewClientVarIdPosList(seg, 1)
+			refs.At(0).SetVarId(varB[:8])
+			write.SetReferences(refs)
+		}, 1)},
+	}
+}
+
+func makeHello(product, version string, isClient bool) []byte {
+	seg := capn.NewBuffer(nil)
+	hello := cmsgs.NewRootHello(seg)
+	hello.SetProduct(product)
+	hello.SetVersion(version)
+	hello.SetIsClient(isClient)
+	return server.SegToBytes(seg)
+}
+
+func makeHeartbeat() []byte {
+	seg := capn.NewBuffer(nil)
+	msg := cmsgs.NewRootClientMessage(seg)
+	msg.SetHeartbeat()
+	return server.SegToBytes(seg)
+}
+
+func makeTxn(txnId []byte, setActions func(*capn.Segment, cmsgs.ClientAction_List), actionCount int) []byte {
+	seg := capn.NewBuffer(nil)
+	msg := cmsgs.NewRootClientMessage(seg)
+	ctxn := cmsgs.NewClientTxn(seg)
+	ctxn.SetId(txnId)
+	ctxn.SetRetry(false)
+	actions := cmsgs.NewClientActionList(seg, actionCount)
+	ctxn.SetActions(actions)
+	setActions(seg, actions)
+	msg.SetClientTxnSubmission(ctxn)
+	return server.SegToBytes(seg)
+}
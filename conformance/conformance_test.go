@@ -0,0 +1,24 @@
+package conformance
+
+import (
+	"testing"
+)
+
+func TestVectors(t *testing.T) {
+	for _, vector := range Vectors() {
+		err := Validate(vector.Kind, vector.Data)
+		if vector.Valid && err != nil {
+			t.Errorf("%v: expected valid, but got error: %v", vector, err)
+		} else if !vector.Valid && err == nil {
+			t.Errorf("%v: expected invalid, but no error", vector)
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for _, vector := range Vectors() {
+		if err := Validate(vector.Kind, vector.Data[:len(vector.Data)/2]); err == nil {
+			t.Errorf("%v: truncated message unexpectedly validated", vector)
+		}
+	}
+}
@@ -22,6 +22,7 @@ type ConsistentHashCache struct {
 	hashCodesPositions map[common.VarUUId]*hcPos
 	resolver           *Resolver
 	rng                *rand.Rand
+	degraded           map[common.RMId]server.EmptyStruct
 }
 
 type hcPos struct {
@@ -80,8 +81,37 @@ func (chc *ConsistentHashCache) Remove(vUUId *common.VarUUId) {
 }
 
 // In here, we don't actually add to the cache because we don't know
-// if the corresponding txn is going to commit or not.
+// if the corresponding txn is going to commit or not. If any RMs are
+// currently degraded, we make a few attempts to find positions which
+// avoid them, keeping whichever attempt hit the fewest degraded RMs.
 func (chc *ConsistentHashCache) CreatePositions(vUUId *common.VarUUId, positionsLength int) (*common.Positions, []common.RMId, error) {
+	var (
+		bestPositions *common.Positions
+		bestHashCodes []common.RMId
+		bestDegraded  int
+	)
+	for attempt := 0; attempt < server.CreatePositionsDegradedAttempts; attempt++ {
+		positions, hashCodes, err := chc.createPositions(positionsLength)
+		if err != nil {
+			return nil, nil, err
+		}
+		degraded := 0
+		for _, rmId := range hashCodes {
+			if _, found := chc.degraded[rmId]; found {
+				degraded++
+			}
+		}
+		if bestPositions == nil || degraded < bestDegraded {
+			bestPositions, bestHashCodes, bestDegraded = positions, hashCodes, degraded
+		}
+		if bestDegraded == 0 {
+			break
+		}
+	}
+	return bestPositions, bestHashCodes, nil
+}
+
+func (chc *ConsistentHashCache) createPositions(positionsLength int) (*common.Positions, []common.RMId, error) {
 	positionsCap := capn.NewBuffer(make([]byte, 0, positionsLength*2)).NewUInt8List(positionsLength)
 	positionsSlice := make([]uint8, positionsLength)
 	n, entropy := uint64(chc.rng.Int63()), uint64(server.TwoToTheSixtyThree)
@@ -110,6 +140,19 @@ func (chc *ConsistentHashCache) CreatePositions(vUUId *common.VarUUId, positions
 	}
 }
 
+// SetDegraded replaces the set of RMs which new vars should, where
+// possible, avoid being placed on with the union of sets. The sets
+// are copied, so the caller is free to go on modifying them.
+func (chc *ConsistentHashCache) SetDegraded(sets ...map[common.RMId]server.EmptyStruct) {
+	degraded := make(map[common.RMId]server.EmptyStruct)
+	for _, set := range sets {
+		for rmId := range set {
+			degraded[rmId] = server.EmptyStructVal
+		}
+	}
+	chc.degraded = degraded
+}
+
 func (chc *ConsistentHashCache) SetResolver(resolver *Resolver) {
 	chc.resolver = resolver
 	for _, hcp := range chc.hashCodesPositions {
@@ -2,6 +2,7 @@ package consistenthash
 
 import (
 	"goshawkdb.io/common"
+	"goshawkdb.io/server"
 	"math/rand"
 	"os"
 	"testing"
@@ -47,6 +48,46 @@ func TestPerms(t *testing.T) {
 	}
 }
 
+func TestCreatePositionsAvoidsDegraded(t *testing.T) {
+	degradedRMId := hashcodes[0]
+	// placements returns how many of count new vars would be placed
+	// on degradedRMId.
+	placements := func(chc *ConsistentHashCache, count int) int {
+		hits := 0
+		for idx := 0; idx < count; idx++ {
+			_, hashCodes, err := chc.CreatePositions(nil, 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rmId := range hashCodes {
+				if rmId == degradedRMId {
+					hits++
+				}
+			}
+		}
+		return hits
+	}
+
+	resolver := NewResolver(hashcodes[:8], 3)
+	healthy := placements(NewCache(resolver, rand.New(rand.NewSource(0))), 1000)
+
+	chc := NewCache(resolver, rand.New(rand.NewSource(0)))
+	disabled := map[common.RMId]server.EmptyStruct{}
+	degraded := map[common.RMId]server.EmptyStruct{degradedRMId: server.EmptyStructVal}
+	chc.SetDegraded(disabled, degraded)
+	// The cache has its own copy, so changes to the sets passed in
+	// are not seen until they're set again.
+	delete(degraded, degradedRMId)
+	if avoided := placements(chc, 1000); avoided*4 > healthy {
+		t.Fatalf("Degraded RM given %v of 1000 new vars; %v when healthy", avoided, healthy)
+	}
+
+	chc.SetDegraded(disabled, degraded)
+	if placed := placements(chc, 1000); placed*2 < healthy {
+		t.Fatalf("Recovered RM given %v of 1000 new vars; %v when healthy", placed, healthy)
+	}
+}
+
 // NB, I could not be bothered to make this non-recursive. Beware
 // stack explosions with big permutations
 func forEachPositions(f func([]uint8), positions []uint8, idx int) {
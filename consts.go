@@ -1,23 +1,81 @@
 package server
 
 import (
+	"crypto/tls"
 	"time"
 )
 
 const (
-	ServerVersion                 = "0.3.1"
-	MDBInitialSize                = 1048576
-	TwoToTheSixtyThree            = 9223372036854775808
-	SubmissionMinSubmitDelay      = 2 * time.Millisecond
-	SubmissionMaxSubmitDelay      = 2 * time.Second
-	VarRollDelayMin               = 50 * time.Millisecond
-	VarRollDelayMax               = 500 * time.Millisecond
-	VarRollTimeExpectation        = 3 * time.Millisecond
-	VarRollPRequirement           = 0.9
-	VarRollForceNotFirstAfter     = time.Second
-	ConnectionRestartDelayRangeMS = 5000
-	ConnectionRestartDelayMin     = 3 * time.Second
-	MostRandomByteIndex           = 7 // will be the lsb of a big-endian client-n in the txnid.
-	MigrationBatchElemCount       = 64
-	PoissonSamples                = 64
+	ServerVersion                   = "0.3.1"
+	MDBInitialSize                  = 1048576
+	DBMaxCommitLatency              = time.Millisecond
+	DBCompressionMinBytes           = 256
+	DBTxnChunkBytes                 = 65536
+	WireCompressionMinBytes         = 1024
+	ReencryptChunkRecords           = 1024
+	TwoToTheSixtyThree              = 9223372036854775808
+	SubmissionMinSubmitDelay        = 2 * time.Millisecond
+	SubmissionMaxSubmitDelay        = 2 * time.Second
+	VarRollDelayMin                 = 50 * time.Millisecond
+	VarRollDelayMax                 = 500 * time.Millisecond
+	VarRollTimeExpectation          = 3 * time.Millisecond
+	VarRollPRequirement             = 0.9
+	VarRollForceNotFirstAfter       = time.Second
+	VarRollClockSlackMax            = 64
+	VarBackgroundWriteMaxDelay      = 100 * time.Millisecond
+	ConnectionRestartDelayRangeMS   = 5000
+	ConnectionRestartDelayMin       = 3 * time.Second
+	MostRandomByteIndex             = 7 // will be the lsb of a big-endian client-n in the txnid.
+	MigrationBatchElemCount         = 64
+	MigrationBatchWindow            = 8
+	MigrationBatchWindowMax         = 256
+	MigrationCheckpointBatches      = 64
+	PoissonSamples                  = 64
+	CreatePositionsDegradedAttempts = 4
+	HealthCheckInterval             = time.Second
+	HealthQueueDepthMax             = 1024
+	HealthDiskFreeMin               = 0.05
+	LifecycleShutdownHookTimeout    = 10 * time.Second
+	StatusCollectionConcurrency     = 1
+	TxnProbeInterval                = 10 * time.Second
+	BadReadPayloadMaxBytes          = 16384
+	BadReadPayloadCacheSize         = 1024
+	TopologyBarrierReportDelay      = 30 * time.Second
+	TopologyBarrierReportMaxDelay   = 8 * time.Minute
+	LogShippingQueueLength          = 4096
+	LogShippingApplyWindow          = 256
+	ProposerCompactionInterval      = 10 * time.Minute
+	ProposerCompactionHorizon       = time.Hour
+	ProposerSpillThreshold          = 65536
+	ProposerRehydrateBatch          = 64
+	OutcomeCacheSize                = 65536
+	ClientOutcomeTTL                = 10 * time.Minute
+	ClientOutcomeExpiryInterval     = time.Minute
+	HotVarWindow                    = 10 * time.Second
+	HotVarMinArrivals               = 100
+	HotVarAbortRatio                = 0.5
+	HotVarDeadlockLoopWindows       = 3
+	DrainTimeout                    = 30 * time.Second
+	DrainPollInterval               = 100 * time.Millisecond
+	SnapshotReadAttempts            = 8
+	GatewayWatchQueueLength         = 64
+	SegBufferPoolMaxCap             = 64 * 1024
+	CertificateWatchInterval        = time.Minute
+	TLSVersionFloor                 = tls.VersionTLS12
+	FailureDetectorWindow           = 128
+	FailureDetectorPhiThreshold     = 8.0
+	FailureDetectorMinStdDev        = 100 * time.Millisecond
+	FailureDetectorCheckInterval    = 250 * time.Millisecond
+	ScrubInterval                   = 6 * time.Hour
+	ScrubChunkVars                  = 1024
+	ScrubReportMaxCorrupt           = 64
+	AntiEntropyBuckets              = 256
+	ExecutorSlowCallback            = 100 * time.Millisecond
+	ExecutorStallTimeout            = 10 * time.Second
+	ExecutorStallCheckInterval      = time.Second
+	TraceCaptureDefaultDuration     = 5 * time.Second
+	TraceCaptureMaxDuration         = time.Minute
+	LogSinkTCPTimeout               = time.Second
+	LogSinkTCPRetryDelay            = 10 * time.Second
+	LogSinkQueueLength              = 1024
 )
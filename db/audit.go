@@ -0,0 +1,206 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+func init() {
+	DB.AuditLog = DeclareTable("AuditLog")
+}
+
+// An AuditEntry records a single administrative or topology
+// operation. Entries form a hash chain: each entry's Hash covers its
+// own fields and the Hash of the entry before it, so an entry can't
+// be altered, removed or inserted without breaking the chain from
+// that point on.
+type AuditEntry struct {
+	Seq      uint64
+	Time     time.Time
+	Kind     string
+	Detail   string
+	PrevHash []byte
+	Hash     []byte
+}
+
+func (ae *AuditEntry) computeHash() []byte {
+	h := sha256.New()
+	bites := make([]byte, 8)
+	binary.BigEndian.PutUint64(bites, ae.Seq)
+	h.Write(bites)
+	h.Write([]byte(ae.Time.UTC().Format(time.RFC3339Nano)))
+	h.Write([]byte{0})
+	h.Write([]byte(ae.Kind))
+	h.Write([]byte{0})
+	h.Write([]byte(ae.Detail))
+	h.Write([]byte{0})
+	h.Write(ae.PrevHash)
+	return h.Sum(nil)
+}
+
+func auditKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// Auditor appends to, and reads from, the append-only AuditLog table.
+type Auditor struct {
+	sync.Mutex
+	dbs      *Databases
+	loaded   bool
+	lastSeq  uint64
+	lastHash []byte
+}
+
+func newAuditor(dbs *Databases) *Auditor {
+	return &Auditor{dbs: dbs}
+}
+
+// load finds the end of the chain. It must be called with the lock
+// held.
+func (a *Auditor) load() error {
+	if a.loaded {
+		return nil
+	}
+	var last *AuditEntry
+	var decodeErr error
+	result, err := a.dbs.ReadonlyTransaction(func(rtxn ReadTxn) interface{} {
+		rtxn.Iterate(a.dbs.AuditLog, func(key, value []byte) bool {
+			entry := &AuditEntry{}
+			if decodeErr = json.Unmarshal(value, entry); decodeErr != nil {
+				return false
+			}
+			last = entry
+			return true
+		})
+		return true
+	}).ResultError()
+	if err != nil {
+		return err
+	} else if decodeErr != nil {
+		return decodeErr
+	} else if result == nil {
+		return errors.New("Shutting down.")
+	}
+	if last != nil {
+		a.lastSeq, a.lastHash = last.Seq, last.Hash
+	}
+	a.loaded = true
+	return nil
+}
+
+// Record appends an entry to the audit log. The write happens in the
+// background; failures are logged.
+func (a *Auditor) Record(kind, format string, args ...interface{}) {
+	a.Lock()
+	defer a.Unlock()
+	if err := a.load(); err != nil {
+		log.Printf("Error: Unable to load audit log: %v", err)
+		return
+	}
+	entry := &AuditEntry{
+		Seq:      a.lastSeq + 1,
+		Time:     time.Now(),
+		Kind:     kind,
+		Detail:   fmt.Sprintf(format, args...),
+		PrevHash: a.lastHash,
+	}
+	entry.Hash = entry.computeHash()
+	value, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error: Unable to encode audit entry: %v", err)
+		return
+	}
+	// The StorageEngine applies writes in the order they're
+	// submitted, and we submit with the lock held, so the chain is
+	// written in order.
+	future := a.dbs.ReadWriteTransaction(false, func(rwtxn ReadWriteTxn) interface{} {
+		if err := rwtxn.Put(a.dbs.AuditLog, auditKey(entry.Seq), value); err != nil {
+			rwtxn.Error(err)
+			return nil
+		}
+		return true
+	})
+	a.lastSeq, a.lastHash = entry.Seq, entry.Hash
+	go func() {
+		if _, err := future.ResultError(); err != nil {
+			log.Printf("Error: Unable to write audit entry %v (%v: %v): %v", entry.Seq, entry.Kind, entry.Detail, err)
+		}
+	}()
+}
+
+// Entries returns up to limit entries, starting from the entry with
+// sequence number from.
+func (a *Auditor) Entries(from uint64, limit int) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	var decodeErr error
+	result, err := a.dbs.ReadonlyTransaction(func(rtxn ReadTxn) interface{} {
+		rtxn.Iterate(a.dbs.AuditLog, func(key, value []byte) bool {
+			if binary.BigEndian.Uint64(key) < from {
+				return true
+			} else if len(entries) == limit {
+				return false
+			}
+			entry := &AuditEntry{}
+			if decodeErr = json.Unmarshal(value, entry); decodeErr != nil {
+				return false
+			}
+			entries = append(entries, entry)
+			return true
+		})
+		return true
+	}).ResultError()
+	if err != nil {
+		return nil, err
+	} else if decodeErr != nil {
+		return nil, decodeErr
+	} else if result == nil {
+		return nil, errors.New("Shutting down.")
+	}
+	return entries, nil
+}
+
+// Verify walks the whole chain, checking every entry's hash and link
+// to the entry before it. It returns the number of entries checked,
+// and an error describing the first break in the chain, if any.
+func (a *Auditor) Verify() (uint64, error) {
+	count := uint64(0)
+	var chainErr error
+	result, err := a.dbs.ReadonlyTransaction(func(rtxn ReadTxn) interface{} {
+		var prevHash []byte
+		rtxn.Iterate(a.dbs.AuditLog, func(key, value []byte) bool {
+			entry := &AuditEntry{}
+			seq := binary.BigEndian.Uint64(key)
+			switch {
+			case json.Unmarshal(value, entry) != nil:
+				chainErr = fmt.Errorf("Audit entry %v cannot be decoded", seq)
+			case entry.Seq != seq || seq != count+1:
+				chainErr = fmt.Errorf("Audit entry %v is out of sequence (expected %v)", seq, count+1)
+			case !bytes.Equal(entry.PrevHash, prevHash):
+				chainErr = fmt.Errorf("Audit entry %v does not follow on from the entry before it", seq)
+			case !bytes.Equal(entry.Hash, entry.computeHash()):
+				chainErr = fmt.Errorf("Audit entry %v has been altered", seq)
+			default:
+				prevHash = entry.Hash
+				count++
+				return true
+			}
+			return false
+		})
+		return true
+	}).ResultError()
+	if err != nil {
+		return count, err
+	} else if result == nil {
+		return count, errors.New("Shutting down.")
+	}
+	return count, chainErr
+}
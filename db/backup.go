@@ -0,0 +1,315 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goshawkdb.io/common"
+	"io"
+	"time"
+)
+
+// A backup is the magic, followed by the length-prefixed JSON
+// encoding of a BackupHeader, followed by a record for every key in
+// every table. Each record is the index of its table in the header's
+// Tables, then the length-prefixed key and value. The backup ends
+// with backupEnd in place of a table index, followed by the number
+// of records written.
+//
+// Values are copied as they are stored: if encryption at rest is on,
+// they stay encrypted in the backup, and restoring it needs a Keyring
+// holding every key they were encrypted with.
+const (
+	backupMagic         = common.ProductName + " Backup\n"
+	backupFormatVersion = 1
+	backupEnd           = 0xff
+	restoreBatchSize    = 1024
+)
+
+// BackupHeader describes where and when a backup was taken.
+type BackupHeader struct {
+	FormatVersion   uint32
+	Created         time.Time
+	RMId            common.RMId
+	BootCount       uint32
+	ClusterId       string
+	ClusterUUId     uint64
+	TopologyVersion uint32
+	Tables          []Table
+	// Encrypted is set if the store was encrypted at rest when the
+	// backup was taken, in which case so is the backup.
+	Encrypted bool `json:",omitempty"`
+}
+
+// BackupSummary counts what was written to (or read from) a backup.
+type BackupSummary struct {
+	*BackupHeader
+	Records uint64
+	Bytes   uint64
+	Elapsed time.Duration
+}
+
+func (bs *BackupSummary) String() string {
+	return fmt.Sprintf("Backup of %v (boot %v; topology %v) taken at %v: %v records; %v bytes; took %v",
+		bs.RMId, bs.BootCount, bs.TopologyVersion, bs.Created, bs.Records, bs.Bytes, bs.Elapsed)
+}
+
+// countingWriter counts the bytes written through it, and remembers
+// the first error.
+type countingWriter struct {
+	*bufio.Writer
+	count uint64
+	err   error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.Writer.Write(p)
+	cw.count += uint64(n)
+	cw.err = err
+	return n, err
+}
+
+func (cw *countingWriter) writeUint32(n uint32) {
+	bites := []byte{0, 0, 0, 0}
+	binary.BigEndian.PutUint32(bites, n)
+	cw.Write(bites)
+}
+
+func (cw *countingWriter) writeBytes(bites []byte) {
+	cw.writeUint32(uint32(len(bites)))
+	cw.Write(bites)
+}
+
+// Backup writes a snapshot of every table to w. The header's Tables
+// and FormatVersion are filled in by Backup.
+//
+// The snapshot of the main StorageEngine is taken within a single
+// read-only txn, so vars, txns, and the acceptor and proposer state in
+// the first consensus shard are all from the same instant, whilst
+// other txns carry on. Each additional consensus shard can only be
+// read in a txn of its own, so they are all read first. The state of
+// a txn is only removed from its shard once the txn's writes are on
+// disk, so every txn whose state is missing from the backup either
+// has its writes in the backup's vars, or had not started when its
+// shard was read. Note that each read-only txn, and so a reader from
+// its StorageEngine, is held for as long as w takes to accept what is
+// read within it.
+func (dbs *Databases) Backup(header *BackupHeader, w io.Writer) (*BackupSummary, error) {
+	start := time.Now()
+	header.FormatVersion = backupFormatVersion
+	header.Tables = append([]Table{}, tables...)
+	header.Encrypted = dbs.Keys != nil
+	if len(header.Tables) >= backupEnd {
+		return nil, fmt.Errorf("Too many tables to backup: %v", len(header.Tables))
+	}
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &BackupSummary{BackupHeader: header}
+	cw := &countingWriter{Writer: bufio.NewWriter(w)}
+	cw.Write([]byte(backupMagic))
+	cw.writeBytes(headerBytes)
+	writer := func(idx int) func(key, value []byte) bool {
+		return func(key, value []byte) bool {
+			cw.Write([]byte{byte(idx)})
+			cw.writeBytes(key)
+			cw.writeBytes(value)
+			summary.Records++
+			return cw.err == nil
+		}
+	}
+
+	for idx, table := range header.Tables {
+		if !dbs.isConsensusTable(table) {
+			continue
+		}
+		for _, shard := range dbs.consensus[1:] {
+			result, err := rawStorage(shard).ReadonlyTransaction(func(shardTxn ReadTxn) interface{} {
+				shardTxn.Iterate(table, writer(idx))
+				if cw.err != nil {
+					shardTxn.Error(cw.err)
+					return nil
+				}
+				return true
+			}).ResultError()
+			if err != nil {
+				return nil, err
+			} else if result == nil {
+				return nil, errors.New("Shutting down.")
+			}
+		}
+	}
+
+	result, err := rawStorage(dbs.StorageEngine).ReadonlyTransaction(func(rtxn ReadTxn) interface{} {
+		for idx, table := range header.Tables {
+			rtxn.Iterate(table, writer(idx))
+			if cw.err != nil {
+				rtxn.Error(cw.err)
+				return nil
+			}
+		}
+		cw.Write([]byte{backupEnd})
+		bites := make([]byte, 8)
+		binary.BigEndian.PutUint64(bites, summary.Records)
+		cw.Write(bites)
+		if cw.err == nil {
+			cw.err = cw.Flush()
+		}
+		if cw.err != nil {
+			rtxn.Error(cw.err)
+			return nil
+		}
+		return true
+	}).ResultError()
+	if err != nil {
+		return nil, err
+	} else if result == nil {
+		return nil, errors.New("Shutting down.")
+	}
+	summary.Bytes = cw.count
+	summary.Elapsed = time.Now().Sub(start)
+	return summary, nil
+}
+
+type backupReader struct {
+	*bufio.Reader
+	count uint64
+}
+
+func (br *backupReader) readFull(bites []byte) error {
+	n, err := io.ReadFull(br.Reader, bites)
+	br.count += uint64(n)
+	return err
+}
+
+func (br *backupReader) readBytes() ([]byte, error) {
+	bites := []byte{0, 0, 0, 0}
+	if err := br.readFull(bites); err != nil {
+		return nil, err
+	}
+	bites = make([]byte, binary.BigEndian.Uint32(bites))
+	return bites, br.readFull(bites)
+}
+
+// Restore reads a backup written by Backup from r, and writes every
+// record into dbs (consensus records into their shard). It should
+// only be used on an empty data directory, before the server is
+// started on it.
+func (dbs *Databases) Restore(r io.Reader) (*BackupSummary, error) {
+	start := time.Now()
+	br := &backupReader{Reader: bufio.NewReader(r)}
+	magic := make([]byte, len(backupMagic))
+	if err := br.readFull(magic); err != nil {
+		return nil, err
+	} else if string(magic) != backupMagic {
+		return nil, errors.New("Not a backup")
+	}
+	headerBytes, err := br.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	header := &BackupHeader{}
+	if err = json.Unmarshal(headerBytes, header); err != nil {
+		return nil, err
+	} else if header.FormatVersion != backupFormatVersion {
+		return nil, fmt.Errorf("Unsupported backup format version: %v", header.FormatVersion)
+	}
+	for _, table := range header.Tables {
+		if !isDeclared(table) {
+			return nil, fmt.Errorf("Backup contains undeclared table %v", table)
+		}
+	}
+	if header.Encrypted && dbs.Keys == nil {
+		return nil, errors.New("Backup is encrypted: it can only be restored with the keys it was encrypted with.")
+	}
+	summary := &BackupSummary{BackupHeader: header}
+
+	type record struct {
+		table      Table
+		key, value []byte
+	}
+	batch := make([]record, 0, restoreBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		byStorage := make(map[StorageEngine][]record)
+		for _, rec := range batch {
+			storage := rawStorage(dbs.storageFor(rec.table, rec.key))
+			byStorage[storage] = append(byStorage[storage], rec)
+		}
+		batch = make([]record, 0, restoreBatchSize)
+		for storage, records := range byStorage {
+			result, err := storage.ReadWriteTransaction(false, func(rwtxn ReadWriteTxn) interface{} {
+				for _, rec := range records {
+					if err := rwtxn.Put(rec.table, rec.key, rec.value); err != nil {
+						rwtxn.Error(err)
+						return nil
+					}
+				}
+				return true
+			}).ResultError()
+			if err == nil && result == nil {
+				err = errors.New("Shutting down.")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	idx := []byte{0}
+	for {
+		if err = br.readFull(idx); err != nil {
+			return nil, err
+		}
+		if idx[0] == backupEnd {
+			break
+		} else if int(idx[0]) >= len(header.Tables) {
+			return nil, fmt.Errorf("Corrupt backup: unknown table index %v", idx[0])
+		}
+		key, err := br.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		value, err := br.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		table := header.Tables[idx[0]]
+		if dbs.Keys != nil {
+			// Records are written as they are, so check now that we
+			// have the key each was encrypted with.
+			if _, _, err := dbs.Keys.open(table, key, value); err != nil {
+				return nil, err
+			}
+		}
+		batch = append(batch, record{table: table, key: key, value: value})
+		summary.Records++
+		if len(batch) == restoreBatchSize {
+			if err = flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = flush(); err != nil {
+		return nil, err
+	}
+	countBytes := make([]byte, 8)
+	if err = br.readFull(countBytes); err != nil {
+		return nil, err
+	} else if count := binary.BigEndian.Uint64(countBytes); count != summary.Records {
+		return nil, fmt.Errorf("Corrupt backup: expected %v records, but read %v", count, summary.Records)
+	}
+	summary.Bytes = br.count
+	summary.Elapsed = time.Now().Sub(start)
+	return summary, nil
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"goshawkdb.io/common"
+)
+
+// Txns carry the values they write, so a txn writing a large value
+// makes for a large record in the Transactions table, which LMDB has
+// to store in a single run of contiguous overflow pages. So, once it
+// has been encoded, a txn longer than Options.TxnChunkBytes is split
+// into chunks, each stored under a key derived from its TxnId: the
+// TxnId followed by the chunk's index. The record under the TxnId
+// itself is then a header: chunkedMagic, which can't start a capnp
+// message, nor a compressed record, followed by the number of
+// chunks. Chunked and unchunked records can be mixed freely, and the
+// chunk size can be changed between restarts.
+var chunkedMagic = []byte{0xff, 0xff, 0xff, 0xfe}
+
+const chunkedHeaderLen = 8
+
+func chunkKey(txnId *common.TxnId, idx uint32) []byte {
+	key := make([]byte, common.KeyLen+4)
+	copy(key, txnId[:])
+	binary.BigEndian.PutUint32(key[common.KeyLen:], idx)
+	return key
+}
+
+// chunkTxn splits txnBites into chunks of no more than chunkBytes.
+// It returns nil if txnBites should be stored whole.
+func chunkTxn(txnBites []byte, chunkBytes int) [][]byte {
+	if chunkBytes <= 0 || len(txnBites) <= chunkBytes {
+		return nil
+	}
+	chunks := make([][]byte, 0, (len(txnBites)+chunkBytes-1)/chunkBytes)
+	for len(txnBites) > chunkBytes {
+		chunks = append(chunks, txnBites[:chunkBytes])
+		txnBites = txnBites[chunkBytes:]
+	}
+	return append(chunks, txnBites)
+}
+
+func chunkedHeader(chunks int) []byte {
+	header := make([]byte, chunkedHeaderLen)
+	copy(header, chunkedMagic)
+	binary.BigEndian.PutUint32(header[len(chunkedMagic):], uint32(chunks))
+	return header
+}
+
+// chunkCount returns the number of chunks the record bites is the
+// header of, or false if bites is a whole txn.
+func chunkCount(bites []byte) (uint32, bool) {
+	if len(bites) != chunkedHeaderLen || !bytes.Equal(bites[:len(chunkedMagic)], chunkedMagic) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(bites[len(chunkedMagic):]), true
+}
+
+func (db *Databases) putTxnChunks(rwtxn ReadWriteTxn, txnId *common.TxnId, txnBites []byte) error {
+	chunks := chunkTxn(txnBites, db.txnChunkBytes)
+	if chunks == nil {
+		Stats.Transactions.Wrote(txnBites)
+		return rwtxn.Put(db.Transactions, txnId[:], txnBites)
+	}
+	for idx, chunk := range chunks {
+		Stats.Transactions.Wrote(chunk)
+		if err := rwtxn.Put(db.Transactions, chunkKey(txnId, uint32(idx)), chunk); err != nil {
+			return err
+		}
+	}
+	header := chunkedHeader(len(chunks))
+	Stats.Transactions.Wrote(header)
+	return rwtxn.Put(db.Transactions, txnId[:], header)
+}
+
+// getTxnChunks reassembles the txn whose record is bites, if it was
+// chunked.
+func (db *Databases) getTxnChunks(rtxn ReadTxn, txnId *common.TxnId, bites []byte) ([]byte, error) {
+	count, chunked := chunkCount(bites)
+	if !chunked {
+		return bites, nil
+	}
+	buf := []byte{}
+	for idx := uint32(0); idx < count; idx++ {
+		chunk, err := rtxn.Get(db.Transactions, chunkKey(txnId, idx))
+		Stats.Transactions.Read(chunk, err)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read chunk %v of %v: %v", idx, count, err)
+		}
+		buf = append(buf, chunk...)
+	}
+	return buf, nil
+}
+
+func (db *Databases) delTxnChunks(rwtxn ReadWriteTxn, txnId *common.TxnId) error {
+	bites, err := rwtxn.Get(db.Transactions, txnId[:])
+	Stats.Transactions.Read(bites, err)
+	if err == NotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	count, _ := chunkCount(bites)
+	for idx := uint32(0); idx < count; idx++ {
+		Stats.Transactions.Deleted()
+		if err = rwtxn.Del(db.Transactions, chunkKey(txnId, idx)); err != nil && err != NotFound {
+			return err
+		}
+	}
+	Stats.Transactions.Deleted()
+	return rwtxn.Del(db.Transactions, txnId[:])
+}
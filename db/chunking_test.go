@@ -0,0 +1,42 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunkTxn(t *testing.T) {
+	txn := bytes.Repeat([]byte("goshawkdb"), 100)
+
+	if chunks := chunkTxn(txn, len(txn)); chunks != nil {
+		t.Fatalf("Expected a txn no longer than the chunk size to be stored whole; got %v chunks", len(chunks))
+	}
+	if chunks := chunkTxn(txn, -1); chunks != nil {
+		t.Fatalf("Expected chunking to be disabled; got %v chunks", len(chunks))
+	}
+
+	chunks := chunkTxn(txn, 64)
+	if len(chunks) != (len(txn)+63)/64 {
+		t.Fatalf("Expected %v chunks; got %v", (len(txn)+63)/64, len(chunks))
+	}
+	for idx, chunk := range chunks {
+		if len(chunk) > 64 {
+			t.Fatalf("Chunk %v is %v bytes long", idx, len(chunk))
+		}
+	}
+	if joined := bytes.Join(chunks, nil); !bytes.Equal(joined, txn) {
+		t.Fatal("Txn changed by chunking and joining")
+	}
+
+	header := chunkedHeader(len(chunks))
+	if count, chunked := chunkCount(header); !chunked || int(count) != len(chunks) {
+		t.Fatalf("Expected a header of %v chunks; got %v (%v)", len(chunks), count, chunked)
+	}
+	// Neither a whole txn, nor a compressed one, is a header.
+	if _, chunked := chunkCount(txn[:chunkedHeaderLen]); chunked {
+		t.Fatal("Txn mistaken for a header")
+	}
+	if _, chunked := chunkCount((&Databases{compress: true}).encodeTxn(txn)[:chunkedHeaderLen]); chunked {
+		t.Fatal("Compressed txn mistaken for a header")
+	}
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"goshawkdb.io/server"
+	"io/ioutil"
+	"sync/atomic"
+)
+
+// A compressed record in the Transactions table starts with
+// compressedMagic, which can't start a capnp message (it would claim
+// 2^32 segments), followed by a byte naming the compression
+// algorithm, and then the compressed txn. Any other record (once
+// reassembled, if it was chunked: see chunkTxn) is an uncompressed
+// txn, so compressed and uncompressed records can be
+// mixed freely, and compression can be turned on and off between
+// restarts.
+var compressedMagic = []byte{0xff, 0xff, 0xff, 0xff}
+
+const (
+	compressionFlate    = 1
+	compressedHeaderLen = 5
+)
+
+// CompressionMetrics counts the txns compressed as they were written
+// to the Transactions table. All methods are safe for concurrent use.
+type CompressionMetrics struct {
+	compressed   uint64
+	uncompressed uint64
+	rawBytes     uint64
+	storedBytes  uint64
+}
+
+func (cm *CompressionMetrics) wrote(raw, stored int, compressed bool) {
+	if compressed {
+		atomic.AddUint64(&cm.compressed, 1)
+	} else {
+		atomic.AddUint64(&cm.uncompressed, 1)
+	}
+	atomic.AddUint64(&cm.rawBytes, uint64(raw))
+	atomic.AddUint64(&cm.storedBytes, uint64(stored))
+}
+
+func (cm *CompressionMetrics) String() string {
+	raw, stored := atomic.LoadUint64(&cm.rawBytes), atomic.LoadUint64(&cm.storedBytes)
+	ratio := 1.0
+	if stored != 0 {
+		ratio = float64(raw) / float64(stored)
+	}
+	return fmt.Sprintf("compressed: %v; uncompressed: %v; raw bytes: %v; stored bytes: %v; ratio: %.2f",
+		atomic.LoadUint64(&cm.compressed), atomic.LoadUint64(&cm.uncompressed), raw, stored, ratio)
+}
+
+// Compression returns whether compression is on. When it is, txns
+// are compressed as they are written to the Transactions table, and
+// the RM compresses the messages it sends to other RMs.
+func (db *Databases) Compression() bool {
+	return db.compress
+}
+
+// encodeTxn returns txnBites as they should be stored: compressed, if
+// compression is on, the txn is large enough to be worth it, and
+// compressing it makes it smaller.
+func (db *Databases) encodeTxn(txnBites []byte) []byte {
+	if !db.compress || len(txnBites) < server.DBCompressionMinBytes {
+		return txnBites
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(txnBites)))
+	buf.Write(compressedMagic)
+	buf.WriteByte(compressionFlate)
+	w, err := flate.NewWriter(buf, flate.BestSpeed)
+	if err == nil {
+		if _, err = w.Write(txnBites); err == nil {
+			err = w.Close()
+		}
+	}
+	if err != nil || buf.Len() >= len(txnBites) {
+		Stats.TransactionCompression.wrote(len(txnBites), len(txnBites), false)
+		return txnBites
+	}
+	Stats.TransactionCompression.wrote(len(txnBites), buf.Len(), true)
+	return buf.Bytes()
+}
+
+// decodeTxn reverses encodeTxn.
+func decodeTxn(bites []byte) ([]byte, error) {
+	if len(bites) < compressedHeaderLen || !bytes.Equal(bites[:len(compressedMagic)], compressedMagic) {
+		return bites, nil
+	}
+	switch algorithm := bites[len(compressedMagic)]; algorithm {
+	case compressionFlate:
+		r := flate.NewReader(bytes.NewReader(bites[compressedHeaderLen:]))
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("Unknown txn compression algorithm: %v", algorithm)
+	}
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"bytes"
+	"goshawkdb.io/server"
+	"math/rand"
+	"testing"
+)
+
+func TestEncodeDecodeTxn(t *testing.T) {
+	compressible := bytes.Repeat([]byte("goshawkdb"), server.DBCompressionMinBytes)
+	incompressible := make([]byte, 4*server.DBCompressionMinBytes)
+	rand.New(rand.NewSource(0)).Read(incompressible)
+	short := compressible[:server.DBCompressionMinBytes-1]
+
+	on, off := &Databases{compress: true}, &Databases{}
+	for _, c := range []struct {
+		name       string
+		db         *Databases
+		txn        []byte
+		compressed bool
+	}{
+		{"compressible", on, compressible, true},
+		{"incompressible", on, incompressible, false},
+		{"short", on, short, false},
+		{"compression off", off, compressible, false},
+	} {
+		stored := c.db.encodeTxn(c.txn)
+		if c.compressed {
+			if len(stored) >= len(c.txn) || !bytes.Equal(stored[:len(compressedMagic)], compressedMagic) {
+				t.Fatalf("%v: expected the txn to be compressed; got %v bytes from %v", c.name, len(stored), len(c.txn))
+			}
+		} else if !bytes.Equal(stored, c.txn) {
+			t.Fatalf("%v: expected the txn to be stored unchanged", c.name)
+		}
+		txn, err := decodeTxn(stored)
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		} else if !bytes.Equal(txn, c.txn) {
+			t.Fatalf("%v: txn changed by encoding and decoding", c.name)
+		}
+	}
+
+	// An unknown algorithm is an error, not a txn.
+	unknown := append(append([]byte{}, compressedMagic...), compressionFlate+1, 0)
+	if _, err := decodeTxn(unknown); err == nil {
+		t.Fatal("Decoded a txn compressed with an unknown algorithm.")
+	}
+}
@@ -0,0 +1,267 @@
+package db
+
+import (
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Consensus state (the Proposers and BallotOutcomes tables) is
+// written far more often than anything else, and each record stands
+// alone: it is only ever written and deleted by the proposer or
+// acceptor of its txn. So it can be spread, by TxnId, over several
+// StorageEngines, each with its own writer, so that the commits and
+// syncs of one shard don't hold up the others. The first shard is
+// always the main StorageEngine; the others live in their own
+// directories alongside it.
+
+// consensusShardDir is the directory, within the data directory, of
+// each additional shard.
+func consensusShardDir(dir string, idx int) string {
+	return filepath.Join(dir, fmt.Sprintf("consensus-%d", idx))
+}
+
+// existingConsensusShards returns the number of shards found within
+// the data directory dir.
+func existingConsensusShards(dir string) int {
+	count := 1
+	for {
+		if _, err := os.Stat(consensusShardDir(dir, count)); err != nil {
+			return count
+		}
+		count++
+	}
+}
+
+func (dbs *Databases) openConsensusShards(factory StorageEngineFactory, dir string, opts *Options, count int) error {
+	dbs.consensus = []StorageEngine{dbs.StorageEngine}
+	shardTables := []Table{}
+	for _, table := range tables {
+		if dbs.isConsensusTable(table) {
+			shardTables = append(shardTables, table)
+		}
+	}
+	for idx := 1; idx < count; idx++ {
+		shardDir := consensusShardDir(dir, idx)
+		if err := os.MkdirAll(shardDir, 0750); err != nil {
+			return err
+		}
+		shard, err := factory(shardDir, shardTables, opts)
+		if err != nil {
+			return err
+		}
+		dbs.consensus = append(dbs.consensus, shard)
+	}
+	// If the number of shards has been reduced, the shards beyond
+	// count must be opened just so they can be emptied.
+	retired, retiredDirs := []StorageEngine{}, []string{}
+	defer func() {
+		for _, shard := range retired {
+			shard.Shutdown()
+		}
+	}()
+	for idx := count; ; idx++ {
+		shardDir := consensusShardDir(dir, idx)
+		if _, err := os.Stat(shardDir); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+		shard, err := factory(shardDir, shardTables, opts)
+		if err != nil {
+			return err
+		}
+		retired = append(retired, shard)
+		retiredDirs = append(retiredDirs, shardDir)
+	}
+	if count > 1 {
+		log.Printf("Consensus state sharded over %v storage environments", count)
+	}
+	if err := dbs.rebalanceConsensus(retired); err != nil {
+		return err
+	}
+	// The retired shards are now empty, and must go: else, as
+	// existingConsensusShards counts directories, the next start
+	// without an explicit shard count would bring them back. They're
+	// removed from the last, so that a crash part way through leaves
+	// the remaining directories numbered contiguously.
+	for idx := len(retired) - 1; idx >= 0; idx-- {
+		retired[idx].Shutdown()
+		retired = retired[:idx]
+		if err := os.RemoveAll(retiredDirs[idx]); err != nil {
+			return err
+		}
+		log.Printf("Removed retired consensus shard %v", retiredDirs[idx])
+	}
+	return nil
+}
+
+func (dbs *Databases) isConsensusTable(table Table) bool {
+	return table != "" && (table == dbs.Proposers || table == dbs.BallotOutcomes)
+}
+
+// ConsensusShardCount is the number of StorageEngines the consensus
+// tables are spread over.
+func (dbs *Databases) ConsensusShardCount() int {
+	return len(dbs.consensus)
+}
+
+// ConsensusShard returns the StorageEngine which holds the consensus
+// state of txnId. Only the consensus tables may be used within its
+// txns.
+func (dbs *Databases) ConsensusShard(txnId *common.TxnId) StorageEngine {
+	return dbs.consensus[dbs.consensusShardIdx(txnId[:])]
+}
+
+func (dbs *Databases) consensusShardIdx(key []byte) int {
+	if len(dbs.consensus) < 2 || len(key) <= server.MostRandomByteIndex {
+		return 0
+	}
+	return int(key[server.MostRandomByteIndex]) % len(dbs.consensus)
+}
+
+// storageFor returns the StorageEngine which holds key within table.
+func (dbs *Databases) storageFor(table Table, key []byte) StorageEngine {
+	if dbs.isConsensusTable(table) {
+		return dbs.consensus[dbs.consensusShardIdx(key)]
+	}
+	return dbs.StorageEngine
+}
+
+// IterateConsensus calls fun with every record in the consensus
+// table, from each shard in turn, each within its own read-only
+// txn. If ran is false, the StorageEngine is shutting down.
+func (dbs *Databases) IterateConsensus(table Table, fun func(key, value []byte) bool) (ran bool, err error) {
+	for _, shard := range dbs.consensus {
+		more := true
+		result, err := shard.ReadonlyTransaction(func(rtxn ReadTxn) interface{} {
+			rtxn.Iterate(table, func(key, value []byte) bool {
+				more = fun(key, value)
+				return more
+			})
+			return true
+		}).ResultError()
+		if err != nil || result == nil {
+			return false, err
+		} else if !more {
+			break
+		}
+	}
+	return true, nil
+}
+
+// rebalanceConsensus moves every consensus record which is not in
+// the shard it belongs in, which happens when the number of shards
+// is changed. Every record in a retired shard is moved. Records are
+// written to their new shard before being deleted from their old, so
+// a crash part way through leaves duplicates, which the next
+// rebalance tidies up.
+func (dbs *Databases) rebalanceConsensus(retired []StorageEngine) error {
+	type record struct {
+		table      Table
+		key, value []byte
+	}
+	moved := 0
+	sources := append(append([]StorageEngine{}, dbs.consensus...), retired...)
+	for idx, shard := range sources {
+		misplaced := make(map[int][]record)
+		for _, table := range tables {
+			if !dbs.isConsensusTable(table) {
+				continue
+			}
+			tableCopy := table
+			_, err := shard.ReadonlyTransaction(func(rtxn ReadTxn) interface{} {
+				rtxn.Iterate(tableCopy, func(key, value []byte) bool {
+					if target := dbs.consensusShardIdx(key); target != idx {
+						misplaced[target] = append(misplaced[target], record{table: tableCopy, key: key, value: value})
+					}
+					return true
+				})
+				return true
+			}).ResultError()
+			if err != nil {
+				return err
+			}
+		}
+		for target, records := range misplaced {
+			_, err := dbs.consensus[target].ReadWriteTransaction(true, func(rwtxn ReadWriteTxn) interface{} {
+				for _, rec := range records {
+					if err := rwtxn.Put(rec.table, rec.key, rec.value); err != nil {
+						rwtxn.Error(err)
+						return nil
+					}
+				}
+				return true
+			}).ResultError()
+			if err != nil {
+				return err
+			}
+			_, err = shard.ReadWriteTransaction(true, func(rwtxn ReadWriteTxn) interface{} {
+				for _, rec := range records {
+					if err := rwtxn.Del(rec.table, rec.key); err != nil && err != NotFound {
+						rwtxn.Error(err)
+						return nil
+					}
+				}
+				return true
+			}).ResultError()
+			if err != nil {
+				return err
+			}
+			moved += len(records)
+		}
+	}
+	if moved > 0 {
+		log.Printf("Moved %v consensus records between %v shards", moved, len(dbs.consensus))
+	}
+	return nil
+}
+
+// multiFuture is the Future of a txn run on several StorageEngines:
+// it waits for every one of them, and returns the first error.
+type multiFuture []Future
+
+func (mf multiFuture) ResultError() (interface{}, error) {
+	var result interface{}
+	var firstErr error
+	for idx, future := range mf {
+		res, err := future.ResultError()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if idx == 0 {
+			result = res
+		}
+	}
+	return result, firstErr
+}
+
+// Flush forces every shard to commit and sync to disk.
+func (dbs *Databases) Flush() Future {
+	futures := make(multiFuture, len(dbs.consensus))
+	for idx, shard := range dbs.consensus {
+		futures[idx] = shard.ReadWriteTransaction(true, func(rwtxn ReadWriteTxn) interface{} {
+			return true
+		})
+	}
+	return futures
+}
+
+// SetNoSync sets NoSync on every shard.
+func (dbs *Databases) SetNoSync(noSync bool) Future {
+	futures := make(multiFuture, len(dbs.consensus))
+	for idx, shard := range dbs.consensus {
+		futures[idx] = shard.SetNoSync(noSync)
+	}
+	return futures
+}
+
+// Shutdown shuts down every shard.
+func (dbs *Databases) Shutdown() {
+	for idx := len(dbs.consensus) - 1; idx >= 0; idx-- {
+		dbs.consensus[idx].Shutdown()
+	}
+}
@@ -1,32 +1,28 @@
 package db
 
-import (
-	mdbs "github.com/msackman/gomdb/server"
-)
-
+// Databases is the StorageEngine along with the tables the server
+// uses. The tables are declared by the packages which use them. The
+// consensus tables may be spread over several StorageEngines: see
+// ConsensusShard.
 type Databases struct {
-	*mdbs.MDBServer
-	Vars            *mdbs.DBISettings
-	Proposers       *mdbs.DBISettings
-	BallotOutcomes  *mdbs.DBISettings
-	Transactions    *mdbs.DBISettings
-	TransactionRefs *mdbs.DBISettings
+	StorageEngine
+	Vars              Table
+	Proposers         Table
+	BallotOutcomes    Table
+	Transactions      Table
+	TransactionRefs   Table
+	MigrationReports  Table
+	AuditLog          Table
+	EmigrationCursors Table
+	ClientOutcomes    Table
+	Audit             *Auditor
+	Idempotency       *IdempotencyLog
+	Keys              *Keyring
+	compress          bool
+	txnChunkBytes     int
+	consensus         []StorageEngine
 }
 
 var (
 	DB = &Databases{}
 )
-
-func (db *Databases) Clone() mdbs.DBIsInterface {
-	return &Databases{
-		Vars:            db.Vars.Clone(),
-		Proposers:       db.Proposers.Clone(),
-		BallotOutcomes:  db.BallotOutcomes.Clone(),
-		Transactions:    db.Transactions.Clone(),
-		TransactionRefs: db.TransactionRefs.Clone(),
-	}
-}
-
-func (db *Databases) SetServer(server *mdbs.MDBServer) {
-	db.MDBServer = server
-}
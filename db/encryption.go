@@ -0,0 +1,365 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"goshawkdb.io/server"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// At-rest encryption wraps a StorageEngine so that the value of every
+// record, in every table, is encrypted with AES-256-GCM as it is
+// written, and decrypted as it is read. Keys are not encrypted: they
+// are TxnIds, VarUUIds and sequence numbers, and the StorageEngine
+// needs them to order records.
+//
+// An encrypted value is encryptedMagic, the id of the key it was
+// encrypted with, a nonce, and the sealed value. The table and key of
+// the record are authenticated along with the value, so a value can't
+// be moved to another record. encryptedMagic can't start a capnp
+// message, and no other value written by the server starts with it,
+// so records written before encryption was turned on are read as
+// they are, and are encrypted when they are next written.
+var encryptedMagic = []byte{0xfe, 0xff, 0xff, 0xff}
+
+const encryptedHeaderLen = 8
+
+// A Keyring holds the keys which encrypt the stores. Records are
+// encrypted with the current key, which is the key with the highest
+// id, and are decrypted with whichever key they were encrypted
+// with. To rotate keys, add a new key to the key file, with a higher
+// id, and restart. Records are rewritten under the new key as they
+// are next written, or read within a read-write txn, or by
+// Reencrypt. Once Reencrypt has run, the old keys can be removed.
+type Keyring struct {
+	path        string
+	keys        map[uint32]cipher.AEAD
+	current     uint32
+	sealed      uint64
+	unencrypted uint64
+	rewritten   uint64
+}
+
+// LoadKeyring reads the key file at path. Each line of the file is a
+// key id, in decimal, then white space, then a 256 bit key, in
+// hex. Blank lines, and lines starting with #, are ignored.
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Mode().Perm()&0077 != 0 {
+		log.Printf("Warning: Key file %v can be read by users other than its owner (mode %v).", path, info.Mode().Perm())
+	}
+
+	kr := &Keyring{path: path, keys: make(map[uint32]cipher.AEAD)}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%v:%v: expected a key id and a key", path, lineNum)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: bad key id: %v", path, lineNum, err)
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: bad key: %v", path, lineNum, err)
+		} else if len(key) != 32 {
+			return nil, fmt.Errorf("%v:%v: key has length %v bytes; expected 32", path, lineNum, len(key))
+		}
+		if _, found := kr.keys[uint32(id)]; found {
+			return nil, fmt.Errorf("%v:%v: key id %v given twice", path, lineNum, id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(kr.keys) == 0 || uint32(id) > kr.current {
+			kr.current = uint32(id)
+		}
+		kr.keys[uint32(id)] = aead
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	} else if len(kr.keys) == 0 {
+		return nil, fmt.Errorf("%v: no keys found", path)
+	}
+	return kr, nil
+}
+
+func encryptionAdditionalData(table Table, key []byte) []byte {
+	ad := make([]byte, 0, len(table)+1+len(key))
+	ad = append(ad, table...)
+	ad = append(ad, 0)
+	return append(ad, key...)
+}
+
+func (kr *Keyring) seal(table Table, key, value []byte) []byte {
+	aead := kr.keys[kr.current]
+	sealed := make([]byte, encryptedHeaderLen+aead.NonceSize(), encryptedHeaderLen+aead.NonceSize()+len(value)+aead.Overhead())
+	copy(sealed, encryptedMagic)
+	binary.BigEndian.PutUint32(sealed[len(encryptedMagic):], kr.current)
+	nonce := sealed[encryptedHeaderLen:]
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("Unable to generate nonce: %v", err))
+	}
+	atomic.AddUint64(&kr.sealed, 1)
+	return aead.Seal(sealed, nonce, value, encryptionAdditionalData(table, key))
+}
+
+// open returns the plain value of a record, and whether it needs
+// rewriting under the current key.
+func (kr *Keyring) open(table Table, key, value []byte) ([]byte, bool, error) {
+	if len(value) < encryptedHeaderLen || !bytes.Equal(value[:len(encryptedMagic)], encryptedMagic) {
+		atomic.AddUint64(&kr.unencrypted, 1)
+		return value, true, nil
+	}
+	id := binary.BigEndian.Uint32(value[len(encryptedMagic):])
+	aead, found := kr.keys[id]
+	if !found {
+		return nil, false, fmt.Errorf("%v: record encrypted with key %v, which is not in %v", table, id, kr.path)
+	}
+	if len(value) < encryptedHeaderLen+aead.NonceSize() {
+		return nil, false, fmt.Errorf("%v: encrypted record is truncated", table)
+	}
+	nonce := value[encryptedHeaderLen : encryptedHeaderLen+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, value[encryptedHeaderLen+aead.NonceSize():], encryptionAdditionalData(table, key))
+	if err != nil {
+		return nil, false, fmt.Errorf("%v: unable to decrypt record: %v", table, err)
+	}
+	return plain, id != kr.current, nil
+}
+
+func (kr *Keyring) Status(sc *server.StatusConsumer) {
+	if kr == nil {
+		sc.Emit("Encryption at rest: off")
+		sc.Join()
+		return
+	}
+	ids := make([]int, 0, len(kr.keys))
+	for id := range kr.keys {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	sc.Emit(fmt.Sprintf("Encryption at rest: on; keys: %v; current key: %v", ids, kr.current))
+	sc.EmitKV("Records encrypted", atomic.LoadUint64(&kr.sealed))
+	sc.EmitKV("Unencrypted records read", atomic.LoadUint64(&kr.unencrypted))
+	sc.EmitKV("Records rewritten under the current key", atomic.LoadUint64(&kr.rewritten))
+	sc.Join()
+}
+
+// encryptingFactory returns a StorageEngineFactory which opens
+// StorageEngines with factory, and encrypts them with kr.
+func encryptingFactory(factory StorageEngineFactory, kr *Keyring) StorageEngineFactory {
+	return func(dir string, tables []Table, opts *Options) (StorageEngine, error) {
+		storage, err := factory(dir, tables, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &encryptedStorageEngine{StorageEngine: storage, keyring: kr}, nil
+	}
+}
+
+type encryptedStorageEngine struct {
+	StorageEngine
+	keyring *Keyring
+}
+
+// rawStorage returns the StorageEngine underneath storage's
+// encryption, if it has any, through which records are read and
+// written exactly as they are stored.
+func rawStorage(storage StorageEngine) StorageEngine {
+	if ese, ok := storage.(*encryptedStorageEngine); ok {
+		return ese.StorageEngine
+	}
+	return storage
+}
+
+func (ese *encryptedStorageEngine) ReadonlyTransaction(fun func(ReadTxn) interface{}) Future {
+	return ese.StorageEngine.ReadonlyTransaction(func(rtxn ReadTxn) interface{} {
+		return fun(&encryptedReadTxn{ReadTxn: rtxn, keyring: ese.keyring})
+	})
+}
+
+func (ese *encryptedStorageEngine) ReadWriteTransaction(forceFlush bool, fun func(ReadWriteTxn) interface{}) Future {
+	return ese.StorageEngine.ReadWriteTransaction(forceFlush, func(rwtxn ReadWriteTxn) interface{} {
+		return fun(&encryptedReadWriteTxn{
+			encryptedReadTxn: encryptedReadTxn{ReadTxn: rwtxn, keyring: ese.keyring},
+			rwtxn:            rwtxn,
+		})
+	})
+}
+
+// reencrypt rewrites under the current key every record in table not
+// already encrypted with it, starting from the record with key from,
+// and visiting at most limit records. It returns the key to start
+// from next time, which is nil once the end of the table is reached.
+func (ese *encryptedStorageEngine) reencrypt(table Table, from []byte, limit int) ([]byte, int, error) {
+	var next []byte
+	rewritten := 0
+	result, err := ese.StorageEngine.ReadWriteTransaction(false, func(rwtxn ReadWriteTxn) interface{} {
+		type record struct{ key, value []byte }
+		stale := []record{}
+		visited := 0
+		var openErr error
+		visit := func(key, value []byte) bool {
+			if visited == limit {
+				next = key
+				return false
+			}
+			visited++
+			plain, rewrite, err := ese.keyring.open(table, key, value)
+			if err != nil {
+				openErr = err
+				return false
+			} else if rewrite {
+				stale = append(stale, record{key: key, value: plain})
+			}
+			return true
+		}
+		if from == nil {
+			rwtxn.Iterate(table, visit)
+		} else {
+			rwtxn.IterateFrom(table, from, visit)
+		}
+		if openErr != nil {
+			rwtxn.Error(openErr)
+			return nil
+		}
+		for _, r := range stale {
+			if err := rwtxn.Put(table, r.key, ese.keyring.seal(table, r.key, r.value)); err != nil {
+				rwtxn.Error(err)
+				return nil
+			}
+		}
+		rewritten = len(stale)
+		return true
+	}).ResultError()
+	if err != nil {
+		return nil, 0, err
+	} else if result == nil {
+		return nil, 0, errors.New("Shutting down.")
+	}
+	atomic.AddUint64(&ese.keyring.rewritten, uint64(rewritten))
+	return next, rewritten, nil
+}
+
+type encryptedReadTxn struct {
+	ReadTxn
+	keyring *Keyring
+}
+
+func (ert *encryptedReadTxn) Get(table Table, key []byte) ([]byte, error) {
+	value, err := ert.ReadTxn.Get(table, key)
+	if err != nil {
+		return value, err
+	}
+	plain, _, err := ert.keyring.open(table, key, value)
+	return plain, err
+}
+
+func (ert *encryptedReadTxn) Iterate(table Table, fun func(key, value []byte) bool) {
+	ert.ReadTxn.Iterate(table, ert.decrypting(table, fun))
+}
+
+func (ert *encryptedReadTxn) IterateFrom(table Table, from []byte, fun func(key, value []byte) bool) {
+	ert.ReadTxn.IterateFrom(table, from, ert.decrypting(table, fun))
+}
+
+func (ert *encryptedReadTxn) decrypting(table Table, fun func(key, value []byte) bool) func(key, value []byte) bool {
+	return func(key, value []byte) bool {
+		plain, _, err := ert.keyring.open(table, key, value)
+		if err != nil {
+			ert.ReadTxn.Error(err)
+			return false
+		}
+		return fun(key, plain)
+	}
+}
+
+type encryptedReadWriteTxn struct {
+	encryptedReadTxn
+	rwtxn ReadWriteTxn
+}
+
+// Get rewrites the record under the current key if it was encrypted
+// with an older one, or not encrypted at all.
+func (erwt *encryptedReadWriteTxn) Get(table Table, key []byte) ([]byte, error) {
+	value, err := erwt.rwtxn.Get(table, key)
+	if err != nil {
+		return value, err
+	}
+	plain, rewrite, err := erwt.keyring.open(table, key, value)
+	if err == nil && rewrite {
+		if err = erwt.rwtxn.Put(table, key, erwt.keyring.seal(table, key, plain)); err == nil {
+			atomic.AddUint64(&erwt.keyring.rewritten, 1)
+		}
+	}
+	return plain, err
+}
+
+func (erwt *encryptedReadWriteTxn) Put(table Table, key, value []byte) error {
+	return erwt.rwtxn.Put(table, key, erwt.keyring.seal(table, key, value))
+}
+
+func (erwt *encryptedReadWriteTxn) Del(table Table, key []byte) error {
+	return erwt.rwtxn.Del(table, key)
+}
+
+// Reencrypt rewrites, under the current key, every record in every
+// table which is not already encrypted with it, ReencryptChunkRecords
+// records at a time. It returns the number of records rewritten.
+func (dbs *Databases) Reencrypt() (int, error) {
+	if dbs.Keys == nil {
+		return 0, errors.New("Encryption at rest is not enabled.")
+	}
+	engines := append([]StorageEngine{dbs.StorageEngine}, dbs.consensus[1:]...)
+	total := 0
+	for idx, engine := range engines {
+		ese, ok := engine.(*encryptedStorageEngine)
+		if !ok {
+			return total, fmt.Errorf("Storage engine %v is not encrypted.", idx)
+		}
+		for _, table := range tables {
+			if idx > 0 && !dbs.isConsensusTable(table) {
+				continue
+			}
+			var from []byte
+			for {
+				next, rewritten, err := ese.reencrypt(table, from, server.ReencryptChunkRecords)
+				total += rewritten
+				if err != nil {
+					return total, err
+				} else if next == nil {
+					break
+				}
+				from = next
+			}
+		}
+	}
+	log.Printf("Reencryption finished: %v records rewritten under key %v.", total, dbs.Keys.current)
+	return total, nil
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// testKeyring returns a Keyring holding a key for each of ids, the
+// last of which is current.
+func testKeyring(t *testing.T, ids ...uint32) *Keyring {
+	kr := &Keyring{path: "test", keys: make(map[uint32]cipher.AEAD)}
+	for _, id := range ids {
+		key := bytes.Repeat([]byte{byte(id)}, 32)
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kr.keys[id] = aead
+		kr.current = id
+	}
+	return kr
+}
+
+func TestSealOpen(t *testing.T) {
+	kr := testKeyring(t, 1)
+	table, key, value := DB.Transactions, []byte("key"), []byte("value")
+	sealed := kr.seal(table, key, value)
+	if bytes.Contains(sealed, value) {
+		t.Fatal("Sealed record contains the plain value.")
+	}
+
+	plain, rewrite, err := kr.open(table, key, sealed)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plain, value) {
+		t.Fatalf("Expected %q; got %q", value, plain)
+	} else if rewrite {
+		t.Fatal("Record sealed with the current key needs rewriting.")
+	}
+
+	// The table and key are authenticated along with the value.
+	if _, _, err := kr.open(DB.TransactionRefs, key, sealed); err == nil {
+		t.Fatal("Record moved to another table was opened.")
+	}
+	if _, _, err := kr.open(table, []byte("other"), sealed); err == nil {
+		t.Fatal("Record moved to another key was opened.")
+	}
+
+	// A record sealed with an older key is opened, but needs
+	// rewriting; one sealed with a key we don't have can't be opened.
+	rotated := testKeyring(t, 1, 2)
+	if plain, rewrite, err = rotated.open(table, key, sealed); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plain, value) || !rewrite {
+		t.Fatalf("Expected %q needing rewriting; got %q (rewrite: %v)", value, plain, rewrite)
+	}
+	if _, _, err := testKeyring(t, 2).open(table, key, sealed); err == nil {
+		t.Fatal("Record sealed with a missing key was opened.")
+	}
+
+	// Records written before encryption was turned on pass through.
+	if plain, rewrite, err = kr.open(table, key, value); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plain, value) || !rewrite {
+		t.Fatalf("Expected %q needing rewriting; got %q (rewrite: %v)", value, plain, rewrite)
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reencrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage, err := storageEngines[DefaultStorageEngine](dir, tables, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Shutdown()
+
+	// Five records under key 1, and two written before encryption
+	// was turned on.
+	table, old := DB.Transactions, testKeyring(t, 1)
+	result, err := storage.ReadWriteTransaction(true, func(rwtxn ReadWriteTxn) interface{} {
+		for idx := 0; idx < 7; idx++ {
+			key := []byte{byte(idx)}
+			value := []byte(fmt.Sprint("value", idx))
+			if idx < 5 {
+				value = old.seal(table, key, value)
+			}
+			if err := rwtxn.Put(table, key, value); err != nil {
+				rwtxn.Error(err)
+				return nil
+			}
+		}
+		return true
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	} else if result == nil {
+		t.Fatal("Unable to write records.")
+	}
+
+	// Three records per chunk, so resuming twice.
+	ese := &encryptedStorageEngine{StorageEngine: storage, keyring: testKeyring(t, 1, 2)}
+	var from []byte
+	chunks, total := 0, 0
+	for {
+		next, rewritten, err := ese.reencrypt(table, from, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks++
+		total += rewritten
+		if next == nil {
+			break
+		} else if from != nil && bytes.Compare(next, from) <= 0 {
+			t.Fatalf("Chunk resumed from %v after %v", next, from)
+		}
+		from = next
+	}
+	if chunks != 3 || total != 7 {
+		t.Fatalf("Expected 7 records rewritten in 3 chunks; got %v in %v", total, chunks)
+	}
+
+	// Every record is now sealed with key 2, and still holds its
+	// value.
+	current := testKeyring(t, 2)
+	result, err = storage.ReadonlyTransaction(func(rtxn ReadTxn) interface{} {
+		count := 0
+		rtxn.Iterate(table, func(key, value []byte) bool {
+			if id := binary.BigEndian.Uint32(value[len(encryptedMagic):]); id != 2 {
+				rtxn.Error(fmt.Errorf("%v sealed with key %v", key, id))
+				return false
+			}
+			plain, _, err := current.open(table, key, value)
+			if err == nil && string(plain) != fmt.Sprint("value", key[0]) {
+				err = fmt.Errorf("%v has value %q", key, plain)
+			}
+			if err != nil {
+				rtxn.Error(err)
+				return false
+			}
+			count++
+			return true
+		})
+		return count
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	} else if result != 7 {
+		t.Fatalf("Expected 7 records; got %v", result)
+	}
+}
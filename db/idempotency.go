@@ -0,0 +1,212 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	"log"
+	"sync"
+	"time"
+)
+
+func init() {
+	DB.ClientOutcomes = DeclareTable("ClientOutcomes")
+}
+
+// The IdempotencyLog records, for ClientOutcomeTTL, the final TxnId
+// of each client txn which committed, keyed by a ClientTxnKey. If a
+// client's connection drops after its txn committed but before the
+// outcome reached it, the client can reconnect and resubmit the txn
+// with the same TxnId, and be told of the commit rather than have
+// the txn run a second time. Aborts are not recorded: an aborted txn
+// changed nothing, so running it again is harmless.
+//
+// Entries are written to the ClientOutcomes table before they are
+// added to memory, so an entry which can be looked up survives a
+// restart.
+type IdempotencyLog struct {
+	sync.Mutex
+	dbs     *Databases
+	entries map[ClientTxnKey]*idempotencyEntry
+	hits    uint64
+}
+
+// A ClientTxnKey identifies a client txn across reconnections: it is
+// the fingerprint of the client's certificate, followed by the TxnId
+// the client first submitted the txn with. A reconnected client is
+// given a new namespace for its TxnIds, but a resubmission carries
+// the original TxnId, and the fingerprint stops one client learning
+// of, or claiming, the txns of another.
+type ClientTxnKey [sha256.Size + common.KeyLen]byte
+
+func MakeClientTxnKey(fingerprint [sha256.Size]byte, clientTxnId *common.TxnId) *ClientTxnKey {
+	key := &ClientTxnKey{}
+	copy(key[:], fingerprint[:])
+	copy(key[sha256.Size:], clientTxnId[:])
+	return key
+}
+
+type idempotencyEntry struct {
+	finalTxnId *common.TxnId
+	recorded   time.Time
+}
+
+func (ie *idempotencyEntry) encode() []byte {
+	bites := make([]byte, 8+common.KeyLen)
+	binary.BigEndian.PutUint64(bites, uint64(ie.recorded.UnixNano()))
+	copy(bites[8:], ie.finalTxnId[:])
+	return bites
+}
+
+func decodeIdempotencyEntry(bites []byte) (*idempotencyEntry, error) {
+	if len(bites) != 8+common.KeyLen {
+		return nil, fmt.Errorf("Client outcome has length %v; expected %v", len(bites), 8+common.KeyLen)
+	}
+	return &idempotencyEntry{
+		finalTxnId: common.MakeTxnId(bites[8:]),
+		recorded:   time.Unix(0, int64(binary.BigEndian.Uint64(bites))),
+	}, nil
+}
+
+func newIdempotencyLog(dbs *Databases) *IdempotencyLog {
+	return &IdempotencyLog{
+		dbs:     dbs,
+		entries: make(map[ClientTxnKey]*idempotencyEntry),
+	}
+}
+
+// load reads the entries from disk. It is called once, as the
+// Databases are opened, so that looking up an entry never has to
+// wait for the disk.
+func (il *IdempotencyLog) load() error {
+	var decodeErr error
+	result, err := il.dbs.ReadonlyTransaction(func(rtxn ReadTxn) interface{} {
+		rtxn.Iterate(il.dbs.ClientOutcomes, func(key, value []byte) bool {
+			entry, err := decodeIdempotencyEntry(value)
+			if err == nil && len(key) != len(ClientTxnKey{}) {
+				err = fmt.Errorf("Client outcome key has length %v; expected %v", len(key), len(ClientTxnKey{}))
+			}
+			if err != nil {
+				decodeErr = err
+				return false
+			}
+			clientTxnKey := ClientTxnKey{}
+			copy(clientTxnKey[:], key)
+			il.entries[clientTxnKey] = entry
+			return true
+		})
+		return true
+	}).ResultError()
+	if err != nil {
+		return err
+	} else if decodeErr != nil {
+		return decodeErr
+	} else if result == nil {
+		return errors.New("Shutting down.")
+	}
+	return nil
+}
+
+// Record records that the client txn identified by key committed as
+// finalTxnId. The record is written, and synced, in the background,
+// along with whatever other writes are pending; done is then called, from another go-routine, with the result.
+func (il *IdempotencyLog) Record(key *ClientTxnKey, finalTxnId *common.TxnId, done func(error)) {
+	entry := &idempotencyEntry{finalTxnId: finalTxnId, recorded: time.Now()}
+	future := il.dbs.ReadWriteTransaction(false, func(rwtxn ReadWriteTxn) interface{} {
+		if err := rwtxn.Put(il.dbs.ClientOutcomes, key[:], entry.encode()); err != nil {
+			rwtxn.Error(err)
+			return nil
+		}
+		return true
+	})
+	go func() {
+		result, err := future.ResultError()
+		if err == nil && result == nil {
+			err = errors.New("Shutting down.")
+		}
+		if err == nil {
+			il.Lock()
+			il.entries[*key] = entry
+			il.Unlock()
+		} else {
+			log.Printf("Error: Unable to write client outcome for %v: %v", finalTxnId, err)
+		}
+		done(err)
+	}()
+}
+
+// Lookup returns the final TxnId of the client txn identified by
+// key, if it committed within the last ClientOutcomeTTL.
+func (il *IdempotencyLog) Lookup(key *ClientTxnKey) (*common.TxnId, bool) {
+	il.Lock()
+	defer il.Unlock()
+	entry, found := il.entries[*key]
+	if !found || time.Now().Sub(entry.recorded) > server.ClientOutcomeTTL {
+		return nil, false
+	}
+	il.hits++
+	return entry.finalTxnId, true
+}
+
+// LookupTxnId returns the final TxnId of the client txn which was
+// first submitted as txnId, or which committed as txnId, if it
+// committed within the last ClientOutcomeTTL. Unlike Lookup, it is
+// not limited to the txns of one client, and has to search every
+// entry, so it is intended for operators rather than clients.
+func (il *IdempotencyLog) LookupTxnId(txnId *common.TxnId) (*common.TxnId, bool) {
+	il.Lock()
+	defer il.Unlock()
+	horizon := time.Now().Add(-server.ClientOutcomeTTL)
+	for key, entry := range il.entries {
+		if entry.recorded.Before(horizon) {
+			continue
+		} else if bytes.Equal(key[sha256.Size:], txnId[:]) || entry.finalTxnId.Compare(txnId) == common.EQ {
+			return entry.finalTxnId, true
+		}
+	}
+	return nil, false
+}
+
+// Expire removes every entry older than ClientOutcomeTTL, from
+// memory and from disk. It is intended to be run periodically.
+func (il *IdempotencyLog) Expire() {
+	il.Lock()
+	defer il.Unlock()
+	horizon := time.Now().Add(-server.ClientOutcomeTTL)
+	expired := []ClientTxnKey{}
+	for key, entry := range il.entries {
+		if entry.recorded.Before(horizon) {
+			expired = append(expired, key)
+			delete(il.entries, key)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	future := il.dbs.ReadWriteTransaction(false, func(rwtxn ReadWriteTxn) interface{} {
+		for idx := range expired {
+			if err := rwtxn.Del(il.dbs.ClientOutcomes, expired[idx][:]); err != nil && err != NotFound {
+				rwtxn.Error(err)
+				return nil
+			}
+		}
+		return true
+	})
+	go func() {
+		if _, err := future.ResultError(); err != nil {
+			log.Printf("Error: Unable to delete %v expired client outcomes: %v", len(expired), err)
+		}
+	}()
+}
+
+func (il *IdempotencyLog) Status(sc *server.StatusConsumer) {
+	il.Lock()
+	sc.EmitKV("Client outcomes recorded", len(il.entries))
+	sc.EmitKV("Client resubmissions answered", il.hits)
+	il.Unlock()
+	sc.Join()
+}
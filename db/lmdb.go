@@ -0,0 +1,203 @@
+package db
+
+import (
+	"fmt"
+	mdb "github.com/msackman/gomdb"
+	mdbs "github.com/msackman/gomdb/server"
+	"goshawkdb.io/server"
+)
+
+func init() {
+	RegisterStorageEngine(DefaultStorageEngine, openLMDB)
+}
+
+// lmdbDBIs is the set of DBIs the MDBServer opens. The MDBServer
+// names each DBI after its field, so the fields must match the names
+// of the declared tables.
+type lmdbDBIs struct {
+	*mdbs.MDBServer
+	Vars              *mdbs.DBISettings
+	Proposers         *mdbs.DBISettings
+	BallotOutcomes    *mdbs.DBISettings
+	Transactions      *mdbs.DBISettings
+	TransactionRefs   *mdbs.DBISettings
+	MigrationReports  *mdbs.DBISettings
+	AuditLog          *mdbs.DBISettings
+	EmigrationCursors *mdbs.DBISettings
+	ClientOutcomes    *mdbs.DBISettings
+}
+
+func (dbis *lmdbDBIs) Clone() mdbs.DBIsInterface {
+	return &lmdbDBIs{
+		Vars:              dbis.Vars.Clone(),
+		Proposers:         dbis.Proposers.Clone(),
+		BallotOutcomes:    dbis.BallotOutcomes.Clone(),
+		Transactions:      dbis.Transactions.Clone(),
+		TransactionRefs:   dbis.TransactionRefs.Clone(),
+		MigrationReports:  dbis.MigrationReports.Clone(),
+		AuditLog:          dbis.AuditLog.Clone(),
+		EmigrationCursors: dbis.EmigrationCursors.Clone(),
+		ClientOutcomes:    dbis.ClientOutcomes.Clone(),
+	}
+}
+
+func (dbis *lmdbDBIs) SetServer(server *mdbs.MDBServer) {
+	dbis.MDBServer = server
+}
+
+func (dbis *lmdbDBIs) byName() map[Table]*mdbs.DBISettings {
+	return map[Table]*mdbs.DBISettings{
+		"Vars":              dbis.Vars,
+		"Proposers":         dbis.Proposers,
+		"BallotOutcomes":    dbis.BallotOutcomes,
+		"Transactions":      dbis.Transactions,
+		"TransactionRefs":   dbis.TransactionRefs,
+		"MigrationReports":  dbis.MigrationReports,
+		"AuditLog":          dbis.AuditLog,
+		"EmigrationCursors": dbis.EmigrationCursors,
+		"ClientOutcomes":    dbis.ClientOutcomes,
+	}
+}
+
+type lmdbEngine struct {
+	server *mdbs.MDBServer
+	dbis   map[Table]*mdbs.DBISettings
+}
+
+func openLMDB(dir string, tables []Table, opts *Options) (StorageEngine, error) {
+	proto := &lmdbDBIs{
+		Vars:              &mdbs.DBISettings{Flags: mdb.CREATE},
+		Proposers:         &mdbs.DBISettings{Flags: mdb.CREATE},
+		BallotOutcomes:    &mdbs.DBISettings{Flags: mdb.CREATE},
+		Transactions:      &mdbs.DBISettings{Flags: mdb.CREATE},
+		TransactionRefs:   &mdbs.DBISettings{Flags: mdb.CREATE},
+		MigrationReports:  &mdbs.DBISettings{Flags: mdb.CREATE},
+		AuditLog:          &mdbs.DBISettings{Flags: mdb.CREATE},
+		EmigrationCursors: &mdbs.DBISettings{Flags: mdb.CREATE},
+		ClientOutcomes:    &mdbs.DBISettings{Flags: mdb.CREATE},
+	}
+	known := proto.byName()
+	for _, table := range tables {
+		if _, found := known[table]; !found {
+			return nil, fmt.Errorf("LMDB storage engine does not support table %v", table)
+		}
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// The MDBServer runs every read-write txn on a single writer,
+	// which commits (and syncs) txns in batches: a batch is committed
+	// once the oldest txn in it has waited for the commit latency, or
+	// a txn in it forces a flush.
+	commitLatency := opts.MaxCommitLatency
+	if commitLatency <= 0 {
+		commitLatency = server.DBMaxCommitLatency
+	}
+	disk, err := mdbs.NewMDBServer(dir, 0, 0600, server.MDBInitialSize, concurrency, commitLatency, proto)
+	if err != nil {
+		return nil, err
+	}
+	dbis := disk.(*lmdbDBIs)
+	return &lmdbEngine{
+		server: dbis.MDBServer,
+		dbis:   dbis.byName(),
+	}, nil
+}
+
+func (engine *lmdbEngine) dbi(table Table) *mdbs.DBISettings {
+	if dbi, found := engine.dbis[table]; found {
+		return dbi
+	}
+	panic(fmt.Sprintf("Unknown table %v", table))
+}
+
+func (engine *lmdbEngine) ReadonlyTransaction(fun func(ReadTxn) interface{}) Future {
+	return engine.server.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+		return fun(&lmdbReadTxn{engine: engine, rtxn: rtxn})
+	})
+}
+
+func (engine *lmdbEngine) ReadWriteTransaction(forceFlush bool, fun func(ReadWriteTxn) interface{}) Future {
+	return engine.server.ReadWriteTransaction(forceFlush, func(rwtxn *mdbs.RWTxn) interface{} {
+		return fun(&lmdbReadWriteTxn{
+			lmdbReadTxn: lmdbReadTxn{engine: engine, rtxn: rwtxn},
+			rwtxn:       rwtxn,
+		})
+	})
+}
+
+func (engine *lmdbEngine) SetNoSync(noSync bool) Future {
+	return engine.server.WithEnv(func(env *mdb.Env) (interface{}, error) {
+		return nil, env.SetFlags(mdb.NOSYNC, noSync)
+	})
+}
+
+func (engine *lmdbEngine) Shutdown() {
+	engine.server.Shutdown()
+}
+
+// mdbsReadTxn is satisfied by both *mdbs.RTxn and *mdbs.RWTxn.
+type mdbsReadTxn interface {
+	Get(dbi *mdbs.DBISettings, key []byte) ([]byte, error)
+	WithCursor(dbi *mdbs.DBISettings, fun func(*mdbs.Cursor) interface{}) (interface{}, error)
+	Error(err error)
+}
+
+type lmdbReadTxn struct {
+	engine *lmdbEngine
+	rtxn   mdbsReadTxn
+}
+
+func (txn *lmdbReadTxn) Get(table Table, key []byte) ([]byte, error) {
+	bites, err := txn.rtxn.Get(txn.engine.dbi(table), key)
+	if err == mdb.NotFound {
+		err = NotFound
+	}
+	return bites, err
+}
+
+func (txn *lmdbReadTxn) Iterate(table Table, fun func(key, value []byte) bool) {
+	txn.iterate(table, nil, mdb.FIRST, fun)
+}
+
+func (txn *lmdbReadTxn) IterateFrom(table Table, from []byte, fun func(key, value []byte) bool) {
+	txn.iterate(table, from, mdb.SET_RANGE, fun)
+}
+
+func (txn *lmdbReadTxn) iterate(table Table, from []byte, op uint, fun func(key, value []byte) bool) {
+	txn.rtxn.WithCursor(txn.engine.dbi(table), func(cursor *mdbs.Cursor) interface{} {
+		// cursor.Get returns a copy of the data.
+		key, value, err := cursor.Get(from, nil, op)
+		for ; err == nil; key, value, err = cursor.Get(nil, nil, mdb.NEXT) {
+			if !fun(key, value) {
+				return nil
+			}
+		}
+		if err != mdb.NotFound {
+			cursor.Error(err)
+		}
+		return nil
+	})
+}
+
+func (txn *lmdbReadTxn) Error(err error) {
+	txn.rtxn.Error(err)
+}
+
+type lmdbReadWriteTxn struct {
+	lmdbReadTxn
+	rwtxn *mdbs.RWTxn
+}
+
+func (txn *lmdbReadWriteTxn) Put(table Table, key, value []byte) error {
+	return txn.rwtxn.Put(txn.engine.dbi(table), key, value, 0)
+}
+
+func (txn *lmdbReadWriteTxn) Del(table Table, key []byte) error {
+	err := txn.rwtxn.Del(txn.engine.dbi(table), key, nil)
+	if err == mdb.NotFound {
+		err = NotFound
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"fmt"
+	"goshawkdb.io/server"
+	"sync/atomic"
+)
+
+// DBIMetrics counts the operations performed against a single
+// DBI. All methods are safe for concurrent use.
+type DBIMetrics struct {
+	reads      uint64
+	readMisses uint64
+	readBytes  uint64
+	writes     uint64
+	writeBytes uint64
+	deletes    uint64
+}
+
+// Read records a Get (or a cursor step) which returned bites and err.
+func (m *DBIMetrics) Read(bites []byte, err error) {
+	atomic.AddUint64(&m.reads, 1)
+	if err != nil || bites == nil {
+		atomic.AddUint64(&m.readMisses, 1)
+	} else {
+		atomic.AddUint64(&m.readBytes, uint64(len(bites)))
+	}
+}
+
+func (m *DBIMetrics) Wrote(bites []byte) {
+	atomic.AddUint64(&m.writes, 1)
+	atomic.AddUint64(&m.writeBytes, uint64(len(bites)))
+}
+
+func (m *DBIMetrics) Deleted() {
+	atomic.AddUint64(&m.deletes, 1)
+}
+
+// DBIMetricsSnapshot is a point in time copy of a DBIMetrics.
+type DBIMetricsSnapshot struct {
+	Reads      uint64
+	ReadMisses uint64
+	ReadBytes  uint64
+	Writes     uint64
+	WriteBytes uint64
+	Deletes    uint64
+}
+
+func (m *DBIMetrics) Snapshot() DBIMetricsSnapshot {
+	return DBIMetricsSnapshot{
+		Reads:      atomic.LoadUint64(&m.reads),
+		ReadMisses: atomic.LoadUint64(&m.readMisses),
+		ReadBytes:  atomic.LoadUint64(&m.readBytes),
+		Writes:     atomic.LoadUint64(&m.writes),
+		WriteBytes: atomic.LoadUint64(&m.writeBytes),
+		Deletes:    atomic.LoadUint64(&m.deletes),
+	}
+}
+
+func (s DBIMetricsSnapshot) String() string {
+	bytesPerRead := uint64(0)
+	if hits := s.Reads - s.ReadMisses; hits != 0 {
+		bytesPerRead = s.ReadBytes / hits
+	}
+	return fmt.Sprintf("reads: %v (misses: %v; bytes: %v; bytes/read: %v); writes: %v (bytes: %v); deletes: %v",
+		s.Reads, s.ReadMisses, s.ReadBytes, bytesPerRead, s.Writes, s.WriteBytes, s.Deletes)
+}
+
+// Metrics are kept per table, and are shared by every Databases
+// opened (the consistency checker opens several).
+type Metrics struct {
+	Vars             DBIMetrics
+	Proposers        DBIMetrics
+	BallotOutcomes   DBIMetrics
+	Transactions     DBIMetrics
+	TransactionRefs  DBIMetrics
+	MigrationReports DBIMetrics
+	// TransactionCompression counts the txns written to the
+	// Transactions table, compressed or not, while compression is on.
+	TransactionCompression CompressionMetrics
+}
+
+var Stats = &Metrics{}
+
+func (m *Metrics) Status(sc *server.StatusConsumer) {
+	sc.Emit("Database")
+	sc.Emit(fmt.Sprintf("- Vars: %v", m.Vars.Snapshot()))
+	sc.Emit(fmt.Sprintf("- Proposers: %v", m.Proposers.Snapshot()))
+	sc.Emit(fmt.Sprintf("- BallotOutcomes: %v", m.BallotOutcomes.Snapshot()))
+	sc.Emit(fmt.Sprintf("- Transactions: %v", m.Transactions.Snapshot()))
+	sc.Emit(fmt.Sprintf("- TransactionRefs: %v", m.TransactionRefs.Snapshot()))
+	sc.Emit(fmt.Sprintf("- MigrationReports: %v", m.MigrationReports.Snapshot()))
+	sc.Emit(fmt.Sprintf("- Transaction compression: %v", &m.TransactionCompression))
+	sc.Join()
+}
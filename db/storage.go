@@ -0,0 +1,186 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"goshawkdb.io/server"
+	"sort"
+	"time"
+)
+
+// A Table names a key-value table held by a StorageEngine. Tables are
+// declared with DeclareTable, normally from init funcs, before the
+// StorageEngine is opened.
+type Table string
+
+// NotFound is returned by ReadTxn.Get when the key is not present.
+var NotFound = errors.New("Not found")
+
+// A Future is returned by the StorageEngine for each txn. ResultError
+// blocks until the txn has been committed (or aborted), and returns
+// the result of the txn func. A nil result with a nil error indicates
+// the StorageEngine is shutting down.
+type Future interface {
+	ResultError() (interface{}, error)
+}
+
+// StorageEngine is the interface to the persistent store underneath
+// the Databases. Implementations other than LMDB can be compiled in
+// by calling RegisterStorageEngine from an init func.
+type StorageEngine interface {
+	// ReadonlyTransaction runs fun within a read-only txn.
+	ReadonlyTransaction(fun func(ReadTxn) interface{}) Future
+	// ReadWriteTransaction runs fun within a read-write txn, which is
+	// committed once fun returns, unless fun calls Error on the
+	// txn. Commits may be batched together; if forceFlush is true,
+	// the txn is committed (and synced) without waiting for more txns
+	// to batch with.
+	ReadWriteTransaction(forceFlush bool, fun func(ReadWriteTxn) interface{}) Future
+	// SetNoSync controls whether commits are synced to disk. If
+	// noSync is true, a crash of the host may lose the most recent
+	// commits.
+	SetNoSync(noSync bool) Future
+	Shutdown()
+}
+
+type ReadTxn interface {
+	// Get returns a copy of the value of key in table, or NotFound.
+	Get(table Table, key []byte) ([]byte, error)
+	// Iterate calls fun with every key and value in table, in
+	// ascending order of key, until fun returns false. The key and
+	// value are copies, so fun may retain them.
+	Iterate(table Table, fun func(key, value []byte) bool)
+	// IterateFrom is Iterate, but starting from the first key which
+	// is not less than from. from must not be empty: to start from
+	// the first key, use Iterate.
+	IterateFrom(table Table, from []byte, fun func(key, value []byte) bool)
+	// Error aborts the txn: the Future of the txn will return err.
+	Error(err error)
+}
+
+type ReadWriteTxn interface {
+	ReadTxn
+	Put(table Table, key, value []byte) error
+	Del(table Table, key []byte) error
+}
+
+// Options control how a StorageEngine is opened.
+type Options struct {
+	// Concurrency is a hint as to how many txns may be run
+	// concurrently.
+	Concurrency int
+	// ConsensusShards is the number of StorageEngines the consensus
+	// tables are spread over. If 0, however many shards already
+	// exist are used (at least 1). See ConsensusShard.
+	ConsensusShards int
+	// MaxCommitLatency is the longest a read-write txn may wait
+	// before being committed. Txns which arrive within this window
+	// are committed together, in a single commit and sync, unless a
+	// txn forces a flush. A longer window makes for fewer, larger
+	// syncs, at the cost of latency. If 0, DBMaxCommitLatency is
+	// used.
+	MaxCommitLatency time.Duration
+	// Compression turns on compression of the txns written to the
+	// Transactions table, and of large messages sent to other RMs.
+	// Compressed txns are read whether or not it is on.
+	Compression bool
+	// TxnChunkBytes is the longest record a txn is written to the
+	// Transactions table as: longer txns are split into chunks of
+	// this size (see chunkTxn). If 0, DBTxnChunkBytes is used; if
+	// negative, txns are never split.
+	TxnChunkBytes int
+	// Keys, if not nil, encrypts the value of every record. See
+	// Keyring.
+	Keys *Keyring
+}
+
+// StorageEngineFactory opens (creating if necessary) a StorageEngine
+// holding tables within the directory dir.
+type StorageEngineFactory func(dir string, tables []Table, opts *Options) (StorageEngine, error)
+
+var (
+	storageEngines = make(map[string]StorageEngineFactory)
+	tables         = []Table{}
+)
+
+// DefaultStorageEngine is the name of the StorageEngine used unless
+// another is requested.
+const DefaultStorageEngine = "lmdb"
+
+func RegisterStorageEngine(name string, factory StorageEngineFactory) {
+	if _, found := storageEngines[name]; found {
+		panic(fmt.Sprintf("StorageEngine %v registered twice", name))
+	}
+	storageEngines[name] = factory
+}
+
+// StorageEngines returns the names of every StorageEngine compiled in.
+func StorageEngines() []string {
+	names := make([]string, 0, len(storageEngines))
+	for name := range storageEngines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeclareTable declares a table which must exist when the
+// StorageEngine is opened. It must be called before Open, and is
+// normally called from an init func.
+func DeclareTable(name string) Table {
+	table := Table(name)
+	if isDeclared(table) {
+		panic(fmt.Sprintf("Table %v declared twice", name))
+	}
+	tables = append(tables, table)
+	return table
+}
+
+func isDeclared(table Table) bool {
+	for _, t := range tables {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
+
+// Open opens the named StorageEngine within the directory dir, and
+// returns a Databases using it. Changing the number of consensus
+// shards moves records between shards as dir is opened.
+func Open(engine, dir string, opts *Options) (*Databases, error) {
+	factory, found := storageEngines[engine]
+	if !found {
+		return nil, fmt.Errorf("Unknown storage engine '%v'. Available storage engines: %v", engine, StorageEngines())
+	}
+	if opts.Keys != nil {
+		factory = encryptingFactory(factory, opts.Keys)
+	}
+	consensusShards := opts.ConsensusShards
+	if consensusShards < 1 {
+		consensusShards = existingConsensusShards(dir)
+	}
+	storage, err := factory(dir, tables, opts)
+	if err != nil {
+		return nil, err
+	}
+	dbs := *DB
+	dbs.StorageEngine = storage
+	dbs.compress = opts.Compression
+	dbs.txnChunkBytes = opts.TxnChunkBytes
+	if dbs.txnChunkBytes == 0 {
+		dbs.txnChunkBytes = server.DBTxnChunkBytes
+	}
+	dbs.Keys = opts.Keys
+	dbs.Audit = newAuditor(&dbs)
+	dbs.Idempotency = newIdempotencyLog(&dbs)
+	if err = dbs.openConsensusShards(factory, dir, opts, consensusShards); err != nil {
+		dbs.Shutdown()
+		return nil, err
+	}
+	if err = dbs.Idempotency.load(); err != nil {
+		dbs.Shutdown()
+		return nil, fmt.Errorf("Unable to load client outcomes: %v", err)
+	}
+	return &dbs, nil
+}
@@ -3,68 +3,79 @@ package db
 import (
 	"encoding/binary"
 	"goshawkdb.io/common"
+	"log"
 	// "fmt"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 )
 
 func init() {
-	DB.Transactions = &mdbs.DBISettings{Flags: mdb.CREATE}
-	DB.TransactionRefs = &mdbs.DBISettings{Flags: mdb.CREATE}
+	DB.Transactions = DeclareTable("Transactions")
+	DB.TransactionRefs = DeclareTable("TransactionRefs")
 }
 
-func (db *Databases) WriteTxnToDisk(rwtxn *mdbs.RWTxn, txnId *common.TxnId, txnBites []byte) error {
+func (db *Databases) WriteTxnToDisk(rwtxn ReadWriteTxn, txnId *common.TxnId, txnBites []byte) error {
 	bites, err := rwtxn.Get(db.TransactionRefs, txnId[:])
+	Stats.TransactionRefs.Read(bites, err)
 
 	switch err {
 	case nil:
 		count := binary.BigEndian.Uint32(bites) + 1
 		// fmt.Printf("%v +Refcount now %v\n", txnId, count)
 		binary.BigEndian.PutUint32(bites, count)
-		return rwtxn.Put(db.TransactionRefs, txnId[:], bites, 0)
+		Stats.TransactionRefs.Wrote(bites)
+		return rwtxn.Put(db.TransactionRefs, txnId[:], bites)
 
-	case mdb.NotFound:
-		if err = rwtxn.Put(db.Transactions, txnId[:], txnBites, 0); err != nil {
+	case NotFound:
+		if err = db.putTxnChunks(rwtxn, txnId, db.encodeTxn(txnBites)); err != nil {
 			return err
 		}
 
 		bites = []byte{0, 0, 0, 0}
 		binary.BigEndian.PutUint32(bites, 1)
 		// fmt.Printf("%v +Refcount now 1\n", txnId)
-		return rwtxn.Put(db.TransactionRefs, txnId[:], bites, 0)
+		Stats.TransactionRefs.Wrote(bites)
+		return rwtxn.Put(db.TransactionRefs, txnId[:], bites)
 
 	default:
 		return err
 	}
 }
 
-func (db *Databases) ReadTxnBytesFromDisk(rtxn *mdbs.RTxn, txnId *common.TxnId) []byte {
+func (db *Databases) ReadTxnBytesFromDisk(rtxn ReadTxn, txnId *common.TxnId) []byte {
 	bites, err := rtxn.Get(db.Transactions, txnId[:])
+	Stats.Transactions.Read(bites, err)
 	if err == nil {
-		return bites
-	} else {
-		return nil
+		if bites, err = db.getTxnChunks(rtxn, txnId, bites); err != nil {
+			log.Printf("Error: Unable to reassemble txn %v: %v", txnId, err)
+		} else if bites, err = decodeTxn(bites); err == nil {
+			return bites
+		} else {
+			log.Printf("Error: Unable to decompress txn %v: %v", txnId, err)
+		}
 	}
+	return nil
 }
 
-func (db *Databases) DeleteTxnFromDisk(rwtxn *mdbs.RWTxn, txnId *common.TxnId) error {
+func (db *Databases) DeleteTxnFromDisk(rwtxn ReadWriteTxn, txnId *common.TxnId) error {
 	bites, err := rwtxn.Get(db.TransactionRefs, txnId[:])
+	Stats.TransactionRefs.Read(bites, err)
 
 	switch err {
 	case nil:
 		if count := binary.BigEndian.Uint32(bites) - 1; count == 0 {
 			// fmt.Printf("%v -Refcount now 0\n", txnId)
-			if err = rwtxn.Del(db.TransactionRefs, txnId[:], nil); err != nil {
+			Stats.TransactionRefs.Deleted()
+			if err = rwtxn.Del(db.TransactionRefs, txnId[:]); err != nil {
 				return err
 			}
-			return rwtxn.Del(db.Transactions, txnId[:], nil)
+			return db.delTxnChunks(rwtxn, txnId)
 
 		} else {
 			// fmt.Printf("%v -Refcount now %v\n", txnId, count)
 			binary.BigEndian.PutUint32(bites, count)
-			return rwtxn.Put(db.TransactionRefs, txnId[:], bites, 0)
+			Stats.TransactionRefs.Wrote(bites)
+			return rwtxn.Put(db.TransactionRefs, txnId[:], bites)
 		}
-	case mdb.NotFound:
+	case NotFound:
 		return nil
 	default:
 		return err
@@ -1,25 +1,33 @@
 package dispatcher
 
 import (
+	"fmt"
 	cc "github.com/msackman/chancell"
 	"log"
+	"sync/atomic"
+	"time"
 )
 
 type Dispatcher struct {
 	ExecutorCount uint8
 	Executors     []*Executor
+	watchdog      *watchdog
 }
 
-func (dis *Dispatcher) Init(count uint8) {
+// Init creates count executors, named after name for logging, and
+// starts a watchdog over them.
+func (dis *Dispatcher) Init(name string, count uint8) {
 	executors := make([]*Executor, count)
 	for idx := range executors {
-		executors[idx] = newExecutor()
+		executors[idx] = newExecutor(fmt.Sprintf("%v %v", name, idx))
 	}
 	dis.Executors = executors
 	dis.ExecutorCount = count
+	dis.watchdog = startWatchdog(executors)
 }
 
 func (dis *Dispatcher) Shutdown() {
+	dis.watchdog.stop()
 	for _, exe := range dis.Executors {
 		exe.shutdown()
 	}
@@ -39,14 +47,39 @@ type applyQuery func()
 
 func (aq applyQuery) witness() executorQuery { return aq }
 
+type contextApplyQuery struct {
+	context fmt.Stringer
+	fun     func()
+}
+
+func (caq *contextApplyQuery) witness() executorQuery { return caq }
+
+// An Executor runs the funcs enqueued on it one at a time, in order,
+// so that the state owned by an executor needs no locking. Each var,
+// proposer and acceptor is owned by exactly one executor, chosen by
+// its id, for its whole life: there is no stealing of work between
+// executors, as that would mean the state of a var could be touched
+// by two go-routines at once. Instead, the number of executors can be
+// chosen at start up, and each executor's queue length and busy time
+// are reported so that imbalance can be spotted.
 type Executor struct {
-	cellTail  *cc.ChanCellTail
-	enqueue   func(executorQuery, *cc.ChanCell, cc.CurCellConsumer) (bool, cc.CurCellConsumer)
-	queryChan <-chan executorQuery
+	// queued, executed, busy, slow and runningSince are accessed
+	// atomically, and so are first to keep them 64-bit aligned.
+	queued       int64
+	executed     uint64
+	busy         int64
+	slow         uint64
+	runningSince int64
+	lastSlow     atomic.Value
+	name         string
+	cellTail     *cc.ChanCellTail
+	enqueue      func(executorQuery, *cc.ChanCell, cc.CurCellConsumer) (bool, cc.CurCellConsumer)
+	queryChan    <-chan executorQuery
 }
 
-func newExecutor() *Executor {
-	exe := &Executor{}
+func newExecutor(name string) *Executor {
+	exe := &Executor{name: name}
+	exe.lastSlow.Store("")
 	var head *cc.ChanCellHead
 	head, exe.cellTail = cc.NewChanCellTail(
 		func(n int, cell *cc.ChanCell) {
@@ -84,7 +117,9 @@ func (exe *Executor) loop(head *cc.ChanCellHead) {
 			case shutdownQuery:
 				terminate = true
 			case applyQuery:
-				query()
+				exe.run(query, nil)
+			case *contextApplyQuery:
+				exe.run(query.fun, query.context)
 			default:
 				log.Printf("Fatal to Executor: Received unexpected message: %#v", query)
 				terminate = true
@@ -105,7 +140,43 @@ func (exe *Executor) send(msg executorQuery) bool {
 }
 
 func (exe *Executor) Enqueue(fun func()) bool {
-	return exe.send(applyQuery(fun))
+	atomic.AddInt64(&exe.queued, 1)
+	if exe.send(applyQuery(fun)) {
+		return true
+	}
+	atomic.AddInt64(&exe.queued, -1)
+	return false
+}
+
+// EnqueueFor is Enqueue, with context (for example, the TxnId fun is
+// working on) to identify fun should it run slowly.
+func (exe *Executor) EnqueueFor(context fmt.Stringer, fun func()) bool {
+	atomic.AddInt64(&exe.queued, 1)
+	if exe.send(&contextApplyQuery{context: context, fun: fun}) {
+		return true
+	}
+	atomic.AddInt64(&exe.queued, -1)
+	return false
+}
+
+// EnqueueSync enqueues fun and waits for it to run. It returns false
+// if fun did not run because the executor is shutting down.
+func (exe *Executor) EnqueueSync(fun func()) bool {
+	done := make(chan struct{})
+	if !exe.Enqueue(func() { fun(); close(done) }) {
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-exe.cellTail.Terminated:
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
 }
 
 func (exe *Executor) WithTerminatedChan(fun func(chan struct{})) {
@@ -117,3 +188,30 @@ func (exe *Executor) shutdown() {
 		exe.cellTail.Wait()
 	}
 }
+
+// ExecutorMetrics describe the work done by an Executor since it
+// started.
+type ExecutorMetrics struct {
+	QueueLength int64
+	Executed    uint64
+	Busy        time.Duration
+	// Slow counts the funcs which ran for at least
+	// ExecutorSlowCallback; LastSlow describes the most recent.
+	Slow     uint64
+	LastSlow string
+}
+
+func (em ExecutorMetrics) String() string {
+	return fmt.Sprintf("queue length: %v; executed: %v; busy: %v; slow: %v (last: %v)",
+		em.QueueLength, em.Executed, em.Busy, em.Slow, em.LastSlow)
+}
+
+func (exe *Executor) Metrics() ExecutorMetrics {
+	return ExecutorMetrics{
+		QueueLength: atomic.LoadInt64(&exe.queued),
+		Executed:    atomic.LoadUint64(&exe.executed),
+		Busy:        time.Duration(atomic.LoadInt64(&exe.busy)),
+		Slow:        atomic.LoadUint64(&exe.slow),
+		LastSlow:    exe.lastSlow.Load().(string),
+	}
+}
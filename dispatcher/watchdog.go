@@ -0,0 +1,103 @@
+package dispatcher
+
+import (
+	"fmt"
+	"goshawkdb.io/server"
+	"log"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// run runs fun, timing it. If it takes ExecutorSlowCallback or
+// longer, it is logged, along with context if not nil.
+func (exe *Executor) run(fun func(), context fmt.Stringer) {
+	start := time.Now()
+	atomic.StoreInt64(&exe.runningSince, start.UnixNano())
+	fun()
+	elapsed := time.Now().Sub(start)
+	atomic.StoreInt64(&exe.runningSince, 0)
+	atomic.AddInt64(&exe.busy, int64(elapsed))
+	atomic.AddUint64(&exe.executed, 1)
+	atomic.AddInt64(&exe.queued, -1)
+	if elapsed >= server.ExecutorSlowCallback {
+		caller := funcName(fun)
+		if context != nil {
+			caller = fmt.Sprintf("%v (%v)", caller, context)
+		}
+		atomic.AddUint64(&exe.slow, 1)
+		exe.lastSlow.Store(caller)
+		log.Printf("Warning: Executor %v: %v took %v.", exe.name, caller, elapsed)
+	}
+}
+
+func funcName(fun func()) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(fun).Pointer()); f != nil {
+		return f.Name()
+	}
+	return "unknown"
+}
+
+// A watchdog checks its executors every ExecutorStallCheckInterval
+// for one which has been running the same func for
+// ExecutorStallTimeout or longer. When it finds one, it logs the
+// stacks of every go-routine, once per stall, so that whatever the
+// executor is stuck on can be found.
+//
+// It has its own ticker rather than being a scheduler job: the
+// scheduler is stopped before the node drains, and a stall during a
+// drain is exactly when the stacks are most wanted. It also lives
+// and dies with its dispatcher, well below where the scheduler is
+// created.
+type watchdog struct {
+	executors []*Executor
+	terminate chan server.EmptyStruct
+}
+
+func startWatchdog(executors []*Executor) *watchdog {
+	wd := &watchdog{
+		executors: executors,
+		terminate: make(chan server.EmptyStruct),
+	}
+	go wd.loop()
+	return wd
+}
+
+func (wd *watchdog) stop() {
+	close(wd.terminate)
+}
+
+func (wd *watchdog) loop() {
+	ticker := time.NewTicker(server.ExecutorStallCheckInterval)
+	defer ticker.Stop()
+	reported := make([]int64, len(wd.executors))
+	for {
+		select {
+		case <-wd.terminate:
+			return
+		case now := <-ticker.C:
+			for idx, exe := range wd.executors {
+				since := atomic.LoadInt64(&exe.runningSince)
+				if since == 0 || since == reported[idx] {
+					continue
+				}
+				if stalled := now.Sub(time.Unix(0, since)); stalled >= server.ExecutorStallTimeout {
+					reported[idx] = since
+					log.Printf("Error: Executor %v has made no progress for %v. Stacks:\n%s", exe.name, stalled, stacks())
+				}
+			}
+		}
+	}
+}
+
+func stacks() []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Code embedding the server can register hooks against these phases,
+// for example to register and deregister the server with a service
+// discovery system. Each phase is reached at most once per process.
+type LifecyclePhase uint8
+
+const (
+	// PostRecovery is reached once the local state (proposers,
+	// acceptors and so on) has been loaded from disk.
+	PostRecovery LifecyclePhase = iota
+	// PostTopologyLoad is reached the first time a stable topology has
+	// been installed and we know which cluster members to connect to.
+	PostTopologyLoad LifecyclePhase = iota
+	// PreDrain is reached as shutdown begins, before anything has been
+	// stopped.
+	PreDrain LifecyclePhase = iota
+	// PostShutdown is reached once everything has been stopped.
+	PostShutdown LifecyclePhase = iota
+)
+
+func (phase LifecyclePhase) String() string {
+	switch phase {
+	case PostRecovery:
+		return "PostRecovery"
+	case PostTopologyLoad:
+		return "PostTopologyLoad"
+	case PreDrain:
+		return "PreDrain"
+	case PostShutdown:
+		return "PostShutdown"
+	default:
+		return fmt.Sprintf("LifecyclePhase(%d)", phase)
+	}
+}
+
+// A LifecycleHook which returns an error does not prevent further
+// hooks (nor the server) from running; the error is logged. Hooks
+// for PostRecovery and PostTopologyLoad are given a context which is
+// cancelled as soon as shutdown begins, so long running hooks should
+// watch ctx.Done(). Hooks for PreDrain and PostShutdown are given a
+// context which is cancelled after LifecycleShutdownHookTimeout.
+type LifecycleHook func(ctx context.Context) error
+
+type lifecycle struct {
+	sync.Mutex
+	hooks   map[LifecyclePhase][]LifecycleHook
+	reached map[LifecyclePhase]bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+var theLifecycle = newLifecycle()
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{
+		hooks:   make(map[LifecyclePhase][]LifecycleHook),
+		reached: make(map[LifecyclePhase]bool),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// OnLifecyclePhase registers hook to be run when phase is reached. If
+// phase has already been reached, hook is run immediately (in a new
+// go-routine).
+func OnLifecyclePhase(phase LifecyclePhase, hook LifecycleHook) {
+	lc := theLifecycle
+	lc.Lock()
+	if lc.reached[phase] {
+		ctx, cancel := lc.context(phase)
+		lc.Unlock()
+		go func() {
+			defer cancel()
+			runLifecycleHook(ctx, phase, hook)
+		}()
+		return
+	}
+	lc.hooks[phase] = append(lc.hooks[phase], hook)
+	lc.Unlock()
+}
+
+// LifecyclePhaseReached runs, in order of registration, all the hooks
+// registered for phase and returns once they have all returned. It is
+// safe to call repeatedly: hooks are only run the first time.
+func LifecyclePhaseReached(phase LifecyclePhase) {
+	lc := theLifecycle
+	lc.Lock()
+	if lc.reached[phase] {
+		lc.Unlock()
+		return
+	}
+	lc.reached[phase] = true
+	if phase == PreDrain {
+		lc.cancel()
+	}
+	hooks := lc.hooks[phase]
+	delete(lc.hooks, phase)
+	ctx, cancel := lc.context(phase)
+	lc.Unlock()
+	defer cancel()
+
+	Log("Lifecycle: reached", phase, "running", len(hooks), "hooks")
+	for _, hook := range hooks {
+		runLifecycleHook(ctx, phase, hook)
+	}
+}
+
+// lock must be held.
+func (lc *lifecycle) context(phase LifecyclePhase) (context.Context, context.CancelFunc) {
+	switch phase {
+	case PreDrain, PostShutdown:
+		return context.WithTimeout(context.Background(), LifecycleShutdownHookTimeout)
+	default:
+		return lc.ctx, func() {}
+	}
+}
+
+func runLifecycleHook(ctx context.Context, phase LifecyclePhase, hook LifecycleHook) {
+	if err := hook(ctx); err != nil {
+		log.Printf("Warning: Lifecycle hook for %v failed: %v", phase, err)
+	}
+}
@@ -6,4 +6,5 @@ import "log"
 
 func init() {
 	Log = LogFunc(log.Println)
+	logAll = true
 }
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// logAll is set by debug builds, in which Log emits everything.
+var logAll = false
+
+// A Subsystem is a part of the server whose debug logging can be
+// turned on and off at runtime, independently of the rest. When a
+// subsystem's logging is off, its debug logging goes to Log, and so
+// is only emitted by debug builds.
+type Subsystem struct {
+	name    string
+	enabled int32
+}
+
+var subsystems struct {
+	sync.Mutex
+	byName map[string]*Subsystem
+}
+
+// NewSubsystem registers a subsystem with the given name, which must
+// be unique. It is intended to be called when packages are
+// initialised.
+func NewSubsystem(name string) *Subsystem {
+	subsystems.Lock()
+	defer subsystems.Unlock()
+	if subsystems.byName == nil {
+		subsystems.byName = make(map[string]*Subsystem)
+	}
+	if _, found := subsystems.byName[name]; found {
+		panic(fmt.Sprintf("Subsystem %v registered twice", name))
+	}
+	s := &Subsystem{name: name}
+	subsystems.byName[name] = s
+	return s
+}
+
+func (s *Subsystem) Enabled() bool {
+	return atomic.LoadInt32(&s.enabled) != 0
+}
+
+func (s *Subsystem) Log(elems ...interface{}) {
+	if s.Enabled() {
+		log.Println(append([]interface{}{s.name + ":"}, elems...)...)
+	} else {
+		Log(elems...)
+	}
+}
+
+// Prefixed returns a LogFunc which logs to the subsystem, preceding
+// its elems with those returned by prefix. prefix is called each time
+// the LogFunc is called, so it can describe state which changes, such
+// as that of a txn.
+func (s *Subsystem) Prefixed(prefix func() []interface{}) LogFunc {
+	return func(elems ...interface{}) {
+		if logAll || s.Enabled() {
+			s.Log(append(prefix(), elems...)...)
+		}
+	}
+}
+
+// SetSubsystemLogging turns the debug logging of the named subsystem
+// on or off.
+func SetSubsystemLogging(name string, enabled bool) error {
+	subsystems.Lock()
+	s, found := subsystems.byName[name]
+	subsystems.Unlock()
+	if !found {
+		return fmt.Errorf("No such subsystem: %v", name)
+	}
+	value := int32(0)
+	if enabled {
+		value = 1
+	}
+	if atomic.SwapInt32(&s.enabled, value) != value {
+		log.Printf("Debug logging of %v set to %v", name, enabled)
+	}
+	return nil
+}
+
+// SubsystemLogging returns whether the debug logging of each
+// subsystem is on.
+func SubsystemLogging() map[string]bool {
+	subsystems.Lock()
+	defer subsystems.Unlock()
+	result := make(map[string]bool, len(subsystems.byName))
+	for name, s := range subsystems.byName {
+		result[name] = s.Enabled()
+	}
+	return result
+}
+
+func SubsystemLoggingStatus(sc *StatusConsumer) {
+	logging := SubsystemLogging()
+	names := make([]string, 0, len(logging))
+	for name, enabled := range logging {
+		if enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	sc.Emit(fmt.Sprintf("Debug logging enabled for: %v", names))
+	sc.Join()
+}
+
+// A Logger logs to a subsystem with a fixed set of fields, written as
+// key=value ahead of each line, so that lines can be filtered by
+// field (every line about one txn, say, across the logs of every RM)
+// and parsed by machine. Debug lines, from Log, go through the
+// subsystem, and so are only written when its logging is on;
+// everything else is always written.
+type Logger struct {
+	subsystem *Subsystem
+	parent    *Logger
+	keyvals   []interface{}
+}
+
+// With returns a Logger for the subsystem with the given fields,
+// which are alternate keys and values.
+func (s *Subsystem) With(keyvals ...interface{}) *Logger {
+	return &Logger{subsystem: s, keyvals: keyvals}
+}
+
+// With returns a Logger with the fields of l, followed by the given
+// fields. The fields are only formatted when a line is written, so
+// With is cheap enough to call for every debug line, and values which
+// are pointers are formatted as they are when each line is written.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	return &Logger{subsystem: l.subsystem, parent: l, keyvals: keyvals}
+}
+
+// Enabled returns whether debug lines will be written.
+func (l *Logger) Enabled() bool {
+	return logAll || l.subsystem.Enabled()
+}
+
+// fields formats the fields of l as key=value, separated by spaces.
+func (l *Logger) fields() string {
+	fields := ""
+	if l.parent != nil {
+		fields = l.parent.fields()
+	}
+	for idx := 0; idx+1 < len(l.keyvals); idx += 2 {
+		if fields != "" {
+			fields += " "
+		}
+		fields += fmt.Sprintf("%v=%v", l.keyvals[idx], l.keyvals[idx+1])
+	}
+	return fields
+}
+
+func (l *Logger) Log(elems ...interface{}) {
+	if !l.Enabled() {
+		return
+	} else if fields := l.fields(); fields == "" {
+		l.subsystem.Log(elems...)
+	} else {
+		l.subsystem.Log(append([]interface{}{fields}, elems...)...)
+	}
+}
+
+// line is the message, preceded by the subsystem name and fields.
+func (l *Logger) line(format string, args []interface{}) string {
+	if fields := l.fields(); fields != "" {
+		return l.subsystem.name + ": " + fields + " " + fmt.Sprintf(format, args...)
+	}
+	return l.subsystem.name + ": " + fmt.Sprintf(format, args...)
+}
+
+func (l *Logger) Info(format string, args ...interface{}) {
+	log.Print(l.line(format, args))
+}
+
+func (l *Logger) Warn(format string, args ...interface{}) {
+	log.Print("Warning: " + l.line(format, args))
+}
+
+func (l *Logger) Error(format string, args ...interface{}) {
+	log.Print("Error: " + l.line(format, args))
+}
@@ -0,0 +1,443 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogSinkConfig describes one destination for the server's log. The
+// log package is pointed at LogOutput, which writes each line to
+// every sink whose Level it meets. Until SetLogSinks is called, lines
+// go to stderr.
+type LogSinkConfig struct {
+	// Kind is one of "stderr", "file", "syslog" or "tcp".
+	Kind string
+	// Level is the least severe level of line written to the sink:
+	// "info" (the default), "warning" or "error".
+	Level string
+	// Path is the file written by a file sink.
+	Path string `json:",omitempty"`
+	// MaxBytes is the size beyond which a file sink rotates its
+	// file, keeping MaxFiles old files. If 0, the file is never
+	// rotated.
+	MaxBytes int64 `json:",omitempty"`
+	MaxFiles int   `json:",omitempty"`
+	// Address is the host:port to which a tcp sink sends each line,
+	// as a JSON object.
+	Address string `json:",omitempty"`
+}
+
+func (lsc LogSinkConfig) String() string {
+	switch lsc.Kind {
+	case "file":
+		return fmt.Sprintf("file(%v; level: %v; max bytes: %v; max files: %v)", lsc.Path, lsc.Level, lsc.MaxBytes, lsc.MaxFiles)
+	case "tcp":
+		return fmt.Sprintf("tcp(%v; level: %v)", lsc.Address, lsc.Level)
+	default:
+		return fmt.Sprintf("%v(level: %v)", lsc.Kind, lsc.Level)
+	}
+}
+
+type logLevel uint8
+
+const (
+	logLevelInfo    logLevel = iota
+	logLevelWarning logLevel = iota
+	logLevelError   logLevel = iota
+)
+
+func parseLogLevel(level string) (logLevel, error) {
+	switch level {
+	case "", "info":
+		return logLevelInfo, nil
+	case "warning":
+		return logLevelWarning, nil
+	case "error":
+		return logLevelError, nil
+	default:
+		return 0, fmt.Errorf("Unknown log level: %v", level)
+	}
+}
+
+func (l logLevel) String() string {
+	switch l {
+	case logLevelInfo:
+		return "info"
+	case logLevelWarning:
+		return "warning"
+	case logLevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("logLevel(%d)", uint8(l))
+	}
+}
+
+var (
+	errorMarker   = []byte(" Error: ")
+	warningMarker = []byte(" Warning: ")
+)
+
+// lineLevel finds the level of a line from its message, which by
+// convention starts with "Error:" or "Warning:" if it is either.
+func lineLevel(line []byte) logLevel {
+	switch {
+	case bytes.Contains(line, errorMarker):
+		return logLevelError
+	case bytes.Contains(line, warningMarker):
+		return logLevelWarning
+	default:
+		return logLevelInfo
+	}
+}
+
+// A logSink must not itself log: it is called with logSinks locked,
+// or, behind an asyncLogSink, would feed its own queue.
+type logSink interface {
+	write(level logLevel, line []byte) error
+	close() error
+}
+
+type configuredLogSink struct {
+	config   LogSinkConfig
+	level    logLevel
+	sink     logSink
+	written  uint64
+	failures uint64
+	dropped  uint64
+}
+
+var logSinks struct {
+	sync.Mutex
+	sinks []*configuredLogSink
+}
+
+// LogOutput writes each line logged to the log sinks.
+var LogOutput io.Writer = logSinkWriter{}
+
+type logSinkWriter struct{}
+
+func (lsw logSinkWriter) Write(line []byte) (int, error) {
+	level := lineLevel(line)
+	logSinks.Lock()
+	defer logSinks.Unlock()
+	if logSinks.sinks == nil {
+		return os.Stderr.Write(line)
+	}
+	for _, s := range logSinks.sinks {
+		if level < s.level {
+			continue
+		}
+		if err := s.sink.write(level, line); err == nil {
+			s.written++
+		} else if err == errLogSinkFull {
+			s.dropped++
+		} else {
+			s.failures++
+		}
+	}
+	return len(line), nil
+}
+
+// SetLogSinks replaces the log sinks with those described by
+// configs. If any of them can't be opened, the sinks are left as they
+// were.
+func SetLogSinks(configs []LogSinkConfig) error {
+	sinks := make([]*configuredLogSink, 0, len(configs))
+	closeAll := func(sinks []*configuredLogSink) {
+		for _, s := range sinks {
+			s.sink.close()
+		}
+	}
+	for _, config := range configs {
+		level, err := parseLogLevel(config.Level)
+		if err != nil {
+			closeAll(sinks)
+			return err
+		}
+		sink, err := newLogSink(&config)
+		if err != nil {
+			closeAll(sinks)
+			return err
+		}
+		config.Level = level.String()
+		sinks = append(sinks, &configuredLogSink{config: config, level: level, sink: sink})
+	}
+	logSinks.Lock()
+	old := logSinks.sinks
+	logSinks.sinks = sinks
+	logSinks.Unlock()
+	closeAll(old)
+	log.Printf("Log sinks set to %v", configs)
+	return nil
+}
+
+func newLogSink(config *LogSinkConfig) (logSink, error) {
+	switch config.Kind {
+	case "stderr":
+		return stderrLogSink{}, nil
+	case "file":
+		return newFileLogSink(config.Path, config.MaxBytes, config.MaxFiles)
+	case "syslog":
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "goshawkdb")
+		if err != nil {
+			return nil, err
+		}
+		return newAsyncLogSink(&syslogLogSink{writer: w}), nil
+	case "tcp":
+		if _, _, err := net.SplitHostPort(config.Address); err != nil {
+			return nil, err
+		}
+		return newAsyncLogSink(&tcpLogSink{address: config.Address}), nil
+	default:
+		return nil, fmt.Errorf("Unknown log sink kind: %v", config.Kind)
+	}
+}
+
+// LogSinks returns the configuration of the current log sinks.
+func LogSinks() []LogSinkConfig {
+	logSinks.Lock()
+	defer logSinks.Unlock()
+	if logSinks.sinks == nil {
+		return []LogSinkConfig{{Kind: "stderr", Level: logLevelInfo.String()}}
+	}
+	configs := make([]LogSinkConfig, len(logSinks.sinks))
+	for idx, s := range logSinks.sinks {
+		configs[idx] = s.config
+	}
+	return configs
+}
+
+func LogSinksStatus(sc *StatusConsumer) {
+	logSinks.Lock()
+	for _, s := range logSinks.sinks {
+		written, failures := s.written, s.failures
+		if async, ok := s.sink.(*asyncLogSink); ok {
+			// Lines queued aren't written until the sink's go-routine
+			// gets to them.
+			written, failures = async.counts()
+		}
+		sc.Emit(fmt.Sprintf("Log sink %v: %v lines written; %v failures; %v dropped", s.config, written, failures, s.dropped))
+	}
+	logSinks.Unlock()
+	sc.Join()
+}
+
+type stderrLogSink struct{}
+
+func (sls stderrLogSink) write(level logLevel, line []byte) error {
+	_, err := os.Stderr.Write(line)
+	return err
+}
+
+func (sls stderrLogSink) close() error { return nil }
+
+// An asyncLogSink writes lines to a sink whose writes may block for
+// a long time (syslog, or a tcp collector) from a go-routine of its
+// own, so that a slow or unreachable destination never holds up
+// logging. Lines wait in a queue of LogSinkQueueLength; when it is
+// full, lines are dropped.
+type asyncLogSink struct {
+	sink     logSink
+	lines    chan asyncLogLine
+	written  uint64
+	failures uint64
+}
+
+type asyncLogLine struct {
+	level logLevel
+	line  []byte
+}
+
+var errLogSinkFull = errors.New("Log sink queue full")
+
+func newAsyncLogSink(sink logSink) *asyncLogSink {
+	als := &asyncLogSink{
+		sink:  sink,
+		lines: make(chan asyncLogLine, LogSinkQueueLength),
+	}
+	go als.run()
+	return als
+}
+
+func (als *asyncLogSink) run() {
+	for l := range als.lines {
+		if err := als.sink.write(l.level, l.line); err == nil {
+			atomic.AddUint64(&als.written, 1)
+		} else {
+			atomic.AddUint64(&als.failures, 1)
+		}
+	}
+	als.sink.close()
+}
+
+// write queues line. The log package reuses its buffer, so line is
+// copied.
+func (als *asyncLogSink) write(level logLevel, line []byte) error {
+	select {
+	case als.lines <- asyncLogLine{level: level, line: append([]byte(nil), line...)}:
+		return nil
+	default:
+		return errLogSinkFull
+	}
+}
+
+// close lets the lines already queued be written, and then closes the
+// sink, without waiting for either.
+func (als *asyncLogSink) close() error {
+	close(als.lines)
+	return nil
+}
+
+func (als *asyncLogSink) counts() (written, failures uint64) {
+	return atomic.LoadUint64(&als.written), atomic.LoadUint64(&als.failures)
+}
+
+type fileLogSink struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func newFileLogSink(path string, maxBytes int64, maxFiles int) (*fileLogSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("File log sink needs a Path")
+	}
+	fls := &fileLogSink{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	return fls, fls.open()
+}
+
+func (fls *fileLogSink) open() error {
+	file, err := os.OpenFile(fls.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	fls.file, fls.size = file, info.Size()
+	return nil
+}
+
+// rotate moves path to path.1, path.1 to path.2, and so on, dropping
+// the oldest, and starts a new file at path.
+func (fls *fileLogSink) rotate() error {
+	fls.file.Close()
+	fls.file = nil
+	for idx := fls.maxFiles - 1; idx > 0; idx-- {
+		os.Rename(fmt.Sprintf("%s.%d", fls.path, idx), fmt.Sprintf("%s.%d", fls.path, idx+1))
+	}
+	if fls.maxFiles > 0 {
+		if err := os.Rename(fls.path, fls.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(fls.path); err != nil {
+		return err
+	}
+	return fls.open()
+}
+
+func (fls *fileLogSink) write(level logLevel, line []byte) error {
+	if fls.file == nil {
+		if err := fls.open(); err != nil {
+			return err
+		}
+	}
+	if fls.maxBytes > 0 && fls.size+int64(len(line)) > fls.maxBytes && fls.size > 0 {
+		if err := fls.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := fls.file.Write(line)
+	fls.size += int64(n)
+	return err
+}
+
+func (fls *fileLogSink) close() error {
+	if fls.file == nil {
+		return nil
+	}
+	return fls.file.Close()
+}
+
+type syslogLogSink struct {
+	writer *syslog.Writer
+}
+
+func (sls *syslogLogSink) write(level logLevel, line []byte) error {
+	msg := string(bytes.TrimRight(line, "\n"))
+	switch level {
+	case logLevelError:
+		return sls.writer.Err(msg)
+	case logLevelWarning:
+		return sls.writer.Warning(msg)
+	default:
+		return sls.writer.Info(msg)
+	}
+}
+
+func (sls *syslogLogSink) close() error {
+	return sls.writer.Close()
+}
+
+// A tcpLogSink sends each line as a JSON object, on a line of its
+// own. It is written to through an asyncLogSink. If the connection
+// fails, lines are dropped until LogSinkTCPRetryDelay has passed, so
+// that an unreachable collector doesn't back up the queue.
+type tcpLogSink struct {
+	address     string
+	conn        net.Conn
+	lastFailure time.Time
+}
+
+type tcpLogLine struct {
+	Time  time.Time
+	Level string
+	Line  string
+}
+
+func (ts *tcpLogSink) write(level logLevel, line []byte) error {
+	now := time.Now()
+	if ts.conn == nil {
+		if now.Sub(ts.lastFailure) < LogSinkTCPRetryDelay {
+			return fmt.Errorf("Log sink %v unavailable", ts.address)
+		}
+		conn, err := net.DialTimeout("tcp", ts.address, LogSinkTCPTimeout)
+		if err != nil {
+			ts.lastFailure = now
+			return err
+		}
+		ts.conn = conn
+	}
+	bites, err := json.Marshal(&tcpLogLine{Time: now, Level: level.String(), Line: string(bytes.TrimRight(line, "\n"))})
+	if err != nil {
+		return err
+	}
+	ts.conn.SetWriteDeadline(now.Add(LogSinkTCPTimeout))
+	if _, err = ts.conn.Write(append(bites, '\n')); err != nil {
+		ts.conn.Close()
+		ts.conn = nil
+		ts.lastFailure = now
+	}
+	return err
+}
+
+func (ts *tcpLogSink) close() error {
+	if ts.conn == nil {
+		return nil
+	}
+	return ts.conn.Close()
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// blockedLogSink holds up every write until it is unblocked.
+type blockedLogSink struct {
+	unblock chan struct{}
+	written chan string
+}
+
+func (bls *blockedLogSink) write(level logLevel, line []byte) error {
+	<-bls.unblock
+	bls.written <- string(line)
+	return nil
+}
+
+func (bls *blockedLogSink) close() error {
+	close(bls.written)
+	return nil
+}
+
+func TestAsyncLogSink(t *testing.T) {
+	sink := &blockedLogSink{
+		unblock: make(chan struct{}),
+		written: make(chan string, 2*LogSinkQueueLength),
+	}
+	als := newAsyncLogSink(sink)
+
+	// However long the sink blocks, writes don't: once the queue is
+	// full, lines are dropped.
+	done := make(chan int)
+	go func() {
+		dropped := 0
+		for idx := 0; idx < 2*LogSinkQueueLength; idx++ {
+			if err := als.write(logLevelInfo, []byte("line\n")); err == errLogSinkFull {
+				dropped++
+			} else if err != nil {
+				t.Error(err)
+			}
+		}
+		done <- dropped
+	}()
+	var dropped int
+	select {
+	case dropped = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Writes to the log sink blocked")
+	}
+	if dropped < LogSinkQueueLength-1 {
+		t.Fatalf("Expected at least %v lines to be dropped; %v were", LogSinkQueueLength-1, dropped)
+	}
+
+	// The lines queued are written once the sink unblocks, and the
+	// sink is closed after them.
+	close(sink.unblock)
+	als.close()
+	written := 0
+	for line := range sink.written {
+		if line != "line\n" {
+			t.Fatalf("Unexpected line written: %q", line)
+		}
+		written++
+	}
+	if written+dropped != 2*LogSinkQueueLength {
+		t.Fatalf("Expected every line to be written or dropped; %v written, %v dropped", written, dropped)
+	}
+	if w, f := als.counts(); int(w) != written || f != 0 {
+		t.Fatalf("Expected %v written and no failures; got %v and %v", written, w, f)
+	}
+}
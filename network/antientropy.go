@@ -0,0 +1,210 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	ch "goshawkdb.io/server/consistenthash"
+	"goshawkdb.io/server/db"
+	"hash"
+)
+
+// FrontierSummary is a two level Merkle tree of the frontiers (the
+// frame txns) of the vars held by both RMId and Peer. Vars are
+// placed into AntiEntropyBuckets buckets by the hash of their
+// UUId. Each bucket's hash covers, in UUId order, the UUId and frame
+// txn of every var in the bucket, and Root covers the buckets' hashes
+// in order. Two RMs holding the same vars with the same frontiers
+// produce identical summaries of each other, whatever the order in
+// which their txns were applied.
+type FrontierSummary struct {
+	RMId    common.RMId
+	Peer    common.RMId
+	Version uint32
+	Vars    uint64
+	Root    string
+	Buckets []string
+}
+
+// FrontierDivergence is the result of CompareFrontiers.
+type FrontierDivergence struct {
+	Local  *FrontierSummary
+	Remote *FrontierSummary
+	// Buckets lists the indices of the buckets whose hashes differ.
+	Buckets []int
+	// Frontiers lists (up to the limit requested) the vars in the
+	// divergent buckets held by this RM, with their frame txns. The
+	// same comparison run on Peer lists the vars it holds in the same
+	// buckets.
+	Frontiers      []*VarFrontier
+	FrontiersCount int
+}
+
+type VarFrontier struct {
+	Bucket     int
+	VarUUId    string
+	FrameTxnId string
+}
+
+// FrontierSummary summarises the frontiers of the vars held by both
+// this RM and peer, so that they can be compared with the same
+// summary taken on peer. The summary is taken from a single snapshot
+// of the var store, but txns in flight can make the frontiers of
+// the two RMs differ briefly, so only divergence which persists
+// across several comparisons indicates lost outcomes.
+func (tt *TopologyTransmogrifier) FrontierSummary(peer common.RMId) (*FrontierSummary, error) {
+	summary, _, err := tt.frontierSummary(peer, nil)
+	return summary, err
+}
+
+// CompareFrontiers compares remote, a FrontierSummary taken on
+// another RM of this RM, with the summary of that RM taken here, and
+// reports the buckets which differ and the vars in them. It only
+// reports: there is no message with which RMs could exchange
+// summaries, or a var's frame, so the comparison is driven by an
+// operator, and divergent vars are left to be rewritten by txns.
+func (tt *TopologyTransmogrifier) CompareFrontiers(remote *FrontierSummary, limit int) (*FrontierDivergence, error) {
+	rmId := tt.connectionManager.RMId
+	switch {
+	case remote.Peer != rmId:
+		return nil, fmt.Errorf("Summary is of %v, not of this RM (%v).", remote.Peer, rmId)
+	case len(remote.Buckets) != server.AntiEntropyBuckets:
+		return nil, fmt.Errorf("Summary has %v buckets; expected %v.", len(remote.Buckets), server.AntiEntropyBuckets)
+	}
+	local, _, err := tt.frontierSummary(remote.RMId, nil)
+	if err != nil {
+		return nil, err
+	} else if local.Version != remote.Version {
+		return nil, fmt.Errorf("Summary is of topology version %v; this RM has version %v.", remote.Version, local.Version)
+	}
+	divergence := &FrontierDivergence{
+		Local:     local,
+		Remote:    remote,
+		Buckets:   []int{},
+		Frontiers: []*VarFrontier{},
+	}
+	if local.Root == remote.Root {
+		return divergence, nil
+	}
+	divergent := make(map[int]bool)
+	for idx, bucketHash := range local.Buckets {
+		if remote.Buckets[idx] != bucketHash {
+			divergent[idx] = true
+			divergence.Buckets = append(divergence.Buckets, idx)
+		}
+	}
+	// A second pass lists the vars in the divergent buckets, rather
+	// than holding on to every var's frontier from the first.
+	_, frontiers, err := tt.frontierSummary(remote.RMId, divergent)
+	if err != nil {
+		return nil, err
+	}
+	divergence.FrontiersCount = len(frontiers)
+	if len(frontiers) > limit {
+		frontiers = frontiers[:limit]
+	}
+	divergence.Frontiers = frontiers
+	return divergence, nil
+}
+
+// frontierSummary summarises the vars held by both this RM and
+// peer, and lists the frontiers of the vars in the buckets given.
+func (tt *TopologyTransmogrifier) frontierSummary(peer common.RMId, buckets map[int]bool) (*FrontierSummary, []*VarFrontier, error) {
+	topology, err := tt.stableTopology("summarise var frontiers")
+	if err != nil {
+		return nil, nil, err
+	}
+	rmId := tt.connectionManager.RMId
+	if peer == rmId {
+		return nil, nil, errors.New("Peer must be another RM.")
+	}
+	rmIds := topology.DataRMs()
+	rmIdx, peerIdx := -1, -1
+	for idx, holder := range rmIds {
+		switch holder {
+		case rmId:
+			rmIdx = idx
+		case peer:
+			peerIdx = idx
+		}
+	}
+	if rmIdx == -1 || peerIdx == -1 {
+		return nil, nil, fmt.Errorf("Both %v and %v must hold vars in topology version %v.", rmId, peer, topology.Version)
+	}
+
+	resolver := ch.NewResolver(rmIds, topology.TwoFInc)
+	summary := &FrontierSummary{
+		RMId:    rmId,
+		Peer:    peer,
+		Version: topology.Version,
+		Buckets: make([]string, server.AntiEntropyBuckets),
+	}
+	hashers := make([]hash.Hash, server.AntiEntropyBuckets)
+	frontiers := []*VarFrontier{}
+	result, err := tt.db.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
+		rtxn.Iterate(tt.db.Vars, func(vUUIdBytes, varBytes []byte) bool {
+			if bytes.Equal(vUUIdBytes, configuration.TopologyVarUUId[:]) {
+				return true
+			}
+			db.Stats.Vars.Read(varBytes, nil)
+			seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
+			if err != nil {
+				rtxn.Error(err)
+				return false
+			}
+			varCap := msgs.ReadRootVar(seg)
+			positions := varCap.Positions().ToArray()
+			for _, idx := range []int{rmIdx, peerIdx} {
+				if held, err := resolver.RMIdHasVar(idx, positions); err != nil {
+					rtxn.Error(err)
+					return false
+				} else if !held {
+					return true
+				}
+			}
+			summary.Vars++
+			bucketHash := sha256.Sum256(vUUIdBytes)
+			bucket := int(bucketHash[0]) % server.AntiEntropyBuckets
+			hasher := hashers[bucket]
+			if hasher == nil {
+				hasher = sha256.New()
+				hashers[bucket] = hasher
+			}
+			hasher.Write(vUUIdBytes)
+			hasher.Write(varCap.WriteTxnId())
+			if buckets[bucket] {
+				frontiers = append(frontiers, &VarFrontier{
+					Bucket:     bucket,
+					VarUUId:    hex.EncodeToString(vUUIdBytes),
+					FrameTxnId: hex.EncodeToString(varCap.WriteTxnId()),
+				})
+			}
+			return true
+		})
+		return true
+	}).ResultError()
+	if err != nil {
+		return nil, nil, err
+	} else if result == nil {
+		return nil, nil, errors.New("Shutting down.")
+	}
+
+	root := sha256.New()
+	for idx, hasher := range hashers {
+		if hasher == nil {
+			hasher = sha256.New()
+		}
+		bucketHash := hasher.Sum(nil)
+		root.Write(bucketHash)
+		summary.Buckets[idx] = hex.EncodeToString(bucketHash)
+	}
+	summary.Root = hex.EncodeToString(root.Sum(nil))
+	return summary, frontiers, nil
+}
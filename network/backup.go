@@ -0,0 +1,80 @@
+package network
+
+import (
+	"errors"
+	"goshawkdb.io/server/db"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Backup streams a consistent snapshot of this RM's vars, txns,
+// acceptor and proposer state to w, whilst txns continue. It is
+// refused whilst a topology change is in progress: during a change,
+// vars are migrating between RMs, so a set of backups from every RM
+// would not be guaranteed to hold every var.
+func (tt *TopologyTransmogrifier) Backup(w io.Writer) (*db.BackupSummary, error) {
+	var header *db.BackupHeader
+	var err error
+	resultChan := make(chan struct{})
+	enqueued := tt.enqueueQuery(topologyTransmogrifierMsgExe(func() error {
+		defer close(resultChan)
+		switch {
+		case tt.active == nil:
+			err = errors.New("Unable to backup: no topology installed yet.")
+		case tt.active.Next() != nil:
+			err = errors.New("Unable to backup: topology change in progress.")
+		default:
+			header = &db.BackupHeader{
+				Created:         time.Now(),
+				RMId:            tt.connectionManager.RMId,
+				BootCount:       tt.connectionManager.BootCount(),
+				ClusterId:       tt.active.ClusterId,
+				ClusterUUId:     tt.active.ClusterUUId(),
+				TopologyVersion: tt.active.Version,
+			}
+		}
+		return nil
+	}))
+	if !enqueued {
+		return nil, errors.New("Shutting down.")
+	}
+	select {
+	case <-resultChan:
+	case <-tt.cellTail.Terminated:
+		return nil, errors.New("Shutting down.")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	summary, err := tt.db.Backup(header, w)
+	if err != nil {
+		log.Printf("Error: Backup failed: %v", err)
+		return nil, err
+	}
+	log.Println(summary)
+	return summary, nil
+}
+
+// BackupToFile writes a backup to a new file at path. The file is
+// removed if the backup fails.
+func (tt *TopologyTransmogrifier) BackupToFile(path string) (*db.BackupSummary, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := tt.Backup(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return summary, nil
+}
@@ -0,0 +1,123 @@
+package network
+
+import (
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"sort"
+	"sync"
+)
+
+// bandwidthSubsystem is the class of traffic a message sent to
+// another RM belongs to. When a link saturates, this tells operators
+// which class of traffic to throttle.
+type bandwidthSubsystem uint8
+
+const (
+	bandwidthConnection bandwidthSubsystem = iota
+	bandwidthPaxos      bandwidthSubsystem = iota
+	bandwidthOutcomes   bandwidthSubsystem = iota
+	bandwidthCompletion bandwidthSubsystem = iota
+	bandwidthMigration  bandwidthSubsystem = iota
+	bandwidthTopology   bandwidthSubsystem = iota
+	bandwidthUnknown    bandwidthSubsystem = iota
+	bandwidthSubsystems int                = iota
+)
+
+func (bs bandwidthSubsystem) String() string {
+	switch bs {
+	case bandwidthConnection:
+		return "connection"
+	case bandwidthPaxos:
+		return "paxos"
+	case bandwidthOutcomes:
+		return "outcomes"
+	case bandwidthCompletion:
+		return "completion"
+	case bandwidthMigration:
+		return "migration"
+	case bandwidthTopology:
+		return "topology"
+	default:
+		return "unknown"
+	}
+}
+
+func classifyMessage(msg []byte) (subsystem bandwidthSubsystem) {
+	defer func() {
+		if r := recover(); r != nil {
+			subsystem = bandwidthUnknown
+		}
+	}()
+	seg, _, err := capn.ReadFromMemoryZeroCopy(msg)
+	if err != nil {
+		return bandwidthUnknown
+	}
+	switch msgs.ReadRootMessage(seg).Which() {
+	case msgs.MESSAGE_HEARTBEAT, msgs.MESSAGE_FLUSHED, msgs.MESSAGE_CONNECTIONERROR:
+		return bandwidthConnection
+	case msgs.MESSAGE_TXNSUBMISSION, msgs.MESSAGE_ONEATXNVOTES, msgs.MESSAGE_ONEBTXNVOTES, msgs.MESSAGE_TWOATXNVOTES, msgs.MESSAGE_TWOBTXNVOTES:
+		return bandwidthPaxos
+	case msgs.MESSAGE_SUBMISSIONOUTCOME:
+		return bandwidthOutcomes
+	case msgs.MESSAGE_SUBMISSIONCOMPLETE, msgs.MESSAGE_SUBMISSIONABORT, msgs.MESSAGE_TXNLOCALLYCOMPLETE, msgs.MESSAGE_TXNGLOBALLYCOMPLETE:
+		return bandwidthCompletion
+	case msgs.MESSAGE_MIGRATION, msgs.MESSAGE_MIGRATIONCOMPLETE, msgs.MESSAGE_MIGRATIONBATCHACK:
+		return bandwidthMigration
+	case msgs.MESSAGE_TOPOLOGYCHANGEREQUEST, msgs.MESSAGE_LEARNERONLY:
+		return bandwidthTopology
+	default:
+		return bandwidthUnknown
+	}
+}
+
+// bandwidthAccountant counts the bytes sent to each RM, per
+// subsystem. It is shared by all server connections, so all methods
+// are safe for concurrent use.
+type bandwidthAccountant struct {
+	sync.Mutex
+	sent map[common.RMId]*[bandwidthSubsystems]uint64
+}
+
+func newBandwidthAccountant() *bandwidthAccountant {
+	return &bandwidthAccountant{
+		sent: make(map[common.RMId]*[bandwidthSubsystems]uint64),
+	}
+}
+
+func (ba *bandwidthAccountant) account(rmId common.RMId, msg []byte) {
+	subsystem := classifyMessage(msg)
+	ba.Lock()
+	counts, found := ba.sent[rmId]
+	if !found {
+		counts = new([bandwidthSubsystems]uint64)
+		ba.sent[rmId] = counts
+	}
+	counts[subsystem] += uint64(len(msg))
+	ba.Unlock()
+}
+
+func (ba *bandwidthAccountant) Status(sc *server.StatusConsumer) {
+	ba.Lock()
+	lines := make([]string, 0, len(ba.sent))
+	for rmId, counts := range ba.sent {
+		total := uint64(0)
+		breakdown := ""
+		for idx, count := range counts {
+			total += count
+			if count != 0 {
+				breakdown += fmt.Sprintf("; %v: %v", bandwidthSubsystem(idx), count)
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%v: %v%s", rmId, total, breakdown))
+	}
+	ba.Unlock()
+	sort.Strings(lines)
+	sc.Emit("Bytes sent to RMs")
+	for _, line := range lines {
+		sc.Emit(fmt.Sprintf("- %s", line))
+	}
+	sc.Join()
+}
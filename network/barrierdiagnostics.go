@@ -0,0 +1,195 @@
+package network
+
+import (
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	"goshawkdb.io/server/configuration"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// BarrierDiagnosis describes why a topology change is waiting at a
+// barrier, as seen from this RM.
+type BarrierDiagnosis struct {
+	Version uint32
+	Barrier int
+	Since   time.Time
+	// Waiting lists the RMs which have not yet reached the barrier.
+	Waiting common.RMIds
+	// Disconnected lists the Waiting RMs we have no connection to.
+	Disconnected common.RMIds
+	// LocalPending lists the local subsystems which are yet to
+	// acknowledge the barrier, if this RM is Waiting. Vars and
+	// proposers acknowledge once the txns in flight through them have
+	// finished.
+	LocalPending []string
+	// Immigrating lists the RMs which have sent us migrations for
+	// this topology change which we are yet to finish applying.
+	Immigrating common.RMIds
+}
+
+func (bd *BarrierDiagnosis) String() string {
+	return fmt.Sprintf("Topology %v waiting at barrier %v for %v: waiting for RMs: %v; disconnected: %v; locally pending: %v; immigrating from: %v",
+		bd.Version, bd.Barrier, time.Now().Sub(bd.Since), bd.Waiting, bd.Disconnected, bd.LocalPending, bd.Immigrating)
+}
+
+// barrierWatch notices when a topology change sits at a barrier for
+// longer than TopologyBarrierReportDelay, and then logs a
+// diagnosis. Whilst the change stays at the same barrier, further
+// diagnoses are logged at doubling intervals, up to
+// TopologyBarrierReportMaxDelay.
+type barrierWatch struct {
+	version   uint32
+	barrier   int
+	since     time.Time
+	delay     time.Duration
+	timer     *time.Timer
+	diagnosis *BarrierDiagnosis
+}
+
+// currentBarrier returns which barrier (1 or 2) the active topology
+// change is waiting at, or 0 if it is not waiting at a barrier.
+func currentBarrier(topology *configuration.Topology) int {
+	if topology == nil {
+		return 0
+	}
+	next := topology.Next()
+	if next == nil || !next.InstalledOnNew {
+		return 0
+	}
+	for _, rmId := range topology.RMs() {
+		if rmId != common.RMIdEmpty && !topology.NextBarrierReached1(rmId) {
+			return 1
+		}
+	}
+	for _, rmId := range topology.RMs() {
+		if rmId != common.RMIdEmpty && !topology.NextBarrierReached2(rmId) {
+			return 2
+		}
+	}
+	return 0
+}
+
+func (tt *TopologyTransmogrifier) watchBarrier() {
+	barrier := currentBarrier(tt.active)
+	bw := tt.barrierWatch
+	if barrier == 0 {
+		if bw != nil {
+			bw.timer.Stop()
+			tt.barrierWatch = nil
+		}
+		return
+	}
+	version := tt.active.Next().Version
+	if bw != nil && bw.version == version && bw.barrier == barrier {
+		return
+	}
+	if bw != nil {
+		bw.timer.Stop()
+	}
+	bw = &barrierWatch{
+		version: version,
+		barrier: barrier,
+		since:   time.Now(),
+		delay:   server.TopologyBarrierReportDelay,
+	}
+	tt.barrierWatch = bw
+	bw.schedule(tt)
+}
+
+func (bw *barrierWatch) schedule(tt *TopologyTransmogrifier) {
+	bw.timer = time.AfterFunc(bw.delay, func() {
+		tt.enqueueQuery(topologyTransmogrifierMsgExe(func() error {
+			if tt.barrierWatch != bw {
+				return nil
+			}
+			bw.diagnosis = tt.diagnoseBarrier(bw)
+			log.Printf("Warning: %v", bw.diagnosis)
+			bw.delay *= 2
+			if bw.delay > server.TopologyBarrierReportMaxDelay {
+				bw.delay = server.TopologyBarrierReportMaxDelay
+			}
+			bw.schedule(tt)
+			return nil
+		}))
+	})
+}
+
+func (tt *TopologyTransmogrifier) diagnoseBarrier(bw *barrierWatch) *BarrierDiagnosis {
+	bd := &BarrierDiagnosis{
+		Version: bw.version,
+		Barrier: bw.barrier,
+		Since:   bw.since,
+	}
+	reached := tt.active.NextBarrierReached1
+	if bw.barrier == 2 {
+		reached = tt.active.NextBarrierReached2
+	}
+	for _, rmId := range tt.active.RMs() {
+		if rmId == common.RMIdEmpty || reached(rmId) {
+			continue
+		}
+		bd.Waiting = append(bd.Waiting, rmId)
+		if _, found := tt.activeConnections[rmId]; !found && rmId != tt.connectionManager.RMId {
+			bd.Disconnected = append(bd.Disconnected, rmId)
+		}
+	}
+	switch task := tt.task.(type) {
+	case *awaitBarrier1:
+		bd.LocalPending = task.pendingSubsystems()
+	case *awaitBarrier2:
+		bd.LocalPending = task.pendingSubsystems()
+	}
+	for rmId, inprogressPtr := range tt.migrations[bw.version] {
+		if atomic.LoadInt32(inprogressPtr) > 0 {
+			bd.Immigrating = append(bd.Immigrating, rmId)
+		}
+	}
+	return bd
+}
+
+func (task *awaitBarrier1) pendingSubsystems() []string {
+	installing := task.installing
+	pending := []string{}
+	if installing == nil || task.varBarrierReached != installing {
+		pending = append(pending, "vars")
+	}
+	if installing == nil || task.proposerBarrierReached != installing {
+		pending = append(pending, "proposers")
+	}
+	if installing == nil || task.connectionBarrierReached != installing {
+		pending = append(pending, "client connections")
+	}
+	if installing == nil || task.connectionManagerBarrierReached != installing {
+		pending = append(pending, "connection manager")
+	}
+	return pending
+}
+
+func (task *awaitBarrier2) pendingSubsystems() []string {
+	if task.installing == nil || task.varBarrierReached != task.installing {
+		return []string{"vars"}
+	}
+	return []string{}
+}
+
+// Status reports the most recent diagnosis of the topology change
+// currently waiting at a barrier, if there is one.
+func (tt *TopologyTransmogrifier) Status(sc *server.StatusConsumer) {
+	if !tt.enqueueQuery(topologyTransmogrifierMsgExe(func() error {
+		if bw := tt.barrierWatch; bw != nil {
+			if bw.diagnosis == nil {
+				sc.Emit(fmt.Sprintf("Topology %v waiting at barrier %v for %v", bw.version, bw.barrier, time.Now().Sub(bw.since)))
+			} else {
+				sc.Emit(fmt.Sprintf("Last barrier diagnosis: %v", bw.diagnosis))
+			}
+		}
+		tt.emigrationThrottle.Status(sc.Fork())
+		sc.Join()
+		return nil
+	})) {
+		sc.Join()
+	}
+}
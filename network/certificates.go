@@ -0,0 +1,92 @@
+package network
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"goshawkdb.io/common/certs"
+	"goshawkdb.io/server"
+	"sync"
+	"time"
+)
+
+// nodeCertificates holds this node's certificate, which can be
+// replaced whilst running, for example when the cluster certificate
+// is rotated. Existing connections carry on with the certificate they
+// handshook with; new connections (to clients and to other RMs) use
+// the latest. Every cluster root loaded since start remains trusted,
+// so that during a rolling rotation, RMs which are yet to be given the
+// new cluster certificate can still connect.
+type nodeCertificates struct {
+	sync.RWMutex
+	pair    *certs.NodeCertificatePrivateKeyPair
+	roots   []*x509.Certificate
+	pool    *x509.CertPool
+	loaded  time.Time
+	reloads uint64
+}
+
+func newNodeCertificates(pair *certs.NodeCertificatePrivateKeyPair) *nodeCertificates {
+	nc := &nodeCertificates{}
+	nc.set(pair)
+	nc.reloads = 0
+	return nc
+}
+
+func (nc *nodeCertificates) set(pair *certs.NodeCertificatePrivateKeyPair) {
+	nc.Lock()
+	defer nc.Unlock()
+	nc.pair = pair
+	nc.loaded = time.Now()
+	nc.reloads++
+	for _, root := range nc.roots {
+		if root.Equal(pair.CertificateRoot) {
+			return
+		}
+	}
+	// The pool may be in use by handshakes in progress, so it is
+	// replaced rather than added to.
+	nc.roots = append(nc.roots, pair.CertificateRoot)
+	pool := x509.NewCertPool()
+	for _, root := range nc.roots {
+		pool.AddCert(root)
+	}
+	nc.pool = pool
+}
+
+func (nc *nodeCertificates) tlsConfig() *tls.Config {
+	nc.RLock()
+	defer nc.RUnlock()
+	return &tls.Config{
+		Certificates: []tls.Certificate{
+			tls.Certificate{
+				Certificate: [][]byte{nc.pair.Certificate},
+				PrivateKey:  nc.pair.PrivateKey,
+			},
+		},
+		CipherSuites:             []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: true,
+		ClientCAs:                nc.pool,
+		RootCAs:                  nc.pool,
+	}
+}
+
+func (nc *nodeCertificates) Status(sc *server.StatusConsumer) {
+	nc.RLock()
+	fingerprint := sha256.Sum256(nc.pair.Certificate)
+	sc.Emit("Node certificate")
+	sc.EmitKV("Fingerprint", hex.EncodeToString(fingerprint[:]))
+	sc.EmitKV("Loaded", nc.loaded)
+	sc.EmitKV("Reloads", nc.reloads)
+	sc.EmitKV("Trusted cluster roots", len(nc.roots))
+	nc.RUnlock()
+	sc.Join()
+}
+
+// SetNodeCertificate replaces this node's certificate. It is used by
+// every connection established from now on.
+func (cm *ConnectionManager) SetNodeCertificate(pair *certs.NodeCertificatePrivateKeyPair) {
+	cm.certificates.set(pair)
+}
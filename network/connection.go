@@ -30,6 +30,12 @@ type Connection struct {
 	remoteRMId        common.RMId
 	remoteBootCount   uint32
 	remoteClusterUUId uint64
+	// remoteCapabilities is the set of serverCapabilities the remote
+	// RM advertised in its hello.
+	remoteCapabilities uint32
+	// compression counts the messages compressed on the wire to and
+	// from the remote RM.
+	compression       wireCompression
 	combinedTieBreak  uint32
 	socket            net.Conn
 	ConnectionNumber  uint32
@@ -62,6 +68,12 @@ type connectionMsgSend []byte
 
 func (cms connectionMsgSend) witness() connectionMsg { return cms }
 
+// A connectionMsgSendDelayed has already been delayed by the fault
+// injector, so must not be interfered with again.
+type connectionMsgSendDelayed []byte
+
+func (cmsd connectionMsgSendDelayed) witness() connectionMsg { return cmsd }
+
 type connectionMsgOutcomeReceived struct {
 	connectionMsgBasic
 	sender  common.RMId
@@ -83,6 +95,15 @@ func (cmtc *connectionMsgTopologyChanged) maybeClose() {
 	}
 }
 
+type connectionMsgSuspicionCheck struct{ connectionMsgBasic }
+
+type connectionMsgLocalReads struct {
+	connectionMsgBasic
+	submitter *client.ClientTxnSubmitter
+	reads     []*eng.LocalRead
+	callback  func([]*eng.LocalRead) error
+}
+
 type connectionMsgStatus struct {
 	connectionMsgBasic
 	*server.StatusConsumer
@@ -264,6 +285,8 @@ func (conn *Connection) handleMsg(msg connectionMsg) (terminate bool, err error)
 		msgT.received()
 	case *connectionBeater:
 		err = conn.beat()
+	case connectionMsgSuspicionCheck:
+		err = conn.checkSuspicion()
 	case connectionReadError:
 		conn.reader = nil
 		err = conn.connectionRun.maybeRestartConnection(msgT.error)
@@ -273,8 +296,12 @@ func (conn *Connection) handleMsg(msg connectionMsg) (terminate bool, err error)
 		err = conn.handleMsgFromClient((cmsgs.ClientMessage)(msgT))
 	case connectionMsgSend:
 		err = conn.sendMessage(msgT)
+	case connectionMsgSendDelayed:
+		err = conn.sendMessageNow(msgT)
 	case connectionMsgOutcomeReceived:
 		err = conn.outcomeReceived(msgT)
+	case connectionMsgLocalReads:
+		err = conn.localReadsDone(msgT)
 	case *connectionMsgTopologyChanged:
 		err = conn.topologyChanged(msgT)
 	case connectionMsgServerConnectionsChanged:
@@ -339,6 +366,12 @@ func (conn *Connection) status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("- Current State: %v", conn.currentState))
 	sc.Emit(fmt.Sprintf("- IsServer? %v", conn.isServer))
 	sc.Emit(fmt.Sprintf("- IsClient? %v", conn.isClient))
+	if conn.currentState == &conn.connectionRun && conn.detector != nil {
+		sc.Emit(fmt.Sprintf("- Failure Suspicion (phi): %.2f", conn.detector.phi(time.Now())))
+	}
+	if conn.isServer {
+		sc.Emit(fmt.Sprintf("- Compressed messages: %v", &conn.compression))
+	}
 	if conn.submitter != nil {
 		conn.submitter.Status(sc.Fork())
 	}
@@ -422,9 +455,10 @@ func (cc *connectionDial) start() (bool, error) {
 
 type connectionAwaitHandshake struct {
 	*Connection
-	isServer bool
-	isClient bool
-	topology *configuration.Topology
+	isServer             bool
+	isClient             bool
+	topology             *configuration.Topology
+	remoteProductVersion string
 }
 
 func (cah *connectionAwaitHandshake) connectionStateMachineComponentWitness() {}
@@ -444,6 +478,7 @@ func (cah *connectionAwaitHandshake) start() (bool, error) {
 	if seg, err := cah.readOne(); err == nil {
 		hello := cmsgs.ReadRootHello(seg)
 		if cah.verifyHello(&hello) {
+			cah.remoteProductVersion = hello.Version()
 			if hello.IsClient() {
 				cah.isClient = true
 				cah.nextState(&cah.connectionAwaitClientHandshake)
@@ -517,23 +552,7 @@ func (cah *connectionAwaitHandshake) maybeRestartConnection(err error) (bool, er
 }
 
 func (cah *connectionAwaitHandshake) commonTLSConfig() *tls.Config {
-	nodeCertPrivKeyPair := cah.connectionManager.NodeCertificatePrivateKeyPair
-	roots := x509.NewCertPool()
-	roots.AddCert(nodeCertPrivKeyPair.CertificateRoot)
-
-	return &tls.Config{
-		Certificates: []tls.Certificate{
-			tls.Certificate{
-				Certificate: [][]byte{nodeCertPrivKeyPair.Certificate},
-				PrivateKey:  nodeCertPrivKeyPair.PrivateKey,
-			},
-		},
-		CipherSuites:             []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-		MinVersion:               tls.VersionTLS12,
-		PreferServerCipherSuites: true,
-		ClientCAs:                roots,
-		RootCAs:                  roots,
-	}
+	return cah.connectionManager.certificates.tlsConfig()
 }
 
 // Await Server Handshake
@@ -615,7 +634,12 @@ func (cash *connectionAwaitServerHandshake) start() (bool, error) {
 
 			cash.remoteClusterUUId = hello.ClusterUUId()
 			cash.remoteBootCount = hello.BootCount()
+			cash.remoteCapabilities = hello.Capabilities()
 			cash.combinedTieBreak = cash.combinedTieBreak ^ hello.TieBreak()
+			if socket, ok := cash.socket.(*tls.Conn); ok {
+				state := socket.ConnectionState()
+				cash.connectionManager.handshakes.audit(false, cash.remoteHost, &state, cash.remoteProductVersion)
+			}
 			cash.nextState(nil)
 			return false, nil
 		} else {
@@ -635,6 +659,26 @@ func (cash *connectionAwaitServerHandshake) verifyTopology(remote *msgs.HelloSer
 	return false
 }
 
+// The serverCapabilities are the optional features of the inter-RM
+// protocol. Each RM advertises the set it supports in its hello, and
+// a feature is only used on a connection if the remote RM supports
+// it.
+const (
+	// serverCapabilityMigrationBatchAck: the RM acks each Migration
+	// batch once its txns are on disk, with a MigrationBatchAck.
+	serverCapabilityMigrationBatchAck uint32 = 1 << iota
+	// serverCapabilityCompression: the RM can decompress a compressed
+	// Message. Every RM can, whether or not it compresses the messages
+	// it sends.
+	serverCapabilityCompression
+	// serverCapabilityHealth: the RM understands a health Message,
+	// and will avoid placing new vars on RMs which report themselves
+	// as degraded.
+	serverCapabilityHealth
+)
+
+const localServerCapabilities = serverCapabilityMigrationBatchAck | serverCapabilityCompression | serverCapabilityHealth
+
 func (cash *connectionAwaitServerHandshake) makeHelloServerFromServer() *capn.Segment {
 	seg := capn.NewBuffer(nil)
 	hello := msgs.NewRootHelloServerFromServer(seg)
@@ -647,6 +691,7 @@ func (cash *connectionAwaitServerHandshake) makeHelloServerFromServer() *capn.Se
 	hello.SetTieBreak(tieBreak)
 	hello.SetClusterId(cash.topology.ClusterId)
 	hello.SetClusterUUId(cash.topology.ClusterUUId())
+	hello.SetCapabilities(localServerCapabilities)
 	return seg
 }
 
@@ -654,9 +699,11 @@ func (cash *connectionAwaitServerHandshake) makeHelloServerFromServer() *capn.Se
 
 type connectionAwaitClientHandshake struct {
 	*Connection
-	peerCerts []*x509.Certificate
-	roots     map[string]*common.Capability
-	rootsVar  map[common.VarUUId]*common.Capability
+	peerCerts   []*x509.Certificate
+	fingerprint [sha256.Size]byte
+	namespace   []byte
+	roots       map[string]*common.Capability
+	rootsVar    map[common.VarUUId]*common.Capability
 }
 
 func (cach *connectionAwaitClientHandshake) connectionStateMachineComponentWitness() {}
@@ -674,6 +721,8 @@ func (cach *connectionAwaitClientHandshake) start() (bool, error) {
 	if err := socket.Handshake(); err != nil {
 		return false, err
 	}
+	state := socket.ConnectionState()
+	cach.connectionManager.handshakes.audit(true, cach.socket.RemoteAddr().String(), &state, cach.remoteProductVersion)
 
 	if cach.topology.ClusterUUId() == 0 {
 		return false, errors.New("Cluster not yet formed")
@@ -684,6 +733,7 @@ func (cach *connectionAwaitClientHandshake) start() (bool, error) {
 	peerCerts := socket.ConnectionState().PeerCertificates
 	if authenticated, hashsum, roots := cach.verifyPeerCerts(peerCerts); authenticated {
 		cach.peerCerts = peerCerts
+		cach.fingerprint = hashsum
 		cach.roots = roots
 		log.Printf("User '%s' authenticated", hex.EncodeToString(hashsum[:]))
 		helloFromServer := cach.makeHelloClientFromServer()
@@ -717,6 +767,7 @@ func (cach *connectionAwaitClientHandshake) makeHelloClientFromServer() *capn.Se
 	binary.BigEndian.PutUint32(namespace[4:8], cach.connectionManager.BootCount())
 	binary.BigEndian.PutUint32(namespace[8:], uint32(cach.connectionManager.RMId))
 	hello.SetNamespace(namespace)
+	cach.namespace = namespace
 	rootsCap := cmsgs.NewRootList(seg, len(cach.roots))
 	idy := 0
 	rootsVar := make(map[common.VarUUId]*common.Capability, len(cach.roots))
@@ -744,6 +795,7 @@ type connectionRun struct {
 	reader        *connectionReader
 	mustSendBeat  bool
 	missingBeats  int
+	detector      *failureDetector
 	beatBytes     []byte
 	restart       bool
 	submitterIdle *connectionMsgTopologyChanged
@@ -764,12 +816,28 @@ func (cr *connectionRun) outcomeReceived(out connectionMsgOutcomeReceived) error
 	if cr.submitterIdle != nil && cr.submitter.IsIdle() {
 		si := cr.submitterIdle
 		cr.submitterIdle = nil
-		server.Log("Connection", cr.Connection, "outcomeReceived", si, "(submitterIdle)")
+		debugLog.Log("Connection", cr.Connection, "outcomeReceived", si, "(submitterIdle)")
 		si.maybeClose()
 	}
 	return err
 }
 
+// localReads is the client.LocalReader of this run's submitter.
+func (cr *connectionRun) localReads(vUUIds []*common.VarUUId, callback func([]*eng.LocalRead) error) {
+	submitter := cr.submitter
+	cr.connectionManager.Dispatchers.VarDispatcher.LocalReads(vUUIds, func(reads []*eng.LocalRead) {
+		cr.enqueueQuery(connectionMsgLocalReads{submitter: submitter, reads: reads, callback: callback})
+	})
+}
+
+func (cr *connectionRun) localReadsDone(lr connectionMsgLocalReads) error {
+	// The reads may have been started by an earlier run.
+	if cr.currentState != cr || cr.submitter != lr.submitter {
+		return nil
+	}
+	return lr.callback(lr.reads)
+}
+
 func (cr *connectionRun) start() (bool, error) {
 	log.Printf("Connection established to %v (%v)\n", cr.remoteHost, cr.remoteRMId)
 
@@ -790,19 +858,22 @@ func (cr *connectionRun) start() (bool, error) {
 		flushMsg := msgs.NewRootMessage(flushSeg)
 		flushMsg.SetFlushed()
 		flushBytes := server.SegToBytes(flushSeg)
-		cr.connectionManager.ServerEstablished(cr.Connection, cr.remoteHost, cr.remoteRMId, cr.remoteBootCount, cr.combinedTieBreak, cr.remoteClusterUUId, func() { cr.Send(flushBytes) })
+		cr.connectionManager.ServerEstablished(cr.Connection, cr.remoteHost, cr.remoteRMId, cr.remoteBootCount, cr.combinedTieBreak, cr.remoteClusterUUId, cr.remoteCapabilities, func() { cr.Send(flushBytes) })
 	}
 	if cr.isClient {
 		servers := cr.connectionManager.ClientEstablished(cr.ConnectionNumber, cr.Connection)
 		if servers == nil {
 			return false, errors.New("Not ready for client connections")
 		}
-		cr.submitter = client.NewClientTxnSubmitter(cr.connectionManager.RMId, cr.connectionManager.BootCount(), cr.rootsVar, cr.connectionManager)
+		cr.submitter = client.NewClientTxnSubmitter(cr.connectionManager.RMId, cr.connectionManager.BootCount(), cr.rootsVar, cr.connectionManager, cr.connectionManager.ClientOutcomes, cr.localReads, cr.fingerprint, cr.namespace)
 		cr.submitter.TopologyChanged(cr.topology)
 		cr.submitter.ServerConnectionsChanged(servers)
 	}
 	cr.mustSendBeat = true
 	cr.missingBeats = 0
+	if cr.isServer {
+		cr.detector = newFailureDetector(time.Now())
+	}
 
 	cr.beater = newConnectionBeater(cr.Connection)
 	go cr.beater.beat()
@@ -820,32 +891,32 @@ func (cr *connectionRun) start() (bool, error) {
 func (cr *connectionRun) topologyChanged(tc *connectionMsgTopologyChanged) error {
 	if si := cr.submitterIdle; si != nil {
 		cr.submitterIdle = nil
-		server.Log("Connection", cr.Connection, "topologyChanged:", tc, "clearing old:", si)
+		debugLog.Log("Connection", cr.Connection, "topologyChanged:", tc, "clearing old:", si)
 		si.maybeClose()
 	}
 	topology := tc.topology
 	cr.topology = topology
 	if cr.currentState != cr {
-		server.Log("Connection", cr.Connection, "topologyChanged", tc, "(not in cr)")
+		debugLog.Log("Connection", cr.Connection, "topologyChanged", tc, "(not in cr)")
 		tc.maybeClose()
 		return nil
 	}
 	if cr.isClient {
 		if topology != nil {
 			if authenticated, _, roots := cr.verifyPeerCerts(cr.peerCerts); !authenticated {
-				server.Log("Connection", cr.Connection, "topologyChanged", tc, "(client unauthed)")
+				debugLog.Log("Connection", cr.Connection, "topologyChanged", tc, "(client unauthed)")
 				tc.maybeClose()
 				return errors.New("Client connection closed: No client certificate known")
 			} else if len(roots) == len(cr.roots) {
 				for name, capsOld := range cr.roots {
 					if capsNew, found := roots[name]; !found || !capsNew.Equal(capsOld) {
-						server.Log("Connection", cr.Connection, "topologyChanged", tc, "(roots changed)")
+						debugLog.Log("Connection", cr.Connection, "topologyChanged", tc, "(roots changed)")
 						tc.maybeClose()
 						return errors.New("Client connection closed: roots have changed")
 					}
 				}
 			} else {
-				server.Log("Connection", cr.Connection, "topologyChanged", tc, "(roots changed)")
+				debugLog.Log("Connection", cr.Connection, "topologyChanged", tc, "(roots changed)")
 				tc.maybeClose()
 				return errors.New("Client connection closed: roots have changed")
 			}
@@ -855,15 +926,15 @@ func (cr *connectionRun) topologyChanged(tc *connectionMsgTopologyChanged) error
 			return err
 		}
 		if cr.submitter.IsIdle() {
-			server.Log("Connection", cr.Connection, "topologyChanged", tc, "(client, submitter is idle)")
+			debugLog.Log("Connection", cr.Connection, "topologyChanged", tc, "(client, submitter is idle)")
 			tc.maybeClose()
 		} else {
-			server.Log("Connection", cr.Connection, "topologyChanged", tc, "(client, submitter not idle)")
+			debugLog.Log("Connection", cr.Connection, "topologyChanged", tc, "(client, submitter not idle)")
 			cr.submitterIdle = tc
 		}
 	}
 	if cr.isServer {
-		server.Log("Connection", cr.Connection, "topologyChanged", tc, "(isServer)")
+		debugLog.Log("Connection", cr.Connection, "topologyChanged", tc, "(isServer)")
 		tc.maybeClose()
 		if topology != nil {
 			if _, found := topology.RMsRemoved()[cr.remoteRMId]; found {
@@ -894,12 +965,27 @@ func (cr *connectionRun) handleMsgFromClient(msg cmsgs.ClientMessage) error {
 	case cmsgs.CLIENTMESSAGE_CLIENTTXNSUBMISSION:
 		ctxn := msg.ClientTxnSubmission()
 		origTxnId := common.MakeTxnId(ctxn.Id())
+		if cr.connectionManager.IsDraining() {
+			return cr.clientTxnError(&ctxn, errors.New("Server is shutting down; please resubmit to another server."), origTxnId)
+		}
 		return cr.submitter.SubmitClientTransaction(&ctxn, func(clientOutcome *cmsgs.ClientTxnOutcome, err error) error {
 			switch {
 			case err != nil:
 				return cr.clientTxnError(&ctxn, err, origTxnId)
 			case clientOutcome == nil: // shutdown
 				return nil
+			case clientOutcome.Which() == cmsgs.CLIENTTXNOUTCOME_COMMIT:
+				// The client mustn't learn of the commit until it's in
+				// the idempotency log, else a resubmission after a
+				// crash could run the txn again.
+				seg := capn.NewBuffer(nil)
+				msg := cmsgs.NewRootClientMessage(seg)
+				msg.SetClientTxnOutcome(*clientOutcome)
+				msgBytes := server.SegToBytes(msg.Segment)
+				cr.connectionManager.ClientOutcomes.AwaitRecorded(common.MakeTxnId(clientOutcome.FinalId()), func(error) {
+					cr.Send(msgBytes)
+				})
+				return nil
 			default:
 				seg := capn.NewBuffer(nil)
 				msg := cmsgs.NewRootClientMessage(seg)
@@ -918,7 +1004,15 @@ func (cr *connectionRun) handleMsgFromServer(msg msgs.Message) error {
 		return nil
 	}
 	cr.missingBeats = 0
-	switch which := msg.Which(); which {
+	which := msg.Which()
+	if cr.detector != nil {
+		if which == msgs.MESSAGE_HEARTBEAT {
+			cr.detector.heartbeat(time.Now())
+		} else {
+			cr.detector.arrived(time.Now())
+		}
+	}
+	switch which {
 	case msgs.MESSAGE_HEARTBEAT:
 		// do nothing
 	case msgs.MESSAGE_CONNECTIONERROR:
@@ -927,6 +1021,12 @@ func (cr *connectionRun) handleMsgFromServer(msg msgs.Message) error {
 		configCap := msg.TopologyChangeRequest()
 		config := configuration.ConfigurationFromCap(&configCap)
 		cr.connectionManager.RequestConfigurationChange(config)
+	case msgs.MESSAGE_COMPRESSED:
+		inner, err := cr.compression.decompress(msg.Compressed())
+		if err != nil {
+			return fmt.Errorf("Unable to decompress message from %v: %v", cr.remoteRMId, err)
+		}
+		return cr.handleMsgFromServer(inner)
 	default:
 		cr.connectionManager.DispatchMessage(cr.remoteRMId, which, msg)
 	}
@@ -982,8 +1082,29 @@ func (cr *connectionRun) maybeRestartConnection(err error) error {
 }
 
 func (cr *connectionRun) sendMessage(msg []byte) error {
+	if cr.currentState == cr && cr.isServer {
+		if drop, delay := cr.connectionManager.Faults.apply(cr.remoteRMId, msg); drop {
+			return nil
+		} else if delay > 0 {
+			conn := cr.Connection
+			time.AfterFunc(delay, func() { conn.enqueueQuery(connectionMsgSendDelayed(msg)) })
+			return nil
+		}
+	}
+	return cr.sendMessageNow(msg)
+}
+
+func (cr *connectionRun) sendMessageNow(msg []byte) error {
 	if cr.currentState == cr {
 		cr.mustSendBeat = false
+		if cr.isServer {
+			cr.connectionManager.bandwidth.account(cr.remoteRMId, msg)
+			if cr.connectionManager.compress && cr.remoteCapabilities&serverCapabilityCompression != 0 {
+				if wire := cr.compression.compress(msg); wire != nil {
+					msg = wire
+				}
+			}
+		}
 		return cr.maybeRestartConnection(cr.send(msg))
 	}
 	return nil
@@ -1005,7 +1126,13 @@ func (cr *connectionRun) beat() error {
 		}
 	*/
 	cr.missingBeats++
+	if cr.isServer && cr.connectionManager.Faults.isPartitioned(cr.remoteRMId) {
+		return nil
+	}
 	if cr.mustSendBeat {
+		if cr.isServer {
+			cr.connectionManager.bandwidth.account(cr.remoteRMId, cr.beatBytes)
+		}
 		return cr.maybeRestartConnection(cr.send(cr.beatBytes))
 	} else {
 		cr.mustSendBeat = true
@@ -1013,6 +1140,24 @@ func (cr *connectionRun) beat() error {
 	return nil
 }
 
+// checkSuspicion restarts the connection if the remote RM is
+// suspected to have failed. That is reported as the loss of the
+// server connection, so proposals for txns in which the remote RM is
+// an active voter start abort proposals on its behalf straight away,
+// rather than waiting for heartbeats to be missed.
+func (cr *connectionRun) checkSuspicion() error {
+	if cr.currentState != cr || cr.detector == nil {
+		return nil
+	}
+	now := time.Now()
+	if phi := cr.detector.phi(now); phi >= server.FailureDetectorPhiThreshold {
+		cr.connectionManager.suspicions.suspect(cr.remoteRMId, phi, now)
+		return cr.maybeRestartConnection(
+			fmt.Errorf("Failure suspected (phi %.1f). Restarting connection.", phi))
+	}
+	return nil
+}
+
 func (cr *connectionRun) maybeStopBeater() {
 	if cr.beater != nil {
 		close(cr.beater.terminate)
@@ -1035,29 +1180,49 @@ func (cr *connectionRun) maybeStopReaderAndCloseSocket() {
 
 // Beater
 
+// The beater sends heartbeats and, for servers, prompts the failure
+// detector's suspicion check. The check is not a scheduler job
+// because it belongs to this one connection run: it must start and
+// stop with the run (a job's first run is jittered by up to a whole
+// interval), and it must be serialised with the heartbeats the
+// detector learns from, which it is by going through the same
+// enqueueQuery.
 type connectionBeater struct {
 	connectionMsgBasic
 	*Connection
 	terminate  chan struct{}
 	terminated *sync.WaitGroup
 	ticker     *time.Ticker
+	checker    *time.Ticker
 }
 
 func newConnectionBeater(conn *Connection) *connectionBeater {
 	wg := new(sync.WaitGroup)
 	wg.Add(1)
-	return &connectionBeater{
+	cb := &connectionBeater{
 		Connection: conn,
 		terminate:  make(chan struct{}),
 		terminated: wg,
 		ticker:     time.NewTicker(common.HeartbeatInterval),
 	}
+	if conn.isServer {
+		cb.checker = time.NewTicker(server.FailureDetectorCheckInterval)
+	}
+	return cb
 }
 
 func (cb *connectionBeater) beat() {
+	var checkerChan <-chan time.Time
+	if cb.checker != nil {
+		checkerChan = cb.checker.C
+	}
 	defer func() {
 		cb.ticker.Stop()
 		cb.ticker = nil
+		if cb.checker != nil {
+			cb.checker.Stop()
+			cb.checker = nil
+		}
 		cb.terminated.Done()
 	}()
 	for {
@@ -1068,6 +1233,10 @@ func (cb *connectionBeater) beat() {
 			if !cb.enqueueQuery(cb) {
 				return
 			}
+		case <-checkerChan:
+			if !cb.enqueueQuery(connectionMsgSuspicionCheck{}) {
+				return
+			}
 		}
 	}
 }
@@ -2,6 +2,7 @@ package network
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
 	cc "github.com/msackman/chancell"
@@ -16,8 +17,11 @@ import (
 	eng "goshawkdb.io/server/txnengine"
 	"log"
 	"sync"
+	"sync/atomic"
 )
 
+var debugLog = server.NewSubsystem("network")
+
 type ShutdownSignaller interface {
 	SignalShutdown()
 }
@@ -27,8 +31,9 @@ type ConnectionManager struct {
 	localHost                     string
 	RMId                          common.RMId
 	bootcount                     uint32
-	NodeCertificatePrivateKeyPair *certs.NodeCertificatePrivateKeyPair
+	certificates                  *nodeCertificates
 	Transmogrifier                *TopologyTransmogrifier
+	LocalConnection               *client.LocalConnection
 	topology                      *configuration.Topology
 	cellTail                      *cc.ChanCellTail
 	enqueueQueryInner             func(connectionManagerMsg, *cc.ChanCell, cc.CurCellConsumer) (bool, cc.CurCellConsumer)
@@ -40,6 +45,20 @@ type ConnectionManager struct {
 	desired                       []string
 	serverConnSubscribers         serverConnSubscribers
 	topologySubscribers           topologySubscribers
+	handshakes                    *handshakeAuditor
+	bandwidth                     *bandwidthAccountant
+	suspicions                    *failureSuspicions
+	Faults                        *FaultInjector
+	ClientOutcomes                *client.OutcomeRecorder
+	learnerOnly                   bool
+	// compress is true if messages sent to other RMs which can
+	// decompress them are compressed.
+	compress                      bool
+	// health is this RM's own health: see health.go. It is only
+	// written by the actor, but read by anyone holding the local
+	// connection.
+	health                        uint32
+	draining                      int32
 	Dispatchers                   *paxos.Dispatchers
 }
 
@@ -67,6 +86,7 @@ func (cm *ConnectionManager) DispatchMessage(sender common.RMId, msgType msgs.Me
 		outcome := msg.SubmissionOutcome()
 		txn := eng.TxnReaderFromData(outcome.Txn())
 		txnId := txn.Id
+		cm.ClientOutcomes.OutcomeReceived(sender, txn, &outcome)
 		connNumber := binary.BigEndian.Uint32(txnId[8:12])
 		bootNumber := binary.BigEndian.Uint32(txnId[12:16])
 		if conn := cm.GetClient(bootNumber, connNumber); conn == nil {
@@ -108,10 +128,17 @@ func (cm *ConnectionManager) DispatchMessage(sender common.RMId, msgType msgs.Me
 	case msgs.MESSAGE_MIGRATIONCOMPLETE:
 		migrationComplete := msg.MigrationComplete()
 		cm.Transmogrifier.MigrationCompleteReceived(sender, &migrationComplete)
+	case msgs.MESSAGE_MIGRATIONBATCHACK:
+		migrationBatchAck := msg.MigrationBatchAck()
+		cm.Transmogrifier.MigrationBatchAckReceived(sender, &migrationBatchAck)
 	case msgs.MESSAGE_FLUSHED:
 		cm.ServerConnectionFlushed(sender)
+	case msgs.MESSAGE_LEARNERONLY:
+		cm.enqueueQuery(connectionManagerMsgServerLearnerOnly{rmId: sender, learnerOnly: msg.LearnerOnly()})
+	case msgs.MESSAGE_HEALTH:
+		cm.enqueueQuery(connectionManagerMsgServerHealth{rmId: sender, health: uint32(msg.Health())})
 	default:
-		panic(fmt.Sprintf("Unexpected message received from %v (%v)", sender, msgType))
+		server.UnknownEnumReceived("message type", msgType, sender)
 	}
 }
 
@@ -136,13 +163,18 @@ type connectionManagerMsgSetDesired struct {
 type connectionManagerMsgServerEstablished struct {
 	connectionManagerMsgBasic
 	*Connection
-	send          func([]byte)
-	established   bool
-	host          string
-	rmId          common.RMId
-	bootCount     uint32
-	tieBreak      uint32
-	clusterUUId   uint64
+	send         func([]byte)
+	established  bool
+	host         string
+	rmId         common.RMId
+	bootCount    uint32
+	tieBreak     uint32
+	clusterUUId  uint64
+	capabilities uint32
+	learnerOnly  bool
+	// health is shared by all clones, and updated in place, so that
+	// changes in health don't have to be republished.
+	health        *uint32
 	flushCallback func()
 }
 
@@ -201,6 +233,19 @@ type connectionManagerMsgRequestConfigChange struct {
 	config *configuration.Configuration
 }
 
+type connectionManagerMsgSetLearnerOnly struct {
+	connectionManagerMsgBasic
+	learnerOnly bool
+	err         error
+	resultChan  chan struct{}
+}
+
+type connectionManagerMsgServerLearnerOnly struct {
+	connectionManagerMsgBasic
+	rmId        common.RMId
+	learnerOnly bool
+}
+
 type connectionManagerMsgStatus struct {
 	connectionManagerMsgBasic
 	*server.StatusConsumer
@@ -221,7 +266,7 @@ func (cm *ConnectionManager) SetDesiredServers(localhost string, remotehosts []s
 	})
 }
 
-func (cm *ConnectionManager) ServerEstablished(conn *Connection, host string, rmId common.RMId, bootCount uint32, tieBreak uint32, clusterUUId uint64, flushCallback func()) {
+func (cm *ConnectionManager) ServerEstablished(conn *Connection, host string, rmId common.RMId, bootCount uint32, tieBreak uint32, clusterUUId uint64, capabilities uint32, flushCallback func()) {
 	cm.enqueueQuery(&connectionManagerMsgServerEstablished{
 		Connection:    conn,
 		send:          conn.Send,
@@ -231,6 +276,8 @@ func (cm *ConnectionManager) ServerEstablished(conn *Connection, host string, rm
 		bootCount:     bootCount,
 		tieBreak:      tieBreak,
 		clusterUUId:   clusterUUId,
+		capabilities:  capabilities,
+		health:        new(uint32),
 		flushCallback: flushCallback,
 	})
 }
@@ -324,6 +371,34 @@ func (cm *ConnectionManager) RequestConfigurationChange(config *configuration.Co
 	cm.enqueueQuery(connectionManagerMsgRequestConfigChange{config: config})
 }
 
+// SetDraining stops client connections from submitting any more
+// txns. It cannot be undone.
+func (cm *ConnectionManager) SetDraining() {
+	atomic.StoreInt32(&cm.draining, 1)
+}
+
+func (cm *ConnectionManager) IsDraining() bool {
+	return atomic.LoadInt32(&cm.draining) == 1
+}
+
+// SetLearnerOnly demotes this RM to a learner (or restores it to a
+// voter). Whilst it is a learner, every RM avoids making it active in
+// the txns it submits, so it stops voting (and acting as an acceptor)
+// but still learns the outcomes of txns on the vars it holds. This is
+// intended for maintenance windows: it is forgotten on restart. As
+// there must be enough voters for every var, at most F RMs can be
+// learners or unreachable at once.
+func (cm *ConnectionManager) SetLearnerOnly(learnerOnly bool) error {
+	query := &connectionManagerMsgSetLearnerOnly{
+		learnerOnly: learnerOnly,
+		resultChan:  make(chan struct{}),
+	}
+	if cm.enqueueSyncQuery(query, query.resultChan) {
+		return query.err
+	}
+	return errors.New("Shutting down.")
+}
+
 func (cm *ConnectionManager) Status(sc *server.StatusConsumer) {
 	cm.enqueueQuery(connectionManagerMsgStatus{StatusConsumer: sc})
 }
@@ -353,12 +428,18 @@ func NewConnectionManager(rmId common.RMId, bootCount uint32, procs int, db *db.
 	cm := &ConnectionManager{
 		RMId:                          rmId,
 		bootcount:                     bootCount,
-		NodeCertificatePrivateKeyPair: nodeCertPrivKeyPair,
+		certificates:                  newNodeCertificates(nodeCertPrivKeyPair),
 		servers:           make(map[string]*connectionManagerMsgServerEstablished),
 		rmToServer:        make(map[common.RMId]*connectionManagerMsgServerEstablished),
 		flushedServers:    make(map[common.RMId]server.EmptyStruct),
 		connCountToClient: make(map[uint32]paxos.ClientConnection),
 		desired:           nil,
+		handshakes:        newHandshakeAuditor(),
+		bandwidth:         newBandwidthAccountant(),
+		suspicions:        newFailureSuspicions(),
+		Faults:            newFaultInjector(),
+		ClientOutcomes:    client.NewOutcomeRecorder(db.Idempotency),
+		compress:          db.Compression(),
 	}
 	cm.serverConnSubscribers.subscribers = make(map[paxos.ServerConnectionSubscriber]server.EmptyStruct)
 	cm.serverConnSubscribers.ConnectionManager = cm
@@ -391,14 +472,17 @@ func NewConnectionManager(rmId common.RMId, bootCount uint32, procs int, db *db.
 			}
 		})
 	cd := &connectionManagerMsgServerEstablished{
-		send:        cm.Send,
-		established: true,
-		rmId:        rmId,
-		bootCount:   bootCount,
+		send:         cm.Send,
+		established:  true,
+		rmId:         rmId,
+		bootCount:    bootCount,
+		capabilities: localServerCapabilities,
+		health:       &cm.health,
 	}
 	cm.rmToServer[cd.rmId] = cd
 	cm.servers[cd.host] = cd
 	lc := client.NewLocalConnection(rmId, bootCount, cm)
+	cm.LocalConnection = lc
 	cm.Dispatchers = paxos.NewDispatchers(cm, rmId, uint8(procs), db, lc)
 	transmogrifier, localEstablished := NewTopologyTransmogrifier(db, cm, lc, port, ss, config)
 	cm.Transmogrifier = transmogrifier
@@ -447,6 +531,15 @@ func (cm *ConnectionManager) actorLoop(head *cc.ChanCellHead) {
 				cm.topologySubscribers.RemoveSubscriber(msgT.subType, msgT.TopologySubscriber)
 			case connectionManagerMsgRequestConfigChange:
 				cm.Transmogrifier.RequestConfigurationChange(msgT.config)
+			case *connectionManagerMsgSetLearnerOnly:
+				msgT.err = cm.setLearnerOnly(msgT.learnerOnly)
+				close(msgT.resultChan)
+			case connectionManagerMsgServerLearnerOnly:
+				cm.serverLearnerOnly(msgT.rmId, msgT.learnerOnly)
+			case connectionManagerMsgSetHealth:
+				cm.setHealth(msgT.health)
+			case connectionManagerMsgServerHealth:
+				cm.serverHealth(msgT.rmId, msgT.health)
 			case connectionManagerMsgStatus:
 				cm.status(msgT.StatusConsumer)
 			default:
@@ -581,6 +674,12 @@ func (cm *ConnectionManager) serverEstablished(connEst *connectionManagerMsgServ
 		cm.servers[connEst.host] = connEst
 		cm.rmToServer[connEst.rmId] = connEst
 		cm.serverConnSubscribers.ServerConnEstablished(connEst, connEst.flushCallback)
+		if cm.learnerOnly {
+			connEst.Send(makeLearnerOnlyMsg(true))
+		}
+		if health := atomic.LoadUint32(&cm.health); health != 0 && peerHasCapability(connEst, serverCapabilityHealth) {
+			connEst.Send(makeHealthMsg(health))
+		}
 	}
 }
 
@@ -629,7 +728,7 @@ func (cm *ConnectionManager) clientEstablished(msg *connectionManagerMsgClientEs
 }
 
 func (cm *ConnectionManager) setTopology(topology *configuration.Topology, callbacks map[eng.TopologyChangeSubscriberType]func()) {
-	server.Log("Topology change:", topology)
+	debugLog.Log("Topology change:", topology)
 	cm.topology = topology
 	cm.topologySubscribers.TopologyChanged(topology, callbacks)
 	cd := cm.rmToServer[cm.RMId]
@@ -664,6 +763,60 @@ func (cm *ConnectionManager) checkFlushed(topology *configuration.Topology) {
 	}
 }
 
+func (cm *ConnectionManager) setLearnerOnly(learnerOnly bool) error {
+	if cm.learnerOnly == learnerOnly {
+		return nil
+	}
+	if learnerOnly {
+		if cm.topology == nil {
+			return errors.New("Cannot become a learner without a topology.")
+		}
+		unavailable := 0
+		for _, rmId := range cm.topology.RMs() {
+			if rmId == common.RMIdEmpty || rmId == cm.RMId {
+				continue
+			} else if cd, found := cm.rmToServer[rmId]; !found || cd.learnerOnly {
+				unavailable++
+			}
+		}
+		if unavailable >= int(cm.topology.F) {
+			return fmt.Errorf("Cannot become a learner: %v RMs are already learners or unreachable, and F is %v.", unavailable, cm.topology.F)
+		}
+	}
+	log.Printf("%v becoming learner only: %v", cm.RMId, learnerOnly)
+	cm.learnerOnly = learnerOnly
+	msg := makeLearnerOnlyMsg(learnerOnly)
+	for rmId, cd := range cm.rmToServer {
+		if rmId != cm.RMId {
+			cd.Send(msg)
+		}
+	}
+	cm.serverLearnerOnly(cm.RMId, learnerOnly)
+	return nil
+}
+
+func (cm *ConnectionManager) serverLearnerOnly(rmId common.RMId, learnerOnly bool) {
+	cd, found := cm.rmToServer[rmId]
+	if !found || cd.learnerOnly == learnerOnly {
+		return
+	}
+	debugLog.Log("CM", rmId, "learner only:", learnerOnly)
+	delete(cm.rmToServer, cd.rmId)
+	cm.serverConnSubscribers.ServerConnLost(cd.rmId)
+	cd = cd.clone()
+	cd.learnerOnly = learnerOnly
+	cm.rmToServer[cd.rmId] = cd
+	cm.servers[cd.host] = cd
+	cm.serverConnSubscribers.ServerConnEstablished(cd, func() { cm.ServerConnectionFlushed(cd.rmId) })
+}
+
+func makeLearnerOnlyMsg(learnerOnly bool) []byte {
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootMessage(seg)
+	msg.SetLearnerOnly(learnerOnly)
+	return server.SegToBytes(seg)
+}
+
 func (cm *ConnectionManager) cloneRMToServer() map[common.RMId]paxos.Connection {
 	rmToServerCopy := make(map[common.RMId]paxos.Connection, len(cm.rmToServer))
 	for rmId, server := range cm.rmToServer {
@@ -675,6 +828,16 @@ func (cm *ConnectionManager) cloneRMToServer() map[common.RMId]paxos.Connection
 func (cm *ConnectionManager) status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("Address: %v", cm.localHost))
 	sc.Emit(fmt.Sprintf("Boot Count: %v", cm.bootcount))
+	sc.Emit(fmt.Sprintf("Unknown Enum Values Received: %v", server.UnknownEnumCount()))
+	sc.Emit(fmt.Sprintf("Txn Checksum Mismatches: %v", eng.ActionsChecksumFailures()))
+	sc.Emit(fmt.Sprintf("Var Checksum Mismatches: %v", eng.VarChecksumFailures()))
+	eng.BadReadPayloadStatus(sc.Fork())
+	cm.handshakes.Status(sc.Fork())
+	cm.bandwidth.Status(sc.Fork())
+	cm.suspicions.Status(sc.Fork())
+	cm.Faults.Status(sc.Fork())
+	cm.certificates.Status(sc.Fork())
+	cm.Transmogrifier.Status(sc.Fork())
 	sc.Emit(fmt.Sprintf("Current Topology: %v", cm.topology))
 	if cm.topology != nil && cm.topology.Next() != nil {
 		sc.Emit(fmt.Sprintf("Next Topology: %v", cm.topology.Next()))
@@ -694,6 +857,21 @@ func (cm *ConnectionManager) status(sc *server.StatusConsumer) {
 		rms = append(rms, rmId)
 	}
 	sc.Emit(fmt.Sprintf("Active Server RMIds: %v", rms))
+	learners := make([]common.RMId, 0, len(cm.rmToServer))
+	for rmId, cd := range cm.rmToServer {
+		if cd.learnerOnly {
+			learners = append(learners, rmId)
+		}
+	}
+	sc.Emit(fmt.Sprintf("Learner Only RMIds: %v", learners))
+	degraded := make([]common.RMId, 0, len(cm.rmToServer))
+	for rmId, cd := range cm.rmToServer {
+		if cd.Degraded() {
+			degraded = append(degraded, rmId)
+		}
+	}
+	sc.Emit(fmt.Sprintf("Health: %v", healthString(atomic.LoadUint32(&cm.health))))
+	sc.Emit(fmt.Sprintf("Degraded RMIds: %v", degraded))
 	sc.Emit(fmt.Sprintf("Active Server Connections: %v", serverConnections))
 	sc.Emit(fmt.Sprintf("Desired Server Connections: %v", cm.desired))
 	for _, conn := range cm.servers {
@@ -753,7 +931,7 @@ func (subs serverConnSubscribers) ServerConnLost(rmId common.RMId) {
 
 func (subs serverConnSubscribers) AddSubscriber(ob paxos.ServerConnectionSubscriber) {
 	if _, found := subs.subscribers[ob]; found {
-		server.Log(ob, "CM found duplicate add serverConn subscriber")
+		debugLog.Log(ob, "CM found duplicate add serverConn subscriber")
 	} else {
 		subs.subscribers[ob] = server.EmptyStructVal
 		ob.ConnectedRMs(subs.cloneRMToServer())
@@ -779,16 +957,16 @@ func (subs topologySubscribers) TopologyChanged(topology *configuration.Topology
 		if cb, found := callbacks[eng.TopologyChangeSubscriberType(subType)]; found {
 			cbCopy := cb
 			go func() {
-				server.Log("CM TopologyChanged", subTypeCopy, "expects", expected, "Dones")
+				debugLog.Log("CM TopologyChanged", subTypeCopy, "expects", expected, "Dones")
 				for expected > 0 {
 					if result := <-resultChan; result {
 						expected--
 					} else {
-						server.Log("CM TopologyChanged", subTypeCopy, "failed")
+						debugLog.Log("CM TopologyChanged", subTypeCopy, "failed")
 						return
 					}
 				}
-				server.Log("CM TopologyChanged", subTypeCopy, "all done")
+				debugLog.Log("CM TopologyChanged", subTypeCopy, "all done")
 				cbCopy()
 			}()
 		}
@@ -797,7 +975,7 @@ func (subs topologySubscribers) TopologyChanged(topology *configuration.Topology
 
 func (subs topologySubscribers) AddSubscriber(subType eng.TopologyChangeSubscriberType, ob eng.TopologySubscriber) {
 	if _, found := subs.subscribers[subType][ob]; found {
-		server.Log(ob, "CM found duplicate add topology subscriber")
+		debugLog.Log(ob, "CM found duplicate add topology subscriber")
 	} else {
 		subs.subscribers[subType][ob] = server.EmptyStructVal
 	}
@@ -827,6 +1005,21 @@ func (cd *connectionManagerMsgServerEstablished) ClusterUUId() uint64 {
 	return cd.clusterUUId
 }
 
+func (cd *connectionManagerMsgServerEstablished) LearnerOnly() bool {
+	return cd.learnerOnly
+}
+
+func (cd *connectionManagerMsgServerEstablished) Degraded() bool {
+	return cd.health != nil && atomic.LoadUint32(cd.health) != 0
+}
+
+// peerHasCapability returns whether the RM at the other end of conn
+// advertised the serverCapability capability in its hello.
+func peerHasCapability(conn paxos.Connection, capability uint32) bool {
+	cd, ok := conn.(*connectionManagerMsgServerEstablished)
+	return ok && cd.capabilities&capability != 0
+}
+
 func (cd *connectionManagerMsgServerEstablished) Send(msg []byte) {
 	cd.send(msg)
 }
@@ -839,13 +1032,16 @@ func (cd *connectionManagerMsgServerEstablished) Shutdown(sync paxos.Blocking) {
 
 func (cd *connectionManagerMsgServerEstablished) clone() *connectionManagerMsgServerEstablished {
 	return &connectionManagerMsgServerEstablished{
-		Connection:  cd.Connection,
-		send:        cd.send,
-		established: cd.established,
-		host:        cd.host,
-		rmId:        cd.rmId,
-		bootCount:   cd.bootCount,
-		tieBreak:    cd.tieBreak,
-		clusterUUId: cd.clusterUUId,
+		Connection:   cd.Connection,
+		send:         cd.send,
+		established:  cd.established,
+		host:         cd.host,
+		rmId:         cd.rmId,
+		bootCount:    cd.bootCount,
+		tieBreak:     cd.tieBreak,
+		clusterUUId:  cd.clusterUUId,
+		capabilities: cd.capabilities,
+		learnerOnly:  cd.learnerOnly,
+		health:       cd.health,
 	}
 }
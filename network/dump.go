@@ -0,0 +1,516 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/client"
+	"goshawkdb.io/server/configuration"
+	ch "goshawkdb.io/server/consistenthash"
+	"goshawkdb.io/server/db"
+	eng "goshawkdb.io/server/txnengine"
+	"io"
+	"log"
+	"os"
+)
+
+// A dump is a stream of JSON-encoded DumpVars, one per var. Ids and
+// positions are hex-encoded; values are base64-encoded, as is usual
+// for JSON. Root, if set, is the name of the root the var was in the
+// cluster the dump was exported from. Import writes such a var over
+// the root of the same name in the cluster being imported into, and
+// rewrites references to it likewise. Root vars must come before all
+// other vars in a dump, as Export writes them.
+type DumpVar struct {
+	VarUUId    string
+	Positions  string
+	Version    string `json:",omitempty"`
+	Root       string `json:",omitempty"`
+	Value      []byte
+	References []DumpReference
+}
+
+// A DumpReference is a reference held by a DumpVar. Capability is one
+// of "none", "read", "write" or "readwrite".
+type DumpReference struct {
+	VarUUId    string
+	Positions  string
+	Capability string
+}
+
+func decodeDumpVarUUId(str string) (*common.VarUUId, error) {
+	bites, err := hex.DecodeString(str)
+	if err != nil || len(bites) != common.KeyLen {
+		return nil, fmt.Errorf("VarUUId must be %v hex-encoded bytes (got %q).", common.KeyLen, str)
+	}
+	return common.MakeVarUUId(bites), nil
+}
+
+func decodeDumpPositions(seg *capn.Segment, str string) (capn.UInt8List, []uint8, error) {
+	bites, err := hex.DecodeString(str)
+	if err != nil || len(bites) == 0 {
+		return capn.UInt8List{}, nil, fmt.Errorf("Positions must be hex-encoded (got %q).", str)
+	}
+	positions := seg.NewUInt8List(len(bites))
+	for idx, b := range bites {
+		positions.Set(idx, b)
+	}
+	return positions, bites, nil
+}
+
+func dumpCapabilityName(capability cmsgs.Capability) string {
+	switch capability.Which() {
+	case cmsgs.CAPABILITY_READ:
+		return "read"
+	case cmsgs.CAPABILITY_WRITE:
+		return "write"
+	case cmsgs.CAPABILITY_READWRITE:
+		return "readwrite"
+	default:
+		return "none"
+	}
+}
+
+func setDumpCapability(capability cmsgs.Capability, str string) error {
+	switch str {
+	case "none":
+		capability.SetNone()
+	case "read":
+		capability.SetRead()
+	case "write":
+		capability.SetWrite()
+	case "readwrite":
+		capability.SetReadWrite()
+	default:
+		return fmt.Errorf("Capability must be one of none, read, write or readwrite (got %q).", str)
+	}
+	return nil
+}
+
+// dumpRoots maps the ids of the root vars of a dump onto the roots of
+// the same names in the topology being imported into.
+type dumpRoots map[common.VarUUId]*walkStart
+
+// add maps dv, which must be a root var, onto its root in topology.
+func (roots dumpRoots) add(topology *configuration.Topology, dv *DumpVar) error {
+	vUUId, err := decodeDumpVarUUId(dv.VarUUId)
+	if err != nil {
+		return err
+	}
+	for _, start := range rootWalkStarts(topology, map[string]bool{dv.Root: true}) {
+		roots[*vUUId] = start
+		return nil
+	}
+	return fmt.Errorf("There is no root named %q to import %v into.", dv.Root, dv.VarUUId)
+}
+
+// remap rewrites dv, and the references it holds, so that the root
+// vars of the dump are replaced by their roots.
+func (roots dumpRoots) remap(dv *DumpVar) error {
+	remap := func(varUUId, positions *string) error {
+		vUUId, err := decodeDumpVarUUId(*varUUId)
+		if err != nil {
+			return err
+		}
+		if root, found := roots[*vUUId]; found {
+			*varUUId = hex.EncodeToString(root.VarUUId[:])
+			*positions = hex.EncodeToString((*capn.UInt8List)(root.Positions).ToArray())
+		}
+		return nil
+	}
+	if err := remap(&dv.VarUUId, &dv.Positions); err != nil {
+		return err
+	}
+	for idx := range dv.References {
+		ref := &dv.References[idx]
+		if err := remap(&ref.VarUUId, &ref.Positions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migration builds the Migration which writes dv into the var store
+// when immigrated. The id of its txn is derived from the var and the
+// version in the dump, so that every RM importing the same dump
+// agrees on it. The var's element of the txn's clock is clockElem.
+// positions are the positions of the var.
+func (dv *DumpVar) migration(topology *configuration.Topology, clockElem uint64) (migration *msgs.Migration, positions []uint8, err error) {
+	vUUId, err := decodeDumpVarUUId(dv.VarUUId)
+	if err != nil {
+		return nil, nil, err
+	}
+	hash := sha256.New()
+	hash.Write(vUUId[:])
+	hash.Write([]byte(dv.Version))
+	txnId := common.MakeTxnId(hash.Sum(nil)[:common.KeyLen])
+
+	actionsSeg := capn.NewBuffer(nil)
+	actionsWrapper := msgs.NewRootActionListWrapper(actionsSeg)
+	actions := msgs.NewActionList(actionsSeg, 1)
+	actionsWrapper.SetActions(actions)
+	action := actions.At(0)
+	action.SetVarId(vUUId[:])
+	action.SetWrite()
+	write := action.Write()
+	write.SetValue(dv.Value)
+	refs := msgs.NewVarIdPosList(actionsSeg, len(dv.References))
+	for idx, ref := range dv.References {
+		target, err := decodeDumpVarUUId(ref.VarUUId)
+		if err != nil {
+			return nil, nil, err
+		}
+		targetPositions, _, err := decodeDumpPositions(actionsSeg, ref.Positions)
+		if err != nil {
+			return nil, nil, err
+		}
+		capability := cmsgs.NewCapability(actionsSeg)
+		if err := setDumpCapability(capability, ref.Capability); err != nil {
+			return nil, nil, err
+		}
+		varIdPos := refs.At(idx)
+		varIdPos.SetId(target[:])
+		varIdPos.SetPositions(targetPositions)
+		varIdPos.SetCapability(capability)
+	}
+	write.SetReferences(refs)
+	actionsBytes := server.SegToBytes(actionsSeg)
+
+	txnSeg := capn.NewBuffer(nil)
+	txn := msgs.NewRootTxn(txnSeg)
+	txn.SetId(txnId[:])
+	txn.SetRetry(false)
+	txn.SetActions(actionsBytes)
+	txn.SetActionsChecksum(eng.ActionsChecksum(&actions))
+	txn.SetAllocations(msgs.NewAllocationList(txnSeg, 0))
+	txn.SetFInc(topology.FInc)
+	txn.SetTopologyVersion(topology.Version)
+
+	seg := capn.NewBuffer(nil)
+	migrationCap := msgs.NewRootMigration(seg)
+	migrationCap.SetVersion(topology.Version)
+	elems := msgs.NewMigrationElementList(seg, 1)
+	elem := elems.At(0)
+	elem.SetTxn(server.SegToBytes(txnSeg))
+	vars := msgs.NewVarList(seg, 1)
+	varCap := vars.At(0)
+	varCap.SetId(vUUId[:])
+	positionsCap, positions, err := decodeDumpPositions(seg, dv.Positions)
+	if err != nil {
+		return nil, nil, err
+	}
+	varCap.SetPositions(positionsCap)
+	varCap.SetWriteTxnId(txnId[:])
+	clock := eng.NewVectorClock().AsMutable()
+	clock.SetVarIdMax(vUUId, clockElem)
+	clockData := clock.AsData()
+	varCap.SetWriteTxnClock(clockData)
+	varCap.SetWritesClock(clockData)
+	elem.SetVars(vars)
+	migrationCap.SetElems(elems)
+	return &migrationCap, positions, nil
+}
+
+// Import reads a dump from r, and writes every var in it for which
+// this RM is responsible straight into the var store, as an
+// immigrating var is applied, rather than running a txn through
+// consensus for each. Every RM in the cluster must be given the same
+// dump. Nothing checks that the vars are not in use: the cluster must
+// be new, or quiesced, until every RM has finished importing, and no
+// var in the dump, other than the roots, may exist already. The root
+// vars of the dump are written over the roots of the same names; they
+// are all read before anything is written, so that references between
+// them can be rewritten too. Import returns once r is exhausted, or an
+// error occurs, and everything written so far is on disk. It returns
+// the number of vars applied.
+func (tt *TopologyTransmogrifier) Import(r io.Reader) (uint64, error) {
+	topology, err := tt.stableTopology("import")
+	if err != nil {
+		return 0, err
+	}
+
+	rmId := tt.connectionManager.RMId
+	resolver := ch.NewResolver(topology.DataRMs(), topology.TwoFInc)
+	window := make(chan server.EmptyStruct, server.LogShippingApplyWindow)
+	roots := make(dumpRoots)
+	read, applied := uint64(0), uint64(0)
+
+	apply := func(dv *DumpVar, idx uint64) error {
+		if err := roots.remap(dv); err != nil {
+			return fmt.Errorf("Var %v of dump: %v", idx, err)
+		}
+		clockElem := uint64(1)
+		if dv.Root != "" {
+			// The root already exists, so must be written with a
+			// clock which supersedes that of its current frame.
+			vUUId, _ := decodeDumpVarUUId(dv.VarUUId)
+			elem, err := tt.importRootClockElem(vUUId)
+			if err != nil {
+				return err
+			}
+			clockElem = elem
+		}
+		migration, positions, err := dv.migration(topology, clockElem)
+		if err != nil {
+			return fmt.Errorf("Var %v of dump: %v", idx, err)
+		}
+		rmIds, err := resolver.ResolveHashCodes(positions)
+		if err != nil {
+			return fmt.Errorf("Unable to resolve positions of %v: %v", dv.VarUUId, err)
+		}
+		local := false
+		for _, holder := range rmIds {
+			if local = holder == rmId; local {
+				break
+			}
+		}
+		if !local {
+			return nil
+		}
+		select {
+		case window <- server.EmptyStructVal:
+		case <-tt.cellTail.Terminated:
+			return errors.New("Shutting down.")
+		}
+		lsc := &shippedTxnLocalStateChange{window: window}
+		tt.connectionManager.Dispatchers.ProposerDispatcher.ImmigrationReceived(migration, lsc)
+		applied++
+		return nil
+	}
+
+	err = func() error {
+		decoder := json.NewDecoder(r)
+		var rootVars []*DumpVar
+		for {
+			dv := &DumpVar{}
+			if err := decoder.Decode(dv); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("Unable to decode var %v of dump: %v", read+1, err)
+			}
+			read++
+			if rootVars != nil && dv.Root == "" {
+				// That's all the roots: apply them now that every
+				// reference to them can be rewritten.
+				for idx, rootVar := range rootVars {
+					if err := apply(rootVar, uint64(idx+1)); err != nil {
+						return err
+					}
+				}
+				rootVars = nil
+			}
+			if dv.Root == "" {
+				if err := apply(dv, read); err != nil {
+					return err
+				}
+			} else if read != uint64(len(rootVars)+1) {
+				return fmt.Errorf("Var %v of dump is root %q, but roots must come before all other vars.", read, dv.Root)
+			} else if err := roots.add(topology, dv); err != nil {
+				return err
+			} else {
+				rootVars = append(rootVars, dv)
+			}
+		}
+		for idx, rootVar := range rootVars {
+			if err := apply(rootVar, uint64(idx+1)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+
+	// Wait for everything to be on disk, even if we've failed part
+	// way through.
+	if awaitErr := tt.awaitWindow(window); err == nil {
+		err = awaitErr
+	}
+	if err != nil {
+		return applied, err
+	}
+	log.Printf("Imported %v of %v vars from dump.", applied, read)
+	return applied, nil
+}
+
+// importRootClockElem returns the element of the clock with which
+// to write the root vUUId on import: one more than that of the
+// root's current frame, if we hold it.
+func (tt *TopologyTransmogrifier) importRootClockElem(vUUId *common.VarUUId) (uint64, error) {
+	result, err := tt.db.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
+		data, err := rtxn.Get(tt.db.Vars, vUUId[:])
+		if err == db.NotFound {
+			return uint64(1)
+		} else if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		record, err := eng.ReadVarRecord(data)
+		if err != nil {
+			rtxn.Error(err)
+			return nil
+		}
+		return eng.VectorClockFromData(record.Var.WriteTxnClock(), false).At(vUUId) + 1
+	}).ResultError()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read root %v: %v", vUUId, err)
+	} else if result == nil {
+		return 0, errors.New("Shutting down.")
+	}
+	return result.(uint64), nil
+}
+
+// Export writes a dump of every var reachable from the roots to w
+// (see reachableVars). Whilst Export can run with txns in progress,
+// it will only succeed if the vars reachable from the roots are quiet
+// for the duration. It returns the number of vars written.
+func (tt *TopologyTransmogrifier) Export(w io.Writer) (uint64, error) {
+	topology, err := tt.stableTopology("export")
+	if err != nil {
+		return 0, err
+	}
+	encoder := json.NewEncoder(w)
+	versions, err := tt.reachableVars(topology, func(dv *DumpVar) error { return encoder.Encode(dv) })
+	if err != nil {
+		return 0, fmt.Errorf("Unable to export: %v", err)
+	}
+	log.Printf("Exported %v vars.", len(versions))
+	return uint64(len(versions)), nil
+}
+
+// reachableVars walks the graph of references from the roots with
+// quorum reads, passing each var read to dumped, if not nil. The
+// graph is then walked a second time; if any var has been written in
+// between, the vars read are not a consistent snapshot, and an error
+// is returned. Otherwise, every var was at the version read at the
+// moment the first walk finished. It returns the version read of
+// every var.
+func (tt *TopologyTransmogrifier) reachableVars(topology *configuration.Topology, dumped func(*DumpVar) error) (map[common.VarUUId]*common.TxnId, error) {
+	versions, err := tt.exportWalk(topology, dumped)
+	if err != nil {
+		return nil, err
+	}
+	verified, err := tt.exportWalk(topology, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(verified) != len(versions) {
+		return nil, fmt.Errorf("%v vars reachable from the roots, but %v read; vars were written during the walk.", len(verified), len(versions))
+	}
+	for vUUId, version := range versions {
+		if other, found := verified[vUUId]; !found || other.Compare(version) != common.EQ {
+			return nil, fmt.Errorf("%v was written during the walk.", &vUUId)
+		}
+	}
+	return versions, nil
+}
+
+// exportWalk reads every var reachable from the roots, passing each
+// to dumped, if not nil. It returns the version read of every var.
+func (tt *TopologyTransmogrifier) exportWalk(topology *configuration.Topology, dumped func(*DumpVar) error) (map[common.VarUUId]*common.TxnId, error) {
+	return tt.walk(rootWalkStarts(topology, nil), func(start *walkStart, vUUId *common.VarUUId, positions *common.Positions, read *client.QuorumReadResult) error {
+		if dumped == nil {
+			return nil
+		}
+		dv := &DumpVar{
+			VarUUId:    hex.EncodeToString(vUUId[:]),
+			Positions:  hex.EncodeToString((*capn.UInt8List)(positions).ToArray()),
+			Version:    hex.EncodeToString(read.TxnId[:]),
+			Value:      read.Value,
+			References: make([]DumpReference, len(read.References)),
+		}
+		if start != nil {
+			dv.Root = start.name
+		}
+		for idx, ref := range read.References {
+			dv.References[idx] = DumpReference{
+				VarUUId:    hex.EncodeToString(ref.VarUUId[:]),
+				Positions:  hex.EncodeToString((*capn.UInt8List)(ref.Positions).ToArray()),
+				Capability: dumpCapabilityName(ref.Capability.Capability),
+			}
+		}
+		return dumped(dv)
+	})
+}
+
+// A walkStart is a root from which to walk the graph of references.
+type walkStart struct {
+	name string
+	configuration.Root
+}
+
+// rootWalkStarts returns the roots of topology, or, if names is not
+// nil, just those roots named in names.
+func rootWalkStarts(topology *configuration.Topology, names map[string]bool) []*walkStart {
+	starts := []*walkStart{}
+	for idx, name := range topology.RootNames() {
+		if idx < len(topology.Roots) && (names == nil || names[name]) {
+			starts = append(starts, &walkStart{name: name, Root: topology.Roots[idx]})
+		}
+	}
+	return starts
+}
+
+// walk reads, with quorum reads, every var reachable from starts,
+// passing each to visit; start is non-nil only when the var is itself
+// one of starts. It returns the version read of every var.
+func (tt *TopologyTransmogrifier) walk(starts []*walkStart, visit func(start *walkStart, vUUId *common.VarUUId, positions *common.Positions, read *client.QuorumReadResult) error) (map[common.VarUUId]*common.TxnId, error) {
+	lc := tt.connectionManager.LocalConnection
+	type pending struct {
+		vUUId     *common.VarUUId
+		positions *common.Positions
+		start     *walkStart
+	}
+	versions := make(map[common.VarUUId]*common.TxnId)
+	worklist := make([]pending, len(starts))
+	for idx, start := range starts {
+		worklist[idx] = pending{vUUId: start.VarUUId, positions: start.Positions, start: start}
+	}
+	for len(worklist) > 0 {
+		next := worklist[0]
+		worklist = worklist[1:]
+		if _, found := versions[*next.vUUId]; found {
+			continue
+		}
+		read, err := lc.QuorumRead(next.vUUId, next.positions)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read %v: %v", next.vUUId, err)
+		} else if read.Missing {
+			return nil, fmt.Errorf("Unable to read %v: the value at %v is not yet known; try again.", next.vUUId, read.TxnId)
+		}
+		versions[*next.vUUId] = read.TxnId
+		for _, ref := range read.References {
+			worklist = append(worklist, pending{vUUId: ref.VarUUId, positions: ref.Positions})
+		}
+		if err := visit(next.start, next.vUUId, next.positions, read); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+// ExportToFile writes a dump to a new file at path. The file is
+// removed if the export fails.
+func (tt *TopologyTransmogrifier) ExportToFile(path string) (uint64, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, err
+	}
+	count, err := tt.Export(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	return count, nil
+}
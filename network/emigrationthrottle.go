@@ -0,0 +1,121 @@
+package network
+
+import (
+	"fmt"
+	"goshawkdb.io/server"
+	"sync"
+	"time"
+)
+
+// EmigrationLimits restrict how fast this RM sends vars to other RMs
+// during a topology change, so that migrations can run alongside
+// production traffic. A zero rate is unlimited. Window is the number
+// of batches which may be sent to an RM before it acknowledges them
+// as on disk; if 0, MigrationBatchWindow is used.
+type EmigrationLimits struct {
+	VarsPerSecond  uint64
+	BytesPerSecond uint64
+	Window         int
+}
+
+func (el *EmigrationLimits) validate() error {
+	if el.Window < 0 || el.Window > server.MigrationBatchWindowMax {
+		return fmt.Errorf("Window must be between 0 and %v.", server.MigrationBatchWindowMax)
+	}
+	return nil
+}
+
+// emigrationThrottle applies the EmigrationLimits to every batch sent
+// by the emigrator, whichever RM it is sent to. Batches are paced:
+// each batch is sent no sooner than the previous batch's vars and
+// bytes permit. The limits can be changed at any time, and apply
+// from the next batch. It is shared by the emigrator's iterators, so
+// all methods are safe for concurrent use.
+type emigrationThrottle struct {
+	sync.Mutex
+	limits    EmigrationLimits
+	next      time.Time
+	throttled time.Duration
+}
+
+func (et *emigrationThrottle) setLimits(limits EmigrationLimits) error {
+	if err := limits.validate(); err != nil {
+		return err
+	}
+	et.Lock()
+	et.limits = limits
+	et.next = time.Time{}
+	et.Unlock()
+	return nil
+}
+
+func (et *emigrationThrottle) getLimits() EmigrationLimits {
+	et.Lock()
+	defer et.Unlock()
+	return et.limits
+}
+
+func (et *emigrationThrottle) window() int {
+	et.Lock()
+	defer et.Unlock()
+	if et.limits.Window == 0 {
+		return server.MigrationBatchWindow
+	}
+	return et.limits.Window
+}
+
+// wait blocks until a batch of vars and bytes may be sent. It
+// returns false if abandoned is closed first.
+func (et *emigrationThrottle) wait(vars, bytes uint64, abandoned chan server.EmptyStruct) bool {
+	et.Lock()
+	now := time.Now()
+	start := et.next
+	if start.Before(now) {
+		start = now
+	}
+	cost := time.Duration(0)
+	if rate := et.limits.VarsPerSecond; rate != 0 {
+		cost = time.Duration(vars) * time.Second / time.Duration(rate)
+	}
+	if rate := et.limits.BytesPerSecond; rate != 0 {
+		if bytesCost := time.Duration(bytes) * time.Second / time.Duration(rate); bytesCost > cost {
+			cost = bytesCost
+		}
+	}
+	et.next = start.Add(cost)
+	delay := start.Sub(now)
+	et.throttled += delay
+	et.Unlock()
+
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-abandoned:
+		return false
+	}
+}
+
+func (et *emigrationThrottle) Status(sc *server.StatusConsumer) {
+	et.Lock()
+	sc.Emit(fmt.Sprintf("Emigration limits: %+v; throttled for %v", et.limits, et.throttled))
+	et.Unlock()
+	sc.Join()
+}
+
+// EmigrationLimits returns the limits currently applied to
+// emigration.
+func (tt *TopologyTransmogrifier) EmigrationLimits() EmigrationLimits {
+	return tt.emigrationThrottle.getLimits()
+}
+
+// SetEmigrationLimits changes the limits applied to emigration. They
+// apply from the next batch sent, including to migrations already in
+// progress.
+func (tt *TopologyTransmogrifier) SetEmigrationLimits(limits EmigrationLimits) error {
+	return tt.emigrationThrottle.setLimits(limits)
+}
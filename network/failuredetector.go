@@ -0,0 +1,127 @@
+package network
+
+import (
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// failureDetector is a phi accrual failure detector (Hayashibara et
+// al.) for a server connection. It learns the distribution of the
+// silences which precede heartbeats from the remote RM; phi is then a
+// measure of how unlikely it is, given how long the remote RM has now
+// been silent, that it is still alive: phi of 1 means a 10% chance
+// of being wrong to suspect it, 2 means 1%, and so on.
+//
+// Heartbeats are only sent when nothing else has been, so only the
+// gaps before heartbeats say anything about how long the remote RM may
+// normally be silent: the gaps between other messages reflect how
+// busy it is, and learning them would make an ordinary pause in
+// traffic look like a failure. Any message ends a silence, but only
+// heartbeats are learnt from. Gaps of up to two heartbeat intervals
+// are normal, so phi only starts to accrue once a silence exceeds a
+// heartbeat interval beyond the expected gap.
+type failureDetector struct {
+	gaps  [server.FailureDetectorWindow]float64
+	next  int
+	count int
+	sum   float64
+	sumSq float64
+	last  time.Time
+}
+
+func newFailureDetector(now time.Time) *failureDetector {
+	return &failureDetector{last: now}
+}
+
+// arrived records the arrival of any message other than a heartbeat
+// from the remote RM.
+func (fd *failureDetector) arrived(now time.Time) {
+	fd.last = now
+}
+
+// heartbeat records the arrival of a heartbeat from the remote RM,
+// and learns the silence which preceded it.
+func (fd *failureDetector) heartbeat(now time.Time) {
+	gap := now.Sub(fd.last).Seconds()
+	fd.last = now
+	if fd.count == len(fd.gaps) {
+		old := fd.gaps[fd.next]
+		fd.sum -= old
+		fd.sumSq -= old * old
+	} else {
+		fd.count++
+	}
+	fd.gaps[fd.next] = gap
+	fd.next = (fd.next + 1) % len(fd.gaps)
+	fd.sum += gap
+	fd.sumSq += gap * gap
+}
+
+// phi is 0 until at least one heartbeat has been seen.
+func (fd *failureDetector) phi(now time.Time) float64 {
+	if fd.count == 0 {
+		return 0
+	}
+	mean := fd.sum / float64(fd.count)
+	stdDev := math.Sqrt(math.Max(0, fd.sumSq/float64(fd.count)-mean*mean))
+	stdDev = math.Max(stdDev, server.FailureDetectorMinStdDev.Seconds())
+	elapsed := now.Sub(fd.last).Seconds() - common.HeartbeatInterval.Seconds()
+	// Probability that a gap is at least as long as this one,
+	// assuming gaps are normally distributed.
+	pLater := 0.5 * math.Erfc((elapsed-mean)/(stdDev*math.Sqrt2))
+	return -math.Log10(pLater)
+}
+
+// failureSuspicions records, for each RM, how often its server
+// connection has been restarted because its failure was suspected.
+// It is shared by all server connections, so all methods are safe for
+// concurrent use.
+type failureSuspicions struct {
+	sync.Mutex
+	suspected map[common.RMId]*failureSuspicion
+}
+
+type failureSuspicion struct {
+	count uint64
+	last  time.Time
+	phi   float64
+}
+
+func newFailureSuspicions() *failureSuspicions {
+	return &failureSuspicions{
+		suspected: make(map[common.RMId]*failureSuspicion),
+	}
+}
+
+func (fs *failureSuspicions) suspect(rmId common.RMId, phi float64, now time.Time) {
+	fs.Lock()
+	suspicion, found := fs.suspected[rmId]
+	if !found {
+		suspicion = &failureSuspicion{}
+		fs.suspected[rmId] = suspicion
+	}
+	suspicion.count++
+	suspicion.last = now
+	suspicion.phi = phi
+	fs.Unlock()
+}
+
+func (fs *failureSuspicions) Status(sc *server.StatusConsumer) {
+	fs.Lock()
+	lines := make([]string, 0, len(fs.suspected))
+	for rmId, suspicion := range fs.suspected {
+		lines = append(lines, fmt.Sprintf("%v: %v (last at %v, phi %.1f)", rmId, suspicion.count, suspicion.last, suspicion.phi))
+	}
+	fs.Unlock()
+	sort.Strings(lines)
+	sc.Emit("Suspected RM failures")
+	for _, line := range lines {
+		sc.Emit(fmt.Sprintf("- %s", line))
+	}
+	sc.Join()
+}
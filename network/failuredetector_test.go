@@ -0,0 +1,51 @@
+package network
+
+import (
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	"testing"
+	"time"
+)
+
+func TestFailureDetectorBurstThenIdle(t *testing.T) {
+	interval := common.HeartbeatInterval
+	now := time.Now()
+	fd := newFailureDetector(now)
+
+	// A busy burst of traffic teaches the detector nothing about how
+	// long the remote RM may be silent.
+	for idx := 0; idx < 2*server.FailureDetectorWindow; idx++ {
+		now = now.Add(time.Millisecond)
+		fd.arrived(now)
+	}
+	if phi := fd.phi(now.Add(2 * interval)); phi != 0 {
+		t.Fatalf("Expected phi of 0 before any heartbeats; got %v", phi)
+	}
+
+	// Once the traffic stops, heartbeats start: the first after up
+	// to two intervals, the rest every interval. None of those
+	// silences should be suspicious.
+	now = now.Add(interval + interval/2)
+	fd.heartbeat(now)
+	for idx := 0; idx < 10; idx++ {
+		if phi := fd.phi(now.Add(2 * interval)); phi >= server.FailureDetectorPhiThreshold {
+			t.Fatalf("Healthy idle connection suspected after %v heartbeats (phi %v)", idx+1, phi)
+		}
+		now = now.Add(interval)
+		fd.heartbeat(now)
+	}
+
+	// Another burst, followed by an ordinary idle silence.
+	for idx := 0; idx < 2*server.FailureDetectorWindow; idx++ {
+		now = now.Add(time.Millisecond)
+		fd.arrived(now)
+	}
+	if phi := fd.phi(now.Add(2 * interval)); phi >= server.FailureDetectorPhiThreshold {
+		t.Fatalf("Healthy connection suspected after a burst (phi %v)", phi)
+	}
+
+	// But a long silence is.
+	if phi := fd.phi(now.Add(10 * interval)); phi < server.FailureDetectorPhiThreshold {
+		t.Fatalf("Expected a long silence to be suspected; got phi %v", phi)
+	}
+}
@@ -0,0 +1,186 @@
+package network
+
+import (
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var faultMessageNames = map[string]msgs.Message_Which{
+	"txnSubmission":       msgs.MESSAGE_TXNSUBMISSION,
+	"submissionOutcome":   msgs.MESSAGE_SUBMISSIONOUTCOME,
+	"submissionComplete":  msgs.MESSAGE_SUBMISSIONCOMPLETE,
+	"submissionAbort":     msgs.MESSAGE_SUBMISSIONABORT,
+	"oneATxnVotes":        msgs.MESSAGE_ONEATXNVOTES,
+	"oneBTxnVotes":        msgs.MESSAGE_ONEBTXNVOTES,
+	"twoATxnVotes":        msgs.MESSAGE_TWOATXNVOTES,
+	"twoBTxnVotes":        msgs.MESSAGE_TWOBTXNVOTES,
+	"txnLocallyComplete":  msgs.MESSAGE_TXNLOCALLYCOMPLETE,
+	"txnGloballyComplete": msgs.MESSAGE_TXNGLOBALLYCOMPLETE,
+	"migration":           msgs.MESSAGE_MIGRATION,
+	"migrationComplete":   msgs.MESSAGE_MIGRATIONCOMPLETE,
+	"migrationBatchAck":   msgs.MESSAGE_MIGRATIONBATCHACK,
+}
+
+// A FaultRule applies to messages sent to other RMs. Message is the
+// name of the message (as in connection.capnp, for example
+// "twoBTxnVotes"), or empty for every message. RMId is the RM the
+// message is sent to, or 0 for every RM. A matching message is
+// dropped with probability DropPercent / 100; if it's not dropped, it
+// is sent DelayMS milliseconds late.
+type FaultRule struct {
+	Message     string
+	RMId        common.RMId
+	DropPercent float64
+	DelayMS     uint32
+	which       msgs.Message_Which
+}
+
+// Faults is the complete set of faults to inject. Nothing at all,
+// not even heartbeats, is sent to an RM in Partition, so connections
+// to them will be restarted.
+type Faults struct {
+	Rules     []FaultRule
+	Partition common.RMIds
+}
+
+// FaultStats counts the messages which have been interfered with.
+type FaultStats struct {
+	Dropped uint64
+	Delayed uint64
+}
+
+// FaultInjector makes server connections misbehave on purpose, so
+// that recovery paths can be exercised on a running cluster. It is
+// shared by all server connections, so all methods are safe for
+// concurrent use. With no faults set, it costs one atomic load per
+// message.
+type FaultInjector struct {
+	sync.Mutex
+	enabled     int32
+	rng         *rand.Rand
+	faults      *Faults
+	partitioned map[common.RMId]server.EmptyStruct
+	stats       FaultStats
+}
+
+func newFaultInjector() *FaultInjector {
+	return &FaultInjector{
+		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Set replaces the current faults with faults.
+func (fi *FaultInjector) Set(faults *Faults) error {
+	for idx := range faults.Rules {
+		rule := &faults.Rules[idx]
+		if rule.Message != "" {
+			which, found := faultMessageNames[rule.Message]
+			if !found {
+				return fmt.Errorf("Unknown message %s.", rule.Message)
+			}
+			rule.which = which
+		}
+		if rule.DropPercent < 0 || rule.DropPercent > 100 {
+			return fmt.Errorf("DropPercent must be between 0 and 100; %v given.", rule.DropPercent)
+		}
+	}
+	partitioned := make(map[common.RMId]server.EmptyStruct, len(faults.Partition))
+	for _, rmId := range faults.Partition {
+		partitioned[rmId] = server.EmptyStructVal
+	}
+	fi.Lock()
+	fi.faults = faults
+	fi.partitioned = partitioned
+	fi.Unlock()
+	if len(faults.Rules) == 0 && len(partitioned) == 0 {
+		atomic.StoreInt32(&fi.enabled, 0)
+	} else {
+		atomic.StoreInt32(&fi.enabled, 1)
+	}
+	return nil
+}
+
+func (fi *FaultInjector) Clear() {
+	fi.Set(&Faults{})
+}
+
+// Get returns the current faults, and the counts of messages
+// interfered with so far.
+func (fi *FaultInjector) Get() (*Faults, FaultStats) {
+	fi.Lock()
+	defer fi.Unlock()
+	if fi.faults == nil {
+		return &Faults{}, fi.stats
+	}
+	return fi.faults, fi.stats
+}
+
+func (fi *FaultInjector) isPartitioned(rmId common.RMId) bool {
+	if atomic.LoadInt32(&fi.enabled) == 0 {
+		return false
+	}
+	fi.Lock()
+	_, found := fi.partitioned[rmId]
+	fi.Unlock()
+	return found
+}
+
+// apply decides the fate of msg, which is about to be sent to rmId.
+func (fi *FaultInjector) apply(rmId common.RMId, msg []byte) (drop bool, delay time.Duration) {
+	if atomic.LoadInt32(&fi.enabled) == 0 {
+		return false, 0
+	}
+	which, ok := messageWhich(msg)
+	fi.Lock()
+	defer fi.Unlock()
+	if _, found := fi.partitioned[rmId]; found {
+		fi.stats.Dropped++
+		return true, 0
+	}
+	for _, rule := range fi.faults.Rules {
+		if (rule.RMId != common.RMIdEmpty && rule.RMId != rmId) || (rule.Message != "" && (!ok || rule.which != which)) {
+			continue
+		}
+		if rule.DropPercent > 0 && fi.rng.Float64()*100 < rule.DropPercent {
+			fi.stats.Dropped++
+			return true, 0
+		}
+		if ruleDelay := time.Duration(rule.DelayMS) * time.Millisecond; ruleDelay > delay {
+			delay = ruleDelay
+		}
+	}
+	if delay > 0 {
+		fi.stats.Delayed++
+	}
+	return false, delay
+}
+
+func messageWhich(msg []byte) (which msgs.Message_Which, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+	seg, _, err := capn.ReadFromMemoryZeroCopy(msg)
+	if err != nil {
+		return 0, false
+	}
+	return msgs.ReadRootMessage(seg).Which(), true
+}
+
+func (fi *FaultInjector) Status(sc *server.StatusConsumer) {
+	faults, stats := fi.Get()
+	sc.Emit("Fault injection")
+	sc.EmitKV("Rules", len(faults.Rules))
+	sc.EmitKV("Partitioned from", faults.Partition)
+	sc.EmitKV("Messages dropped", stats.Dropped)
+	sc.EmitKV("Messages delayed", stats.Delayed)
+	sc.Join()
+}
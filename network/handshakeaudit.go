@@ -0,0 +1,95 @@
+package network
+
+import (
+	"crypto/tls"
+	"fmt"
+	"goshawkdb.io/server"
+	"log"
+	"sort"
+	"sync"
+)
+
+// handshakeAuditor records what every connection negotiated, so that
+// in a cluster with mixed versions of Go (and thus TLS stacks) we can
+// spot peers silently negotiating something weaker than we intended.
+type handshakeAuditor struct {
+	sync.Mutex
+	counts     map[handshakeAuditKey]uint64
+	belowFloor uint64
+}
+
+type handshakeAuditKey struct {
+	isClient       bool
+	tlsVersion     uint16
+	cipherSuite    uint16
+	productVersion string
+}
+
+func newHandshakeAuditor() *handshakeAuditor {
+	return &handshakeAuditor{
+		counts: make(map[handshakeAuditKey]uint64),
+	}
+}
+
+func (ha *handshakeAuditor) audit(isClient bool, remoteHost string, state *tls.ConnectionState, productVersion string) {
+	key := handshakeAuditKey{
+		isClient:       isClient,
+		tlsVersion:     state.Version,
+		cipherSuite:    state.CipherSuite,
+		productVersion: productVersion,
+	}
+	belowFloor := state.Version < server.TLSVersionFloor
+	ha.Lock()
+	ha.counts[key]++
+	if belowFloor {
+		ha.belowFloor++
+	}
+	ha.Unlock()
+
+	if belowFloor {
+		log.Printf("Warning: Connection to %v negotiated %v, which is below the floor of %v.",
+			remoteHost, &key, tlsVersionName(server.TLSVersionFloor))
+	} else {
+		debugLog.Log("Connection to", remoteHost, "negotiated", &key)
+	}
+}
+
+func (ha *handshakeAuditor) Status(sc *server.StatusConsumer) {
+	ha.Lock()
+	lines := make([]string, 0, len(ha.counts))
+	for key, count := range ha.counts {
+		lines = append(lines, fmt.Sprintf("%v: %v", &key, count))
+	}
+	belowFloor := ha.belowFloor
+	ha.Unlock()
+	sort.Strings(lines)
+	sc.Emit(fmt.Sprintf("Handshakes below TLS floor (%v): %v", tlsVersionName(server.TLSVersionFloor), belowFloor))
+	for _, line := range lines {
+		sc.Emit(fmt.Sprintf("- Handshake %v", line))
+	}
+	sc.Join()
+}
+
+func (key *handshakeAuditKey) String() string {
+	kind := "server"
+	if key.isClient {
+		kind = "client"
+	}
+	return fmt.Sprintf("%s, %v, cipher suite 0x%04x, product version '%s'",
+		kind, tlsVersionName(key.tlsVersion), key.cipherSuite, key.productVersion)
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionSSL30:
+		return "SSLv3"
+	case tls.VersionTLS10:
+		return "TLSv1.0"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	default:
+		return fmt.Sprintf("TLS(0x%04x)", version)
+	}
+}
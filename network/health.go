@@ -0,0 +1,129 @@
+package network
+
+import (
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/dispatcher"
+	"log"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// The health of an RM is a bit set of the ways in which it is
+// currently degraded; 0 is healthy. Each RM samples its own health,
+// and tells the other RMs whenever it changes, so that they can avoid
+// placing new vars on it until it recovers.
+const (
+	// healthQueueDepth: an executor's queue is at least
+	// HealthQueueDepthMax long.
+	healthQueueDepth uint32 = 1 << iota
+	// healthDiskFull: less than HealthDiskFreeMin of the disk holding
+	// the data dir is free.
+	healthDiskFull
+	// healthRecovering: the RM is not yet ready for client
+	// connections.
+	healthRecovering
+)
+
+type connectionManagerMsgSetHealth struct {
+	connectionManagerMsgBasic
+	health uint32
+}
+
+type connectionManagerMsgServerHealth struct {
+	connectionManagerMsgBasic
+	rmId   common.RMId
+	health uint32
+}
+
+// CheckHealth samples the executor queues and the disk holding
+// dataDir, and updates the health of this RM. It is intended to be
+// run periodically.
+func (cm *ConnectionManager) CheckHealth(dataDir string) {
+	health := uint32(0)
+	if cm.maxQueueLength() >= server.HealthQueueDepthMax {
+		health |= healthQueueDepth
+	}
+	if free, err := diskFree(dataDir); err != nil {
+		log.Printf("Unable to check free space of %v: %v", dataDir, err)
+	} else if free < server.HealthDiskFreeMin {
+		health |= healthDiskFull
+	}
+	cm.enqueueQuery(connectionManagerMsgSetHealth{health: health})
+}
+
+func (cm *ConnectionManager) maxQueueLength() int64 {
+	d := cm.Dispatchers
+	max := int64(0)
+	for _, dis := range []*dispatcher.Dispatcher{&d.AcceptorDispatcher.Dispatcher, &d.ProposerDispatcher.Dispatcher, &d.VarDispatcher.Dispatcher} {
+		for _, exe := range dis.Executors {
+			if queued := exe.Metrics().QueueLength; queued > max {
+				max = queued
+			}
+		}
+	}
+	return max
+}
+
+// diskFree returns the fraction of the disk holding dir which is
+// available to us.
+func diskFree(dir string) (float64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	} else if stat.Blocks == 0 {
+		return 1, nil
+	}
+	return float64(stat.Bavail) / float64(stat.Blocks), nil
+}
+
+func (cm *ConnectionManager) setHealth(health uint32) {
+	if cm.flushedServers != nil {
+		health |= healthRecovering
+	}
+	if atomic.LoadUint32(&cm.health) == health {
+		return
+	}
+	log.Printf("%v health: %v", cm.RMId, healthString(health))
+	atomic.StoreUint32(&cm.health, health)
+	msg := makeHealthMsg(health)
+	for rmId, cd := range cm.rmToServer {
+		if rmId != cm.RMId && peerHasCapability(cd, serverCapabilityHealth) {
+			cd.Send(msg)
+		}
+	}
+}
+
+// serverHealth records the health reported by the remote RM. The
+// connection is not republished: subscribers sample Degraded when
+// they need it.
+func (cm *ConnectionManager) serverHealth(rmId common.RMId, health uint32) {
+	if cd, found := cm.rmToServer[rmId]; found && rmId != cm.RMId {
+		debugLog.Log("CM", rmId, "health:", healthString(health))
+		atomic.StoreUint32(cd.health, health)
+	}
+}
+
+func makeHealthMsg(health uint32) []byte {
+	seg := capn.NewBuffer(nil)
+	msg := msgs.NewRootMessage(seg)
+	msg.SetHealth(uint8(health))
+	return server.SegToBytes(seg)
+}
+
+func healthString(health uint32) string {
+	if health == 0 {
+		return "healthy"
+	}
+	reasons := []string{}
+	for idx, reason := range []string{"queue depth", "disk full", "recovering"} {
+		if health&(1<<uint(idx)) != 0 {
+			reasons = append(reasons, reason)
+		}
+	}
+	return fmt.Sprintf("degraded (%v)", strings.Join(reasons, ", "))
+}
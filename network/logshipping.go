@@ -0,0 +1,260 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/configuration"
+	ch "goshawkdb.io/server/consistenthash"
+	eng "goshawkdb.io/server/txnengine"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// A LogShipper streams every frame written by the vars of this RM to
+// a sink, so that a standby cluster can apply them with
+// ApplyShippedLog. Each frame is written as a Migration message
+// holding the frame's txn and the var (with its positions and
+// clocks), exactly as an emigrating var is sent.
+//
+// A standby should first be seeded from a Backup taken after the
+// LogShipper was started. Shipping never holds up the vars: if the
+// sink falls LogShippingQueueLength frames behind, or fails, the
+// LogShipper stops, and the standby must be seeded again.
+type LogShipper struct {
+	tt         *TopologyTransmogrifier
+	w          io.Writer
+	queue      chan []byte
+	stopped    int32
+	terminated chan struct{}
+	lock       sync.Mutex
+	shipped    uint64
+	err        error
+}
+
+// StartLogShipping starts shipping frames to w. Only one LogShipper
+// can run at once.
+func (tt *TopologyTransmogrifier) StartLogShipping(w io.Writer) (*LogShipper, error) {
+	tt.logShipperLock.Lock()
+	defer tt.logShipperLock.Unlock()
+	if tt.logShipper != nil {
+		return nil, errors.New("Log shipping already in progress.")
+	}
+	ls := &LogShipper{
+		tt:         tt,
+		w:          w,
+		queue:      make(chan []byte, server.LogShippingQueueLength),
+		terminated: make(chan struct{}),
+	}
+	tt.logShipper = ls
+	go ls.writer()
+	tt.connectionManager.Dispatchers.VarDispatcher.SetFrameWriteObserver(ls)
+	return ls, nil
+}
+
+// FrameWritten is called by the vars, from their executors.
+func (ls *LogShipper) FrameWritten(vUUId *common.VarUUId, txnBytes, varBytes []byte) {
+	if atomic.LoadInt32(&ls.stopped) != 0 || vUUId.Compare(configuration.TopologyVarUUId) == common.EQ {
+		return
+	}
+	varSeg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
+	if err != nil {
+		ls.fail(err)
+		return
+	}
+	seg := capn.NewBuffer(nil)
+	migration := msgs.NewRootMigration(seg)
+	elems := msgs.NewMigrationElementList(seg, 1)
+	elem := msgs.NewMigrationElement(seg)
+	elem.SetTxn(txnBytes)
+	vars := msgs.NewVarList(seg, 1)
+	vars.Set(0, msgs.ReadRootVar(varSeg))
+	elem.SetVars(vars)
+	elems.Set(0, elem)
+	migration.SetElems(elems)
+
+	select {
+	case ls.queue <- server.SegToBytes(seg):
+	default:
+		ls.fail(errors.New("Sink is too slow"))
+	}
+}
+
+func (ls *LogShipper) writer() {
+	for {
+		select {
+		case <-ls.terminated:
+			return
+		case bites := <-ls.queue:
+			if _, err := ls.w.Write(bites); err != nil {
+				ls.fail(err)
+				return
+			}
+			ls.lock.Lock()
+			ls.shipped++
+			ls.lock.Unlock()
+		}
+	}
+}
+
+func (ls *LogShipper) fail(err error) {
+	ls.lock.Lock()
+	if ls.err == nil {
+		ls.err = err
+		log.Printf("Error: Log shipping stopped: %v", err)
+	}
+	ls.lock.Unlock()
+	ls.Stop()
+}
+
+// Stop stops shipping. Frames already queued are discarded.
+func (ls *LogShipper) Stop() {
+	if atomic.CompareAndSwapInt32(&ls.stopped, 0, 1) {
+		close(ls.terminated)
+		ls.tt.logShipperLock.Lock()
+		defer ls.tt.logShipperLock.Unlock()
+		ls.tt.logShipper = nil
+		ls.tt.connectionManager.Dispatchers.VarDispatcher.SetFrameWriteObserver(nil)
+	}
+}
+
+// Shipped returns the number of frames written to the sink, and the
+// error which stopped shipping, if any.
+func (ls *LogShipper) Shipped() (uint64, error) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	return ls.shipped, ls.err
+}
+
+// ApplyShippedLog reads a log written by a LogShipper from r, and
+// applies every var for which this RM is responsible, as an
+// immigrating var is applied. The standby cluster must have the same
+// RMs and F as the shipping cluster for every var to be applied
+// somewhere. It returns the number of vars applied once r is
+// exhausted.
+func (tt *TopologyTransmogrifier) ApplyShippedLog(r io.Reader) (uint64, error) {
+	topology, err := tt.stableTopology("apply shipped log")
+	if err != nil {
+		return 0, err
+	}
+
+	rmId := tt.connectionManager.RMId
+	resolver := ch.NewResolver(topology.DataRMs(), topology.TwoFInc)
+	window := make(chan server.EmptyStruct, server.LogShippingApplyWindow)
+	applied := uint64(0)
+	for {
+		seg, err := capn.ReadFromStream(r, nil)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return applied, err
+		}
+		migration := msgs.ReadRootMigration(seg)
+		elems := migration.Elems()
+		for idx, l := 0, elems.Len(); idx < l; idx++ {
+			elem := elems.At(idx)
+			varCaps := elem.Vars()
+			local := make([]msgs.Var, 0, varCaps.Len())
+			for idy, m := 0, varCaps.Len(); idy < m; idy++ {
+				varCap := varCaps.At(idy)
+				rmIds, err := resolver.ResolveHashCodes(varCap.Positions().ToArray())
+				if err != nil {
+					return applied, fmt.Errorf("Unable to resolve positions of %v: %v", common.MakeVarUUId(varCap.Id()), err)
+				}
+				for _, holder := range rmIds {
+					if holder == rmId {
+						local = append(local, varCap)
+						break
+					}
+				}
+			}
+			if len(local) == 0 {
+				continue
+			}
+			select {
+			case window <- server.EmptyStructVal:
+			case <-tt.cellTail.Terminated:
+				return applied, errors.New("Shutting down.")
+			}
+			applySeg := capn.NewBuffer(nil)
+			applyMigration := msgs.NewRootMigration(applySeg)
+			applyElems := msgs.NewMigrationElementList(applySeg, 1)
+			applyElem := msgs.NewMigrationElement(applySeg)
+			applyElem.SetTxn(elem.Txn())
+			vars := msgs.NewVarList(applySeg, len(local))
+			for idy, varCap := range local {
+				vars.Set(idy, varCap)
+			}
+			applyElem.SetVars(vars)
+			applyElems.Set(0, applyElem)
+			applyMigration.SetElems(applyElems)
+			lsc := &shippedTxnLocalStateChange{window: window}
+			tt.connectionManager.Dispatchers.ProposerDispatcher.ImmigrationReceived(&applyMigration, lsc)
+			applied += uint64(len(local))
+		}
+	}
+	// Wait for everything to be on disk.
+	return applied, tt.awaitWindow(window)
+}
+
+// stableTopology returns the active topology, provided no topology
+// change is in progress. purpose describes the caller, for errors.
+func (tt *TopologyTransmogrifier) stableTopology(purpose string) (*configuration.Topology, error) {
+	var topology *configuration.Topology
+	var err error
+	resultChan := make(chan struct{})
+	enqueued := tt.enqueueQuery(topologyTransmogrifierMsgExe(func() error {
+		defer close(resultChan)
+		switch {
+		case tt.active == nil:
+			err = fmt.Errorf("Unable to %v: no topology installed yet.", purpose)
+		case tt.active.Next() != nil:
+			err = fmt.Errorf("Unable to %v: topology change in progress.", purpose)
+		default:
+			topology = tt.active
+		}
+		return nil
+	}))
+	if !enqueued {
+		return nil, errors.New("Shutting down.")
+	}
+	select {
+	case <-resultChan:
+		return topology, err
+	case <-tt.cellTail.Terminated:
+		return nil, errors.New("Shutting down.")
+	}
+}
+
+// awaitWindow waits for every txn holding a slot in window to be
+// locally complete, and so on disk.
+func (tt *TopologyTransmogrifier) awaitWindow(window chan server.EmptyStruct) error {
+	for idx := 0; idx < cap(window); idx++ {
+		select {
+		case window <- server.EmptyStructVal:
+		case <-tt.cellTail.Terminated:
+			return errors.New("Shutting down.")
+		}
+	}
+	return nil
+}
+
+type shippedTxnLocalStateChange struct {
+	window chan server.EmptyStruct
+}
+
+func (stlsc *shippedTxnLocalStateChange) TxnBallotsComplete(...*eng.Ballot) {
+	panic("TxnBallotsComplete called on shipped txn.")
+}
+
+func (stlsc *shippedTxnLocalStateChange) TxnLocallyComplete(txn *eng.Txn) {
+	txn.CompletionReceived()
+	<-stlsc.window
+}
+
+func (stlsc *shippedTxnLocalStateChange) TxnFinished(*eng.Txn) {}
@@ -0,0 +1,277 @@
+package network
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/db"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+func init() {
+	db.DB.EmigrationCursors = db.DeclareTable("EmigrationCursors")
+}
+
+// TopologyProgress describes the topology change in progress, if
+// any, as seen from this RM.
+type TopologyProgress struct {
+	ActiveVersion uint32
+	NextVersion   uint32 `json:",omitempty"`
+	// Task is the step of the topology change this RM is working on.
+	Task            string       `json:",omitempty"`
+	NewRMIds        common.RMIds `json:",omitempty"`
+	SurvivingRMIds  common.RMIds `json:",omitempty"`
+	LostRMIds       common.RMIds `json:",omitempty"`
+	BarrierReached1 common.RMIds `json:",omitempty"`
+	BarrierReached2 common.RMIds `json:",omitempty"`
+	// Barrier is the barrier (1 or 2) the change is waiting at, or 0.
+	Barrier   int               `json:",omitempty"`
+	Diagnosis *BarrierDiagnosis `json:",omitempty"`
+	// Pending maps each RM which has yet to receive all its
+	// migrations to the RMs which have so far supplied it.
+	Pending map[string]common.RMIds `json:",omitempty"`
+	// Migration counts what this RM has sent and received so far.
+	Migration *MigrationReport `json:",omitempty"`
+	// Emigration describes this RM's scans of its vars, one per RM
+	// being sent to.
+	Emigration []EmigrationPairProgress `json:",omitempty"`
+}
+
+// EmigrationPairProgress describes the scan of this RM's vars for
+// those to send to To. The scan starts from the cursor last
+// checkpointed for To, if any. A checkpoint is taken every
+// MigrationCheckpointBatches batches, once every batch sent has been
+// acknowledged as on disk by To. Total is the number of vars from
+// the start of the scan, counted as it began.
+type EmigrationPairProgress struct {
+	To                 common.RMId
+	Started            time.Time
+	ResumedFrom        string `json:",omitempty"`
+	Total              uint64
+	Scanned            uint64
+	Checkpoint         string `json:",omitempty"`
+	Checkpoints        uint64
+	EstimatedRemaining time.Duration
+}
+
+// emigrationProgress is shared between the emigrator's iterators,
+// which update it, and the TopologyTransmogrifier, which reports it,
+// so all methods are safe for concurrent use.
+type emigrationProgress struct {
+	sync.Mutex
+	pairs map[common.RMId]*EmigrationPairProgress
+}
+
+func newEmigrationProgress() *emigrationProgress {
+	return &emigrationProgress{
+		pairs: make(map[common.RMId]*EmigrationPairProgress),
+	}
+}
+
+func (ep *emigrationProgress) started(to common.RMId, resumedFrom []byte, total uint64) {
+	ep.Lock()
+	ep.pairs[to] = &EmigrationPairProgress{
+		To:          to,
+		Started:     time.Now(),
+		ResumedFrom: hex.EncodeToString(resumedFrom),
+		Total:       total,
+	}
+	ep.Unlock()
+}
+
+func (ep *emigrationProgress) scanned(to common.RMId) {
+	ep.Lock()
+	if pair, found := ep.pairs[to]; found {
+		pair.Scanned++
+	}
+	ep.Unlock()
+}
+
+func (ep *emigrationProgress) checkpointed(to common.RMId, cursor []byte) {
+	ep.Lock()
+	if pair, found := ep.pairs[to]; found {
+		pair.Checkpoint = hex.EncodeToString(cursor)
+		pair.Checkpoints++
+	}
+	ep.Unlock()
+}
+
+func (ep *emigrationProgress) snapshot() []EmigrationPairProgress {
+	now := time.Now()
+	ep.Lock()
+	pairs := make([]EmigrationPairProgress, 0, len(ep.pairs))
+	for _, pair := range ep.pairs {
+		pairCopy := *pair
+		if pair.Scanned != 0 && pair.Total > pair.Scanned {
+			elapsed := now.Sub(pair.Started)
+			pairCopy.EstimatedRemaining = time.Duration(float64(elapsed) * float64(pair.Total-pair.Scanned) / float64(pair.Scanned))
+		}
+		pairs = append(pairs, pairCopy)
+	}
+	ep.Unlock()
+	sort.Sort(emigrationPairs(pairs))
+	return pairs
+}
+
+type emigrationPairs []EmigrationPairProgress
+
+func (ep emigrationPairs) Len() int           { return len(ep) }
+func (ep emigrationPairs) Less(i, j int) bool { return ep[i].To < ep[j].To }
+func (ep emigrationPairs) Swap(i, j int)      { ep[i], ep[j] = ep[j], ep[i] }
+
+func emigrationCursorKey(version uint32, to common.RMId) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint32(key, version)
+	binary.BigEndian.PutUint32(key[4:], uint32(to))
+	return key
+}
+
+// writeEmigrationCursor records that every var up to and including
+// cursor has been sent to, and is on disk at, the RM to.
+func (e *emigrator) writeEmigrationCursor(version uint32, to common.RMId, cursor []byte) {
+	key := emigrationCursorKey(version, to)
+	future := e.db.ReadWriteTransaction(false, func(rwtxn db.ReadWriteTxn) interface{} {
+		rwtxn.Put(e.db.EmigrationCursors, key, cursor)
+		return true
+	})
+	go func() {
+		if _, err := future.ResultError(); err != nil {
+			log.Printf("Error: Unable to checkpoint emigration to %v for version %v: %v", to, version, err)
+		}
+	}()
+}
+
+// deleteEmigrationCursors deletes the cursors of every topology
+// change up to and including version, which have completed.
+func (tt *TopologyTransmogrifier) deleteEmigrationCursors(version uint32) {
+	future := tt.db.ReadWriteTransaction(false, func(rwtxn db.ReadWriteTxn) interface{} {
+		keys := [][]byte{}
+		rwtxn.Iterate(tt.db.EmigrationCursors, func(key, value []byte) bool {
+			if binary.BigEndian.Uint32(key) <= version {
+				keys = append(keys, key)
+				return true
+			}
+			return false
+		})
+		for _, key := range keys {
+			rwtxn.Del(tt.db.EmigrationCursors, key)
+		}
+		return true
+	})
+	go func() {
+		if _, err := future.ResultError(); err != nil {
+			log.Printf("Error: Unable to delete emigration cursors for version %v: %v", version, err)
+		}
+	}()
+}
+
+// TopologyProgress reports on the topology change in progress, if
+// any.
+func (tt *TopologyTransmogrifier) TopologyProgress() (*TopologyProgress, error) {
+	var progress *TopologyProgress
+	var err error
+	resultChan := make(chan struct{})
+	enqueued := tt.enqueueQuery(topologyTransmogrifierMsgExe(func() error {
+		defer close(resultChan)
+		if tt.active == nil {
+			err = errors.New("No topology installed yet.")
+			return nil
+		}
+		progress = tt.topologyProgress()
+		return nil
+	}))
+	if !enqueued {
+		return nil, errors.New("Shutting down.")
+	}
+	select {
+	case <-resultChan:
+		return progress, err
+	case <-tt.cellTail.Terminated:
+		return nil, errors.New("Shutting down.")
+	}
+}
+
+func (tt *TopologyTransmogrifier) topologyProgress() *TopologyProgress {
+	progress := &TopologyProgress{
+		ActiveVersion: tt.active.Version,
+		Task:          topologyTaskName(tt.task),
+		Barrier:       currentBarrier(tt.active),
+	}
+	if bw := tt.barrierWatch; bw != nil {
+		progress.Diagnosis = bw.diagnosis
+	}
+	next := tt.active.Next()
+	if next == nil {
+		return progress
+	}
+	progress.NextVersion = next.Version
+	progress.NewRMIds = next.NewRMIds
+	progress.SurvivingRMIds = next.SurvivingRMIds
+	progress.LostRMIds = next.LostRMIds
+	progress.BarrierReached1 = next.BarrierReached1
+	progress.BarrierReached2 = next.BarrierReached2
+	progress.Pending = make(map[string]common.RMIds, len(next.Pending))
+	for rmId, condSup := range next.Pending {
+		progress.Pending[rmId.String()] = append(common.RMIds{}, condSup.Suppliers...)
+	}
+	if report, found := tt.migrationReports[next.Version]; found {
+		reportCopy := *report
+		reportCopy.Pairs = make([]*MigrationPairReport, len(report.Pairs))
+		for idx, pair := range report.Pairs {
+			pairCopy := *pair
+			reportCopy.Pairs[idx] = &pairCopy
+		}
+		progress.Migration = &reportCopy
+	}
+	if task, ok := tt.task.(*migrate); ok && task.emigrator != nil {
+		progress.Emigration = task.emigrator.progress.snapshot()
+	}
+	return progress
+}
+
+func topologyTaskName(task topologyTask) string {
+	switch task.(type) {
+	case nil:
+		return ""
+	case *ensureLocalTopology:
+		return "EnsureLocalTopology"
+	case *joinCluster:
+		return "JoinCluster"
+	case *installTargetOld:
+		return "InstallTargetOld"
+	case *installTargetNew:
+		return "InstallTargetNew"
+	case *awaitBarrier1:
+		return "AwaitBarrier1"
+	case *awaitBarrier2:
+		return "AwaitBarrier2"
+	case *migrate:
+		return "Migrate"
+	case *installCompletion:
+		return "InstallCompletion"
+	default:
+		return "Unknown"
+	}
+}
+
+// checkpoint waits until the receiver has acknowledged every batch
+// sent, and then records the cursor. Only receivers which ack
+// batches can be checkpointed.
+func (sb *sendBatch) checkpoint() {
+	for len(sb.window.slots) != 0 {
+		select {
+		case <-sb.window.acked:
+		case <-sb.window.abandoned:
+			sb.abandon()
+			return
+		}
+	}
+	to := sb.conn.RMId()
+	sb.emigrator.writeEmigrationCursor(sb.version, to, sb.cursor)
+	sb.emigrator.progress.checkpointed(to, sb.cursor)
+	debugLog.Log("Topology: Checkpointed emigration to", to, "at", sb.cursor)
+}
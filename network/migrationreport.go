@@ -0,0 +1,154 @@
+package network
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/db"
+	"log"
+	"time"
+)
+
+func init() {
+	db.DB.MigrationReports = db.DeclareTable("MigrationReports")
+}
+
+// MigrationReport records what this RM did during the migration of
+// one topology change. It is built up by the TopologyTransmogrifier
+// as migrations are sent and received, and is written to disk and
+// logged once the topology change has completed.
+type MigrationReport struct {
+	Version   uint32
+	RMId      common.RMId
+	Started   time.Time
+	Completed time.Time
+	Duration  time.Duration
+	// Retries counts how many times recording our migration progress
+	// in the topology had to be resubmitted.
+	Retries uint32
+	Pairs   []*MigrationPairReport
+}
+
+// MigrationPairReport counts what was moved from one RM to
+// another. We only ever know about pairs in which we are either the
+// sender or the receiver. Verified is only set by the receiver, once
+// the sender has declared it is finished and every txn received has
+// been locally completed (and thus is on disk).
+type MigrationPairReport struct {
+	From     common.RMId
+	To       common.RMId
+	Batches  uint32
+	Txns     uint64
+	Vars     uint64
+	Bytes    uint64
+	Verified bool
+}
+
+func newMigrationReport(version uint32, rmId common.RMId) *MigrationReport {
+	return &MigrationReport{
+		Version: version,
+		RMId:    rmId,
+		Started: time.Now(),
+	}
+}
+
+func (mr *MigrationReport) pair(from, to common.RMId) *MigrationPairReport {
+	for _, pair := range mr.Pairs {
+		if pair.From == from && pair.To == to {
+			return pair
+		}
+	}
+	pair := &MigrationPairReport{From: from, To: to}
+	mr.Pairs = append(mr.Pairs, pair)
+	return pair
+}
+
+func (mr *MigrationReport) complete() {
+	mr.Completed = time.Now()
+	mr.Duration = mr.Completed.Sub(mr.Started)
+}
+
+func (mr *MigrationReport) String() string {
+	return fmt.Sprintf("MigrationReport{Version: %v, RMId: %v, Duration: %v, Retries: %v, Pairs: %v}",
+		mr.Version, mr.RMId, mr.Duration, mr.Retries, mr.Pairs)
+}
+
+func (mpr *MigrationPairReport) add(txns, vars, bytes uint64) {
+	mpr.Batches++
+	mpr.Txns += txns
+	mpr.Vars += vars
+	mpr.Bytes += bytes
+}
+
+func (mpr *MigrationPairReport) String() string {
+	return fmt.Sprintf("%v->%v: %v batches, %v txns, %v vars, %v bytes, verified: %v",
+		mpr.From, mpr.To, mpr.Batches, mpr.Txns, mpr.Vars, mpr.Bytes, mpr.Verified)
+}
+
+func (tt *TopologyTransmogrifier) migrationReport(version uint32) *MigrationReport {
+	report, found := tt.migrationReports[version]
+	if !found {
+		report = newMigrationReport(version, tt.connectionManager.RMId)
+		tt.migrationReports[version] = report
+	}
+	return report
+}
+
+func (tt *TopologyTransmogrifier) completeMigrationReports(version uint32) {
+	for v, report := range tt.migrationReports {
+		if v > version {
+			continue
+		}
+		delete(tt.migrationReports, v)
+		report.complete()
+		log.Printf("Topology: %v", report)
+		tt.writeMigrationReport(report)
+	}
+	tt.deleteEmigrationCursors(version)
+}
+
+func (tt *TopologyTransmogrifier) writeMigrationReport(report *MigrationReport) {
+	value, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("Error: Unable to encode migration report for version %v: %v", report.Version, err)
+		return
+	}
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, report.Version)
+	future := tt.db.ReadWriteTransaction(false, func(rwtxn db.ReadWriteTxn) interface{} {
+		db.Stats.MigrationReports.Wrote(value)
+		rwtxn.Put(tt.db.MigrationReports, key, value)
+		return true
+	})
+	go func() {
+		if _, err := future.ResultError(); err != nil {
+			log.Printf("Error: Unable to write migration report for version %v: %v", report.Version, err)
+		}
+	}()
+}
+
+// ReadMigrationReports loads every migration report from disk, in
+// ascending order of topology version.
+func ReadMigrationReports(dbs *db.Databases) ([]*MigrationReport, error) {
+	res, err := dbs.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
+		reports := []*MigrationReport{}
+		rtxn.Iterate(dbs.MigrationReports, func(key, value []byte) bool {
+			db.Stats.MigrationReports.Read(value, nil)
+			report := &MigrationReport{}
+			if err := json.Unmarshal(value, report); err != nil {
+				rtxn.Error(err)
+				return false
+			}
+			reports = append(reports, report)
+			return true
+		})
+		return reports
+	}).ResultError()
+	if err != nil {
+		return nil, err
+	} else if res == nil {
+		return nil, nil
+	}
+	return res.([]*MigrationReport), nil
+}
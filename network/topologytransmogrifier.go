@@ -6,8 +6,6 @@ import (
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
 	cc "github.com/msackman/chancell"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	cmsgs "goshawkdb.io/common/capnp"
 	"goshawkdb.io/server"
@@ -19,6 +17,7 @@ import (
 	eng "goshawkdb.io/server/txnengine"
 	"log"
 	"math/rand"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -32,6 +31,8 @@ type TopologyTransmogrifier struct {
 	hostToConnection     map[string]paxos.Connection
 	activeConnections    map[common.RMId]paxos.Connection
 	migrations           map[uint32]map[common.RMId]*int32
+	migrationReports     map[uint32]*MigrationReport
+	barrierWatch         *barrierWatch
 	task                 topologyTask
 	cellTail             *cc.ChanCellTail
 	enqueueQueryInner    func(topologyTransmogrifierMsg, *cc.ChanCell, cc.CurCellConsumer) (bool, cc.CurCellConsumer)
@@ -40,6 +41,9 @@ type TopologyTransmogrifier struct {
 	rng                  *rand.Rand
 	shutdownSignaller    ShutdownSignaller
 	localEstablished     chan struct{}
+	logShipperLock       sync.Mutex
+	logShipper           *LogShipper
+	emigrationThrottle   emigrationThrottle
 }
 
 type topologyTransmogrifierMsg interface {
@@ -111,6 +115,19 @@ func (tt *TopologyTransmogrifier) MigrationCompleteReceived(sender common.RMId,
 	})
 }
 
+type topologyTransmogrifierMsgMigrationBatchAck struct {
+	topologyTransmogrifierMsgBasic
+	ack    *msgs.MigrationBatchAck
+	sender common.RMId
+}
+
+func (tt *TopologyTransmogrifier) MigrationBatchAckReceived(sender common.RMId, migrationBatchAck *msgs.MigrationBatchAck) {
+	tt.enqueueQuery(topologyTransmogrifierMsgMigrationBatchAck{
+		ack:    migrationBatchAck,
+		sender: sender,
+	})
+}
+
 func (tt *TopologyTransmogrifier) enqueueQuery(msg topologyTransmogrifierMsg) bool {
 	var f cc.CurCellConsumer
 	f = func(cell *cc.ChanCell) (bool, cc.CurCellConsumer) {
@@ -125,6 +142,7 @@ func NewTopologyTransmogrifier(db *db.Databases, cm *ConnectionManager, lc *clie
 		connectionManager: cm,
 		localConnection:   lc,
 		migrations:        make(map[uint32]map[common.RMId]*int32),
+		migrationReports:  make(map[uint32]*MigrationReport),
 		listenPort:        listenPort,
 		rng:               rand.New(rand.NewSource(time.Now().UnixNano())),
 		shutdownSignaller: ss,
@@ -226,15 +244,17 @@ func (tt *TopologyTransmogrifier) actorLoop(head *cc.ChanCellHead) {
 					msgT.done()
 				}
 			case topologyTransmogrifierMsgTopologyObserved:
-				server.Log("Topology: New topology observed:", msgT.topology)
+				debugLog.Log("Topology: New topology observed:", msgT.topology)
 				err = tt.setActive(msgT.topology)
 			case topologyTransmogrifierMsgRequestConfigChange:
-				server.Log("Topology: Topology change request:", msgT.config)
+				debugLog.Log("Topology: Topology change request:", msgT.config)
 				tt.selectGoal(&configuration.NextConfiguration{Configuration: msgT.config})
 			case topologyTransmogrifierMsgMigration:
 				err = tt.migrationReceived(msgT)
 			case topologyTransmogrifierMsgMigrationComplete:
 				err = tt.migrationCompleteReceived(msgT)
+			case topologyTransmogrifierMsgMigrationBatchAck:
+				tt.migrationBatchAckReceived(msgT)
 			case topologyTransmogrifierMsgExe:
 				err = msgT()
 			default:
@@ -272,7 +292,7 @@ func (tt *TopologyTransmogrifier) activeConnectionsChange(conns map[common.RMId]
 }
 
 func (tt *TopologyTransmogrifier) setActive(topology *configuration.Topology) error {
-	server.Log("Topology: setActive:", topology)
+	debugLog.Log("Topology: setActive:", topology)
 	if tt.active != nil {
 		switch {
 		case tt.active.ClusterId != topology.ClusterId && tt.active.ClusterId != "":
@@ -293,7 +313,12 @@ func (tt *TopologyTransmogrifier) setActive(topology *configuration.Topology) er
 	if _, found := topology.RMsRemoved()[tt.connectionManager.RMId]; found {
 		return errors.New("We have been removed from the cluster. Shutting down.")
 	}
+	if tt.active != nil && tt.active.Version != topology.Version {
+		tt.db.Audit.Record("topology", "Topology version %v replaced by version %v (transition pending? %v)",
+			tt.active.Version, topology.Version, topology.Next() != nil)
+	}
 	tt.active = topology
+	tt.watchBarrier()
 
 	if tt.task != nil {
 		if err := tt.task.tick(); err != nil {
@@ -309,16 +334,16 @@ func (tt *TopologyTransmogrifier) setActive(topology *configuration.Topology) er
 				return err
 			}
 			log.Printf(">==> We are %v (%v) <==<\n", localHost, tt.connectionManager.RMId)
+			go server.LifecyclePhaseReached(server.PostTopologyLoad)
 
-			future := tt.db.WithEnv(func(env *mdb.Env) (interface{}, error) {
-				return nil, env.SetFlags(mdb.NOSYNC, topology.NoSync)
-			})
+			future := tt.db.SetNoSync(topology.NoSync)
 			tt.connectionManager.SetDesiredServers(localHost, remoteHosts)
 			for version := range tt.migrations {
 				if version <= topology.Version {
 					delete(tt.migrations, version)
 				}
 			}
+			tt.completeMigrationReports(topology.Version)
 
 			_, err = future.ResultError()
 			if err != nil {
@@ -333,7 +358,7 @@ func (tt *TopologyTransmogrifier) setActive(topology *configuration.Topology) er
 }
 
 func (tt *TopologyTransmogrifier) installTopology(topology *configuration.Topology, callbacks map[eng.TopologyChangeSubscriberType]func() error) {
-	server.Log("Topology: Installing topology to connection manager, et al:", topology)
+	debugLog.Log("Topology: Installing topology to connection manager, et al:", topology)
 	if tt.localEstablished != nil {
 		if callbacks == nil {
 			callbacks = make(map[eng.TopologyChangeSubscriberType]func() error)
@@ -413,14 +438,14 @@ func (tt *TopologyTransmogrifier) selectGoal(goal *configuration.NextConfigurati
 			return // goal already in progress
 
 		default:
-			server.Log("Topology: Abandoning old task")
+			debugLog.Log("Topology: Abandoning old task")
 			tt.task.abandon()
 			tt.task = nil
 		}
 	}
 
 	if tt.task == nil {
-		server.Log("Topology: Creating new task")
+		debugLog.Log("Topology: Creating new task")
 		tt.task = &targetConfig{
 			TopologyTransmogrifier: tt,
 			config:                 goal,
@@ -474,8 +499,16 @@ func (tt *TopologyTransmogrifier) migrationReceived(migration topologyTransmogri
 		inprogressPtr = &inprogress
 		senders[sender] = inprogressPtr
 	}
-	txnCount := int32(migration.migration.Elems().Len())
-	lsc := tt.newTxnLSC(txnCount, inprogressPtr)
+	elems := migration.migration.Elems()
+	txnCount := int32(elems.Len())
+	varCount, byteCount := uint64(0), uint64(0)
+	for idx, l := 0, elems.Len(); idx < l; idx++ {
+		elem := elems.At(idx)
+		varCount += uint64(elem.Vars().Len())
+		byteCount += uint64(len(elem.Txn()))
+	}
+	tt.migrationReport(version).pair(sender, tt.connectionManager.RMId).add(uint64(txnCount), varCount, byteCount)
+	lsc := tt.newTxnLSC(txnCount, inprogressPtr, sender, version)
 	tt.connectionManager.Dispatchers.ProposerDispatcher.ImmigrationReceived(migration.migration, lsc)
 	return nil
 }
@@ -483,7 +516,7 @@ func (tt *TopologyTransmogrifier) migrationReceived(migration topologyTransmogri
 func (tt *TopologyTransmogrifier) migrationCompleteReceived(migrationComplete topologyTransmogrifierMsgMigrationComplete) error {
 	version := migrationComplete.complete.Version()
 	sender := migrationComplete.sender
-	server.Log("Topology: MCR from", sender, "v", version)
+	debugLog.Log("Topology: MCR from", sender, "v", version)
 	senders, found := tt.migrations[version]
 	if !found {
 		if version > tt.active.Version {
@@ -507,11 +540,19 @@ func (tt *TopologyTransmogrifier) migrationCompleteReceived(migrationComplete to
 	return nil
 }
 
-func (tt *TopologyTransmogrifier) newTxnLSC(txnCount int32, inprogressPtr *int32) eng.TxnLocalStateChange {
+func (tt *TopologyTransmogrifier) migrationBatchAckReceived(migrationBatchAck topologyTransmogrifierMsgMigrationBatchAck) {
+	if task, ok := tt.task.(*migrate); ok && task.emigrator != nil {
+		task.emigrator.batchAcked(migrationBatchAck.sender, migrationBatchAck.ack.Version())
+	}
+}
+
+func (tt *TopologyTransmogrifier) newTxnLSC(txnCount int32, inprogressPtr *int32, sender common.RMId, version uint32) eng.TxnLocalStateChange {
 	return &migrationTxnLocalStateChange{
 		TopologyTransmogrifier: tt,
 		pendingLocallyComplete: txnCount,
 		inprogressPtr:          inprogressPtr,
+		sender:                 sender,
+		version:                version,
 	}
 }
 
@@ -519,6 +560,8 @@ type migrationTxnLocalStateChange struct {
 	*TopologyTransmogrifier
 	pendingLocallyComplete int32
 	inprogressPtr          *int32
+	sender                 common.RMId
+	version                uint32
 }
 
 func (mtlsc *migrationTxnLocalStateChange) TxnBallotsComplete(...*eng.Ballot) {
@@ -528,15 +571,27 @@ func (mtlsc *migrationTxnLocalStateChange) TxnBallotsComplete(...*eng.Ballot) {
 // Careful: we're in the proposer dispatcher go routine here!
 func (mtlsc *migrationTxnLocalStateChange) TxnLocallyComplete(txn *eng.Txn) {
 	txn.CompletionReceived()
-	if atomic.AddInt32(&mtlsc.pendingLocallyComplete, -1) == 0 &&
-		atomic.AddInt32(mtlsc.inprogressPtr, -1) == 0 {
-		mtlsc.enqueueQuery(topologyTransmogrifierMsgExe(func() error {
-			if mtlsc.task != nil {
-				return mtlsc.task.tick()
-			}
-			return nil
-		}))
+	if atomic.AddInt32(&mtlsc.pendingLocallyComplete, -1) != 0 {
+		return
 	}
+	// The whole batch is now on disk, so the sender can have its
+	// window slot back.
+	inprogress := atomic.AddInt32(mtlsc.inprogressPtr, -1)
+	mtlsc.enqueueQuery(topologyTransmogrifierMsgExe(func() error {
+		// RMs which don't ack batches wouldn't understand the ack.
+		if conn, found := mtlsc.activeConnections[mtlsc.sender]; found && peerHasCapability(conn, serverCapabilityMigrationBatchAck) {
+			seg := capn.NewBuffer(nil)
+			msg := msgs.NewRootMessage(seg)
+			ack := msgs.NewMigrationBatchAck(seg)
+			ack.SetVersion(mtlsc.version)
+			msg.SetMigrationBatchAck(ack)
+			conn.Send(server.SegToBytes(seg))
+		}
+		if inprogress == 0 && mtlsc.task != nil {
+			return mtlsc.task.tick()
+		}
+		return nil
+	}))
 }
 
 func (mtlsc *migrationTxnLocalStateChange) TxnFinished(*eng.Txn) {}
@@ -924,14 +979,14 @@ func (task *installTargetOld) tick() error {
 	}
 
 	targetTopology.SetClusterUUId(task.active.ClusterUUId())
-	server.Log("Set cluster uuid", targetTopology.ClusterUUId())
+	debugLog.Log("Set cluster uuid", targetTopology.ClusterUUId())
 
 	_, resubmit, err := task.rewriteTopology(task.active, targetTopology, active, passive)
 	if err != nil {
 		return task.fatal(err)
 	}
 	if resubmit {
-		server.Log("Topology: Installing to old requires resubmit.")
+		debugLog.Log("Topology: Installing to old requires resubmit.")
 		task.createOrAdvanceBackoff()
 		task.enqueueTick(task, task.targetConfig)
 		return nil
@@ -1229,7 +1284,7 @@ func (task *installTargetNew) tick() error {
 		return task.fatal(err)
 	}
 	if resubmit {
-		server.Log("Topology: Installing to new requires resubmit.")
+		debugLog.Log("Topology: Installing to new requires resubmit.")
 		task.createOrAdvanceBackoff()
 		task.enqueueTick(task, task.targetConfig)
 	}
@@ -1296,7 +1351,7 @@ func (task *awaitBarrier1) tick() error {
 			return task.fatal(err)
 		}
 		if resubmit {
-			server.Log("Topology: Barrier1 reached. Requires resubmit.")
+			debugLog.Log("Topology: Barrier1 reached. Requires resubmit.")
 			task.createOrAdvanceBackoff()
 			task.enqueueTick(task, task.targetConfig)
 		}
@@ -1406,7 +1461,7 @@ func (task *awaitBarrier2) tick() error {
 			return task.fatal(err)
 		}
 		if resubmit {
-			server.Log("Topology: Barrier2 reached. Requires resubmit.")
+			debugLog.Log("Topology: Barrier2 reached. Requires resubmit.")
 			task.createOrAdvanceBackoff()
 			task.enqueueTick(task, task.targetConfig)
 		}
@@ -1480,10 +1535,12 @@ func (task *migrate) tick() error {
 	}
 	topology := task.active.Clone()
 	next = topology.Next()
+	report := task.migrationReport(next.Version)
 	changed := false
 	for sender, inprogressPtr := range senders {
 		if atomic.LoadInt32(inprogressPtr) == 0 {
 			// Because we wait for locallyComplete, we know they've gone to disk.
+			report.pair(sender, task.connectionManager.RMId).Verified = true
 			changed = next.Pending.SuppliedBy(task.connectionManager.RMId, sender, maxSuppliers) || changed
 		}
 	}
@@ -1509,6 +1566,7 @@ func (task *migrate) tick() error {
 		return task.fatal(err)
 	}
 	if resubmit {
+		report.Retries++
 		task.createOrAdvanceBackoff()
 		task.enqueueTick(task, task.targetConfig)
 		return nil
@@ -1658,7 +1716,9 @@ func (task *targetConfig) createTopologyTransaction(read, write *configuration.T
 		}
 		rw.SetReferences(refs)
 	}
-	txn.SetActions(server.SegToBytes(actionsSeg))
+	actionsBytes := server.SegToBytes(actionsSeg)
+	txn.SetActions(actionsBytes)
+	txn.SetActionsChecksum(eng.ActionsChecksum(&actions))
 
 	allocs := msgs.NewAllocationList(seg, len(active)+len(passive))
 	txn.SetAllocations(allocs)
@@ -1776,11 +1836,11 @@ func (task *targetConfig) rewriteTopology(read, write *configuration.Topology, a
 	if result.Which() == msgs.OUTCOME_COMMIT {
 		topology := write.Clone()
 		topology.DBVersion = txnId
-		server.Log("Topology Txn Committed ok with txnId", topology.DBVersion)
+		debugLog.Log("Topology Txn Committed ok with txnId", topology.DBVersion)
 		return topology, false, nil
 	}
 	abort := result.Abort()
-	server.Log("Topology Txn Aborted", txnId)
+	debugLog.Log("Topology Txn Aborted", txnId)
 	if abort.Which() == msgs.OUTCOMEABORT_RESUBMIT {
 		return nil, true, nil
 	}
@@ -1819,7 +1879,7 @@ func (task *targetConfig) rewriteTopology(read, write *configuration.Topology, a
 }
 
 func (task *targetConfig) attemptCreateRoots(rootCount int) (bool, configuration.Roots, error) {
-	server.Log("Topology: Creating Roots.")
+	debugLog.Log("Topology: Creating Roots.")
 
 	seg := capn.NewBuffer(nil)
 	ctxn := cmsgs.NewClientTxn(seg)
@@ -1839,7 +1899,7 @@ func (task *targetConfig) attemptCreateRoots(rootCount int) (bool, configuration
 	}
 	ctxn.SetActions(actions)
 	txnReader, result, err := task.localConnection.RunClientTransaction(&ctxn, nil, nil)
-	server.Log("Create root result", result, err)
+	debugLog.Log("Create root result", result, err)
 	if err != nil {
 		return false, nil, err
 	}
@@ -1861,7 +1921,7 @@ func (task *targetConfig) attemptCreateRoots(rootCount int) (bool, configuration
 			positions := action.Create().Positions()
 			root.Positions = (*common.Positions)(&positions)
 		}
-		server.Log("Topology: Roots created in", roots)
+		debugLog.Log("Topology: Roots created in", roots)
 		return false, roots, nil
 	}
 	if result.Abort().Which() == msgs.OUTCOMEABORT_RESUBMIT {
@@ -1879,6 +1939,23 @@ type emigrator struct {
 	activeBatches     map[common.RMId]*sendBatch
 	topology          *configuration.Topology
 	conns             map[common.RMId]paxos.Connection
+	windowsLock       sync.Mutex
+	windows           map[common.RMId]*migrationWindow
+	progress          *emigrationProgress
+}
+
+// A migrationWindow limits how many batches we can have sent to an
+// RM which it has not yet acknowledged as being on disk. This means
+// a slow receiver throttles us rather than us buffering without
+// bound. slots holds a token per unacknowledged batch; how many are
+// allowed is set by the EmigrationLimits. acked is signalled
+// whenever a batch is acknowledged. abandoned is closed when the
+// batch using the window must give up: see sendBatch.abandon.
+type migrationWindow struct {
+	version   uint32
+	slots     chan server.EmptyStruct
+	acked     chan server.EmptyStruct
+	abandoned chan server.EmptyStruct
 }
 
 func newEmigrator(task *migrate) *emigrator {
@@ -1886,6 +1963,8 @@ func newEmigrator(task *migrate) *emigrator {
 		db:                task.db,
 		connectionManager: task.connectionManager,
 		activeBatches:     make(map[common.RMId]*sendBatch),
+		windows:           make(map[common.RMId]*migrationWindow),
+		progress:          newEmigrationProgress(),
 	}
 	e.topology = e.connectionManager.AddTopologySubscriber(eng.EmigratorSubscriber, e)
 	e.connectionManager.AddServerConnectionSubscriber(e)
@@ -1896,6 +1975,12 @@ func (e *emigrator) stopAsync() {
 	atomic.StoreInt32(&e.stop, 1)
 	e.connectionManager.RemoveServerConnectionSubscriber(e)
 	e.connectionManager.RemoveTopologySubscriberAsync(eng.EmigratorSubscriber, e)
+	e.windowsLock.Lock()
+	for rmId, window := range e.windows {
+		close(window.abandoned)
+		delete(e.windows, rmId)
+	}
+	e.windowsLock.Unlock()
 }
 
 func (e *emigrator) TopologyChanged(topology *configuration.Topology, done func(bool)) {
@@ -1911,6 +1996,48 @@ func (e *emigrator) ConnectedRMs(conns map[common.RMId]paxos.Connection) {
 
 func (e *emigrator) ConnectionLost(rmId common.RMId, conns map[common.RMId]paxos.Connection) {
 	delete(e.activeBatches, rmId)
+	e.windowsLock.Lock()
+	if window, found := e.windows[rmId]; found {
+		close(window.abandoned)
+		delete(e.windows, rmId)
+	}
+	e.windowsLock.Unlock()
+}
+
+// newWindow creates the window for a new batch to rmId. Should an
+// older batch to rmId still be running, its window is abandoned: the
+// older batch fails, and the new batch sends everything after the
+// last checkpoint again, including whatever the older batch had not
+// had acked.
+func (e *emigrator) newWindow(rmId common.RMId, version uint32) *migrationWindow {
+	window := &migrationWindow{
+		version:   version,
+		slots:     make(chan server.EmptyStruct, server.MigrationBatchWindowMax),
+		acked:     make(chan server.EmptyStruct, 1),
+		abandoned: make(chan server.EmptyStruct),
+	}
+	e.windowsLock.Lock()
+	if old, found := e.windows[rmId]; found {
+		close(old.abandoned)
+	}
+	e.windows[rmId] = window
+	e.windowsLock.Unlock()
+	return window
+}
+
+func (e *emigrator) batchAcked(rmId common.RMId, version uint32) {
+	e.windowsLock.Lock()
+	defer e.windowsLock.Unlock()
+	if window, found := e.windows[rmId]; found && window.version == version {
+		select {
+		case <-window.slots:
+		default:
+		}
+		select {
+		case window.acked <- server.EmptyStructVal:
+		default:
+		}
+	}
 }
 
 func (e *emigrator) ConnectionEstablished(rmId common.RMId, conn paxos.Connection, conns map[common.RMId]paxos.Connection, done func()) {
@@ -1957,73 +2084,183 @@ type dbIterator struct {
 	*emigrator
 	topology *configuration.Topology
 	batch    []*sendBatch
+	// next is the key the next round of the scan starts from, or nil
+	// to start from the first var.
+	next []byte
+}
+
+// iterate scans the vars, sending those each batch's RM needs. The
+// scan for each batch resumes after the cursor last checkpointed for
+// its RM, if there is one: everything up to the cursor is already on
+// disk there, and anything written since was written to the RM
+// directly, as its barrier has been reached.
+//
+// The scan is done in rounds, each in its own read txn. A round
+// stops as soon as a batch is full, and the full batches are then
+// sent once the read txn has finished: sending may wait a long time
+// for the receiver's window and the throttle, and we mustn't hold a
+// snapshot of the store open while it does. The next round resumes
+// from the var the previous one stopped at, which is safe for the
+// same reason resuming from a checkpoint is.
+func (it *dbIterator) iterate() {
+	for round := 0; ; round++ {
+		if atomic.LoadInt32(&it.stop) != 0 || it.abandoned() {
+			return
+		}
+		result, err := it.db.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
+			if round == 0 && !it.resume(rtxn) {
+				return nil
+			}
+			return it.scanRound(rtxn)
+		}).ResultError()
+		if err != nil {
+			panic(fmt.Sprintf("Topology iterator error: %v", err))
+		} else if result == nil {
+			return
+		}
+		finished := result.(bool)
+		for _, sb := range it.batch {
+			if finished || sb.full() {
+				sb.flush()
+			}
+		}
+		if finished {
+			break
+		}
+	}
+	it.connectionManager.AddServerConnectionSubscriber(it)
 }
 
-func (it *dbIterator) iterate() {
-	ran, err := it.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		result, _ := rtxn.WithCursor(it.db.Vars, func(cursor *mdbs.Cursor) interface{} {
-			vUUIdBytes, varBytes, err := cursor.Get(nil, nil, mdb.FIRST)
-			for ; err == nil; vUUIdBytes, varBytes, err = cursor.Get(nil, nil, mdb.NEXT) {
-				seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
-				if err != nil {
-					cursor.Error(err)
-					return true
-				}
-				varCap := msgs.ReadRootVar(seg)
-				if bytes.Equal(varCap.Id(), configuration.TopologyVarUUId[:]) {
-					continue
-				}
-				txnId := common.MakeTxnId(varCap.WriteTxnId())
-				txnBytes := it.db.ReadTxnBytesFromDisk(cursor.RTxn, txnId)
-				if txnBytes == nil {
-					return true
-				}
-				txn := eng.TxnReaderFromData(txnBytes)
-				// So, we only need to send based on the vars that we have
-				// (in fact, we require the positions so we can only look
-				// at the vars we have). However, the txn var allocations
-				// only cover what's assigned to us at the time of txn
-				// creation and that can change and we don't rewrite the
-				// txn when it changes. So that all just means we must
-				// ignore the allocations here, and just work through the
-				// actions directly.
-				actions := txn.Actions(true).Actions()
-				varCaps, err := it.filterVars(cursor, vUUIdBytes, txnId[:], actions)
-				if err != nil {
-					return true
-				} else if len(varCaps) == 0 {
-					continue
-				}
-				for _, sb := range it.batch {
-					matchingVarCaps, err := it.matchVarsAgainstCond(sb.cond, varCaps)
-					if err != nil {
-						cursor.Error(err)
-						return true
-					} else if len(matchingVarCaps) != 0 {
-						sb.add(txn, matchingVarCaps)
+// resume sets each batch to resume after the cursor last
+// checkpointed for its RM, and reports how many vars are left to
+// scan for each.
+func (it *dbIterator) resume(rtxn db.ReadTxn) bool {
+	for idx, sb := range it.batch {
+		cursor, err := rtxn.Get(it.db.EmigrationCursors, emigrationCursorKey(sb.version, sb.conn.RMId()))
+		if err == nil {
+			sb.resumeAfter = cursor
+			debugLog.Log("Topology: Resuming emigration to", sb.conn.RMId(), "after", cursor)
+		} else if err != db.NotFound {
+			rtxn.Error(err)
+			return false
+		}
+		if idx == 0 || bytes.Compare(cursor, it.next) < 0 {
+			it.next = cursor
+		}
+	}
+	totals := make([]uint64, len(it.batch))
+	it.scan(rtxn, func(vUUIdBytes, varBytes []byte) bool {
+		for idx, sb := range it.batch {
+			if sb.resumeAfter == nil || bytes.Compare(vUUIdBytes, sb.resumeAfter) > 0 {
+				totals[idx]++
+			}
+		}
+		return true
+	})
+	for idx, sb := range it.batch {
+		it.progress.started(sb.conn.RMId(), sb.resumeAfter, totals[idx])
+	}
+	return true
+}
+
+// scan iterates the vars from it.next. IterateFrom can't start from
+// an empty key, so a scan from the first var must use Iterate.
+func (it *dbIterator) scan(rtxn db.ReadTxn, fun func(key, value []byte) bool) {
+	if it.next == nil {
+		rtxn.Iterate(it.db.Vars, fun)
+	} else {
+		rtxn.IterateFrom(it.db.Vars, it.next, fun)
+	}
+}
+
+// scanRound adds vars to the batches until a batch is full, or the
+// vars run out, in which case it returns true.
+func (it *dbIterator) scanRound(rtxn db.ReadTxn) bool {
+	finished := true
+	var last []byte
+	live := make([]*sendBatch, 0, len(it.batch))
+	it.scan(rtxn, func(vUUIdBytes, varBytes []byte) bool {
+		for _, sb := range it.batch {
+			if sb.full() {
+				// Every batch has now dealt with every var up to last.
+				finished = false
+				it.next = vUUIdBytes
+				for _, b := range it.batch {
+					if b.resumeAfter == nil || bytes.Compare(b.resumeAfter, last) < 0 {
+						b.resumeAfter = last
 					}
 				}
+				return false
 			}
-			if err == mdb.NotFound {
-				return true
-			} else {
-				cursor.Error(err)
-				return true
-			}
-		})
-		return result
-	}).ResultError()
-	if err != nil {
-		panic(fmt.Sprintf("Topology iterator error: %v", err))
-	} else if ran != nil {
+		}
+		last = vUUIdBytes
+		live = live[:0]
 		for _, sb := range it.batch {
-			sb.flush()
+			if sb.resumeAfter == nil || bytes.Compare(vUUIdBytes, sb.resumeAfter) > 0 {
+				live = append(live, sb)
+				it.progress.scanned(sb.conn.RMId())
+			}
+		}
+		if len(live) == 0 {
+			return true
+		}
+		db.Stats.Vars.Read(varBytes, nil)
+		seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
+		if err != nil {
+			rtxn.Error(err)
+			return false
+		}
+		varCap := msgs.ReadRootVar(seg)
+		if bytes.Equal(varCap.Id(), configuration.TopologyVarUUId[:]) {
+			return true
+		}
+		txnId := common.MakeTxnId(varCap.WriteTxnId())
+		txnBytes := it.db.ReadTxnBytesFromDisk(rtxn, txnId)
+		if txnBytes == nil {
+			return false
+		}
+		txn := eng.TxnReaderFromData(txnBytes)
+		// So, we only need to send based on the vars that we have
+		// (in fact, we require the positions so we can only look at
+		// the vars we have). However, the txn var allocations only
+		// cover what's assigned to us at the time of txn creation and
+		// that can change and we don't rewrite the txn when it
+		// changes. So that all just means we must ignore the
+		// allocations here, and just work through the actions
+		// directly.
+		actions := txn.Actions(true).Actions()
+		varCaps, err := it.filterVars(rtxn, vUUIdBytes, txnId[:], actions)
+		if err != nil {
+			return false
+		} else if len(varCaps) == 0 {
+			return true
+		}
+		for _, sb := range live {
+			matchingVarCaps, err := it.matchVarsAgainstCond(sb.cond, varCaps)
+			if err != nil {
+				rtxn.Error(err)
+				return false
+			} else if len(matchingVarCaps) != 0 {
+				sb.add(txn, matchingVarCaps, vUUIdBytes)
+			}
+		}
+		return true
+	})
+	return finished
+}
+
+// abandoned returns whether every batch has been abandoned, in which
+// case there's no point scanning further.
+func (it *dbIterator) abandoned() bool {
+	for _, sb := range it.batch {
+		if !sb.abandoned {
+			return false
 		}
-		it.connectionManager.AddServerConnectionSubscriber(it)
 	}
+	return true
 }
 
-func (it *dbIterator) filterVars(cursor *mdbs.Cursor, vUUIdBytes []byte, txnIdBytes []byte, actions *msgs.Action_List) ([]*msgs.Var, error) {
+func (it *dbIterator) filterVars(rtxn db.ReadTxn, vUUIdBytes []byte, txnIdBytes []byte, actions *msgs.Action_List) ([]*msgs.Var, error) {
 	varCaps := make([]*msgs.Var, 0, actions.Len()>>1)
 	for idx, l := 0, actions.Len(); idx < l; idx++ {
 		action := actions.At(idx)
@@ -2033,17 +2270,18 @@ func (it *dbIterator) filterVars(cursor *mdbs.Cursor, vUUIdBytes []byte, txnIdBy
 			continue
 		}
 		actionVarUUIdBytes := action.VarId()
-		varBytes, err := cursor.RTxn.Get(it.db.Vars, actionVarUUIdBytes)
-		if err == mdb.NotFound {
+		varBytes, err := rtxn.Get(it.db.Vars, actionVarUUIdBytes)
+		db.Stats.Vars.Read(varBytes, err)
+		if err == db.NotFound {
 			continue
 		} else if err != nil {
-			cursor.Error(err)
+			rtxn.Error(err)
 			return nil, err
 		}
 
 		seg, _, err := capn.ReadFromMemoryZeroCopy(varBytes)
 		if err != nil {
-			cursor.Error(err)
+			rtxn.Error(err)
 			return nil, err
 		}
 		varCap := msgs.ReadRootVar(seg)
@@ -2067,7 +2305,7 @@ func (it *dbIterator) matchVarsAgainstCond(cond configuration.Cond, varCaps []*m
 	result := make([]*msgs.Var, 0, len(varCaps)>>1)
 	for _, varCap := range varCaps {
 		pos := varCap.Positions()
-		server.Log("Topology: Testing", common.MakeVarUUId(varCap.Id()), (*common.Positions)(&pos), "against condition", cond)
+		debugLog.Log("Topology: Testing", common.MakeVarUUId(varCap.Id()), (*common.Positions)(&pos), "against condition", cond)
 		if b, err := cond.SatisfiedBy(it.topology, (*common.Positions)(&pos)); err == nil && b {
 			result = append(result, varCap)
 		} else if err != nil {
@@ -2092,6 +2330,9 @@ func (it *dbIterator) ConnectedRMs(conns map[common.RMId]paxos.Connection) {
 	bites := server.SegToBytes(seg)
 
 	for _, sb := range it.batch {
+		if sb.abandoned {
+			continue
+		}
 		if conn, found := conns[sb.conn.RMId()]; found && sb.conn == conn {
 			// The connection has not changed since we started sending to
 			// it (because we cached it, you can discount the issue of
@@ -2099,7 +2340,7 @@ func (it *dbIterator) ConnectedRMs(conns map[common.RMId]paxos.Connection) {
 			// the completion msg. If it has changed, we rely on the
 			// ConnectionLost being called in the emigrator to do any
 			// necessary tidying up.
-			server.Log("Topology: Sending migration completion to", conn.RMId())
+			debugLog.Log("Topology: Sending migration completion to", conn.RMId())
 			conn.Send(bites)
 		}
 	}
@@ -2110,10 +2351,22 @@ func (it *dbIterator) ConnectionEstablished(rmId common.RMId, conn paxos.Connect
 }
 
 type sendBatch struct {
-	version uint32
-	conn    paxos.Connection
-	cond    configuration.Cond
-	elems   []*migrationElem
+	emigrator *emigrator
+	version   uint32
+	conn      paxos.Connection
+	cond      configuration.Cond
+	elems     []*migrationElem
+	report    func(txns, vars, bytes uint64)
+	window    *migrationWindow
+	// acks is whether the receiver acks each batch once it's on
+	// disk. If it doesn't, we can neither use the window nor
+	// checkpoint.
+	acks        bool
+	abandoned   bool
+	throttle    *emigrationThrottle
+	resumeAfter []byte
+	cursor      []byte
+	flushed     int
 }
 
 type migrationElem struct {
@@ -2122,18 +2375,80 @@ type migrationElem struct {
 }
 
 func (e *emigrator) newBatch(conn paxos.Connection, cond configuration.Cond) *sendBatch {
+	version := e.topology.Next().Version
+	from, to := e.connectionManager.RMId, conn.RMId()
+	tt := e.connectionManager.Transmogrifier
 	return &sendBatch{
-		version: e.topology.Next().Version,
-		conn:    conn,
-		cond:    cond,
-		elems:   make([]*migrationElem, 0, server.MigrationBatchElemCount),
+		emigrator: e,
+		version:   version,
+		conn:      conn,
+		cond:      cond,
+		elems:     make([]*migrationElem, 0, server.MigrationBatchElemCount),
+		report: func(txns, vars, bytes uint64) {
+			tt.enqueueQuery(topologyTransmogrifierMsgExe(func() error {
+				tt.migrationReport(version).pair(from, to).add(txns, vars, bytes)
+				return nil
+			}))
+		},
+		window:   e.newWindow(to, version),
+		acks:     peerHasCapability(conn, serverCapabilityMigrationBatchAck),
+		throttle: &tt.emigrationThrottle,
 	}
 }
 
+// full returns whether the batch must be sent before any more is
+// added to it.
+func (sb *sendBatch) full() bool {
+	return len(sb.elems) >= server.MigrationBatchElemCount
+}
+
+// abandon gives up on the batch, because the connection has gone, or
+// we've been stopped, or a newer batch to the same RM has started.
+// Whatever is unsent is not lost: whichever batch next emigrates to
+// the RM resumes from the last checkpoint, and so sends it again.
+func (sb *sendBatch) abandon() {
+	if !sb.abandoned {
+		log.Printf("Warning: Emigration to %v abandoned; it will resume from its last checkpoint.", sb.conn.RMId())
+		sb.abandoned = true
+	}
+	sb.elems = sb.elems[:0]
+}
+
+// flush sends the batch. It must not be called from within a read
+// txn, as it may block for a long time.
 func (sb *sendBatch) flush() {
 	if len(sb.elems) == 0 {
 		return
 	}
+	select {
+	case <-sb.window.abandoned:
+		sb.abandon()
+		return
+	default:
+	}
+	// Wait for the receiver to have acknowledged enough earlier
+	// batches, and then for the throttle. If the connection goes or
+	// we're stopped, there's no point sending anything more.
+	for sb.acks && len(sb.window.slots) >= sb.throttle.window() {
+		select {
+		case <-sb.window.acked:
+		case <-sb.window.abandoned:
+			sb.abandon()
+			return
+		}
+	}
+	varCount, byteCount := uint64(0), uint64(0)
+	for _, elem := range sb.elems {
+		varCount += uint64(len(elem.vars))
+		byteCount += uint64(len(elem.txn.Data))
+	}
+	if !sb.throttle.wait(varCount, byteCount, sb.window.abandoned) {
+		sb.abandon()
+		return
+	}
+	if sb.acks {
+		sb.window.slots <- server.EmptyStructVal
+	}
 	seg := capn.NewBuffer(nil)
 	msg := msgs.NewRootMessage(seg)
 	migration := msgs.NewMigration(seg)
@@ -2152,18 +2467,23 @@ func (sb *sendBatch) flush() {
 	migration.SetElems(elems)
 	msg.SetMigration(migration)
 	bites := server.SegToBytes(seg)
-	server.Log("Topology: Migrating", len(sb.elems), "txns to", sb.conn.RMId())
+	debugLog.Log("Topology: Migrating", len(sb.elems), "txns to", sb.conn.RMId())
 	sb.conn.Send(bites)
+	sb.report(uint64(len(sb.elems)), varCount, byteCount)
 	sb.elems = sb.elems[:0]
+	sb.flushed++
+	if sb.acks && sb.flushed%server.MigrationCheckpointBatches == 0 {
+		sb.checkpoint()
+	}
 }
 
-func (sb *sendBatch) add(txn *eng.TxnReader, varCaps []*msgs.Var) {
+// add adds txn to the batch, along with those of its vars which are
+// to be sent. The txn was found from the var with key cursor.
+func (sb *sendBatch) add(txn *eng.TxnReader, varCaps []*msgs.Var, cursor []byte) {
 	elem := &migrationElem{
 		txn:  txn,
 		vars: varCaps,
 	}
+	sb.cursor = cursor
 	sb.elems = append(sb.elems, elem)
-	if len(sb.elems) == server.MigrationBatchElemCount {
-		sb.flush()
-	}
 }
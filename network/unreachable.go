@@ -0,0 +1,77 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
+)
+
+// UnreachableReport is the result of UnreachableVars.
+type UnreachableReport struct {
+	// Held is the number of vars this RM holds.
+	Held int
+	// Reachable is the number of vars, cluster-wide, reachable from
+	// the roots.
+	Reachable int
+	// Unreachable lists (up to the limit requested) the vars this RM
+	// holds which are not reachable from any root.
+	Unreachable []*common.VarUUId
+	// UnreachableCount is the number of such vars, which may exceed
+	// len(Unreachable).
+	UnreachableCount int
+}
+
+// UnreachableVars finds the vars held by this RM which can no longer
+// be reached from any root, and so can never be read or written by a
+// client again. The vars held are listed first, and then the graph
+// from the roots is walked (see reachableVars): a var created after
+// the listing can't be reported, and a var which is reachable at any
+// point during the walk is not reported either.
+//
+// This only reports: there is no action with which a txn could
+// delete a var, so unreachable vars can't be reclaimed yet.
+func (tt *TopologyTransmogrifier) UnreachableVars(limit int) (*UnreachableReport, error) {
+	topology, err := tt.stableTopology("find unreachable vars")
+	if err != nil {
+		return nil, err
+	}
+
+	held := []*common.VarUUId{}
+	result, err := tt.db.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
+		rtxn.Iterate(tt.db.Vars, func(key, value []byte) bool {
+			vUUId := common.MakeVarUUId(key)
+			if vUUId.Compare(configuration.TopologyVarUUId) != common.EQ {
+				held = append(held, vUUId)
+			}
+			return true
+		})
+		return true
+	}).ResultError()
+	if err != nil {
+		return nil, err
+	} else if result == nil {
+		return nil, errors.New("Shutting down.")
+	}
+
+	reachable, err := tt.reachableVars(topology, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find unreachable vars: %v", err)
+	}
+
+	report := &UnreachableReport{
+		Held:        len(held),
+		Reachable:   len(reachable),
+		Unreachable: []*common.VarUUId{},
+	}
+	for _, vUUId := range held {
+		if _, found := reachable[*vUUId]; !found {
+			report.UnreachableCount++
+			if len(report.Unreachable) < limit {
+				report.Unreachable = append(report.Unreachable, vUUId)
+			}
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,66 @@
+package network
+
+import (
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/client"
+)
+
+// StorageUsage is the number of vars, and the bytes of their values,
+// reachable from a root, or from any of an account's roots.
+type StorageUsage struct {
+	Vars  uint64
+	Bytes uint64
+}
+
+// StorageUsageReport is the result of StorageUsage.
+type StorageUsageReport struct {
+	Roots    map[string]*StorageUsage
+	Accounts map[string]*StorageUsage
+}
+
+// StorageUsage attributes vars to the roots, and accounts, from which
+// they can be reached, by walking the graph of references from each
+// root with quorum reads. A var reachable from several roots counts
+// towards each of them, but only once towards any one account. Each
+// walk reads the vars as they are when it reaches them, so with txns
+// in progress the result is an estimate.
+func (tt *TopologyTransmogrifier) StorageUsage() (*StorageUsageReport, error) {
+	topology, err := tt.stableTopology("account for storage")
+	if err != nil {
+		return nil, err
+	}
+	report := &StorageUsageReport{
+		Roots:    make(map[string]*StorageUsage),
+		Accounts: make(map[string]*StorageUsage),
+	}
+	for _, start := range rootWalkStarts(topology, nil) {
+		usage, err := tt.storageUsage([]*walkStart{start})
+		if err != nil {
+			return nil, err
+		}
+		report.Roots[start.name] = usage
+	}
+	for account, rootsCapability := range topology.Accounts {
+		names := make(map[string]bool, len(rootsCapability))
+		for name := range rootsCapability {
+			names[name] = true
+		}
+		usage, err := tt.storageUsage(rootWalkStarts(topology, names))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to account for storage of account %v: %v", account, err)
+		}
+		report.Accounts[account] = usage
+	}
+	return report, nil
+}
+
+func (tt *TopologyTransmogrifier) storageUsage(starts []*walkStart) (*StorageUsage, error) {
+	usage := &StorageUsage{}
+	_, err := tt.walk(starts, func(start *walkStart, vUUId *common.VarUUId, positions *common.Positions, read *client.QuorumReadResult) error {
+		usage.Vars++
+		usage.Bytes += uint64(len(read.Value))
+		return nil
+	})
+	return usage, err
+}
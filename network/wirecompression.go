@@ -0,0 +1,84 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"io/ioutil"
+)
+
+// wireCompression counts the messages compressed and decompressed on
+// a connection to another RM. It is only used from within the
+// connection's go-routine.
+type wireCompression struct {
+	sent          uint64
+	sentRawBytes  uint64
+	sentWireBytes uint64
+	received      uint64
+	recvRawBytes  uint64
+	recvWireBytes uint64
+}
+
+// compress returns msg wrapped in a compressed Message, or nil if msg
+// is too small to be worth compressing, or compressing it doesn't
+// make it smaller.
+func (wc *wireCompression) compress(msg []byte) []byte {
+	if len(msg) < server.WireCompressionMinBytes {
+		return nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(msg)))
+	w, err := flate.NewWriter(buf, flate.BestSpeed)
+	if err == nil {
+		if _, err = w.Write(msg); err == nil {
+			err = w.Close()
+		}
+	}
+	if err != nil || buf.Len() >= len(msg) {
+		return nil
+	}
+	seg := capn.NewBuffer(nil)
+	wrapper := msgs.NewRootMessage(seg)
+	wrapper.SetCompressed(buf.Bytes())
+	wire := server.SegToBytes(seg)
+	if len(wire) >= len(msg) {
+		return nil
+	}
+	wc.sent++
+	wc.sentRawBytes += uint64(len(msg))
+	wc.sentWireBytes += uint64(len(wire))
+	return wire
+}
+
+// decompress returns the Message held within a compressed Message.
+func (wc *wireCompression) decompress(compressed []byte) (msgs.Message, error) {
+	r := flate.NewReader(bytes.NewReader(compressed))
+	defer r.Close()
+	bites, err := ioutil.ReadAll(r)
+	if err != nil {
+		return msgs.Message{}, err
+	}
+	seg, _, err := capn.ReadFromMemoryZeroCopy(bites)
+	if err != nil {
+		return msgs.Message{}, err
+	}
+	wc.received++
+	wc.recvRawBytes += uint64(len(bites))
+	wc.recvWireBytes += uint64(len(compressed))
+	return msgs.ReadRootMessage(seg), nil
+}
+
+func (wc *wireCompression) String() string {
+	return fmt.Sprintf("sent: %v (%v bytes as %v; ratio: %.2f); received: %v (%v bytes as %v; ratio: %.2f)",
+		wc.sent, wc.sentRawBytes, wc.sentWireBytes, compressionRatio(wc.sentRawBytes, wc.sentWireBytes),
+		wc.received, wc.recvRawBytes, wc.recvWireBytes, compressionRatio(wc.recvRawBytes, wc.recvWireBytes))
+}
+
+func compressionRatio(raw, wire uint64) float64 {
+	if wire == 0 {
+		return 1.0
+	}
+	return float64(raw) / float64(wire)
+}
@@ -0,0 +1,82 @@
+package network
+
+import (
+	"bytes"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"testing"
+)
+
+// Outcomes and migrations carry whole txns, so are the messages
+// between RMs most worth compressing. Both are sent through
+// Connection.Send, and so through wireCompression.
+func TestWireCompression(t *testing.T) {
+	txn := bytes.Repeat([]byte("goshawkdb"), server.WireCompressionMinBytes)
+
+	outcomeMsg := func() []byte {
+		seg := capn.NewBuffer(nil)
+		msg := msgs.NewRootMessage(seg)
+		twoB := msgs.NewTwoBTxnVotes(seg)
+		outcome := msgs.NewOutcome(seg)
+		outcome.SetTxn(txn)
+		outcome.SetCommit([]byte{})
+		twoB.SetOutcome(outcome)
+		msg.SetTwoBTxnVotes(twoB)
+		return server.SegToBytes(seg)
+	}
+	migrationMsg := func() []byte {
+		seg := capn.NewBuffer(nil)
+		msg := msgs.NewRootMessage(seg)
+		migration := msgs.NewMigration(seg)
+		elems := msgs.NewMigrationElementList(seg, 1)
+		elem := msgs.NewMigrationElement(seg)
+		elem.SetTxn(txn)
+		elems.Set(0, elem)
+		migration.SetElems(elems)
+		msg.SetMigration(migration)
+		return server.SegToBytes(seg)
+	}
+
+	sender, receiver := &wireCompression{}, &wireCompression{}
+	for _, c := range []struct {
+		name  string
+		msg   []byte
+		which msgs.Message_Which
+	}{
+		{"outcome", outcomeMsg(), msgs.MESSAGE_TWOBTXNVOTES},
+		{"migration", migrationMsg(), msgs.MESSAGE_MIGRATION},
+	} {
+		wire := sender.compress(c.msg)
+		if wire == nil || len(wire) >= len(c.msg) {
+			t.Fatalf("%v: expected the message to be compressed", c.name)
+		}
+		seg, _, err := capn.ReadFromMemoryZeroCopy(wire)
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+		wrapper := msgs.ReadRootMessage(seg)
+		if wrapper.Which() != msgs.MESSAGE_COMPRESSED {
+			t.Fatalf("%v: expected a compressed message; got %v", c.name, wrapper.Which())
+		}
+		inner, err := receiver.decompress(wrapper.Compressed())
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		} else if inner.Which() != c.which {
+			t.Fatalf("%v: expected %v once decompressed; got %v", c.name, c.which, inner.Which())
+		}
+	}
+
+	// Small messages, such as heartbeats, are sent as they are.
+	seg := capn.NewBuffer(nil)
+	msgs.NewRootMessage(seg).SetHeartbeat()
+	if wire := sender.compress(server.SegToBytes(seg)); wire != nil {
+		t.Fatal("Expected a heartbeat not to be compressed")
+	}
+
+	if sender.sent != 2 || receiver.received != 2 {
+		t.Fatalf("Expected 2 messages compressed and decompressed; got %v and %v", sender.sent, receiver.received)
+	} else if ratio := compressionRatio(sender.sentRawBytes, sender.sentWireBytes); ratio <= 1 {
+		t.Fatalf("Expected a compression ratio above 1; got %.2f", ratio)
+	}
+}
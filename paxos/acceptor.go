@@ -3,19 +3,21 @@ package paxos
 import (
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
 	eng "goshawkdb.io/server/txnengine"
-	"log"
 )
 
 type Acceptor struct {
 	txnId           *common.TxnId
 	acceptorManager *AcceptorManager
 	currentState    acceptorStateMachineComponent
+	traceContext    []byte
+	span            server.Span
+	log             *server.Logger
 	acceptorReceiveBallots
 	acceptorWriteToDisk
 	acceptorAwaitLocallyComplete
@@ -26,6 +28,8 @@ func NewAcceptor(txn *eng.TxnReader, am *AcceptorManager) *Acceptor {
 	a := &Acceptor{
 		txnId:           txn.Id,
 		acceptorManager: am,
+		traceContext:    txn.Txn.TraceContext(),
+		log:             am.log.With("txn", txn.Id),
 	}
 	a.init(txn)
 	return a
@@ -35,7 +39,7 @@ func AcceptorFromData(txnId *common.TxnId, outcome *msgs.Outcome, sendToAll bool
 	outcomeEqualId := (*outcomeEqualId)(outcome)
 	txn := eng.TxnReaderFromData(outcome.Txn())
 	a := NewAcceptor(txn, am)
-	a.ballotAccumulator = BallotAccumulatorFromData(txn, outcomeEqualId, instances)
+	a.ballotAccumulator = BallotAccumulatorFromData(txn, outcomeEqualId, instances, a.log)
 	a.outcome = outcomeEqualId
 	a.sendToAll = sendToAll
 	a.sendToAllOnDisk = sendToAll
@@ -59,15 +63,17 @@ func (a *Acceptor) Start() {
 	} else {
 		a.currentState = &a.acceptorAwaitLocallyComplete
 	}
+	a.span = server.StartSpan("acceptor", a.txnId, a.traceContext)
+	a.span.LogEvent(fmt.Sprint(a.currentState))
 	a.currentState.start()
 }
 
 func (a *Acceptor) Status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("Acceptor for %v", a.txnId))
-	sc.Emit(fmt.Sprintf("- Current State: %v", a.currentState))
-	sc.Emit(fmt.Sprintf("- Outcome determined? %v", a.outcome != nil))
-	sc.Emit(fmt.Sprintf("- Pending TLC: %v", a.pendingTLC))
-	sc.Emit(fmt.Sprintf("- Received TSC: %v", a.tscReceived))
+	sc.EmitKV("Current State", a.currentState)
+	sc.EmitKV("Outcome determined", a.outcome != nil)
+	sc.EmitKV("Pending TLC", a.pendingTLC)
+	sc.EmitKV("Received TSC", a.tscReceived)
 	a.ballotAccumulator.Status(sc.Fork())
 	sc.Join()
 }
@@ -83,12 +89,14 @@ func (a *Acceptor) nextState(requestedState acceptorStateMachineComponent) {
 			a.currentState = &a.acceptorDeleteFromDisk
 		case &a.acceptorDeleteFromDisk:
 			a.currentState = nil
+			a.span.Finish()
 			return
 		}
 
 	} else {
 		a.currentState = requestedState
 	}
+	a.span.LogEvent(fmt.Sprint(a.currentState))
 
 	a.currentState.start()
 }
@@ -113,7 +121,7 @@ type acceptorReceiveBallots struct {
 
 func (arb *acceptorReceiveBallots) init(a *Acceptor, txn *eng.TxnReader) {
 	arb.Acceptor = a
-	arb.ballotAccumulator = NewBallotAccumulator(txn)
+	arb.ballotAccumulator = NewBallotAccumulator(txn, a.log)
 	arb.txn = txn
 	arb.txnSubmitter = common.RMId(txn.Txn.Submitter())
 	arb.txnSubmitterBootCount = txn.Txn.SubmitterBootCount()
@@ -159,7 +167,7 @@ func (arb *acceptorReceiveBallots) BallotAccepted(instanceRMId common.RMId, inst
 	// we've received a TLC from instanceRMId (see notes in ALC re
 	// retry). Note an acceptor can change it's mind!
 	if arb.currentState == &arb.acceptorDeleteFromDisk {
-		log.Printf("Error: %v received ballot for instance %v after all TLCs received.", arb.txnId, instanceRMId)
+		arb.log.Error("Received ballot for instance %v after all TLCs received.", instanceRMId)
 	}
 	outcome := arb.ballotAccumulator.BallotReceived(instanceRMId, inst, vUUId, txn)
 	if outcome != nil && !outcome.Equal(arb.outcome) {
@@ -205,7 +213,7 @@ func (arb *acceptorReceiveBallots) createTxnSender() {
 				activeRMs = append(activeRMs, common.RMId(alloc.RmId()))
 			}
 		}
-		server.Log(arb.txnId, "Starting extra txn sender with actives:", activeRMs)
+		arb.log.Log("Starting extra txn sender with actives:", activeRMs)
 		arb.txnSender = NewRepeatingSender(server.SegToBytes(seg), activeRMs...)
 		arb.acceptorManager.AddServerConnectionSubscriber(arb.txnSender)
 	}
@@ -243,9 +251,10 @@ func (awtd *acceptorWriteToDisk) start() {
 
 	// to ensure correct order of writes, schedule the write from
 	// the current go-routine...
-	server.Log(awtd.txnId, "Writing 2B to disk...")
-	future := awtd.acceptorManager.DB.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
-		rwtxn.Put(awtd.acceptorManager.DB.BallotOutcomes, awtd.txnId[:], data, 0)
+	awtd.log.Log("Writing 2B to disk...")
+	future := awtd.acceptorManager.DB.ConsensusShard(awtd.txnId).ReadWriteTransaction(false, func(rwtxn db.ReadWriteTxn) interface{} {
+		db.Stats.BallotOutcomes.Wrote(data)
+		rwtxn.Put(awtd.acceptorManager.DB.BallotOutcomes, awtd.txnId[:], data)
 		return true
 	})
 	go func() {
@@ -253,7 +262,7 @@ func (awtd *acceptorWriteToDisk) start() {
 		if ran, err := future.ResultError(); err != nil {
 			panic(fmt.Sprintf("Error: %v Acceptor Write error: %v", awtd.txnId, err))
 		} else if ran != nil {
-			server.Log(awtd.txnId, "Writing 2B to disk...done.")
+			awtd.log.Log("Writing 2B to disk...done.")
 			awtd.acceptorManager.Exe.Enqueue(func() { awtd.writeDone(outcome, sendToAll) })
 		}
 	}()
@@ -319,8 +328,8 @@ func (aalc *acceptorAwaitLocallyComplete) start() {
 	aalc.tgcRecipients = make([]common.RMId, 0, allocs.Len())
 
 	var rmsRemoved map[common.RMId]server.EmptyStruct
-	if aalc.acceptorManager.Topology != nil {
-		rmsRemoved = aalc.acceptorManager.Topology.RMsRemoved()
+	if topology := aalc.acceptorManager.Topology.Load(); topology != nil {
+		rmsRemoved = topology.RMsRemoved()
 	}
 
 	for idx, l := 0, allocs.Len(); idx < l; idx++ {
@@ -346,9 +355,9 @@ func (aalc *acceptorAwaitLocallyComplete) start() {
 		aalc.maybeDelete()
 
 	} else {
-		server.Log(aalc.txnId, "Adding sender for 2B")
+		aalc.log.Log("Adding sender for 2B")
 		submitter := common.RMId(aalc.ballotAccumulator.txn.Txn.Submitter())
-		aalc.twoBSender = newTwoBTxnVotesSender((*msgs.Outcome)(aalc.outcomeOnDisk), aalc.txnId, submitter, aalc.tgcRecipients...)
+		aalc.twoBSender = newTwoBTxnVotesSender((*msgs.Outcome)(aalc.outcomeOnDisk), aalc.log, submitter, aalc.tgcRecipients...)
 		aalc.acceptorManager.AddServerConnectionSubscriber(aalc.twoBSender)
 	}
 }
@@ -417,15 +426,16 @@ func (adfd *acceptorDeleteFromDisk) start() {
 		adfd.acceptorManager.RemoveServerConnectionSubscriber(adfd.twoBSender)
 		adfd.twoBSender = nil
 	}
-	future := adfd.acceptorManager.DB.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
-		rwtxn.Del(adfd.acceptorManager.DB.BallotOutcomes, adfd.txnId[:], nil)
+	future := adfd.acceptorManager.DB.ConsensusShard(adfd.txnId).ReadWriteTransaction(false, func(rwtxn db.ReadWriteTxn) interface{} {
+		db.Stats.BallotOutcomes.Deleted()
+		rwtxn.Del(adfd.acceptorManager.DB.BallotOutcomes, adfd.txnId[:])
 		return true
 	})
 	go func() {
 		if ran, err := future.ResultError(); err != nil {
 			panic(fmt.Sprintf("Error: %v Acceptor Deletion error: %v", adfd.txnId, err))
 		} else if ran != nil {
-			server.Log(adfd.txnId, "Deleted 2B from disk...done.")
+			adfd.log.Log("Deleted 2B from disk...done.")
 			adfd.acceptorManager.Exe.Enqueue(adfd.deletionDone)
 		}
 	}()
@@ -446,7 +456,7 @@ func (adfd *acceptorDeleteFromDisk) deletionDone() {
 		tgc := msgs.NewTxnGloballyComplete(seg)
 		msg.SetTxnGloballyComplete(tgc)
 		tgc.SetTxnId(adfd.txnId[:])
-		server.Log(adfd.txnId, "Sending TGC to", adfd.tgcRecipients)
+		adfd.log.Log("Sending TGC to", adfd.tgcRecipients)
 		// If this gets lost it doesn't matter - the TLC will eventually
 		// get resent and we'll then send out another TGC.
 		NewOneShotSender(server.SegToBytes(seg), adfd.acceptorManager, adfd.tgcRecipients...)
@@ -462,7 +472,7 @@ type twoBTxnVotesSender struct {
 	submitter    common.RMId
 }
 
-func newTwoBTxnVotesSender(outcome *msgs.Outcome, txnId *common.TxnId, submitter common.RMId, recipients ...common.RMId) *twoBTxnVotesSender {
+func newTwoBTxnVotesSender(outcome *msgs.Outcome, log *server.Logger, submitter common.RMId, recipients ...common.RMId) *twoBTxnVotesSender {
 	submitterSeg := capn.NewBuffer(nil)
 	submitterMsg := msgs.NewRootMessage(submitterSeg)
 	submitterMsg.SetSubmissionOutcome(*outcome)
@@ -478,7 +488,7 @@ func newTwoBTxnVotesSender(outcome *msgs.Outcome, txnId *common.TxnId, submitter
 	msg.SetTwoBTxnVotes(twoB)
 	twoB.SetOutcome(*outcome)
 
-	server.Log(txnId, "Sending 2B to", recipients)
+	log.Log("Sending 2B to", recipients)
 
 	return &twoBTxnVotesSender{
 		msg:          server.SegToBytes(seg),
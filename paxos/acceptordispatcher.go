@@ -2,15 +2,12 @@ package paxos
 
 import (
 	"fmt"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/db"
 	"goshawkdb.io/server/dispatcher"
 	eng "goshawkdb.io/server/txnengine"
-	"log"
 )
 
 type AcceptorDispatcher struct {
@@ -23,11 +20,11 @@ func NewAcceptorDispatcher(count uint8, rmId common.RMId, cm ConnectionManager,
 	ad := &AcceptorDispatcher{
 		acceptormanagers: make([]*AcceptorManager, count),
 	}
-	ad.Dispatcher.Init(count)
+	ad.Dispatcher.Init("Acceptor", count)
 	for idx, exe := range ad.Executors {
 		ad.acceptormanagers[idx] = NewAcceptorManager(rmId, exe, cm, db)
 	}
-	ad.loadFromDisk(db)
+	ad.loadFromDisk(rmId, db)
 	return ad
 }
 
@@ -38,6 +35,11 @@ func (ad *AcceptorDispatcher) OneATxnVotesReceived(sender common.RMId, oneATxnVo
 
 func (ad *AcceptorDispatcher) TwoATxnVotesReceived(sender common.RMId, twoATxnVotes *msgs.TwoATxnVotes) {
 	txn := eng.TxnReaderFromData(twoATxnVotes.Txn())
+	if txn.VerifyChecksum(sender) != nil {
+		// Don't persist it. The proposer will keep resending, so
+		// transient corruption will heal.
+		return
+	}
 	txnId := txn.Id
 	ad.withAcceptorManager(txnId, func(am *AcceptorManager) { am.TwoATxnVotesReceived(sender, txn, twoATxnVotes) })
 }
@@ -56,49 +58,37 @@ func (ad *AcceptorDispatcher) Status(sc *server.StatusConsumer) {
 	sc.Emit("Acceptors")
 	for idx, executor := range ad.Executors {
 		s := sc.Fork()
-		s.Emit(fmt.Sprintf("Acceptor Manager %v", idx))
+		s.Emit(fmt.Sprintf("Acceptor Manager %v (%v)", idx, executor.Metrics()))
 		manager := ad.acceptormanagers[idx]
 		executor.Enqueue(func() { manager.Status(s) })
 	}
 	sc.Join()
 }
 
-func (ad *AcceptorDispatcher) loadFromDisk(db *db.Databases) {
-	res, err := db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		res, _ := rtxn.WithCursor(db.BallotOutcomes, func(cursor *mdbs.Cursor) interface{} {
-			// cursor.Get returns a copy of the data. So it's fine for us
-			// to store and process this later - it's not about to be
-			// overwritten on disk.
-			acceptorStates := make(map[*common.TxnId][]byte)
-			txnIdData, acceptorState, err := cursor.Get(nil, nil, mdb.FIRST)
-			for ; err == nil; txnIdData, acceptorState, err = cursor.Get(nil, nil, mdb.NEXT) {
-				txnId := common.MakeTxnId(txnIdData)
-				acceptorStates[txnId] = acceptorState
-			}
-			if err == mdb.NotFound {
-				// fine, we just fell off the end as expected.
-				return acceptorStates
-			} else {
-				cursor.Error(err)
-				return nil
-			}
-		})
-		return res
-	}).ResultError()
+func (ad *AcceptorDispatcher) loadFromDisk(rmId common.RMId, dbs *db.Databases) {
+	// Iterate gives us copies of the data. So it's fine for us to
+	// store and process this later - it's not about to be
+	// overwritten on disk.
+	acceptorStates := make(map[*common.TxnId][]byte)
+	ran, err := dbs.IterateConsensus(dbs.BallotOutcomes, func(txnIdData, acceptorState []byte) bool {
+		db.Stats.BallotOutcomes.Read(acceptorState, nil)
+		txnId := common.MakeTxnId(txnIdData)
+		acceptorStates[txnId] = acceptorState
+		return true
+	})
 	if err != nil {
 		panic(fmt.Sprintf("AcceptorDispatcher error loading from disk: %v", err))
-	} else if res != nil {
-		acceptorStates := res.(map[*common.TxnId][]byte)
+	} else if ran {
 		for txnId, acceptorState := range acceptorStates {
 			acceptorStateCopy := acceptorState
 			txnIdCopy := txnId
 			ad.withAcceptorManager(txnIdCopy, func(am *AcceptorManager) {
 				if err := am.loadFromData(txnIdCopy, acceptorStateCopy); err != nil {
-					log.Printf("AcceptorDispatcher error loading %v from disk: %v\n", txnIdCopy, err)
+					am.txnLog(txnIdCopy).Error("AcceptorDispatcher unable to load acceptor from disk: %v", err)
 				}
 			})
 		}
-		log.Printf("Loaded %v acceptors from disk\n", len(acceptorStates))
+		debugLog.With("rm", rmId).Info("Loaded %v acceptors from disk", len(acceptorStates))
 	}
 }
 
@@ -106,5 +96,5 @@ func (ad *AcceptorDispatcher) withAcceptorManager(txnId *common.TxnId, fun func(
 	idx := uint8(txnId[server.MostRandomByteIndex]) % ad.ExecutorCount
 	executor := ad.Executors[idx]
 	manager := ad.acceptormanagers[idx]
-	return executor.Enqueue(func() { fun(manager) })
+	return executor.EnqueueFor(txnId, func() { fun(manager) })
 }
@@ -5,8 +5,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
@@ -17,7 +15,7 @@ import (
 )
 
 func init() {
-	db.DB.BallotOutcomes = &mdbs.DBISettings{Flags: mdb.CREATE}
+	db.DB.BallotOutcomes = db.DeclareTable("BallotOutcomes")
 }
 
 type AcceptorManager struct {
@@ -27,7 +25,8 @@ type AcceptorManager struct {
 	Exe       *dispatcher.Executor
 	instances map[instanceId]*instance
 	acceptors map[common.TxnId]*acceptorInstances
-	Topology  *configuration.Topology
+	Topology  configuration.AtomicTopology
+	log       *server.Logger
 }
 
 func NewAcceptorManager(rmId common.RMId, exe *dispatcher.Executor, cm ConnectionManager, db *db.Databases) *AcceptorManager {
@@ -38,8 +37,9 @@ func NewAcceptorManager(rmId common.RMId, exe *dispatcher.Executor, cm Connectio
 		Exe:       exe,
 		instances: make(map[instanceId]*instance),
 		acceptors: make(map[common.TxnId]*acceptorInstances),
+		log:       debugLog.With("rm", rmId),
 	}
-	exe.Enqueue(func() { am.Topology = cm.AddTopologySubscriber(eng.AcceptorSubscriber, am) })
+	exe.Enqueue(func() { am.Topology.Store(cm.AddTopologySubscriber(eng.AcceptorSubscriber, am)) })
 	return am
 }
 
@@ -140,7 +140,7 @@ func (am *AcceptorManager) loadFromData(txnId *common.TxnId, data []byte) error
 func (am *AcceptorManager) TopologyChanged(topology *configuration.Topology, done func(bool)) {
 	resultChan := make(chan struct{})
 	enqueued := am.Exe.Enqueue(func() {
-		am.Topology = topology
+		am.Topology.Store(topology)
 		for _, ai := range am.acceptors {
 			if ai.acceptor != nil {
 				ai.acceptor.TopologyChanged(topology)
@@ -166,9 +166,14 @@ func (am *AcceptorManager) TopologyChanged(topology *configuration.Topology, don
 	}
 }
 
+// txnLog returns a Logger for lines about txnId.
+func (am *AcceptorManager) txnLog(txnId *common.TxnId) *server.Logger {
+	return am.log.With("txn", txnId)
+}
+
 func (am *AcceptorManager) OneATxnVotesReceived(sender common.RMId, txnId *common.TxnId, oneATxnVotes *msgs.OneATxnVotes) {
 	instanceRMId := common.RMId(oneATxnVotes.RmId())
-	server.Log(txnId, "1A received from", sender, "; instance:", instanceRMId)
+	am.txnLog(txnId).Log("1A received from", sender, "; instance:", instanceRMId)
 	instId := instanceId([instanceIdLen]byte{})
 	instIdSlice := instId[:]
 	copy(instIdSlice, txnId[:])
@@ -200,7 +205,7 @@ func (am *AcceptorManager) OneATxnVotesReceived(sender common.RMId, txnId *commo
 func (am *AcceptorManager) TwoATxnVotesReceived(sender common.RMId, txn *eng.TxnReader, twoATxnVotes *msgs.TwoATxnVotes) {
 	instanceRMId := common.RMId(twoATxnVotes.RmId())
 	txnId := txn.Id
-	server.Log(txnId, "2A received from", sender, "; instance:", instanceRMId)
+	am.txnLog(txnId).Log("2A received from", sender, "; instance:", instanceRMId)
 	instId := instanceId([instanceIdLen]byte{})
 	instIdSlice := instId[:]
 	copy(instIdSlice, txnId[:])
@@ -243,7 +248,7 @@ func (am *AcceptorManager) TwoATxnVotesReceived(sender common.RMId, txn *eng.Txn
 			failure.SetRoundNumber(failureRequests[idx].RoundNumber())
 			failure.SetRoundNumberTooLow(uint32(inst.promiseNum >> 32))
 		}
-		server.Log(txnId, "Sending 2B failures to", sender, "; instance:", instanceRMId)
+		am.txnLog(txnId).Log("Sending 2B failures to", sender, "; instance:", instanceRMId)
 		// The proposal senders are repeating, so this use of OSS is fine.
 		NewOneShotSender(server.SegToBytes(replySeg), am, sender)
 	}
@@ -251,7 +256,7 @@ func (am *AcceptorManager) TwoATxnVotesReceived(sender common.RMId, txn *eng.Txn
 
 func (am *AcceptorManager) TxnLocallyCompleteReceived(sender common.RMId, txnId *common.TxnId, tlc *msgs.TxnLocallyComplete) {
 	if aInst, found := am.acceptors[*txnId]; found && aInst.acceptor != nil {
-		server.Log(txnId, "TLC received from", sender, "(acceptor found)")
+		am.txnLog(txnId).Log("TLC received from", sender, "(acceptor found)")
 		aInst.acceptor.TxnLocallyCompleteReceived(sender)
 
 	} else {
@@ -259,13 +264,13 @@ func (am *AcceptorManager) TxnLocallyCompleteReceived(sender common.RMId, txnId
 		// immediately prior to sending TGC, and then died. Now we're
 		// back up, the proposers have sent us more TLCs, and we should
 		// just reply with TGCs.
-		server.Log(txnId, "TLC received from", sender, "(acceptor not found)")
+		am.txnLog(txnId).Log("TLC received from", sender, "(acceptor not found)")
 		seg := capn.NewBuffer(nil)
 		msg := msgs.NewRootMessage(seg)
 		tgc := msgs.NewTxnGloballyComplete(seg)
 		msg.SetTxnGloballyComplete(tgc)
 		tgc.SetTxnId(txnId[:])
-		server.Log(txnId, "Sending single TGC to", sender)
+		am.txnLog(txnId).Log("Sending single TGC to", sender)
 		// Use of OSS here is ok because this is the default action on
 		// not finding state.
 		NewOneShotSender(server.SegToBytes(seg), am, sender)
@@ -274,13 +279,13 @@ func (am *AcceptorManager) TxnLocallyCompleteReceived(sender common.RMId, txnId
 
 func (am *AcceptorManager) TxnSubmissionCompleteReceived(sender common.RMId, txnId *common.TxnId, tsc *msgs.TxnSubmissionComplete) {
 	if aInst, found := am.acceptors[*txnId]; found && aInst.acceptor != nil {
-		server.Log(txnId, "TSC received from", sender, "(acceptor found)")
+		am.txnLog(txnId).Log("TSC received from", sender, "(acceptor found)")
 		aInst.acceptor.TxnSubmissionCompleteReceived(sender)
 	}
 }
 
 func (am *AcceptorManager) AcceptorFinished(txnId *common.TxnId) {
-	server.Log(txnId, "Acceptor finished")
+	am.txnLog(txnId).Log("Acceptor finished")
 	if aInst, found := am.acceptors[*txnId]; found {
 		delete(am.acceptors, *txnId)
 		for _, instId := range aInst.instances {
@@ -17,13 +17,14 @@ type BallotAccumulator struct {
 	outcome        *outcomeEqualId
 	incompleteVars int
 	dirty          bool
+	log            *server.Logger
 }
 
 // You get one BallotAccumulator per txn. Which means the remaining
 // paxos instance namespace is {rmId,varId}. So for each var, we
 // expect to see ballots from fInc distinct rms.
 
-func NewBallotAccumulator(txn *eng.TxnReader) *BallotAccumulator {
+func NewBallotAccumulator(txn *eng.TxnReader, log *server.Logger) *BallotAccumulator {
 	actions := txn.Actions(true).Actions()
 	ba := &BallotAccumulator{
 		txn:            txn,
@@ -31,6 +32,7 @@ func NewBallotAccumulator(txn *eng.TxnReader) *BallotAccumulator {
 		outcome:        nil,
 		incompleteVars: actions.Len(),
 		dirty:          false,
+		log:            log,
 	}
 
 	vBallots := make([]varBallot, ba.incompleteVars)
@@ -82,8 +84,8 @@ type rmBallot struct {
 	roundNumber  paxosNumber
 }
 
-func BallotAccumulatorFromData(txn *eng.TxnReader, outcome *outcomeEqualId, instances *msgs.InstancesForVar_List) *BallotAccumulator {
-	ba := NewBallotAccumulator(txn)
+func BallotAccumulatorFromData(txn *eng.TxnReader, outcome *outcomeEqualId, instances *msgs.InstancesForVar_List, log *server.Logger) *BallotAccumulator {
+	ba := NewBallotAccumulator(txn, log)
 	ba.outcome = outcome
 
 	for idx, l := 0, instances.Len(); idx < l; idx++ {
@@ -166,7 +168,7 @@ func (ba *BallotAccumulator) determineOutcome() *outcomeEqualId {
 
 	vUUIds := common.VarUUIds(make([]*common.VarUUId, 0, len(ba.vUUIdToBallots)))
 	br := NewBadReads()
-	server.Log(ba.txn.Id, "Calculating result")
+	ba.log.Log("Calculating result")
 	for _, vBallot := range ba.vUUIdToBallots {
 		if len(vBallot.rmToBallot) < vBallot.voters {
 			continue
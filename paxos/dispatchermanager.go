@@ -1,13 +1,15 @@
 package paxos
 
 import (
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
+	"goshawkdb.io/server"
 	"goshawkdb.io/server/db"
+	"goshawkdb.io/server/dispatcher"
 	eng "goshawkdb.io/server/txnengine"
 )
 
+var debugLog = server.NewSubsystem("paxos")
+
 type Dispatchers struct {
 	db                 *db.Databases
 	AcceptorDispatcher *AcceptorDispatcher
@@ -33,16 +35,41 @@ func NewDispatchers(cm ConnectionManager, rmId common.RMId, count uint8, db *db.
 	}
 	d.ProposerDispatcher = NewProposerDispatcher(count, rmId, cm, db, d.VarDispatcher)
 
+	// Loading the proposers and acceptors has only been queued up on
+	// their executors. Recovery is over once every executor has got
+	// through its share.
+	go func() {
+		if d.awaitLoaded() {
+			server.LifecyclePhaseReached(server.PostRecovery)
+		}
+	}()
+
 	return d
 }
 
+// awaitLoaded returns once every proposer and acceptor found on disk
+// has been loaded, or false if we're shutting down first. Each
+// executor runs what it's given in order, so it's enough to wait for
+// a no-op queued on each behind the loads.
+func (d *Dispatchers) awaitLoaded() bool {
+	for _, dis := range []*dispatcher.Dispatcher{&d.ProposerDispatcher.Dispatcher, &d.AcceptorDispatcher.Dispatcher} {
+		for _, exe := range dis.Executors {
+			if !exe.EnqueueSync(func() {}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func (d *Dispatchers) IsDatabaseEmpty() (bool, error) {
-	res, err := d.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		res, _ := rtxn.WithCursor(d.db.Vars, func(cursor *mdbs.Cursor) interface{} {
-			_, _, err := cursor.Get(nil, nil, mdb.FIRST)
-			return err == mdb.NotFound
+	res, err := d.db.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
+		empty := true
+		rtxn.Iterate(d.db.Vars, func(key, value []byte) bool {
+			empty = false
+			return false
 		})
-		return res
+		return empty
 	}).ResultError()
 	if err != nil || res == nil {
 		return false, err
@@ -41,6 +41,13 @@ type Connection interface {
 	BootCount() uint32
 	TieBreak() uint32
 	ClusterUUId() uint64
+	// LearnerOnly is true if the RM has been (temporarily) demoted to
+	// a learner, and so should not be made active in new txns.
+	LearnerOnly() bool
+	// Degraded is true if the RM is currently struggling (deep
+	// queues, disk nearly full, or recovering), and so new vars
+	// should, where possible, not be placed on it.
+	Degraded() bool
 	Send(msg []byte)
 }
 
@@ -136,7 +143,7 @@ func NewOneShotSender(msg []byte, connPub ServerConnectionPublisher, recipients
 		msg:       msg,
 		connPub:   connPub,
 	}
-	server.Log(oss, "Adding one shot sender with recipients", recipients)
+	debugLog.Log(oss, "Adding one shot sender with recipients", recipients)
 	connPub.AddServerConnectionSubscriber(oss)
 	return oss
 }
@@ -149,7 +156,7 @@ func (s *OneShotSender) ConnectedRMs(conns map[common.RMId]Connection) {
 		}
 	}
 	if len(s.remaining) == 0 {
-		server.Log(s, "Removing one shot sender")
+		debugLog.Log(s, "Removing one shot sender")
 		s.connPub.RemoveServerConnectionSubscriber(s)
 	}
 }
@@ -161,7 +168,7 @@ func (s *OneShotSender) ConnectionEstablished(rmId common.RMId, conn Connection,
 		delete(s.remaining, rmId)
 		conn.Send(s.msg)
 		if len(s.remaining) == 0 {
-			server.Log(s, "Removing one shot sender")
+			debugLog.Log(s, "Removing one shot sender")
 			s.connPub.RemoveServerConnectionSubscriber(s)
 		}
 	}
@@ -20,6 +20,7 @@ type OutcomeAccumulator struct {
 	allKnownOutcomes []*txnOutcome
 	pendingTGC       int
 	fInc             int
+	log              *server.Logger
 }
 
 type acceptorIndexWithTxnOutcome struct {
@@ -34,7 +35,7 @@ type txnOutcome struct {
 	outcomeReceivedCount int
 }
 
-func NewOutcomeAccumulator(fInc int, acceptors common.RMIds) *OutcomeAccumulator {
+func NewOutcomeAccumulator(fInc int, acceptors common.RMIds, log *server.Logger) *OutcomeAccumulator {
 	acceptorOutcomes := make(map[common.RMId]*acceptorIndexWithTxnOutcome, len(acceptors))
 	ids := make([]acceptorIndexWithTxnOutcome, len(acceptors))
 	for idx, rmId := range acceptors {
@@ -49,6 +50,7 @@ func NewOutcomeAccumulator(fInc int, acceptors common.RMIds) *OutcomeAccumulator
 		allKnownOutcomes: make([]*txnOutcome, 0, 1),
 		pendingTGC:       len(acceptors),
 		fInc:             fInc,
+		log:              log,
 	}
 }
 
@@ -70,7 +72,7 @@ func (oa *OutcomeAccumulator) TopologyChange(topology *configuration.Topology) b
 	for rmId := range topology.RMsRemoved() {
 		if acceptorOutcome, found := oa.acceptorOutcomes[rmId]; found {
 			delete(oa.acceptorOutcomes, rmId)
-			server.Log("OutcomeAccumulator deleting acceptor", rmId)
+			oa.log.Log("OutcomeAccumulator deleting acceptor", rmId)
 			oa.acceptors[acceptorOutcome.idx] = common.RMIdEmpty
 			if l := oa.acceptors.NonEmptyLen(); l < oa.fInc {
 				oa.fInc = l
@@ -138,7 +140,7 @@ func (oa *OutcomeAccumulator) BallotOutcomeReceived(acceptorId common.RMId, outc
 }
 
 func (oa *OutcomeAccumulator) TxnGloballyCompleteReceived(acceptorId common.RMId) bool {
-	server.Log("TGC received from", acceptorId, "; pending:", oa.pendingTGC)
+	oa.log.Log("TGC received from", acceptorId, "; pending:", oa.pendingTGC)
 	acceptorOutcome, found := oa.acceptorOutcomes[acceptorId]
 	if !found {
 		// It must have been removed due to a topology change. See notes
@@ -0,0 +1,74 @@
+package paxos
+
+import (
+	"container/list"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+)
+
+// The outcomeCache remembers the outcomes of the last
+// OutcomeCacheSize txns whose proposers have finished. Once a
+// proposer has finished, this RM has applied the txn's outcome, and
+// every acceptor has been told so. Without the cache, a duplicate
+// submission of the txn, or a delayed 2B, arriving afterwards finds no
+// proposer, and a new one is created, which votes (or learns) all
+// over again. With it, the duplicate submission is ignored, and the
+// 2B is answered with a TLC straight away. Like the ProposerManager
+// which owns it, it is only used from the manager's executor.
+type outcomeCache struct {
+	entries map[common.TxnId]*list.Element
+	lru     *list.List
+	hits    uint64
+}
+
+type outcomeCacheEntry struct {
+	txnId  common.TxnId
+	status TxnOutcomeStatus
+}
+
+func newOutcomeCache() *outcomeCache {
+	return &outcomeCache{
+		entries: make(map[common.TxnId]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (oc *outcomeCache) add(txnId *common.TxnId, outcome *msgs.Outcome) {
+	status := TxnOutcomeDetermined
+	if outcome != nil {
+		if outcome.Which() == msgs.OUTCOME_COMMIT {
+			status = TxnOutcomeCommitted
+		} else {
+			status = TxnOutcomeAborted
+		}
+	}
+	if elem, found := oc.entries[*txnId]; found {
+		elem.Value.(*outcomeCacheEntry).status = status
+		oc.lru.MoveToFront(elem)
+		return
+	}
+	oc.entries[*txnId] = oc.lru.PushFront(&outcomeCacheEntry{txnId: *txnId, status: status})
+	if oc.lru.Len() > server.OutcomeCacheSize {
+		oldest := oc.lru.Back()
+		oc.lru.Remove(oldest)
+		delete(oc.entries, oldest.Value.(*outcomeCacheEntry).txnId)
+	}
+}
+
+// get returns the status of the txn's outcome, and whether it was
+// found, counting a hit if so.
+func (oc *outcomeCache) get(txnId *common.TxnId) (TxnOutcomeStatus, bool) {
+	elem, found := oc.entries[*txnId]
+	if !found {
+		return TxnOutcomeUnknown, false
+	}
+	oc.hits++
+	oc.lru.MoveToFront(elem)
+	return elem.Value.(*outcomeCacheEntry).status, true
+}
+
+func (oc *outcomeCache) status(sc *server.StatusConsumer) {
+	sc.EmitKV("Cached outcomes", oc.lru.Len())
+	sc.EmitKV("Outcome cache hits", oc.hits)
+}
@@ -0,0 +1,274 @@
+// Package paxostest provides fake implementations of the paxos
+// network interfaces, so that code depending on a ConnectionManager
+// can be tested without real connections. Connections record what is
+// sent to them, and the set of connections and the topology are
+// changed explicitly by the test.
+//
+// Unlike the real ConnectionManager, the fakes call subscribers
+// synchronously, from the go-routine of the test.
+package paxostest
+
+import (
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/paxos"
+	eng "goshawkdb.io/server/txnengine"
+	"sync"
+)
+
+// Connection is a fake paxos.Connection to another RM. Every message
+// sent to it is recorded.
+type Connection struct {
+	sync.Mutex
+	HostPort   string
+	Id         common.RMId
+	Boot       uint32
+	TieBreaker uint32
+	Cluster    uint64
+	Learner    bool
+	Degrade    bool
+	sent       [][]byte
+}
+
+func NewConnection(rmId common.RMId, bootCount uint32) *Connection {
+	return &Connection{
+		Id:   rmId,
+		Boot: bootCount,
+	}
+}
+
+func (c *Connection) Host() string        { return c.HostPort }
+func (c *Connection) RMId() common.RMId   { return c.Id }
+func (c *Connection) BootCount() uint32   { return c.Boot }
+func (c *Connection) TieBreak() uint32    { return c.TieBreaker }
+func (c *Connection) ClusterUUId() uint64 { return c.Cluster }
+func (c *Connection) LearnerOnly() bool   { return c.Learner }
+func (c *Connection) Degraded() bool      { return c.Degrade }
+
+func (c *Connection) Send(msg []byte) {
+	c.Lock()
+	defer c.Unlock()
+	c.sent = append(c.sent, msg)
+}
+
+// Sent returns every message sent to the connection so far, oldest
+// first.
+func (c *Connection) Sent() [][]byte {
+	c.Lock()
+	defer c.Unlock()
+	sent := make([][]byte, len(c.sent))
+	copy(sent, c.sent)
+	return sent
+}
+
+// ClearSent forgets every message sent so far.
+func (c *Connection) ClearSent() {
+	c.Lock()
+	defer c.Unlock()
+	c.sent = nil
+}
+
+// ServerConnectionPublisher is a fake paxos.ServerConnectionPublisher
+// whose set of connections is controlled by the test through
+// Establish and Lose.
+type ServerConnectionPublisher struct {
+	lock    sync.Mutex
+	servers map[common.RMId]paxos.Connection
+	subs    map[paxos.ServerConnectionSubscriber]server.EmptyStruct
+}
+
+func NewServerConnectionPublisher(conns ...paxos.Connection) *ServerConnectionPublisher {
+	pub := &ServerConnectionPublisher{
+		servers: make(map[common.RMId]paxos.Connection, len(conns)),
+		subs:    make(map[paxos.ServerConnectionSubscriber]server.EmptyStruct),
+	}
+	for _, conn := range conns {
+		pub.servers[conn.RMId()] = conn
+	}
+	return pub
+}
+
+// AddServerConnectionSubscriber adds obs, and immediately calls its
+// ConnectedRMs with the current connections.
+func (pub *ServerConnectionPublisher) AddServerConnectionSubscriber(obs paxos.ServerConnectionSubscriber) {
+	pub.lock.Lock()
+	pub.subs[obs] = server.EmptyStructVal
+	servers := pub.serversCopy()
+	pub.lock.Unlock()
+	obs.ConnectedRMs(servers)
+}
+
+func (pub *ServerConnectionPublisher) RemoveServerConnectionSubscriber(obs paxos.ServerConnectionSubscriber) {
+	pub.lock.Lock()
+	defer pub.lock.Unlock()
+	delete(pub.subs, obs)
+}
+
+// Subscribers returns the number of current subscribers.
+func (pub *ServerConnectionPublisher) Subscribers() int {
+	pub.lock.Lock()
+	defer pub.lock.Unlock()
+	return len(pub.subs)
+}
+
+// Connections returns a copy of the current connections.
+func (pub *ServerConnectionPublisher) Connections() map[common.RMId]paxos.Connection {
+	pub.lock.Lock()
+	defer pub.lock.Unlock()
+	return pub.serversCopy()
+}
+
+// Establish adds (or replaces) conn, and tells every subscriber. It
+// returns once every subscriber has called the done func it was
+// given.
+func (pub *ServerConnectionPublisher) Establish(conn paxos.Connection) {
+	pub.lock.Lock()
+	pub.servers[conn.RMId()] = conn
+	servers := pub.serversCopy()
+	subs := pub.subsCopy()
+	pub.lock.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(len(subs))
+	for _, sub := range subs {
+		sub.ConnectionEstablished(conn.RMId(), conn, servers, wg.Done)
+	}
+	wg.Wait()
+}
+
+// Lose removes the connection to rmId, and tells every subscriber.
+func (pub *ServerConnectionPublisher) Lose(rmId common.RMId) {
+	pub.lock.Lock()
+	delete(pub.servers, rmId)
+	servers := pub.serversCopy()
+	subs := pub.subsCopy()
+	pub.lock.Unlock()
+
+	for _, sub := range subs {
+		sub.ConnectionLost(rmId, servers)
+	}
+}
+
+func (pub *ServerConnectionPublisher) serversCopy() map[common.RMId]paxos.Connection {
+	servers := make(map[common.RMId]paxos.Connection, len(pub.servers))
+	for rmId, conn := range pub.servers {
+		servers[rmId] = conn
+	}
+	return servers
+}
+
+func (pub *ServerConnectionPublisher) subsCopy() []paxos.ServerConnectionSubscriber {
+	subs := make([]paxos.ServerConnectionSubscriber, 0, len(pub.subs))
+	for sub := range pub.subs {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// ConnectionManager is a fake paxos.ConnectionManager. Its server
+// connections are controlled through the embedded
+// ServerConnectionPublisher, and its topology through
+// ChangeTopology.
+type ConnectionManager struct {
+	*ServerConnectionPublisher
+	bootCount    uint32
+	lock         sync.Mutex
+	topology     *configuration.Topology
+	topologySubs []map[eng.TopologySubscriber]server.EmptyStruct
+	clients      map[uint32]paxos.ClientConnection
+}
+
+func NewConnectionManager(bootCount uint32, topology *configuration.Topology, conns ...paxos.Connection) *ConnectionManager {
+	topologySubs := make([]map[eng.TopologySubscriber]server.EmptyStruct, eng.TopologyChangeSubscriberTypeLimit)
+	for idx := range topologySubs {
+		topologySubs[idx] = make(map[eng.TopologySubscriber]server.EmptyStruct)
+	}
+	return &ConnectionManager{
+		ServerConnectionPublisher: NewServerConnectionPublisher(conns...),
+		bootCount:                 bootCount,
+		topology:                  topology,
+		topologySubs:              topologySubs,
+		clients:                   make(map[uint32]paxos.ClientConnection),
+	}
+}
+
+func (cm *ConnectionManager) BootCount() uint32 {
+	return cm.bootCount
+}
+
+func (cm *ConnectionManager) AddTopologySubscriber(subType eng.TopologyChangeSubscriberType, obs eng.TopologySubscriber) *configuration.Topology {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	cm.topologySubs[subType][obs] = server.EmptyStructVal
+	return cm.topology
+}
+
+func (cm *ConnectionManager) RemoveTopologySubscriberAsync(subType eng.TopologyChangeSubscriberType, obs eng.TopologySubscriber) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	delete(cm.topologySubs[subType], obs)
+}
+
+// Topology returns the current topology.
+func (cm *ConnectionManager) Topology() *configuration.Topology {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	return cm.topology
+}
+
+// ChangeTopology installs topology, and calls TopologyChanged on every
+// topology subscriber, in order of subscriber type. It waits for every
+// subscriber to call its done func, and returns true iff they all
+// called it with true.
+func (cm *ConnectionManager) ChangeTopology(topology *configuration.Topology) bool {
+	cm.lock.Lock()
+	cm.topology = topology
+	subs := make([]eng.TopologySubscriber, 0, len(cm.topologySubs))
+	for _, subsOfType := range cm.topologySubs {
+		for sub := range subsOfType {
+			subs = append(subs, sub)
+		}
+	}
+	cm.lock.Unlock()
+
+	resultChan := make(chan bool, len(subs))
+	for _, sub := range subs {
+		sub.TopologyChanged(topology, func(result bool) { resultChan <- result })
+	}
+	result := true
+	for range subs {
+		result = <-resultChan && result
+	}
+	return result
+}
+
+// ClientEstablished records conn, and returns the current server
+// connections.
+func (cm *ConnectionManager) ClientEstablished(connNumber uint32, conn paxos.ClientConnection) map[common.RMId]paxos.Connection {
+	cm.lock.Lock()
+	cm.clients[connNumber] = conn
+	cm.lock.Unlock()
+	return cm.Connections()
+}
+
+func (cm *ConnectionManager) ClientLost(connNumber uint32, conn paxos.ClientConnection) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	delete(cm.clients, connNumber)
+}
+
+func (cm *ConnectionManager) GetClient(bootNumber, connNumber uint32) paxos.ClientConnection {
+	if bootNumber != cm.bootCount && bootNumber != 0 {
+		return nil
+	}
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	return cm.clients[connNumber]
+}
+
+var (
+	_ paxos.Connection                = (*Connection)(nil)
+	_ paxos.ServerConnectionPublisher = (*ServerConnectionPublisher)(nil)
+	_ paxos.ConnectionManager         = (*ConnectionManager)(nil)
+)
@@ -0,0 +1,39 @@
+package paxostest
+
+import (
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/paxos"
+	"testing"
+)
+
+func TestOneShotSender(t *testing.T) {
+	connA := NewConnection(common.RMId(1), 1)
+	connB := NewConnection(common.RMId(2), 1)
+	pub := NewServerConnectionPublisher(connA)
+	msg := []byte("hello")
+
+	paxos.NewOneShotSender(msg, pub, connA.RMId(), connB.RMId())
+	if sent := connA.Sent(); len(sent) != 1 || string(sent[0]) != string(msg) {
+		t.Fatalf("Expected %v to have been sent the message once; got %v", connA.RMId(), sent)
+	}
+	if sent := connB.Sent(); len(sent) != 0 {
+		t.Fatalf("Expected nothing sent to %v before it connected; got %v", connB.RMId(), sent)
+	}
+	if subs := pub.Subscribers(); subs != 1 {
+		t.Fatalf("Expected the sender to still be subscribed; %v subscribers", subs)
+	}
+
+	pub.Establish(connB)
+	if sent := connB.Sent(); len(sent) != 1 || string(sent[0]) != string(msg) {
+		t.Fatalf("Expected %v to have been sent the message once; got %v", connB.RMId(), sent)
+	}
+	if subs := pub.Subscribers(); subs != 0 {
+		t.Fatalf("Expected the sender to have unsubscribed; %v subscribers", subs)
+	}
+
+	pub.Lose(connA.RMId())
+	pub.Establish(connA)
+	if sent := connA.Sent(); len(sent) != 1 {
+		t.Fatalf("Expected %v to have been sent the message only once; got %v", connA.RMId(), sent)
+	}
+}
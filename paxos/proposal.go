@@ -23,6 +23,7 @@ type proposal struct {
 	pending            []*proposalInstance
 	abortInstances     []common.RMId
 	finished           bool
+	log                *server.Logger
 }
 
 func NewProposal(pm *ProposerManager, txn *eng.TxnReader, fInc int, ballots []*eng.Ballot, instanceRMId common.RMId, acceptors []common.RMId, skipPhase1 bool) *proposal {
@@ -51,6 +52,7 @@ func NewProposal(pm *ProposerManager, txn *eng.TxnReader, fInc int, ballots []*e
 		instances:          make(map[common.VarUUId]*proposalInstance, len(ballots)),
 		pending:            make([]*proposalInstance, 0, len(ballots)),
 		finished:           false,
+		log:                pm.log.With("txn", txn.Id, "instance", instanceRMId),
 	}
 	for _, ballot := range ballots {
 		pi := newProposalInstance(p, ballot)
@@ -109,7 +111,7 @@ func (p *proposal) maybeSendOneA() {
 		pi.addOneAToProposal(&proposal, sender)
 	}
 	sender.msg = server.SegToBytes(seg)
-	server.Log(txnId, "Adding sender for 1A")
+	p.log.Log("Adding sender for 1A")
 	p.proposerManager.AddServerConnectionSubscriber(sender)
 }
 
@@ -149,7 +151,7 @@ func (p *proposal) maybeSendTwoA() {
 	}
 	twoACap.SetTxn(p.txn.Data)
 	sender.msg = server.SegToBytes(seg)
-	server.Log(p.txn.Id, "Adding sender for 2A")
+	p.log.Log("Adding sender for 2A")
 	p.proposerManager.AddServerConnectionSubscriber(sender)
 }
 
@@ -173,12 +175,12 @@ func (p *proposal) FinishProposing() []common.RMId {
 	for _, pi := range p.instances {
 		if sender := pi.oneASender; sender != nil {
 			pi.oneASender = nil
-			server.Log(p.txn.Id, "finishing sender for 1A")
+			p.log.Log("finishing sender for 1A")
 			sender.finished()
 		}
 		if sender := pi.twoASender; sender != nil {
 			pi.twoASender = nil
-			server.Log(p.txn.Id, pi.ballot.VarUUId, "finishing sender for 2A")
+			p.log.Log(pi.ballot.VarUUId, "finishing sender for 2A")
 			sender.finished()
 		}
 	}
@@ -325,7 +327,8 @@ func (oneB *proposalOneB) oneBTxnVotesReceived(sender common.RMId, promise *msgs
 			oneB.winningBallot = accepted.Ballot()
 		}
 	default:
-		panic(fmt.Sprintf("Unexpected promise type: %v", promise.Which()))
+		server.UnknownEnumReceived("promise type", promise.Which(), sender)
+		return
 	}
 	found := false
 	for _, rmId := range oneB.promisesReceivedFrom {
@@ -453,7 +456,7 @@ func (s *proposalSender) instanceComplete(pi *proposalInstance) {
 func (s *proposalSender) finished() {
 	if !s.done {
 		s.done = true
-		server.Log("Removing proposal sender")
+		s.log.Log("Removing proposal sender")
 		s.proposerManager.RemoveServerConnectionSubscriber(s)
 	}
 }
@@ -509,7 +512,7 @@ func (s *proposalSender) ConnectionLost(lost common.RMId, conns map[common.RMId]
 						break
 					}
 					ballots := MakeAbortBallots(s.proposal.txn, &alloc)
-					server.Log(s.proposal.txn.Id, "Trying to abort", rmId, "due to lost submitter", lost, "Found actions:", len(ballots))
+					s.log.Log("Trying to abort", rmId, "due to lost submitter", lost, "Found actions:", len(ballots))
 					s.proposal.abortInstances = append(s.proposal.abortInstances, rmId)
 					s.proposal.proposerManager.NewPaxosProposals(
 						s.txn, s.fInc, ballots, s.proposal.acceptors, rmId, false)
@@ -533,7 +536,7 @@ func (s *proposalSender) ConnectionLost(lost common.RMId, conns map[common.RMId]
 			}
 		}
 		ballots := MakeAbortBallots(s.proposal.txn, alloc)
-		server.Log(s.proposal.txn.Id, "Trying to abort for", lost, "Found actions:", len(ballots))
+		s.log.Log("Trying to abort for", lost, "Found actions:", len(ballots))
 		s.proposal.abortInstances = append(s.proposal.abortInstances, lost)
 		s.proposal.proposerManager.NewPaxosProposals(
 			s.txn, s.fInc, ballots, s.proposal.acceptors, lost, false)
@@ -3,13 +3,12 @@ package paxos
 import (
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/configuration"
+	"goshawkdb.io/server/db"
 	eng "goshawkdb.io/server/txnengine"
-	"log"
 )
 
 type ProposerMode uint8
@@ -21,15 +20,34 @@ const (
 	proposerTLCSender      ProposerMode = iota
 )
 
+func (pm ProposerMode) String() string {
+	switch pm {
+	case ProposerActiveVoter:
+		return "ActiveVoter"
+	case ProposerActiveLearner:
+		return "ActiveLearner"
+	case ProposerPassiveLearner:
+		return "PassiveLearner"
+	case proposerTLCSender:
+		return "TLCSender"
+	default:
+		return fmt.Sprintf("ProposerMode(%d)", uint8(pm))
+	}
+}
+
 type Proposer struct {
 	proposerManager *ProposerManager
 	mode            ProposerMode
 	txn             *eng.Txn
 	txnId           *common.TxnId
 	acceptors       common.RMIds
+	submitter       common.RMId
+	log             *server.Logger
 	topology        *configuration.Topology
 	fInc            int
 	currentState    proposerStateMachineComponent
+	traceContext    []byte
+	span            server.Span
 	proposerAwaitBallots
 	proposerReceiveOutcomes
 	proposerAwaitLocallyComplete
@@ -48,8 +66,11 @@ func NewProposer(pm *ProposerManager, txn *eng.TxnReader, mode ProposerMode, top
 		mode:            mode,
 		txnId:           txn.Id,
 		acceptors:       GetAcceptorsFromTxn(txnCap),
+		submitter:       common.RMId(txnCap.Submitter()),
+		log:             pm.log.With("txn", txn.Id),
 		topology:        topology,
 		fInc:            int(txnCap.FInc()),
+		traceContext:    txnCap.TraceContext(),
 	}
 	if mode == ProposerActiveVoter {
 		p.txn = eng.TxnFromReader(pm.Exe, pm.VarDispatcher, p, pm.RMId, txn)
@@ -79,6 +100,7 @@ func ProposerFromData(pm *ProposerManager, txnId *common.TxnId, data []byte, top
 		mode:            proposerTLCSender,
 		txnId:           txnId,
 		acceptors:       acceptors,
+		log:             pm.log.With("txn", txnId),
 		topology:        topology,
 		fInc:            -1,
 	}
@@ -110,6 +132,8 @@ func (p *Proposer) Start() {
 	case proposerTLCSender:
 		p.currentState = &p.proposerReceiveGloballyComplete
 	}
+	p.span = server.StartSpan("proposer", p.txnId, p.traceContext)
+	p.span.LogEvent(fmt.Sprint(p.currentState))
 
 	if p.topology != nil {
 		topology := p.topology
@@ -122,11 +146,11 @@ func (p *Proposer) Start() {
 
 func (p *Proposer) Status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("Proposer for %v", p.txnId))
-	sc.Emit(fmt.Sprintf("- Mode: %v", p.mode))
-	sc.Emit(fmt.Sprintf("- Current state: %v", p.currentState))
+	sc.EmitKV("Mode", p.mode)
+	sc.EmitKV("Current state", p.currentState)
 	sc.Emit("- Outcome Accumulator")
 	p.outcomeAccumulator.Status(sc.Fork())
-	sc.Emit(fmt.Sprintf("- Locally Complete? %v", p.locallyCompleted))
+	sc.EmitKV("Locally Complete", p.locallyCompleted)
 	if p.txn != nil {
 		sc.Emit("- Txn")
 		p.txn.Status(sc.Fork())
@@ -140,7 +164,7 @@ func (p *Proposer) TopologyChange(topology *configuration.Topology) {
 	}
 	p.topology = topology
 	rmsRemoved := topology.RMsRemoved()
-	server.Log("proposer", p.txnId, "in", p.currentState, "sees loss of", rmsRemoved)
+	p.log.Log("Proposer in", p.currentState, "sees loss of", rmsRemoved)
 	if _, found := rmsRemoved[p.proposerManager.RMId]; found {
 		return
 	}
@@ -186,8 +210,10 @@ func (p *Proposer) nextState() {
 		p.currentState = &p.proposerAwaitFinished
 	case &p.proposerAwaitFinished:
 		p.currentState = nil
+		p.span.Finish()
 		return
 	}
+	p.span.LogEvent(fmt.Sprint(p.currentState))
 	p.currentState.start()
 }
 
@@ -223,27 +249,26 @@ func (pab *proposerAwaitBallots) String() string {
 
 func (pab *proposerAwaitBallots) TxnBallotsComplete(ballots ...*eng.Ballot) {
 	if pab.currentState == pab {
-		server.Log(pab.txnId, "TxnBallotsComplete callback. Acceptors:", pab.acceptors)
+		pab.log.Log("TxnBallotsComplete callback. Acceptors:", pab.acceptors)
 		if !pab.allAcceptorsAgreed {
 			pab.proposerManager.NewPaxosProposals(pab.txn.TxnReader, pab.fInc, ballots, pab.acceptors, pab.proposerManager.RMId, true)
 		}
 		pab.nextState()
 
 	} else if pab.txn.Retry && pab.currentState == &pab.proposerReceiveOutcomes {
-		server.Log(pab.txnId, "TxnBallotsComplete (retry) callback with existing proposals")
+		pab.log.Log("TxnBallotsComplete (retry) callback with existing proposals")
 		if !pab.allAcceptorsAgreed {
 			pab.proposerManager.AddToPaxosProposals(pab.txnId, ballots, pab.proposerManager.RMId)
 		}
 
 	} else if !pab.txn.Retry {
-		log.Printf("Error: %v TxnBallotsComplete callback invoked in wrong state (%v)\n",
-			pab.txnId, pab.currentState)
+		pab.log.Error("TxnBallotsComplete callback invoked in wrong state (%v)", pab.currentState)
 	}
 }
 
 func (pab *proposerAwaitBallots) Abort() {
 	if pab.currentState == pab && !pab.allAcceptorsAgreed {
-		server.Log(pab.txnId, "Proposer Aborting")
+		pab.log.Log("Proposer Aborting")
 		txn := pab.txn.TxnReader
 		alloc := AllocForRMId(txn.Txn, pab.proposerManager.RMId)
 		ballots := MakeAbortBallots(txn, alloc)
@@ -282,7 +307,7 @@ type proposerReceiveOutcomes struct {
 
 func (pro *proposerReceiveOutcomes) init(proposer *Proposer) {
 	pro.Proposer = proposer
-	pro.outcomeAccumulator = NewOutcomeAccumulator(pro.fInc, pro.acceptors)
+	pro.outcomeAccumulator = NewOutcomeAccumulator(pro.fInc, pro.acceptors, pro.log)
 }
 
 func (pro *proposerReceiveOutcomes) start() {
@@ -301,7 +326,7 @@ func (pro *proposerReceiveOutcomes) String() string {
 }
 
 func (pro *proposerReceiveOutcomes) BallotOutcomeReceived(sender common.RMId, outcome *msgs.Outcome) {
-	server.Log(pro.txnId, "Ballot outcome received from", sender)
+	pro.log.Log("Ballot outcome received from", sender)
 	if pro.mode == proposerTLCSender {
 		// Consensus already reached and we've been to disk. So this
 		// *must* be a duplicate: safe to ignore.
@@ -324,7 +349,7 @@ func (pro *proposerReceiveOutcomes) BallotOutcomeReceived(sender common.RMId, ou
 			// abort. Therefore we're abandoning this learner, and
 			// sending TLCs immediately to everyone we've received the
 			// abort outcome from.
-			server.Log(pro.txnId, "abandoning learner with all aborts", knownAcceptors)
+			pro.log.Log("abandoning learner with all aborts", knownAcceptors)
 			pro.proposerManager.FinishProposers(pro.txnId)
 			pro.proposerManager.TxnFinished(pro.txnId)
 			tlcMsg := MakeTxnLocallyCompleteMsg(pro.txnId)
@@ -366,7 +391,7 @@ func (palc *proposerAwaitLocallyComplete) init(proposer *Proposer) {
 }
 
 func (palc *proposerAwaitLocallyComplete) start() {
-	server.Log(palc.txnId, "Outcome for txn determined")
+	palc.log.Log("Outcome for txn determined")
 	if palc.txn == nil && palc.outcome.Which() == msgs.OUTCOME_COMMIT {
 		// We are a learner (either active or passive), and the result
 		// has turned out to be a commit.
@@ -389,7 +414,7 @@ func (palc *proposerAwaitLocallyComplete) String() string {
 
 func (palc *proposerAwaitLocallyComplete) TxnLocallyComplete(*eng.Txn) {
 	if palc.currentState == palc && !palc.callbackInvoked {
-		server.Log(palc.txnId, "Txn locally completed")
+		palc.log.Log("Txn locally completed")
 		palc.callbackInvoked = true
 		palc.maybeWriteToDisk()
 	}
@@ -418,8 +443,9 @@ func (palc *proposerAwaitLocallyComplete) maybeWriteToDisk() {
 
 	data := server.SegToBytes(stateSeg)
 
-	future := palc.proposerManager.DB.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
-		rwtxn.Put(palc.proposerManager.DB.Proposers, palc.txnId[:], data, 0)
+	future := palc.proposerManager.DB.ConsensusShard(palc.txnId).ReadWriteTransaction(false, func(rwtxn db.ReadWriteTxn) interface{} {
+		db.Stats.Proposers.Wrote(data)
+		rwtxn.Put(palc.proposerManager.DB.Proposers, palc.txnId[:], data)
 		return true
 	})
 	go func() {
@@ -455,7 +481,7 @@ func (prgc *proposerReceiveGloballyComplete) start() {
 		prgc.mode = proposerTLCSender
 		tlcMsg := MakeTxnLocallyCompleteMsg(prgc.txnId)
 		prgc.tlcSender = NewRepeatingSender(tlcMsg, prgc.acceptors...)
-		server.Log(prgc.txnId, "Adding TLC Sender to", prgc.acceptors)
+		prgc.log.Log("Adding TLC Sender to", prgc.acceptors)
 		prgc.proposerManager.AddServerConnectionSubscriber(prgc.tlcSender)
 	}
 }
@@ -475,8 +501,7 @@ func (prgc *proposerReceiveGloballyComplete) TxnGloballyCompleteReceived(sender
 	// could just be a duplicate from some acceptor that's got bounced.
 	// But we should not receive any TGC until we've issued TLCs.
 	if !prgc.locallyCompleted {
-		log.Printf("Error: %v globally complete received from %v without us issuing locally complete. (%v)\n",
-			prgc.txnId, sender, prgc.currentState)
+		prgc.log.Error("Globally complete received from %v without us issuing locally complete (%v)", sender, prgc.currentState)
 	}
 }
 
@@ -504,11 +529,12 @@ func (paf *proposerAwaitFinished) String() string {
 }
 
 func (paf *proposerAwaitFinished) TxnFinished(*eng.Txn) {
-	server.Log(paf.txnId, "Txn Finished Callback")
+	paf.log.Log("Txn Finished Callback")
 	if paf.currentState == paf {
 		paf.nextState()
-		future := paf.proposerManager.DB.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
-			rwtxn.Del(paf.proposerManager.DB.Proposers, paf.txnId[:], nil)
+		future := paf.proposerManager.DB.ConsensusShard(paf.txnId).ReadWriteTransaction(false, func(rwtxn db.ReadWriteTxn) interface{} {
+			db.Stats.Proposers.Deleted()
+			rwtxn.Del(paf.proposerManager.DB.Proposers, paf.txnId[:])
 			return true
 		})
 		go func() {
@@ -523,7 +549,6 @@ func (paf *proposerAwaitFinished) TxnFinished(*eng.Txn) {
 			}
 		}()
 	} else {
-		log.Printf("Error: %v TxnFinished callback invoked with proposer in wrong state: %v",
-			paf.txnId, paf.currentState)
+		paf.log.Error("TxnFinished callback invoked with proposer in wrong state: %v", paf.currentState)
 	}
 }
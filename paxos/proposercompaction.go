@@ -0,0 +1,167 @@
+package paxos
+
+import (
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	"goshawkdb.io/server/db"
+	"time"
+)
+
+// A proposer's state is deleted from disk once its txn is globally
+// complete, just before the proposer itself is forgotten. So a record
+// in the Proposers table without a live (or spilled) proposer has
+// been left behind: for example, because it could not be loaded.
+// Compaction finds such orphans and, once they have been orphaned for
+// longer than a horizon, deals with them. Only the orphans of txns
+// known to be globally complete (we hold their outcome, which is only
+// added once every acceptor has sent TGC) are deleted. The rest are
+// reloaded: their acceptors may still be waiting for our TLCs, and a
+// reloaded proposer resends them, and deletes its record on TGC.
+type proposerCompaction struct {
+	orphans      map[common.TxnId]time.Time
+	runs         uint64
+	lastRun      time.Time
+	removed      uint64
+	removedBytes uint64
+	reloaded     uint64
+}
+
+func (pc *proposerCompaction) status(sc *server.StatusConsumer) {
+	sc.EmitKV("Compaction runs", pc.runs)
+	sc.EmitKV("Compaction last run", pc.lastRun)
+	sc.EmitKV("Orphaned proposer records", len(pc.orphans))
+	sc.EmitKV("Reclaimed proposer records", pc.removed)
+	sc.EmitKV("Reclaimed proposer bytes", pc.removedBytes)
+	sc.EmitKV("Reloaded proposer records", pc.reloaded)
+}
+
+// Compact scans the Proposers table, and deletes (or reloads) every
+// record which has had no live proposer for longer than horizon.
+// Orphans are tracked from one Compact to the next, so a record is
+// only dealt with by a Compact at least horizon after the one which
+// first found it.
+func (pd *ProposerDispatcher) Compact(horizon time.Duration) error {
+	managerRecords := make([]map[common.TxnId][]byte, pd.ExecutorCount)
+	for idx := range managerRecords {
+		managerRecords[idx] = make(map[common.TxnId][]byte)
+	}
+	ran, err := pd.db.IterateConsensus(pd.db.Proposers, func(txnIdData, proposerState []byte) bool {
+		db.Stats.Proposers.Read(proposerState, nil)
+		txnId := common.MakeTxnId(txnIdData)
+		idx := uint8(txnId[server.MostRandomByteIndex]) % pd.ExecutorCount
+		// the value is only valid for the duration of the txn.
+		managerRecords[idx][*txnId] = append([]byte(nil), proposerState...)
+		return true
+	})
+	if err != nil || !ran {
+		return err
+	}
+	now := time.Now()
+	for idx, records := range managerRecords {
+		manager, recordsCopy := pd.proposermanagers[idx], records
+		pd.Executors[idx].Enqueue(func() { manager.compact(recordsCopy, now, horizon) })
+	}
+	return nil
+}
+
+func (pm *ProposerManager) compact(records map[common.TxnId][]byte, now time.Time, horizon time.Duration) {
+	pc := &pm.compaction
+	live := func(txnId *common.TxnId) bool {
+		_, found := pm.proposers[*txnId]
+		return found || pm.spill.isSpilled(txnId)
+	}
+	complete := func(txnId *common.TxnId) bool {
+		_, found := pm.outcomes.get(txnId)
+		return found
+	}
+	expired, reload := pc.sweep(records, now, horizon, live, complete)
+
+	reloaded := 0
+	for _, txnId := range reload {
+		txnIdCopy := txnId
+		if err := pm.loadFromData(&txnIdCopy, records[txnId]); err != nil {
+			// It stays an orphan, and is tried again next time.
+			pm.txnLog(&txnIdCopy).Error("ProposerManager unable to reload orphaned proposer: %v", err)
+			continue
+		}
+		delete(pc.orphans, txnId)
+		reloaded++
+	}
+	if reloaded != 0 {
+		pc.reloaded += uint64(reloaded)
+		pm.log.Info("ProposerManager reloaded %v orphaned proposers which may not be globally complete", reloaded)
+	}
+	if len(expired) == 0 {
+		return
+	}
+	expiredBytes := 0
+	for _, txnId := range expired {
+		expiredBytes += len(txnId) + len(records[txnId])
+	}
+
+	shards := make(map[db.StorageEngine][]common.TxnId)
+	for _, txnId := range expired {
+		txnIdCopy := txnId
+		shard := pm.DB.ConsensusShard(&txnIdCopy)
+		shards[shard] = append(shards[shard], txnId)
+	}
+	futures := make([]db.Future, 0, len(shards))
+	for shard, txnIds := range shards {
+		txnIdsCopy := txnIds
+		futures = append(futures, shard.ReadWriteTransaction(false, func(rwtxn db.ReadWriteTxn) interface{} {
+			for _, txnId := range txnIdsCopy {
+				db.Stats.Proposers.Deleted()
+				rwtxn.Del(pm.DB.Proposers, txnId[:])
+			}
+			return true
+		}))
+	}
+	go func() {
+		for _, future := range futures {
+			if ran, err := future.ResultError(); err != nil {
+				pm.log.Error("ProposerManager unable to delete orphaned proposers: %v", err)
+				return
+			} else if ran == nil {
+				return
+			}
+		}
+		pm.Exe.Enqueue(func() {
+			for _, txnId := range expired {
+				delete(pc.orphans, txnId)
+			}
+			pc.removed += uint64(len(expired))
+			pc.removedBytes += uint64(expiredBytes)
+			pm.log.Info("ProposerManager deleted %v orphaned proposers (%v bytes)", len(expired), expiredBytes)
+		})
+	}()
+}
+
+// sweep updates the orphans from records, the Proposers table as
+// found by a Compact at now. It returns the records which have been
+// orphaned for at least horizon, split into those whose txns are
+// globally complete, which can be deleted, and those which must be
+// reloaded.
+func (pc *proposerCompaction) sweep(records map[common.TxnId][]byte, now time.Time, horizon time.Duration, live, complete func(*common.TxnId) bool) (expired, reload []common.TxnId) {
+	pc.runs++
+	pc.lastRun = now
+	for txnId := range pc.orphans {
+		if _, found := records[txnId]; !found {
+			delete(pc.orphans, txnId)
+		}
+	}
+	for txnId := range records {
+		txnIdCopy := txnId
+		if live(&txnIdCopy) {
+			delete(pc.orphans, txnId)
+		} else if orphaned, found := pc.orphans[txnId]; !found {
+			pc.orphans[txnId] = now
+		} else if now.Sub(orphaned) < horizon {
+			continue
+		} else if complete(&txnIdCopy) {
+			expired = append(expired, txnId)
+		} else {
+			reload = append(reload, txnId)
+		}
+	}
+	return expired, reload
+}
@@ -0,0 +1,58 @@
+package paxos
+
+import (
+	"goshawkdb.io/common"
+	"testing"
+	"time"
+)
+
+func TestProposerCompactionSweep(t *testing.T) {
+	makeTxnId := func(b byte) *common.TxnId {
+		key := make([]byte, common.KeyLen)
+		key[0] = b
+		return common.MakeTxnId(key)
+	}
+	liveId, completeId, neededId := makeTxnId(1), makeTxnId(2), makeTxnId(3)
+	records := map[common.TxnId][]byte{
+		*liveId:     []byte("live"),
+		*completeId: []byte("complete"),
+		*neededId:   []byte("needed"),
+	}
+	live := func(txnId *common.TxnId) bool { return *txnId == *liveId }
+	complete := func(txnId *common.TxnId) bool { return *txnId == *completeId }
+
+	pc := &proposerCompaction{orphans: make(map[common.TxnId]time.Time)}
+	horizon := time.Minute
+	start := time.Now()
+
+	// The first sweep finds the orphans, but acts on none of them.
+	if expired, reload := pc.sweep(records, start, horizon, live, complete); len(expired) != 0 || len(reload) != 0 {
+		t.Fatalf("Expected nothing to be done on the first sweep; got %v, %v", expired, reload)
+	}
+	if len(pc.orphans) != 2 {
+		t.Fatalf("Expected 2 orphans; got %v", pc.orphans)
+	}
+
+	// Nor before the horizon.
+	if expired, reload := pc.sweep(records, start.Add(horizon/2), horizon, live, complete); len(expired) != 0 || len(reload) != 0 {
+		t.Fatalf("Expected nothing to be done before the horizon; got %v, %v", expired, reload)
+	}
+
+	// After the horizon, only the globally complete orphan may be
+	// deleted. The other is still needed: its acceptors may be
+	// waiting for our TLCs, so it must be reloaded.
+	expired, reload := pc.sweep(records, start.Add(horizon), horizon, live, complete)
+	if len(expired) != 1 || expired[0] != *completeId {
+		t.Fatalf("Expected only %v to have expired; got %v", completeId, expired)
+	}
+	if len(reload) != 1 || reload[0] != *neededId {
+		t.Fatalf("Expected %v to be reloaded; got %v", neededId, reload)
+	}
+
+	// Records which have gone are no longer orphans.
+	delete(records, *completeId)
+	pc.sweep(records, start.Add(horizon), horizon, live, complete)
+	if _, found := pc.orphans[*completeId]; found || len(pc.orphans) != 1 {
+		t.Fatalf("Expected only %v to still be an orphan; got %v", neededId, pc.orphans)
+	}
+}
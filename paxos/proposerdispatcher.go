@@ -2,31 +2,30 @@ package paxos
 
 import (
 	"fmt"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
 	"goshawkdb.io/server/db"
 	"goshawkdb.io/server/dispatcher"
 	eng "goshawkdb.io/server/txnengine"
-	"log"
 )
 
 type ProposerDispatcher struct {
 	dispatcher.Dispatcher
 	proposermanagers []*ProposerManager
+	db               *db.Databases
 }
 
 func NewProposerDispatcher(count uint8, rmId common.RMId, cm ConnectionManager, db *db.Databases, varDispatcher *eng.VarDispatcher) *ProposerDispatcher {
 	pd := &ProposerDispatcher{
 		proposermanagers: make([]*ProposerManager, count),
+		db:               db,
 	}
-	pd.Dispatcher.Init(count)
+	pd.Dispatcher.Init("Proposer", count)
 	for idx, exe := range pd.Executors {
 		pd.proposermanagers[idx] = NewProposerManager(exe, rmId, cm, db, varDispatcher)
 	}
-	pd.loadFromDisk(db)
+	pd.loadFromDisk(rmId, db)
 	return pd
 }
 
@@ -48,9 +47,13 @@ func (pd *ProposerDispatcher) TwoBTxnVotesReceived(sender common.RMId, twoBTxnVo
 		txnId = common.MakeTxnId(twoBTxnVotes.Failures().TxnId())
 	case msgs.TWOBTXNVOTES_OUTCOME:
 		txn = eng.TxnReaderFromData(twoBTxnVotes.Outcome().Txn())
+		if txn.VerifyChecksum(sender) != nil {
+			return
+		}
 		txnId = txn.Id
 	default:
-		panic(fmt.Sprintf("Unexpected 2BVotes type: %v", twoBTxnVotes.Which()))
+		server.UnknownEnumReceived("2BVotes type", twoBTxnVotes.Which(), sender)
+		return
 	}
 	pd.withProposerManager(txnId, func(pm *ProposerManager) { pm.TwoBTxnVotesReceived(sender, txnId, txn, twoBTxnVotes) })
 }
@@ -77,53 +80,69 @@ func (pd *ProposerDispatcher) ImmigrationReceived(migration *msgs.Migration, sta
 	}
 }
 
+// TxnOutcome asynchronously looks up what the local proposer knows of
+// txnId, handing the result to callback on the proposer's executor. If
+// the lookup cannot be enqueued (we're shutting down), callback is
+// never invoked and false is returned.
+func (pd *ProposerDispatcher) TxnOutcome(txnId *common.TxnId, callback func(TxnOutcomeStatus, *msgs.Outcome)) bool {
+	return pd.withProposerManager(txnId, func(pm *ProposerManager) { callback(pm.TxnOutcome(txnId)) })
+}
+
+// ListProposers returns a summary of every live proposer.
+func (pd *ProposerDispatcher) ListProposers() []ProposerSummary {
+	summaries := []ProposerSummary{}
+	for idx, executor := range pd.Executors {
+		manager := pd.proposermanagers[idx]
+		executor.EnqueueSync(func() { summaries = append(summaries, manager.ListProposers()...) })
+	}
+	return summaries
+}
+
+// AbortTxn makes the local proposer for txnId vote to abort the txn,
+// exactly as if the txn's submitter had asked for it to be aborted. It
+// returns false if there is no such proposer, or it has already voted
+// or learnt the outcome.
+func (pd *ProposerDispatcher) AbortTxn(txnId *common.TxnId) bool {
+	aborted := false
+	pd.withProposerManagerSync(txnId, func(pm *ProposerManager) { aborted = pm.AbortTxn(txnId) })
+	return aborted
+}
+
 func (pd *ProposerDispatcher) Status(sc *server.StatusConsumer) {
 	sc.Emit("Proposers")
 	for idx, executor := range pd.Executors {
 		s := sc.Fork()
-		s.Emit(fmt.Sprintf("Proposer Manager %v", idx))
+		s.Emit(fmt.Sprintf("Proposer Manager %v (%v)", idx, executor.Metrics()))
 		manager := pd.proposermanagers[idx]
 		executor.Enqueue(func() { manager.Status(s) })
 	}
 	sc.Join()
 }
 
-func (pd *ProposerDispatcher) loadFromDisk(db *db.Databases) {
-	res, err := db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		res, _ := rtxn.WithCursor(db.Proposers, func(cursor *mdbs.Cursor) interface{} {
-			// cursor.Get returns a copy of the data. So it's fine for us
-			// to store and process this later - it's not about to be
-			// overwritten on disk.
-			proposerStates := make(map[*common.TxnId][]byte)
-			txnIdData, proposerState, err := cursor.Get(nil, nil, mdb.FIRST)
-			for ; err == nil; txnIdData, proposerState, err = cursor.Get(nil, nil, mdb.NEXT) {
-				txnId := common.MakeTxnId(txnIdData)
-				proposerStates[txnId] = proposerState
-			}
-			if err == mdb.NotFound {
-				// fine, we just fell off the end as expected.
-				return proposerStates
-			} else {
-				cursor.Error(err)
-				return nil
-			}
-		})
-		return res
-	}).ResultError()
+func (pd *ProposerDispatcher) loadFromDisk(rmId common.RMId, dbs *db.Databases) {
+	// Iterate gives us copies of the data. So it's fine for us to
+	// store and process this later - it's not about to be
+	// overwritten on disk.
+	proposerStates := make(map[*common.TxnId][]byte)
+	ran, err := dbs.IterateConsensus(dbs.Proposers, func(txnIdData, proposerState []byte) bool {
+		db.Stats.Proposers.Read(proposerState, nil)
+		txnId := common.MakeTxnId(txnIdData)
+		proposerStates[txnId] = proposerState
+		return true
+	})
 	if err != nil {
 		panic(fmt.Sprintf("ProposerDispatcher error loading from disk: %v", err))
-	} else if res != nil {
-		proposerStates := res.(map[*common.TxnId][]byte)
+	} else if ran {
 		for txnId, proposerState := range proposerStates {
 			proposerStateCopy := proposerState
 			txnIdCopy := txnId
 			pd.withProposerManager(txnIdCopy, func(pm *ProposerManager) {
 				if err := pm.loadFromData(txnIdCopy, proposerStateCopy); err != nil {
-					log.Printf("ProposerDispatcher error loading %v from disk: %v\n", txnIdCopy, err)
+					pm.txnLog(txnIdCopy).Error("ProposerDispatcher unable to load proposer from disk: %v", err)
 				}
 			})
 		}
-		log.Printf("Loaded %v proposers from disk\n", len(proposerStates))
+		debugLog.With("rm", rmId).Info("Loaded %v proposers from disk", len(proposerStates))
 	}
 }
 
@@ -131,5 +150,12 @@ func (pd *ProposerDispatcher) withProposerManager(txnId *common.TxnId, fun func(
 	idx := uint8(txnId[server.MostRandomByteIndex]) % pd.ExecutorCount
 	executor := pd.Executors[idx]
 	manager := pd.proposermanagers[idx]
-	return executor.Enqueue(func() { fun(manager) })
+	return executor.EnqueueFor(txnId, func() { fun(manager) })
+}
+
+func (pd *ProposerDispatcher) withProposerManagerSync(txnId *common.TxnId, fun func(*ProposerManager)) bool {
+	idx := uint8(txnId[server.MostRandomByteIndex]) % pd.ExecutorCount
+	executor := pd.Executors[idx]
+	manager := pd.proposermanagers[idx]
+	return executor.EnqueueSync(func() { fun(manager) })
 }
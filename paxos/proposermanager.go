@@ -4,8 +4,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
@@ -13,11 +11,11 @@ import (
 	"goshawkdb.io/server/db"
 	"goshawkdb.io/server/dispatcher"
 	eng "goshawkdb.io/server/txnengine"
-	"log"
+	"time"
 )
 
 func init() {
-	db.DB.Proposers = &mdbs.DBISettings{Flags: mdb.CREATE}
+	db.DB.Proposers = db.DeclareTable("Proposers")
 }
 
 const ( //                  txnId  rmId
@@ -26,6 +24,35 @@ const ( //                  txnId  rmId
 
 type instanceIdPrefix [instanceIdPrefixLen]byte
 
+// TxnOutcomeStatus is what a ProposerManager can tell you about a
+// txn which may have been submitted through it.
+type TxnOutcomeStatus uint8
+
+const (
+	TxnOutcomeUnknown    TxnOutcomeStatus = iota
+	TxnOutcomeInProgress TxnOutcomeStatus = iota
+	TxnOutcomeDetermined TxnOutcomeStatus = iota
+	TxnOutcomeCommitted  TxnOutcomeStatus = iota
+	TxnOutcomeAborted    TxnOutcomeStatus = iota
+)
+
+func (tos TxnOutcomeStatus) String() string {
+	switch tos {
+	case TxnOutcomeUnknown:
+		return "Unknown"
+	case TxnOutcomeInProgress:
+		return "InProgress"
+	case TxnOutcomeDetermined:
+		return "Determined"
+	case TxnOutcomeCommitted:
+		return "Committed"
+	case TxnOutcomeAborted:
+		return "Aborted"
+	default:
+		return fmt.Sprintf("TxnOutcomeStatus(%d)", uint8(tos))
+	}
+}
+
 type ProposerManager struct {
 	ServerConnectionPublisher
 	RMId          common.RMId
@@ -35,7 +62,14 @@ type ProposerManager struct {
 	DB            *db.Databases
 	proposals     map[instanceIdPrefix]*proposal
 	proposers     map[common.TxnId]*Proposer
-	topology      *configuration.Topology
+	topology      configuration.AtomicTopology
+	compaction    proposerCompaction
+	spill         proposerSpill
+	outcomes      *outcomeCache
+	log           *server.Logger
+	// forged counts the messages ignored because their sender had
+	// no business sending them.
+	forged uint64
 }
 
 func NewProposerManager(exe *dispatcher.Executor, rmId common.RMId, cm ConnectionManager, db *db.Databases, varDispatcher *eng.VarDispatcher) *ProposerManager {
@@ -45,31 +79,44 @@ func NewProposerManager(exe *dispatcher.Executor, rmId common.RMId, cm Connectio
 		BootCount:     cm.BootCount(),
 		proposals:     make(map[instanceIdPrefix]*proposal),
 		proposers:     make(map[common.TxnId]*Proposer),
+		compaction:    proposerCompaction{orphans: make(map[common.TxnId]time.Time)},
+		spill: proposerSpill{
+			spilled:     make(map[common.TxnId]server.EmptyStruct),
+			rehydrating: make(map[common.TxnId][]func(*Proposer)),
+		},
+		outcomes:      newOutcomeCache(),
+		log:           debugLog.With("rm", rmId),
 		VarDispatcher: varDispatcher,
 		Exe:           exe,
 		DB:            db,
-		topology:      nil,
 	}
-	exe.Enqueue(func() { pm.topology = cm.AddTopologySubscriber(eng.ProposerSubscriber, pm) })
+	exe.Enqueue(func() { pm.topology.Store(cm.AddTopologySubscriber(eng.ProposerSubscriber, pm)) })
 	return pm
 }
 
 func (pm *ProposerManager) loadFromData(txnId *common.TxnId, data []byte) error {
-	if _, found := pm.proposers[*txnId]; !found {
-		proposer, err := ProposerFromData(pm, txnId, data, pm.topology)
-		if err != nil {
-			return err
-		}
-		pm.proposers[*txnId] = proposer
-		proposer.Start()
+	if _, found := pm.proposers[*txnId]; found || pm.spill.isSpilled(txnId) {
+		return nil
+	}
+	if len(pm.proposers) >= server.ProposerSpillThreshold {
+		// Leave it on disk until there's room for it.
+		pm.spill.spilled[*txnId] = server.EmptyStructVal
+		pm.spill.spills++
+		return nil
+	}
+	proposer, err := ProposerFromData(pm, txnId, data, pm.topology.Load())
+	if err != nil {
+		return err
 	}
+	pm.proposers[*txnId] = proposer
+	proposer.Start()
 	return nil
 }
 
 func (pm *ProposerManager) TopologyChanged(topology *configuration.Topology, done func(bool)) {
 	resultChan := make(chan struct{})
 	enqueued := pm.Exe.Enqueue(func() {
-		pm.topology = topology
+		pm.topology.Store(topology)
 		for _, proposer := range pm.proposers {
 			proposer.TopologyChange(topology)
 		}
@@ -105,20 +152,27 @@ func (pm *ProposerManager) TxnReceived(sender common.RMId, txn *eng.TxnReader) {
 	// is correct to ignore this message.
 	txnId := txn.Id
 	txnCap := txn.Txn
-	if _, found := pm.proposers[*txnId]; !found {
-		server.Log(txnId, "Received")
+	if _, found := pm.outcomes.get(txnId); found {
+		pm.txnLog(txnId).Log("Received (already finished; ignored)")
+		return
+	}
+	if _, found := pm.proposers[*txnId]; !found && !pm.spill.isSpilled(txnId) {
+		pm.txnLog(txnId).Log("Received")
+		// Take a single snapshot so that every decision below is made
+		// against the same topology.
+		topology := pm.topology.Load()
 		accept := true
-		if pm.topology != nil {
-			accept = (pm.topology.Next() == nil && pm.topology.Version == txnCap.TopologyVersion()) ||
-				// Could also do pm.topology.BarrierReached1(sender), but
+		if topology != nil {
+			accept = (topology.Next() == nil && topology.Version == txnCap.TopologyVersion()) ||
+				// Could also do topology.BarrierReached1(sender), but
 				// would need to specialise that to rolls rather than
 				// topology txns, and it's enforced on the sending side
 				// anyway. Once the sender has received the next topology,
 				// it'll do the right thing and locally block until it's
 				// in barrier1.
-				(pm.topology.Next() != nil && pm.topology.Next().Version == txnCap.TopologyVersion())
+				(topology.Next() != nil && topology.Next().Version == txnCap.TopologyVersion())
 			if accept {
-				_, found := pm.topology.RMsRemoved()[sender]
+				_, found := topology.RMsRemoved()[sender]
 				accept = !found
 				if accept {
 					accept = false
@@ -132,19 +186,28 @@ func (pm *ProposerManager) TxnReceived(sender common.RMId, txn *eng.TxnReader) {
 						}
 					}
 					if !accept {
-						server.Log(txnId, "Aborting received txn as it was submitted for an older version of us so we may have already voted on it.", pm.BootCount)
+						pm.txnLog(txnId).Log("Aborting received txn as it was submitted for an older version of us so we may have already voted on it.", pm.BootCount)
 					}
 				} else {
-					server.Log(txnId, "Aborting received txn as sender has been removed from topology.", sender)
+					pm.txnLog(txnId).Log("Aborting received txn as sender has been removed from topology.", sender)
 				}
 			} else {
-				server.Log(txnId, "Aborting received txn due to non-matching topology.", txnCap.TopologyVersion())
+				pm.txnLog(txnId).Log("Aborting received txn due to non-matching topology.", txnCap.TopologyVersion())
 			}
 		}
 		if accept {
-			proposer := NewProposer(pm, txn, ProposerActiveVoter, pm.topology)
+			if err := eng.ValidateActions(txn); err != nil {
+				server.UnknownEnumReceived("txn action", err, sender)
+				accept = false
+			} else if err := txn.VerifyChecksum(sender); err != nil {
+				accept = false
+			}
+		}
+		if accept {
+			proposer := NewProposer(pm, txn, ProposerActiveVoter, topology)
 			pm.proposers[*txnId] = proposer
 			proposer.Start()
+			pm.maybeSpill()
 
 		} else {
 			acceptors := GetAcceptorsFromTxn(txnCap)
@@ -155,9 +218,10 @@ func (pm *ProposerManager) TxnReceived(sender common.RMId, txn *eng.TxnReader) {
 			// ActiveLearner is right - we don't want the proposer to
 			// vote, but it should exist to collect the 2Bs that should
 			// come back.
-			proposer := NewProposer(pm, txn, ProposerActiveLearner, pm.topology)
+			proposer := NewProposer(pm, txn, ProposerActiveLearner, topology)
 			pm.proposers[*txnId] = proposer
 			proposer.Start()
+			pm.maybeSpill()
 		}
 	}
 }
@@ -169,7 +233,7 @@ func (pm *ProposerManager) NewPaxosProposals(txn *eng.TxnReader, fInc int, ballo
 	copy(instIdSlice, txnId[:])
 	binary.BigEndian.PutUint32(instIdSlice[common.KeyLen:], uint32(rmId))
 	if _, found := pm.proposals[instId]; !found {
-		server.Log(txnId, "NewPaxos; acceptors:", acceptors, "; instance:", rmId)
+		pm.txnLog(txnId).Log("NewPaxos; acceptors:", acceptors, "; instance:", rmId)
 		prop := NewProposal(pm, txn, fInc, ballots, rmId, acceptors, skipPhase1)
 		pm.proposals[instId] = prop
 		prop.Start()
@@ -177,7 +241,7 @@ func (pm *ProposerManager) NewPaxosProposals(txn *eng.TxnReader, fInc int, ballo
 }
 
 func (pm *ProposerManager) AddToPaxosProposals(txnId *common.TxnId, ballots []*eng.Ballot, rmId common.RMId) {
-	server.Log(txnId, "Adding ballot to Paxos; instance:", rmId)
+	pm.txnLog(txnId).Log("Adding ballot to Paxos; instance:", rmId)
 	instId := instanceIdPrefix([instanceIdPrefixLen]byte{})
 	instIdSlice := instId[:]
 	copy(instIdSlice, txnId[:])
@@ -185,18 +249,18 @@ func (pm *ProposerManager) AddToPaxosProposals(txnId *common.TxnId, ballots []*e
 	if prop, found := pm.proposals[instId]; found {
 		prop.AddBallots(ballots)
 	} else {
-		log.Printf("Error: Adding ballot to Paxos, unable to find proposals. %v %v\n", txnId, rmId)
+		pm.txnLog(txnId).Error("Adding ballot to Paxos, unable to find proposals; instance: %v", rmId)
 	}
 }
 
 // from network
 func (pm *ProposerManager) OneBTxnVotesReceived(sender common.RMId, txnId *common.TxnId, oneBTxnVotes *msgs.OneBTxnVotes) {
-	server.Log(txnId, "1B received from", sender, "; instance:", common.RMId(oneBTxnVotes.RmId()))
+	pm.txnLog(txnId).Log("1B received from", sender, "; instance:", common.RMId(oneBTxnVotes.RmId()))
 	instId := instanceIdPrefix([instanceIdPrefixLen]byte{})
 	instIdSlice := instId[:]
 	copy(instIdSlice, txnId[:])
 	binary.BigEndian.PutUint32(instIdSlice[common.KeyLen:], oneBTxnVotes.RmId())
-	if prop, found := pm.proposals[instId]; found {
+	if prop, found := pm.proposals[instId]; found && pm.verifySender(txnId, "1B", sender, prop.acceptors) {
 		prop.OneBTxnVotesReceived(sender, oneBTxnVotes)
 	}
 	// If not found, it should be safe to ignore - it's just a delayed
@@ -213,9 +277,9 @@ func (pm *ProposerManager) TwoBTxnVotesReceived(sender common.RMId, txnId *commo
 	switch twoBTxnVotes.Which() {
 	case msgs.TWOBTXNVOTES_FAILURES:
 		failures := twoBTxnVotes.Failures()
-		server.Log(txnId, "2B received from", sender, "; instance:", common.RMId(failures.RmId()))
+		pm.txnLog(txnId).Log("2B received from", sender, "; instance:", common.RMId(failures.RmId()))
 		binary.BigEndian.PutUint32(instIdSlice[common.KeyLen:], failures.RmId())
-		if prop, found := pm.proposals[instId]; found {
+		if prop, found := pm.proposals[instId]; found && pm.verifySender(txnId, "2B", sender, prop.acceptors) {
 			prop.TwoBFailuresReceived(sender, &failures)
 		}
 
@@ -223,9 +287,31 @@ func (pm *ProposerManager) TwoBTxnVotesReceived(sender common.RMId, txnId *commo
 		binary.BigEndian.PutUint32(instIdSlice[common.KeyLen:], uint32(pm.RMId))
 		outcome := twoBTxnVotes.Outcome()
 
+		// The txn comes from the message itself, so its acceptors can
+		// only be trusted if we know of no others: a proposer's
+		// acceptors come from the txn as we first received it.
 		if proposer, found := pm.proposers[*txnId]; found {
-			server.Log(txnId, "2B outcome received from", sender, "(known active)")
-			proposer.BallotOutcomeReceived(sender, &outcome)
+			pm.txnLog(txnId).Log("2B outcome received from", sender, "(known active)")
+			if pm.verifySender(txnId, "2B outcome", sender, proposer.acceptors) {
+				proposer.BallotOutcomeReceived(sender, &outcome)
+			}
+			return
+		} else if pm.rehydrate(txnId, func(proposer *Proposer) {
+			// Consensus was reached long ago; the proposer just
+			// resends its TLCs.
+			if pm.verifySender(txnId, "2B outcome", sender, proposer.acceptors) {
+				proposer.BallotOutcomeReceived(sender, &outcome)
+			}
+		}) {
+			pm.txnLog(txnId).Log("2B outcome received from", sender, "(spilled)")
+			return
+		} else if !pm.verifySender(txnId, "2B outcome", sender, GetAcceptorsFromTxn(txn.Txn)) {
+			return
+		} else if _, found := pm.outcomes.get(txnId); found {
+			// We've already applied the outcome, but the acceptor
+			// hasn't had our TLC.
+			pm.txnLog(txnId).Log("2B outcome received from", sender, "(finished)")
+			NewOneShotSender(MakeTxnLocallyCompleteMsg(txnId), pm, sender)
 			return
 		}
 
@@ -241,7 +327,7 @@ func (pm *ProposerManager) TwoBTxnVotesReceived(sender common.RMId, txnId *commo
 			// abort (abort proposers out there) or commit (we previously
 			// voted, and that vote got recorded, but we have since died
 			// and restarted).
-			server.Log(txnId, "2B outcome received from", sender, "(unknown active)")
+			pm.txnLog(txnId).Log("2B outcome received from", sender, "(unknown active)")
 
 			// There's a possibility the acceptor that sent us this 2B is
 			// one of only a few acceptors that got enough 2As to
@@ -251,24 +337,26 @@ func (pm *ProposerManager) TwoBTxnVotesReceived(sender common.RMId, txnId *commo
 			// itself will detect any further absences and take care of
 			// them.
 			acceptors := GetAcceptorsFromTxn(txnCap)
-			server.Log(txnId, "Starting abort proposals with acceptors", acceptors)
+			pm.txnLog(txnId).Log("Starting abort proposals with acceptors", acceptors)
 			fInc := int(txnCap.FInc())
 			ballots := MakeAbortBallots(txn, alloc)
 			pm.NewPaxosProposals(txn, fInc, ballots, acceptors, pm.RMId, false)
 
-			proposer := NewProposer(pm, txn, ProposerActiveLearner, pm.topology)
+			proposer := NewProposer(pm, txn, ProposerActiveLearner, pm.topology.Load())
 			pm.proposers[*txnId] = proposer
 			proposer.Start()
 			proposer.BallotOutcomeReceived(sender, &outcome)
+			pm.maybeSpill()
 		} else {
 			// Not active, so we are a learner
 			if outcome.Which() == msgs.OUTCOME_COMMIT {
-				server.Log(txnId, "2B outcome received from", sender, "(unknown learner)")
+				pm.txnLog(txnId).Log("2B outcome received from", sender, "(unknown learner)")
 				// we must be a learner.
-				proposer := NewProposer(pm, txn, ProposerPassiveLearner, pm.topology)
+				proposer := NewProposer(pm, txn, ProposerPassiveLearner, pm.topology.Load())
 				pm.proposers[*txnId] = proposer
 				proposer.Start()
 				proposer.BallotOutcomeReceived(sender, &outcome)
+				pm.maybeSpill()
 
 			} else {
 				// Whilst it's an abort now, at some point in the past it
@@ -276,7 +364,7 @@ func (pm *ProposerManager) TwoBTxnVotesReceived(sender common.RMId, txnId *commo
 				// outcome. However, we must have since died and so lost
 				// that state/proposer. We should now immediately reply
 				// with a TLC.
-				server.Log(txnId, "Sending immediate TLC for unknown abort learner")
+				pm.txnLog(txnId).Log("Sending immediate TLC for unknown abort learner")
 				// We have no state here, and if we receive further 2Bs
 				// from the repeating sender at the acceptor then we will
 				// send further TLCs. So the use of OSS here is correct.
@@ -285,33 +373,70 @@ func (pm *ProposerManager) TwoBTxnVotesReceived(sender common.RMId, txnId *commo
 		}
 
 	default:
-		panic(fmt.Sprintf("Unexpected 2BVotes type: %v", twoBTxnVotes.Which()))
+		server.UnknownEnumReceived("2BVotes type", twoBTxnVotes.Which(), sender)
 	}
 }
 
 // from network
 func (pm *ProposerManager) TxnGloballyCompleteReceived(sender common.RMId, txnId *common.TxnId) {
 	if proposer, found := pm.proposers[*txnId]; found {
-		server.Log(txnId, "TGC received from", sender, "(proposer found)")
-		proposer.TxnGloballyCompleteReceived(sender)
+		pm.txnLog(txnId).Log("TGC received from", sender, "(proposer found)")
+		if pm.verifySender(txnId, "TGC", sender, proposer.acceptors) {
+			proposer.TxnGloballyCompleteReceived(sender)
+		}
+	} else if pm.rehydrate(txnId, func(proposer *Proposer) {
+		if pm.verifySender(txnId, "TGC", sender, proposer.acceptors) {
+			proposer.TxnGloballyCompleteReceived(sender)
+		}
+	}) {
+		pm.txnLog(txnId).Log("TGC received from", sender, "(proposer spilled)")
 	} else {
-		server.Log(txnId, "TGC received from", sender, "(ignored)")
+		pm.txnLog(txnId).Log("TGC received from", sender, "(ignored)")
 	}
 }
 
 // from network
 func (pm *ProposerManager) TxnSubmissionAbortReceived(sender common.RMId, txnId *common.TxnId) {
 	if proposer, found := pm.proposers[*txnId]; found {
-		server.Log(txnId, "TSA received from", sender, "(proposer found)")
-		proposer.Abort()
+		pm.txnLog(txnId).Log("TSA received from", sender, "(proposer found)")
+		if pm.verifySender(txnId, "TSA", sender, common.RMIds{proposer.submitter}) {
+			proposer.Abort()
+		}
 	} else {
-		server.Log(txnId, "TSA received from", sender, "(ignored)")
+		pm.txnLog(txnId).Log("TSA received from", sender, "(ignored)")
+	}
+}
+
+// The connection each message arrives on is authenticated, and sender
+// is the RM at the other end of it. But every RM in the cluster is
+// trusted with the same certificate, so verifySender also checks that
+// sender is one of the RMs which may send the message for the txn:
+// an acceptor of the txn for 1Bs, 2Bs and TGCs; the txn's submitter
+// for TSAs. Anything else is ignored, with a warning, as a
+// misbehaving or compromised RM could otherwise inject outcomes.
+func (pm *ProposerManager) verifySender(txnId *common.TxnId, kind string, sender common.RMId, permitted common.RMIds) bool {
+	for _, rmId := range permitted {
+		if rmId == sender {
+			return true
+		}
 	}
+	pm.forged++
+	pm.txnLog(txnId).Warn("%v received from %v, which is not one of %v; ignored.", kind, sender, permitted)
+	return false
+}
+
+// txnLog returns a Logger for lines about txnId.
+func (pm *ProposerManager) txnLog(txnId *common.TxnId) *server.Logger {
+	return pm.log.With("txn", txnId)
 }
 
 // from proposer
 func (pm *ProposerManager) TxnFinished(txnId *common.TxnId) {
+	if proposer, found := pm.proposers[*txnId]; found {
+		pm.outcomes.add(txnId, proposer.outcome)
+	}
 	delete(pm.proposers, *txnId)
+	pm.maybeRehydrate()
 }
 
 // We have an outcome by this point, so we should stop sending proposals.
@@ -333,15 +458,78 @@ func (pm *ProposerManager) FinishProposers(txnId *common.TxnId) {
 	}
 }
 
+// TxnOutcome reports what we know of the outcome of txnId. If the
+// outcome is known, it is returned too, which for a commit carries
+// the txn's clock. Proposers reloaded from disk, and spilled
+// proposers, only know that the outcome was determined, not what it
+// was. Once the proposer has finished, only the outcomeCache knows of
+// the txn, and then without the outcome itself; once it has been
+// evicted from there, we have no record of the txn at all.
+func (pm *ProposerManager) TxnOutcome(txnId *common.TxnId) (TxnOutcomeStatus, *msgs.Outcome) {
+	proposer, found := pm.proposers[*txnId]
+	switch {
+	case !found && pm.spill.isSpilled(txnId):
+		return TxnOutcomeDetermined, nil
+	case !found:
+		status, _ := pm.outcomes.get(txnId)
+		return status, nil
+	case proposer.mode == proposerTLCSender:
+		return TxnOutcomeDetermined, nil
+	case proposer.outcome == nil:
+		return TxnOutcomeInProgress, nil
+	case proposer.outcome.Which() == msgs.OUTCOME_COMMIT:
+		return TxnOutcomeCommitted, proposer.outcome
+	default:
+		return TxnOutcomeAborted, proposer.outcome
+	}
+}
+
+// ProposerSummary describes a live proposer.
+type ProposerSummary struct {
+	TxnId     *common.TxnId
+	Mode      ProposerMode
+	State     string
+	Outcome   TxnOutcomeStatus
+	Acceptors common.RMIds
+}
+
+func (pm *ProposerManager) ListProposers() []ProposerSummary {
+	summaries := make([]ProposerSummary, 0, len(pm.proposers))
+	for _, proposer := range pm.proposers {
+		outcome, _ := pm.TxnOutcome(proposer.txnId)
+		summaries = append(summaries, ProposerSummary{
+			TxnId:     proposer.txnId,
+			Mode:      proposer.mode,
+			State:     fmt.Sprint(proposer.currentState),
+			Outcome:   outcome,
+			Acceptors: proposer.acceptors,
+		})
+	}
+	return summaries
+}
+
+func (pm *ProposerManager) AbortTxn(txnId *common.TxnId) bool {
+	if proposer, found := pm.proposers[*txnId]; found && proposer.currentState == &proposer.proposerAwaitBallots && !proposer.allAcceptorsAgreed {
+		pm.txnLog(txnId).Info("Aborting txn on request")
+		proposer.Abort()
+		return true
+	}
+	return false
+}
+
 func (pm *ProposerManager) Status(sc *server.StatusConsumer) {
-	sc.Emit(fmt.Sprintf("Live proposers: %v", len(pm.proposers)))
+	sc.EmitKV("Live proposers", len(pm.proposers))
 	for _, prop := range pm.proposers {
 		prop.Status(sc.Fork())
 	}
-	sc.Emit(fmt.Sprintf("Live proposals: %v", len(pm.proposals)))
+	sc.EmitKV("Live proposals", len(pm.proposals))
 	for _, prop := range pm.proposals {
 		prop.Status(sc.Fork())
 	}
+	pm.compaction.status(sc)
+	pm.spill.status(sc)
+	pm.outcomes.status(sc)
+	sc.EmitKV("Messages from unexpected senders", pm.forged)
 	sc.Join()
 }
 
@@ -0,0 +1,53 @@
+package paxos
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	eng "goshawkdb.io/server/txnengine"
+	"testing"
+)
+
+// A 2B outcome carries the txn it is for, so anyone can claim to be
+// one of its acceptors by sending a txn which lists them. Once we
+// have a proposer for the txn, only its acceptors are believed.
+func TestTwoBOutcomeFromForgedTxn(t *testing.T) {
+	key := make([]byte, common.KeyLen)
+	key[0] = 1
+	txnId := common.MakeTxnId(key)
+	acceptors := common.RMIds{common.RMId(1), common.RMId(2), common.RMId(3)}
+	forger := common.RMId(4)
+
+	pm := &ProposerManager{
+		RMId:      common.RMId(1),
+		proposers: map[common.TxnId]*Proposer{*txnId: &Proposer{txnId: txnId, acceptors: acceptors}},
+		log:       debugLog.With("rm", common.RMId(1)),
+	}
+
+	seg := capn.NewBuffer(nil)
+	txnCap := msgs.NewRootTxn(seg)
+	txnCap.SetId(txnId[:])
+	txnCap.SetFInc(1)
+	allocs := msgs.NewAllocationList(seg, 1)
+	alloc := msgs.NewAllocation(seg)
+	alloc.SetRmId(uint32(forger))
+	allocs.Set(0, alloc)
+	txnCap.SetAllocations(allocs)
+	txn := eng.TxnReaderFromData(server.SegToBytes(seg))
+	if forged := GetAcceptorsFromTxn(txn.Txn); len(forged) != 1 || forged[0] != forger {
+		t.Fatalf("Expected the forged txn to list %v as its acceptor; got %v", forger, forged)
+	}
+
+	seg = capn.NewBuffer(nil)
+	twoB := msgs.NewRootTwoBTxnVotes(seg)
+	outcome := msgs.NewOutcome(seg)
+	outcome.SetTxn(txn.Data)
+	outcome.SetCommit([]byte{})
+	twoB.SetOutcome(outcome)
+
+	pm.TwoBTxnVotesReceived(forger, txnId, txn, &twoB)
+	if pm.forged != 1 {
+		t.Fatalf("Expected the 2B outcome from %v to be ignored as forged; %v forged", forger, pm.forged)
+	}
+}
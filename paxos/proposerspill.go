@@ -0,0 +1,158 @@
+package paxos
+
+import (
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	"goshawkdb.io/server/db"
+)
+
+// Once a proposer has written its state to disk and is only waiting
+// for TGCs from its acceptors, it holds nothing in memory which can't
+// be rebuilt from that state (see ProposerFromData). When a
+// ProposerManager has more than ProposerSpillThreshold live
+// proposers, such proposers are spilled: forgotten, leaving only
+// their record in the Proposers table. A spilled proposer is
+// rehydrated when a message for its txn arrives. So that its txn
+// completes even if no such message ever arrives, spilled proposers
+// are also rehydrated, in batches, once the number of live proposers
+// has fallen to half the threshold. Rehydration reads from disk off
+// the executor; messages which arrive for the txn meanwhile are
+// buffered until it is done. A rehydrated proposer resends its
+// TLCs, and acceptors which have already forgotten the txn reply with
+// TGCs, so nothing is lost by forgetting which TGCs had arrived.
+type proposerSpill struct {
+	spilled      map[common.TxnId]server.EmptyStruct
+	rehydrating  map[common.TxnId][]func(*Proposer)
+	spills       uint64
+	rehydrations uint64
+}
+
+func (ps *proposerSpill) status(sc *server.StatusConsumer) {
+	sc.EmitKV("Spilled proposers", len(ps.spilled))
+	sc.EmitKV("Rehydrating proposers", len(ps.rehydrating))
+	sc.EmitKV("Proposer spills", ps.spills)
+	sc.EmitKV("Proposer rehydrations", ps.rehydrations)
+}
+
+func (ps *proposerSpill) isSpilled(txnId *common.TxnId) bool {
+	_, found := ps.spilled[*txnId]
+	return found
+}
+
+// maybeSpill spills proposers, if there are too many live, until
+// there are no more than three quarters of the threshold, or there
+// are no more which can be spilled.
+func (pm *ProposerManager) maybeSpill() {
+	if len(pm.proposers) <= server.ProposerSpillThreshold {
+		return
+	}
+	target := server.ProposerSpillThreshold - (server.ProposerSpillThreshold / 4)
+	for txnId, proposer := range pm.proposers {
+		if len(pm.proposers) <= target {
+			break
+		}
+		// Proposers with a txn must stay: the txn awaits our
+		// CompletionReceived.
+		if proposer.currentState != &proposer.proposerReceiveGloballyComplete || proposer.txn != nil {
+			continue
+		}
+		proposer.log.Log("Spilling proposer")
+		pm.RemoveServerConnectionSubscriber(proposer.tlcSender)
+		proposer.tlcSender = nil
+		proposer.currentState = nil
+		proposer.span.Finish()
+		delete(pm.proposers, txnId)
+		pm.spill.spilled[txnId] = server.EmptyStructVal
+		pm.spill.spills++
+	}
+}
+
+// maybeRehydrate rehydrates a batch of spilled proposers if there are
+// few enough live. No more than ProposerRehydrateBatch are loaded at
+// once.
+func (pm *ProposerManager) maybeRehydrate() {
+	if len(pm.spill.spilled) == 0 || len(pm.proposers)+len(pm.spill.rehydrating) > server.ProposerSpillThreshold/2 {
+		return
+	}
+	batch := make([]common.TxnId, 0, server.ProposerRehydrateBatch)
+	for txnId := range pm.spill.spilled {
+		if len(batch)+len(pm.spill.rehydrating) >= cap(batch) {
+			break
+		} else if _, found := pm.spill.rehydrating[txnId]; !found {
+			batch = append(batch, txnId)
+		}
+	}
+	for idx := range batch {
+		pm.rehydrate(&batch[idx], nil)
+	}
+}
+
+// rehydrate starts recreating the spilled proposer for txnId from
+// disk. The load happens off the executor: until it completes, the
+// txn remains spilled, and the continuations of any messages for it
+// are buffered. Once the proposer has been recreated and started,
+// fun (if not nil) and the buffered continuations are called with it,
+// in order. It returns false if txnId has no spilled proposer.
+func (pm *ProposerManager) rehydrate(txnId *common.TxnId, fun func(*Proposer)) bool {
+	if !pm.spill.isSpilled(txnId) {
+		return false
+	}
+	waiting, found := pm.spill.rehydrating[*txnId]
+	if fun != nil {
+		waiting = append(waiting, fun)
+	}
+	pm.spill.rehydrating[*txnId] = waiting
+	if found {
+		return true
+	}
+
+	future := pm.DB.ConsensusShard(txnId).ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
+		// rtxn.Get returns a copy of the data, so we don't need to
+		// worry about pointers into the db
+		bites, err := rtxn.Get(pm.DB.Proposers, txnId[:])
+		db.Stats.Proposers.Read(bites, err)
+		if err == nil {
+			return bites
+		} else {
+			return true
+		}
+	})
+	go func() {
+		// Wait for the result in a new go-routine to avoid blocking
+		// the executor.
+		if result, err := future.ResultError(); err != nil || result != nil {
+			pm.Exe.Enqueue(func() { pm.rehydrated(txnId, result, err) })
+		}
+	}()
+	return true
+}
+
+func (pm *ProposerManager) rehydrated(txnId *common.TxnId, result interface{}, err error) {
+	waiting := pm.spill.rehydrating[*txnId]
+	delete(pm.spill.rehydrating, *txnId)
+	if err != nil {
+		// Leave it spilled: the next message for it, or the next
+		// batch, will try again.
+		pm.txnLog(txnId).Error("Unable to load spilled proposer from disk: %v", err)
+		return
+	}
+	delete(pm.spill.spilled, *txnId)
+	bites, ok := result.([]byte)
+	if !ok {
+		// Not found, so there's nothing left to do.
+		pm.txnLog(txnId).Log("Spilled proposer not found on disk; dropping", len(waiting), "messages")
+		return
+	}
+	proposer, err := ProposerFromData(pm, txnId, bites, pm.topology.Load())
+	if err != nil {
+		pm.txnLog(txnId).Error("Unable to recreate spilled proposer: %v", err)
+		return
+	}
+	pm.txnLog(txnId).Log("Rehydrated proposer")
+	pm.spill.rehydrations++
+	pm.proposers[*txnId] = proposer
+	proposer.Start()
+	for _, fun := range waiting {
+		fun(proposer)
+	}
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+var unknownEnumCount uint64
+
+// UnknownEnumReceived should be called instead of panicking when a
+// message contains an enum or union value we don't recognise. This
+// is normally caused by a peer running a newer version of the
+// server. The message (or txn) concerned should then be dropped or
+// rejected so that mixed version clusters keep running.
+func UnknownEnumReceived(what string, value interface{}, from interface{}) {
+	count := atomic.AddUint64(&unknownEnumCount, 1)
+	log.Printf("Warning: Unknown %v (%v) received from %v. Total unknown values received: %v", what, value, from, count)
+}
+
+func UnknownEnumCount() uint64 {
+	return atomic.LoadUint64(&unknownEnumCount)
+}
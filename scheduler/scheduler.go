@@ -0,0 +1,235 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"goshawkdb.io/server"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The Scheduler owns recurring background jobs. Each job runs at
+// most once at a time: if a job is still running when its next run
+// is due, that run is skipped rather than queued. The first run of
+// each job is delayed by a random fraction of its interval so that
+// jobs registered together (and the same jobs on different RMs) do
+// not all fire at once.
+type Scheduler struct {
+	lock       sync.Mutex
+	jobs       map[string]*job
+	rng        *rand.Rand
+	terminated bool
+}
+
+type job struct {
+	scheduler *Scheduler
+	name      string
+	interval  time.Duration
+	enabled   bool
+	fun       func()
+	timer     *time.Timer
+	running   bool
+	report    JobReport
+}
+
+// JobReport describes the state of one job, and the outcome of its
+// most recent run.
+type JobReport struct {
+	Name         string
+	Enabled      bool
+	Interval     time.Duration
+	Running      bool
+	Runs         uint64
+	Skipped      uint64
+	LastStarted  time.Time
+	LastDuration time.Duration
+	NextRun      time.Time
+}
+
+var (
+	JobExists     = errors.New("Job already exists")
+	JobNotFound   = errors.New("Job not found")
+	JobIllegal    = errors.New("Job interval must be greater than 0")
+	SchedulerDown = errors.New("Scheduler has been shutdown")
+)
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		jobs: make(map[string]*job),
+		rng:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Add registers a new job which, whilst enabled, calls fun every
+// interval. fun is called from its own go-routine so it must do its
+// own synchronisation (for example by enqueueing onto an actor).
+func (s *Scheduler) Add(name string, interval time.Duration, enabled bool, fun func()) error {
+	if interval <= 0 {
+		return JobIllegal
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.terminated {
+		return SchedulerDown
+	} else if _, found := s.jobs[name]; found {
+		return JobExists
+	}
+	j := &job{
+		scheduler: s,
+		name:      name,
+		interval:  interval,
+		enabled:   enabled,
+		fun:       fun,
+	}
+	s.jobs[name] = j
+	if enabled {
+		j.schedule(s.jitter(interval))
+	}
+	return nil
+}
+
+// Remove stops and forgets the named job. A run already in progress
+// is allowed to finish.
+func (s *Scheduler) Remove(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	j, found := s.jobs[name]
+	if !found {
+		return JobNotFound
+	}
+	j.cancel()
+	delete(s.jobs, name)
+	return nil
+}
+
+// Configure changes whether the named job is enabled, and its
+// interval. A zero interval leaves the interval unchanged.
+func (s *Scheduler) Configure(name string, enabled bool, interval time.Duration) error {
+	if interval < 0 {
+		return JobIllegal
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	j, found := s.jobs[name]
+	if !found {
+		return JobNotFound
+	}
+	if interval == 0 {
+		interval = j.interval
+	}
+	if j.enabled == enabled && j.interval == interval {
+		return nil
+	}
+	j.cancel()
+	j.enabled = enabled
+	j.interval = interval
+	if enabled && !s.terminated {
+		j.schedule(s.jitter(interval))
+	}
+	return nil
+}
+
+// Reports returns a JobReport for every job, sorted by name.
+func (s *Scheduler) Reports() []JobReport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	reports := make([]JobReport, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		report := j.report
+		report.Name = j.name
+		report.Enabled = j.enabled
+		report.Interval = j.interval
+		report.Running = j.running
+		reports = append(reports, report)
+	}
+	sort.Sort(jobReports(reports))
+	return reports
+}
+
+func (s *Scheduler) Shutdown() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.terminated = true
+	for _, j := range s.jobs {
+		j.cancel()
+	}
+}
+
+func (s *Scheduler) Status(sc *server.StatusConsumer) {
+	sc.Emit("Scheduler")
+	for _, report := range s.Reports() {
+		sc.Emit(fmt.Sprintf("- %v", &report))
+	}
+	sc.Join()
+}
+
+// lock must be held.
+func (s *Scheduler) jitter(interval time.Duration) time.Duration {
+	return time.Duration(s.rng.Int63n(int64(interval)))
+}
+
+// lock must be held.
+func (j *job) schedule(delay time.Duration) {
+	j.report.NextRun = time.Now().Add(delay)
+	j.timer = time.AfterFunc(delay, j.tick)
+}
+
+// lock must be held.
+func (j *job) cancel() {
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+	j.report.NextRun = time.Time{}
+}
+
+func (j *job) tick() {
+	s := j.scheduler
+	s.lock.Lock()
+	if s.terminated || !j.enabled || s.jobs[j.name] != j {
+		s.lock.Unlock()
+		return
+	}
+	j.schedule(j.interval)
+	if j.running {
+		j.report.Skipped++
+		s.lock.Unlock()
+		server.Log("Scheduler: skipping", j.name, "as previous run has not finished")
+		return
+	}
+	j.running = true
+	j.report.Runs++
+	started := time.Now()
+	j.report.LastStarted = started
+	s.lock.Unlock()
+
+	j.run()
+
+	s.lock.Lock()
+	j.running = false
+	j.report.LastDuration = time.Now().Sub(started)
+	s.lock.Unlock()
+}
+
+func (j *job) run() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Error: Scheduler: job %v failed: %v", j.name, r)
+		}
+	}()
+	j.fun()
+}
+
+func (report *JobReport) String() string {
+	return fmt.Sprintf("%v: enabled: %v; interval: %v; running: %v; runs: %v; skipped: %v; last started: %v; last duration: %v; next run: %v",
+		report.Name, report.Enabled, report.Interval, report.Running, report.Runs, report.Skipped, report.LastStarted, report.LastDuration, report.NextRun)
+}
+
+type jobReports []JobReport
+
+func (jr jobReports) Len() int           { return len(jr) }
+func (jr jobReports) Less(i, j int) bool { return jr[i].Name < jr[j].Name }
+func (jr jobReports) Swap(i, j int)      { jr[i], jr[j] = jr[j], jr[i] }
@@ -0,0 +1,89 @@
+// Package sim provides a deterministic simulated network, for driving
+// the paxos and txnengine state machines of several RMs within one
+// process. Time is virtual: nothing happens until the test steps the
+// Clock, and every message is delivered by an event on the Clock. The
+// order of deliveries, and which messages are dropped, duplicated or
+// delayed, is decided by a pseudo-random number generator, so a run
+// can be repeated exactly from its seed.
+//
+// The state machines themselves run on executors, so for a run to be
+// fully repeatable, each Node should wait for a delivered message to
+// be processed before returning from Deliver.
+package sim
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Clock is a virtual clock. Events are run in time order, and events
+// for the same time are run in the order they were added.
+type Clock struct {
+	now    time.Time
+	seq    uint64
+	events events
+}
+
+type event struct {
+	at  time.Time
+	seq uint64
+	fun func()
+}
+
+type events []*event
+
+func (es events) Len() int { return len(es) }
+func (es events) Less(i, j int) bool {
+	a, b := es[i], es[j]
+	return a.at.Before(b.at) || (a.at.Equal(b.at) && a.seq < b.seq)
+}
+func (es events) Swap(i, j int)       { es[i], es[j] = es[j], es[i] }
+func (es *events) Push(e interface{}) { *es = append(*es, e.(*event)) }
+func (es *events) Pop() interface{} {
+	old := *es
+	e := old[len(old)-1]
+	*es = old[:len(old)-1]
+	return e
+}
+
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	return c.now
+}
+
+// After schedules fun to be run once the clock reaches d from now.
+func (c *Clock) After(d time.Duration, fun func()) {
+	c.seq++
+	heap.Push(&c.events, &event{at: c.now.Add(d), seq: c.seq, fun: fun})
+}
+
+// Pending returns the number of events yet to run.
+func (c *Clock) Pending() int {
+	return len(c.events)
+}
+
+// Step advances the clock to the next event, and runs it. It returns
+// false if there are no events.
+func (c *Clock) Step() bool {
+	if len(c.events) == 0 {
+		return false
+	}
+	e := heap.Pop(&c.events).(*event)
+	c.now = e.at
+	e.fun()
+	return true
+}
+
+// Run steps the clock until there are no events left, or limit events
+// have been run, and returns the number of events run. If limit is 0,
+// there is no limit.
+func (c *Clock) Run(limit int) int {
+	count := 0
+	for (limit == 0 || count < limit) && c.Step() {
+		count++
+	}
+	return count
+}
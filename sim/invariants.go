@@ -0,0 +1,78 @@
+package sim
+
+import (
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	msgs "goshawkdb.io/server/capnp"
+	eng "goshawkdb.io/server/txnengine"
+)
+
+// OutcomeChecker checks that no txn is seen to both commit and
+// abort. Outcomes can be recorded directly, or the checker can watch
+// every outcome sent over a Network with Watch.
+type OutcomeChecker struct {
+	outcomes   map[common.TxnId]bool
+	violations []error
+}
+
+func NewOutcomeChecker() *OutcomeChecker {
+	return &OutcomeChecker{
+		outcomes: make(map[common.TxnId]bool),
+	}
+}
+
+// Record notes that rmId has seen txnId commit (if commit is true) or
+// abort.
+func (oc *OutcomeChecker) Record(rmId common.RMId, txnId *common.TxnId, commit bool) {
+	if prev, found := oc.outcomes[*txnId]; !found {
+		oc.outcomes[*txnId] = commit
+	} else if prev != commit {
+		oc.violations = append(oc.violations,
+			fmt.Errorf("%v: txn %v seen with commit %v, but previously with commit %v", rmId, txnId, commit, prev))
+	}
+}
+
+// RecordOutcome is Record for an outcome message.
+func (oc *OutcomeChecker) RecordOutcome(rmId common.RMId, outcome *msgs.Outcome) {
+	txnId := eng.TxnReaderFromData(outcome.Txn()).Id
+	oc.Record(rmId, txnId, outcome.Which() == msgs.OUTCOME_COMMIT)
+}
+
+// Watch records every outcome delivered over network: the outcomes
+// acceptors send to each other and to learners, and those sent to
+// submitters.
+func (oc *OutcomeChecker) Watch(network *Network) {
+	network.Observe(func(from, to common.RMId, data []byte) {
+		seg, _, err := capn.ReadFromMemoryZeroCopy(data)
+		if err != nil {
+			oc.violations = append(oc.violations, fmt.Errorf("%v -> %v: undecodable message: %v", from, to, err))
+			return
+		}
+		msg := msgs.ReadRootMessage(seg)
+		switch msg.Which() {
+		case msgs.MESSAGE_SUBMISSIONOUTCOME:
+			outcome := msg.SubmissionOutcome()
+			oc.RecordOutcome(from, &outcome)
+		case msgs.MESSAGE_TWOBTXNVOTES:
+			if twoB := msg.TwoBTxnVotes(); twoB.Which() == msgs.TWOBTXNVOTES_OUTCOME {
+				outcome := twoB.Outcome()
+				oc.RecordOutcome(from, &outcome)
+			}
+		}
+	})
+}
+
+// Txns returns the number of txns for which an outcome has been seen.
+func (oc *OutcomeChecker) Txns() int {
+	return len(oc.outcomes)
+}
+
+// Check returns an error describing the first violation found, if
+// any.
+func (oc *OutcomeChecker) Check() error {
+	if len(oc.violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v violations; first: %v", len(oc.violations), oc.violations[0])
+}
@@ -0,0 +1,169 @@
+package sim
+
+import (
+	"fmt"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server/paxos"
+	"math/rand"
+	"time"
+)
+
+// Faults describes how the network misbehaves. Each message is
+// dropped with probability Drop. Otherwise it is delivered after a
+// delay chosen uniformly between MinDelay and MaxDelay, and, with
+// probability Duplicate, delivered a second time after another such
+// delay.
+type Faults struct {
+	Drop      float64
+	Duplicate float64
+	MinDelay  time.Duration
+	MaxDelay  time.Duration
+}
+
+// A Node is a simulated RM. Deliver is called from the Clock's Step.
+type Node interface {
+	Deliver(sender common.RMId, msg []byte)
+}
+
+// NetworkStats counts what has happened to messages on a Network.
+type NetworkStats struct {
+	Sent        uint64
+	Delivered   uint64
+	Dropped     uint64
+	Duplicated  uint64
+	Partitioned uint64
+}
+
+func (ns NetworkStats) String() string {
+	return fmt.Sprintf("sent: %v; delivered: %v; dropped: %v; duplicated: %v; partitioned: %v",
+		ns.Sent, ns.Delivered, ns.Dropped, ns.Duplicated, ns.Partitioned)
+}
+
+type link struct {
+	from common.RMId
+	to   common.RMId
+}
+
+// Network delivers messages between Nodes, using a Clock. All of its
+// randomness comes from a single seed.
+type Network struct {
+	Stats       NetworkStats
+	clock       *Clock
+	rng         *rand.Rand
+	faults      Faults
+	nodes       map[common.RMId]Node
+	partitioned map[link]bool
+	observers   []func(from, to common.RMId, msg []byte)
+}
+
+func NewNetwork(clock *Clock, seed int64, faults Faults) *Network {
+	return &Network{
+		clock:       clock,
+		rng:         rand.New(rand.NewSource(seed)),
+		faults:      faults,
+		nodes:       make(map[common.RMId]Node),
+		partitioned: make(map[link]bool),
+	}
+}
+
+func (n *Network) AddNode(rmId common.RMId, node Node) {
+	n.nodes[rmId] = node
+}
+
+func (n *Network) SetFaults(faults Faults) {
+	n.faults = faults
+}
+
+// Observe adds fun to be called with every message delivered, just
+// before it is delivered.
+func (n *Network) Observe(fun func(from, to common.RMId, msg []byte)) {
+	n.observers = append(n.observers, fun)
+}
+
+// Partition cuts every link between an RM in as and an RM in bs, in
+// both directions. Messages sent over a cut link are lost.
+func (n *Network) Partition(as, bs []common.RMId) {
+	for _, a := range as {
+		for _, b := range bs {
+			n.partitioned[link{from: a, to: b}] = true
+			n.partitioned[link{from: b, to: a}] = true
+		}
+	}
+}
+
+// Heal restores every link cut by Partition.
+func (n *Network) Heal() {
+	n.partitioned = make(map[link]bool)
+}
+
+// Connection returns a paxos.Connection from one RM to another, which
+// sends over the network.
+func (n *Network) Connection(from, to common.RMId, bootCount uint32) *Connection {
+	return &Connection{
+		network: n,
+		from:    from,
+		to:      to,
+		boot:    bootCount,
+	}
+}
+
+// Send sends msg from one RM to another, subject to the network's
+// faults and partitions.
+func (n *Network) Send(from, to common.RMId, msg []byte) {
+	n.Stats.Sent++
+	switch {
+	case n.partitioned[link{from: from, to: to}]:
+		n.Stats.Partitioned++
+	case n.rng.Float64() < n.faults.Drop:
+		n.Stats.Dropped++
+	default:
+		n.deliverLater(from, to, msg)
+		if n.rng.Float64() < n.faults.Duplicate {
+			n.Stats.Duplicated++
+			n.deliverLater(from, to, msg)
+		}
+	}
+}
+
+func (n *Network) deliverLater(from, to common.RMId, msg []byte) {
+	delay := n.faults.MinDelay
+	if spread := n.faults.MaxDelay - n.faults.MinDelay; spread > 0 {
+		delay += time.Duration(n.rng.Int63n(int64(spread) + 1))
+	}
+	n.clock.After(delay, func() {
+		// The partition may have started since the message was sent.
+		if n.partitioned[link{from: from, to: to}] {
+			n.Stats.Partitioned++
+			return
+		}
+		node, found := n.nodes[to]
+		if !found {
+			n.Stats.Dropped++
+			return
+		}
+		n.Stats.Delivered++
+		for _, obs := range n.observers {
+			obs(from, to, msg)
+		}
+		node.Deliver(from, msg)
+	})
+}
+
+// Connection is a paxos.Connection over a simulated Network.
+type Connection struct {
+	network *Network
+	from    common.RMId
+	to      common.RMId
+	boot    uint32
+}
+
+var _ paxos.Connection = (*Connection)(nil)
+
+func (c *Connection) Host() string        { return fmt.Sprintf("sim-%v", c.to) }
+func (c *Connection) RMId() common.RMId   { return c.to }
+func (c *Connection) BootCount() uint32   { return c.boot }
+func (c *Connection) TieBreak() uint32    { return 0 }
+func (c *Connection) ClusterUUId() uint64 { return 0 }
+func (c *Connection) LearnerOnly() bool   { return false }
+func (c *Connection) Degraded() bool      { return false }
+func (c *Connection) Send(msg []byte)     { c.network.Send(c.from, c.to, msg) }
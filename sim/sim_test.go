@@ -0,0 +1,101 @@
+package sim
+
+import (
+	"fmt"
+	"goshawkdb.io/common"
+	"testing"
+	"time"
+)
+
+type recordingNode struct {
+	rmId     common.RMId
+	received *[]string
+}
+
+func (rn *recordingNode) Deliver(sender common.RMId, msg []byte) {
+	*rn.received = append(*rn.received, fmt.Sprintf("%v->%v:%s", sender, rn.rmId, msg))
+}
+
+func runNetwork(seed int64, faults Faults) ([]string, NetworkStats) {
+	clock := NewClock(time.Unix(0, 0))
+	network := NewNetwork(clock, seed, faults)
+	received := []string{}
+	rmIds := []common.RMId{1, 2, 3}
+	for _, rmId := range rmIds {
+		network.AddNode(rmId, &recordingNode{rmId: rmId, received: &received})
+	}
+	for idx := 0; idx < 20; idx++ {
+		for _, from := range rmIds {
+			for _, to := range rmIds {
+				if from != to {
+					network.Connection(from, to, 1).Send([]byte(fmt.Sprint(idx)))
+				}
+			}
+		}
+	}
+	clock.Run(0)
+	return received, network.Stats
+}
+
+func TestClockOrder(t *testing.T) {
+	clock := NewClock(time.Unix(0, 0))
+	order := []int{}
+	clock.After(2*time.Second, func() { order = append(order, 3) })
+	clock.After(time.Second, func() { order = append(order, 1) })
+	clock.After(time.Second, func() { order = append(order, 2) })
+	if ran := clock.Run(0); ran != 3 {
+		t.Fatalf("Expected 3 events to run; %v ran", ran)
+	}
+	if fmt.Sprint(order) != "[1 2 3]" {
+		t.Fatalf("Events ran out of order: %v", order)
+	}
+	if now := clock.Now(); !now.Equal(time.Unix(2, 0)) {
+		t.Fatalf("Expected clock to have advanced to the last event; it's at %v", now)
+	}
+}
+
+func TestNetworkDeterministic(t *testing.T) {
+	faults := Faults{Drop: 0.1, Duplicate: 0.1, MinDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	a, statsA := runNetwork(42, faults)
+	b, statsB := runNetwork(42, faults)
+	if fmt.Sprint(a) != fmt.Sprint(b) || statsA != statsB {
+		t.Fatalf("Two runs with the same seed differ:\n%v (%v)\n%v (%v)", a, statsA, b, statsB)
+	}
+	if statsA.Dropped == 0 || statsA.Duplicated == 0 {
+		t.Fatalf("Expected some messages to be dropped and duplicated: %v", statsA)
+	}
+	if statsA.Delivered != statsA.Sent-statsA.Dropped+statsA.Duplicated {
+		t.Fatalf("Delivered count doesn't add up: %v", statsA)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	clock := NewClock(time.Unix(0, 0))
+	network := NewNetwork(clock, 1, Faults{})
+	received := []string{}
+	network.AddNode(1, &recordingNode{rmId: 1, received: &received})
+	network.AddNode(2, &recordingNode{rmId: 2, received: &received})
+	network.Partition([]common.RMId{1}, []common.RMId{2})
+	network.Send(1, 2, []byte("lost"))
+	clock.Run(0)
+	network.Heal()
+	network.Send(2, 1, []byte("found"))
+	clock.Run(0)
+	if fmt.Sprint(received) != "[2->1:found]" {
+		t.Fatalf("Expected only the message sent after healing to arrive; got %v", received)
+	}
+}
+
+func TestOutcomeChecker(t *testing.T) {
+	oc := NewOutcomeChecker()
+	txnA := common.MakeTxnId(make([]byte, common.KeyLen))
+	oc.Record(1, txnA, true)
+	oc.Record(2, txnA, true)
+	if err := oc.Check(); err != nil {
+		t.Fatalf("Unexpected violation: %v", err)
+	}
+	oc.Record(3, txnA, false)
+	if err := oc.Check(); err == nil {
+		t.Fatal("Expected a txn that committed and aborted to be a violation")
+	}
+}
@@ -1,24 +1,64 @@
 package server
 
 import (
+	"encoding/json"
+	"fmt"
 	"strings"
 	"sync"
 	"sync/atomic"
 )
 
+// A StatusConsumer collects a tree of status: each Fork is a subtree,
+// which must be Joined once complete. Once the whole tree is joined,
+// it can be rendered as indented text with Consume, or as JSON with
+// ConsumeJSON.
 type StatusConsumer struct {
 	sync.Mutex
 	forkCount int32
 	sep       string
-	slots     [][]string
+	entries   []statusEntry
 	joined    chan struct{}
 }
 
+type statusEntry struct {
+	lines []string
+	key   string
+	value interface{}
+	fork  *StatusConsumer
+}
+
+func (se *statusEntry) text(sep string) string {
+	switch {
+	case se.fork != nil:
+		return se.fork.text()
+	case se.key != "":
+		return fmt.Sprintf("- %s: %v", se.key, se.value)
+	default:
+		return strings.Join(se.lines, sep)
+	}
+}
+
+var statusCollections = make(chan EmptyStruct, StatusCollectionConcurrency)
+
+// StartStatusCollection limits how many status collections can run at
+// once, so that monitoring can't pile up work on the executors. If ok
+// is false, the limit has been reached and no status should be
+// collected. Otherwise, done must be called once the status has been
+// consumed.
+func StartStatusCollection() (done func(), ok bool) {
+	select {
+	case statusCollections <- EmptyStructVal:
+		return func() { <-statusCollections }, true
+	default:
+		return nil, false
+	}
+}
+
 func NewStatusConsumer() *StatusConsumer {
 	return &StatusConsumer{
 		forkCount: 1,
 		sep:       "\n ",
-		slots:     make([][]string, 0, 16),
+		entries:   make([]statusEntry, 0, 16),
 		joined:    make(chan struct{}),
 	}
 }
@@ -28,15 +68,12 @@ func (s *StatusConsumer) Fork() *StatusConsumer {
 	sc := NewStatusConsumer()
 	sc.sep = s.sep + " "
 	s.Lock()
-	slotIdx := len(s.slots)
-	s.slots = append(s.slots, nil)
+	s.entries = append(s.entries, statusEntry{fork: sc})
 	s.Unlock()
-	go sc.Consume(func(str string) {
-		s.Lock()
-		s.slots[slotIdx] = []string{str}
-		s.Unlock()
+	go func() {
+		<-sc.joined
 		s.Join()
-	})
+	}()
 	return sc
 }
 
@@ -48,20 +85,71 @@ func (s *StatusConsumer) Join() {
 
 func (s *StatusConsumer) Emit(status ...string) {
 	s.Lock()
-	s.slots = append(s.slots, status)
+	s.entries = append(s.entries, statusEntry{lines: status})
+	s.Unlock()
+}
+
+// EmitKV emits a named value. In text, it is rendered as "- key:
+// value". In JSON, booleans, numbers and strings keep their types;
+// any other value is formatted as a string straight away, so it's
+// safe to pass values which will later be modified.
+func (s *StatusConsumer) EmitKV(key string, value interface{}) {
+	switch value.(type) {
+	case bool, string, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+	default:
+		value = fmt.Sprint(value)
+	}
+	s.Lock()
+	s.entries = append(s.entries, statusEntry{key: key, value: value})
 	s.Unlock()
 }
 
 func (s *StatusConsumer) Consume(fun func(string)) {
-	buf := " "
 	<-s.joined
-	for _, strs := range s.slots {
-		buf += strings.Join(strs, s.sep) + s.sep
+	fun(s.text())
+}
+
+func (s *StatusConsumer) text() string {
+	buf := " "
+	for idx := range s.entries {
+		buf += s.entries[idx].text(s.sep) + s.sep
 	}
 	if len(buf) == 1 {
-		fun(buf)
+		return buf
 	} else {
 		end := len(buf) - len(s.sep)
-		fun(buf[:end])
+		return buf[:end]
+	}
+}
+
+// statusJSON is the JSON form of a StatusConsumer. Emitted lines,
+// named values and forks are kept apart; lines and forks stay in the
+// order in which they were emitted.
+type statusJSON struct {
+	Lines    []string               `json:",omitempty"`
+	Values   map[string]interface{} `json:",omitempty"`
+	Children []*statusJSON          `json:",omitempty"`
+}
+
+func (s *StatusConsumer) ConsumeJSON(fun func([]byte, error)) {
+	<-s.joined
+	fun(json.Marshal(s.toJSON()))
+}
+
+func (s *StatusConsumer) toJSON() *statusJSON {
+	sj := &statusJSON{}
+	for _, entry := range s.entries {
+		switch {
+		case entry.fork != nil:
+			sj.Children = append(sj.Children, entry.fork.toJSON())
+		case entry.key != "":
+			if sj.Values == nil {
+				sj.Values = make(map[string]interface{})
+			}
+			sj.Values[entry.key] = entry.value
+		default:
+			sj.Lines = append(sj.Lines, entry.lines...)
+		}
 	}
+	return sj
 }
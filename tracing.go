@@ -0,0 +1,65 @@
+package server
+
+import (
+	"goshawkdb.io/common"
+	"sync"
+)
+
+// A Tracer records spans: timed pieces of work done for a txn, on
+// this RM. Spans for the same txn on different RMs are connected by
+// the txn's traceContext, which carries the Context of the span of
+// the RM that created the txn. The default Tracer does nothing. To
+// feed spans into OpenTracing or OpenTelemetry, install an adapter
+// with SetTracer, which should use the tracing system's binary
+// carrier format for Context and parent.
+type Tracer interface {
+	// StartSpan starts a span for txnId. parent is the Context of the
+	// parent span, and may be nil or empty.
+	StartSpan(operation string, txnId *common.TxnId, parent []byte) Span
+}
+
+type Span interface {
+	// Context returns the encoding of the span to be used as the
+	// parent of spans started elsewhere.
+	Context() []byte
+	// LogEvent records that event occurred within the span.
+	LogEvent(event string)
+	Finish()
+}
+
+type noopSpan struct{}
+
+func (ns noopSpan) Context() []byte       { return nil }
+func (ns noopSpan) LogEvent(event string) {}
+func (ns noopSpan) Finish()               {}
+
+type noopTracer struct{}
+
+func (nt noopTracer) StartSpan(string, *common.TxnId, []byte) Span { return noopSpan{} }
+
+var tracer struct {
+	sync.RWMutex
+	Tracer
+}
+
+func init() {
+	tracer.Tracer = noopTracer{}
+}
+
+// SetTracer installs t as the Tracer. If t is nil, tracing is
+// disabled.
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracer.Lock()
+	defer tracer.Unlock()
+	tracer.Tracer = t
+}
+
+func StartSpan(operation string, txnId *common.TxnId, parent []byte) Span {
+	tracer.RLock()
+	t := tracer.Tracer
+	tracer.RUnlock()
+	return t.StartSpan(operation, txnId, parent)
+}
@@ -0,0 +1,98 @@
+package txnengine
+
+import (
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"sync"
+)
+
+// A BadRead ballot carries the actions of the txn which the voter
+// believes the aborting txn should have read. For large txns that is
+// expensive, and a heavily contended var can issue many BadRead
+// ballots against the same txn. So payloads bigger than
+// BadReadPayloadMaxBytes are trimmed to just the voting var's action
+// (which is all the ballot accumulator strictly needs from this
+// voter), and the trimmed payloads are cached, shared by all vars.
+type badReadPayloadCache struct {
+	sync.Mutex
+	payloads map[badReadPayloadKey][]byte
+	order    []badReadPayloadKey
+	next     int
+	trimmed  uint64
+	hits     uint64
+}
+
+type badReadPayloadKey struct {
+	txnId common.TxnId
+	vUUId common.VarUUId
+}
+
+var badReadPayloads = &badReadPayloadCache{
+	payloads: make(map[badReadPayloadKey][]byte, server.BadReadPayloadCacheSize),
+	order:    make([]badReadPayloadKey, 0, server.BadReadPayloadCacheSize),
+}
+
+func badReadPayload(txnId *common.TxnId, vUUId *common.VarUUId, actions *TxnActions) []byte {
+	bites := actions.Data
+	if len(bites) <= server.BadReadPayloadMaxBytes {
+		return bites
+	}
+	return badReadPayloads.trimmedPayload(txnId, vUUId, actions)
+}
+
+func (c *badReadPayloadCache) trimmedPayload(txnId *common.TxnId, vUUId *common.VarUUId, actions *TxnActions) []byte {
+	key := badReadPayloadKey{txnId: *txnId, vUUId: *vUUId}
+	c.Lock()
+	if bites, found := c.payloads[key]; found {
+		c.hits++
+		c.Unlock()
+		return bites
+	}
+	c.Unlock()
+
+	bites := trimActions(vUUId, actions)
+
+	c.Lock()
+	defer c.Unlock()
+	c.trimmed++
+	if _, found := c.payloads[key]; found {
+		return bites
+	}
+	if len(c.order) < cap(c.order) {
+		c.order = append(c.order, key)
+	} else {
+		delete(c.payloads, c.order[c.next])
+		c.order[c.next] = key
+		c.next = (c.next + 1) % len(c.order)
+	}
+	c.payloads[key] = bites
+	return bites
+}
+
+func trimActions(vUUId *common.VarUUId, actions *TxnActions) []byte {
+	actionsCap := actions.Actions()
+	for idx, l := 0, actionsCap.Len(); idx < l; idx++ {
+		action := actionsCap.At(idx)
+		if common.MakeVarUUId(action.VarId()).Compare(vUUId) != common.EQ {
+			continue
+		}
+		seg := capn.NewBuffer(nil)
+		root := msgs.NewRootActionListWrapper(seg)
+		list := msgs.NewActionList(seg, 1)
+		root.SetActions(list)
+		list.Set(0, action)
+		return server.SegToBytes(seg)
+	}
+	panic(fmt.Sprintf("BadRead for %v against txn which does not contain it.", vUUId))
+}
+
+func BadReadPayloadStatus(sc *server.StatusConsumer) {
+	c := badReadPayloads
+	c.Lock()
+	sc.Emit(fmt.Sprintf("BadRead Payloads Trimmed: %v (cache hits: %v; cached: %v)", c.trimmed, c.hits, len(c.payloads)))
+	c.Unlock()
+	sc.Join()
+}
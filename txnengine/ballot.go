@@ -6,6 +6,7 @@ import (
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
+	"sync"
 )
 
 type Vote msgs.Vote_Which
@@ -50,11 +51,16 @@ func (b *Ballot) String() string {
 	return fmt.Sprintf("%v %v", b.VarUUId, b.Vote)
 }
 
+// A BallotBuilder is used once, to build a single Ballot: once
+// ToBallot or CreateBadReadBallot has been called, the builder is
+// returned to a pool and must not be used again.
 type BallotBuilder struct {
 	*Ballot
 	Clock *VectorClockMutable
 }
 
+var ballotBuilderPool = sync.Pool{New: func() interface{} { return new(BallotBuilder) }}
+
 func BallotFromData(data []byte) *Ballot {
 	seg, _, err := capn.ReadFromMemoryZeroCopy(data)
 	if err != nil {
@@ -77,14 +83,21 @@ func (ballot *Ballot) Aborted() bool {
 }
 
 func NewBallotBuilder(vUUId *common.VarUUId, vote Vote, clock *VectorClockMutable) *BallotBuilder {
-	ballot := &Ballot{
+	builder := ballotBuilderPool.Get().(*BallotBuilder)
+	builder.Ballot = &Ballot{
 		VarUUId: vUUId,
 		Vote:    vote,
 	}
-	return &BallotBuilder{
-		Ballot: ballot,
-		Clock:  clock,
-	}
+	builder.Clock = clock
+	return builder
+}
+
+func (ballot *BallotBuilder) release() *Ballot {
+	result := ballot.Ballot
+	ballot.Ballot = nil
+	ballot.Clock = nil
+	ballotBuilderPool.Put(ballot)
+	return result
 }
 
 func (ballot *BallotBuilder) buildSeg() (*capn.Segment, msgs.Ballot) {
@@ -106,10 +119,10 @@ func (ballot *BallotBuilder) CreateBadReadBallot(txnId *common.TxnId, actions *T
 	voteCap.SetAbortBadRead()
 	badReadCap := voteCap.AbortBadRead()
 	badReadCap.SetTxnId(txnId[:])
-	badReadCap.SetTxnActions(actions.Data)
+	badReadCap.SetTxnActions(badReadPayload(txnId, ballot.VarUUId, actions))
 	ballotCap.SetVote(voteCap)
 	ballot.Data = server.SegToBytes(seg)
-	return ballot.Ballot
+	return ballot.release()
 }
 
 func (ballot *BallotBuilder) ToBallot() *Ballot {
@@ -130,5 +143,5 @@ func (ballot *BallotBuilder) ToBallot() *Ballot {
 
 	ballotCap.SetVote(*ballot.VoteCap)
 	ballot.Data = server.SegToBytes(seg)
-	return ballot.Ballot
+	return ballot.release()
 }
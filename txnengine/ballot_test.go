@@ -0,0 +1,38 @@
+package txnengine
+
+import (
+	"goshawkdb.io/common"
+	"testing"
+)
+
+func benchmarkVarUUIds(n int) []*common.VarUUId {
+	vUUIds := make([]*common.VarUUId, n)
+	for idx := range vUUIds {
+		vUUId := common.VarUUId{}
+		vUUId[0], vUUId[1] = byte(idx), byte(idx>>8)
+		vUUIds[idx] = &vUUId
+	}
+	return vUUIds
+}
+
+func BenchmarkAbortBallot(b *testing.B) {
+	vUUIds := benchmarkVarUUIds(64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		NewBallotBuilder(vUUIds[idx%len(vUUIds)], AbortDeadlock, nil).ToBallot()
+	}
+}
+
+func BenchmarkCommitBallot(b *testing.B) {
+	vUUIds := benchmarkVarUUIds(64)
+	clock := NewVectorClock().AsMutable()
+	for idx, vUUId := range vUUIds[:8] {
+		clock.SetVarIdMax(vUUId, uint64(idx+1))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		NewBallotBuilder(vUUIds[idx%len(vUUIds)], Commit, clock).ToBallot()
+	}
+}
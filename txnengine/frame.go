@@ -54,7 +54,7 @@ func NewFrame(parent *frame, v *Var, txnId *common.TxnId, txnActions *TxnActions
 		f.scheduleBackoff.Shrink(server.VarRollDelayMin)
 	}
 	f.init()
-	server.Log(f, "NewFrame")
+	debugLog.Log(f, "NewFrame")
 	f.maybeStartRoll()
 	return f
 }
@@ -86,7 +86,35 @@ func (f *frame) String() string {
 }
 
 func (f *frame) Status(sc *server.StatusConsumer) {
-	sc.Emit(f.String())
+	f.statusSnapshot().status(sc)
+}
+
+// frameStatusSnapshot is a copy of the state of a frame which can be
+// formatted off the executor. It must be taken on the executor.
+type frameStatusSnapshot struct {
+	vUUId             *common.VarUUId
+	frameTxnId        *common.TxnId
+	frameTxnClockLen  int
+	readVoteClock     *VectorClockMutable
+	writeVoteClock    *VectorClockMutable
+	readCount         int
+	readHistogram     []int
+	uncommittedReads  int
+	learntFutureReads int
+	writeCount        int
+	writeHistogram    []int
+	uncommittedWrites int
+	rwPresent         bool
+	mask              *VectorClockMutable
+	currentState      string
+	rollScheduled     bool
+	rollActive        bool
+	onDisk            bool
+	hasChild          bool
+	parent            *frameStatusSnapshot
+}
+
+func (f *frame) statusSnapshot() *frameStatusSnapshot {
 	readHistogram := make([]int, 4)
 	for node := f.reads.First(); node != nil; node = node.Next() {
 		readHistogram[int(node.Value.(txnStatus))]++
@@ -95,20 +123,50 @@ func (f *frame) Status(sc *server.StatusConsumer) {
 	for node := f.writes.First(); node != nil; node = node.Next() {
 		writeHistogram[int(node.Value.(txnStatus))]++
 	}
-	sc.Emit(fmt.Sprintf("- Read Count: %v %v", f.reads.Len(), readHistogram))
-	sc.Emit(fmt.Sprintf("- Uncommitted Read Count: %v", f.uncommittedReads))
-	sc.Emit(fmt.Sprintf("- Learnt future reads: %v", len(f.learntFutureReads)))
-	sc.Emit(fmt.Sprintf("- Write Count: %v %v", f.writes.Len(), writeHistogram))
-	sc.Emit(fmt.Sprintf("- Uncommitted Write Count: %v", f.uncommittedWrites))
-	sc.Emit(fmt.Sprintf("- RW Present: %v", f.rwPresent))
-	sc.Emit(fmt.Sprintf("- Mask: %v", f.mask))
-	sc.Emit(fmt.Sprintf("- Current State: %v", f.currentState))
-	sc.Emit(fmt.Sprintf("- Roll scheduled/active? %v/%v", f.rollScheduled != nil, f.rollActive))
-	sc.Emit(fmt.Sprintf("- DescendentOnDisk? %v", f.onDisk))
-	sc.Emit(fmt.Sprintf("- Child == nil? %v", f.child == nil))
-	sc.Emit(fmt.Sprintf("- Parent == nil? %v", f.parent == nil))
+	// The clocks are copy-on-write, so cloning them is cheap.
+	fss := &frameStatusSnapshot{
+		vUUId:             f.v.UUId,
+		frameTxnId:        f.frameTxnId,
+		frameTxnClockLen:  f.frameTxnClock.Len(),
+		readVoteClock:     f.readVoteClock.Clone(),
+		writeVoteClock:    f.writeVoteClock.Clone(),
+		readCount:         f.reads.Len(),
+		readHistogram:     readHistogram,
+		uncommittedReads:  f.uncommittedReads,
+		learntFutureReads: len(f.learntFutureReads),
+		writeCount:        f.writes.Len(),
+		writeHistogram:    writeHistogram,
+		uncommittedWrites: f.uncommittedWrites,
+		rwPresent:         f.rwPresent,
+		mask:              f.mask.Clone(),
+		currentState:      fmt.Sprint(f.currentState),
+		rollScheduled:     f.rollScheduled != nil,
+		rollActive:        f.rollActive,
+		onDisk:            f.onDisk,
+		hasChild:          f.child != nil,
+	}
 	if f.parent != nil {
-		f.parent.Status(sc.Fork())
+		fss.parent = f.parent.statusSnapshot()
+	}
+	return fss
+}
+
+func (fss *frameStatusSnapshot) status(sc *server.StatusConsumer) {
+	sc.Emit(fmt.Sprintf("%v Frame %v (%v) r%v w%v", fss.vUUId, fss.frameTxnId, fss.frameTxnClockLen, fss.readVoteClock, fss.writeVoteClock))
+	sc.Emit(fmt.Sprintf("- Read Count: %v %v", fss.readCount, fss.readHistogram))
+	sc.Emit(fmt.Sprintf("- Uncommitted Read Count: %v", fss.uncommittedReads))
+	sc.Emit(fmt.Sprintf("- Learnt future reads: %v", fss.learntFutureReads))
+	sc.Emit(fmt.Sprintf("- Write Count: %v %v", fss.writeCount, fss.writeHistogram))
+	sc.Emit(fmt.Sprintf("- Uncommitted Write Count: %v", fss.uncommittedWrites))
+	sc.Emit(fmt.Sprintf("- RW Present: %v", fss.rwPresent))
+	sc.Emit(fmt.Sprintf("- Mask: %v", fss.mask))
+	sc.Emit(fmt.Sprintf("- Current State: %v", fss.currentState))
+	sc.Emit(fmt.Sprintf("- Roll scheduled/active? %v/%v", fss.rollScheduled, fss.rollActive))
+	sc.Emit(fmt.Sprintf("- DescendentOnDisk? %v", fss.onDisk))
+	sc.Emit(fmt.Sprintf("- Child == nil? %v", !fss.hasChild))
+	sc.Emit(fmt.Sprintf("- Parent == nil? %v", fss.parent == nil))
+	if fss.parent != nil {
+		fss.parent.status(sc.Fork())
 	}
 	sc.Join()
 }
@@ -163,7 +221,7 @@ func (fo *frameOpen) String() string            { return "frameOpen" }
 
 func (fo *frameOpen) ReadRetry(action *localAction) bool {
 	txn := action.Txn
-	server.Log(fo.frame, "ReadRetry", txn)
+	debugLog.Log(fo.frame, "ReadRetry", txn)
 	switch {
 	case fo.currentState != fo:
 		panic(fmt.Sprintf("%v ReadRetry called for %v with frame in state %v", fo.v, txn, fo.currentState))
@@ -179,12 +237,13 @@ func (fo *frameOpen) ReadRetry(action *localAction) bool {
 func (fo *frameOpen) AddRead(action *localAction) {
 	fo.v.poisson.AddNow()
 	txn := action.Txn
-	server.Log(fo.frame, "AddRead", txn, action.readVsn)
+	debugLog.Log(fo.frame, "AddRead", txn, action.readVsn)
 	switch {
 	case fo.currentState != fo:
 		panic(fmt.Sprintf("%v AddRead called for %v with frame in state %v", fo.v, txn, fo.currentState))
 	case fo.writes.Len() != 0 || (fo.writes.Len() != 0 && fo.writes.First().Key.Compare(action) == sl.LT) || fo.frameTxnActions == nil:
 		// We could have learnt a write at this point but we're still fine to accept smaller reads.
+		fo.v.heat.deadlocked()
 		action.VoteDeadlock(fo.frameTxnClock)
 	case fo.frameTxnId.Compare(action.readVsn) != common.EQ:
 		action.VoteBadRead(fo.frameTxnClock, fo.frameTxnId, fo.frameTxnActions)
@@ -207,7 +266,7 @@ func (fo *frameOpen) AddRead(action *localAction) {
 
 func (fo *frameOpen) ReadAborted(action *localAction) {
 	txn := action.Txn
-	server.Log(fo.frame, "ReadAborted", txn)
+	debugLog.Log(fo.frame, "ReadAborted", txn)
 	if fo.currentState != fo {
 		panic(fmt.Sprintf("%v ReadAborted called for %v with frame in state %v", fo.frame, txn, fo.currentState))
 	}
@@ -225,7 +284,7 @@ func (fo *frameOpen) ReadAborted(action *localAction) {
 
 func (fo *frameOpen) ReadCommitted(action *localAction) {
 	txn := action.Txn
-	server.Log(fo.frame, "ReadCommitted", txn)
+	debugLog.Log(fo.frame, "ReadCommitted", txn)
 	if fo.currentState != fo {
 		panic(fmt.Sprintf("%v ReadAborted called for %v with frame in state %v", fo.v, txn, fo.currentState))
 	}
@@ -241,13 +300,14 @@ func (fo *frameOpen) ReadCommitted(action *localAction) {
 func (fo *frameOpen) AddWrite(action *localAction) {
 	fo.v.poisson.AddNow()
 	txn := action.Txn
-	server.Log(fo.frame, "AddWrite", txn)
+	debugLog.Log(fo.frame, "AddWrite", txn)
 	cid := txn.Id.ClientId()
 	_, found := fo.clientWrites[cid]
 	switch {
 	case fo.currentState != fo:
 		panic(fmt.Sprintf("%v AddWrite called for %v with frame in state %v", fo.v, txn, fo.currentState))
 	case fo.rwPresent || (fo.maxUncommittedRead != nil && action.Compare(fo.maxUncommittedRead) == sl.LT) || found || len(fo.learntFutureReads) != 0:
+		fo.v.heat.deadlocked()
 		action.VoteDeadlock(fo.frameTxnClock)
 	case fo.writes.Get(action) == nil:
 		fo.uncommittedWrites++
@@ -269,7 +329,7 @@ func (fo *frameOpen) AddWrite(action *localAction) {
 
 func (fo *frameOpen) WriteAborted(action *localAction, permitInactivate bool) {
 	txn := action.Txn
-	server.Log(fo.frame, "WriteAborted", txn)
+	debugLog.Log(fo.frame, "WriteAborted", txn)
 	if fo.currentState != fo {
 		panic(fmt.Sprintf("%v WriteAborted called for %v with frame in state %v", fo.v, txn, fo.currentState))
 	}
@@ -294,7 +354,7 @@ func (fo *frameOpen) WriteAborted(action *localAction, permitInactivate bool) {
 
 func (fo *frameOpen) WriteCommitted(action *localAction) {
 	txn := action.Txn
-	server.Log(fo.frame, "WriteCommitted", txn)
+	debugLog.Log(fo.frame, "WriteCommitted", txn)
 	if fo.currentState != fo {
 		panic(fmt.Sprintf("%v WriteCommitted called for %v with frame in state %v", fo.v, txn, fo.currentState))
 	}
@@ -311,11 +371,12 @@ func (fo *frameOpen) WriteCommitted(action *localAction) {
 func (fo *frameOpen) AddReadWrite(action *localAction) {
 	fo.v.poisson.AddNow()
 	txn := action.Txn
-	server.Log(fo.frame, "AddReadWrite", txn, action.readVsn)
+	debugLog.Log(fo.frame, "AddReadWrite", txn, action.readVsn)
 	switch {
 	case fo.currentState != fo:
 		panic(fmt.Sprintf("%v AddReadWrite called for %v with frame in state %v", fo.v, txn, fo.currentState))
 	case fo.writes.Len() != 0 || fo.writes.Len() != 0 || (fo.maxUncommittedRead != nil && action.Compare(fo.maxUncommittedRead) == sl.LT) || fo.frameTxnActions == nil || len(fo.learntFutureReads) != 0:
+		fo.v.heat.deadlocked()
 		action.VoteDeadlock(fo.frameTxnClock)
 	case fo.frameTxnId.Compare(action.readVsn) != common.EQ:
 		action.VoteBadRead(fo.frameTxnClock, fo.frameTxnId, fo.frameTxnActions)
@@ -340,7 +401,7 @@ func (fo *frameOpen) AddReadWrite(action *localAction) {
 
 func (fo *frameOpen) ReadWriteAborted(action *localAction, permitInactivate bool) {
 	txn := action.Txn
-	server.Log(fo.frame, "ReadWriteAborted", txn)
+	debugLog.Log(fo.frame, "ReadWriteAborted", txn)
 	if fo.currentState != fo {
 		panic(fmt.Sprintf("%v ReadWriteAborted called for %v with frame in state %v", fo.v, txn, fo.currentState))
 	}
@@ -365,7 +426,7 @@ func (fo *frameOpen) ReadWriteAborted(action *localAction, permitInactivate bool
 
 func (fo *frameOpen) ReadWriteCommitted(action *localAction) {
 	txn := action.Txn
-	server.Log(fo.frame, "ReadWriteCommitted", txn)
+	debugLog.Log(fo.frame, "ReadWriteCommitted", txn)
 	if fo.currentState != fo {
 		panic(fmt.Sprintf("%v ReadWriteCommitted called for %v with frame in state %v", fo.v, txn, fo.currentState))
 	}
@@ -398,11 +459,11 @@ func (fo *frameOpen) ReadLearnt(action *localAction) bool {
 		// only should ignore this read if its write clock elem is < our
 		// frame write clock elem.
 		if actClockElem < reqClockElem {
-			server.Log(fo.frame, "ReadLearnt", txn, "ignored, too old")
+			debugLog.Log(fo.frame, "ReadLearnt", txn, "ignored, too old")
 			fo.maybeStartRoll()
 			return false
 		} else {
-			server.Log(fo.frame, "ReadLearnt", txn, "of future frame")
+			debugLog.Log(fo.frame, "ReadLearnt", txn, "of future frame")
 			fo.learntFutureReads = append(fo.learntFutureReads, action)
 			action.frame = fo.frame
 			return true
@@ -430,7 +491,7 @@ func (fo *frameOpen) ReadLearnt(action *localAction) bool {
 			return true
 		})
 		fo.subtractClock(mask)
-		server.Log(fo.frame, "ReadLearnt", txn, "uncommittedReads:", fo.uncommittedReads, "uncommittedWrites:", fo.uncommittedWrites)
+		debugLog.Log(fo.frame, "ReadLearnt", txn, "uncommittedReads:", fo.uncommittedReads, "uncommittedWrites:", fo.uncommittedWrites)
 		fo.maybeStartRoll()
 		return true
 	} else {
@@ -446,12 +507,12 @@ func (fo *frameOpen) WriteLearnt(action *localAction) bool {
 	actClockElem := action.outcomeClock.At(fo.v.UUId)
 	reqClockElem := fo.frameTxnClock.At(fo.v.UUId)
 	if actClockElem < reqClockElem || (actClockElem == reqClockElem && action.Id.Compare(fo.frameTxnId) == common.LT) {
-		server.Log(fo.frame, "WriteLearnt", txn, "ignored, too old")
+		debugLog.Log(fo.frame, "WriteLearnt", txn, "ignored, too old")
 		fo.maybeStartRoll()
 		return false
 	}
 	if action.Id.Compare(fo.frameTxnId) == common.EQ {
-		server.Log(fo.frame, "WriteLearnt", txn, "is duplicate of current frame")
+		debugLog.Log(fo.frame, "WriteLearnt", txn, "is duplicate of current frame")
 		fo.maybeStartRoll()
 		return false
 	}
@@ -483,7 +544,7 @@ func (fo *frameOpen) WriteLearnt(action *localAction) bool {
 			return true
 		})
 		fo.subtractClock(mask)
-		server.Log(fo.frame, "WriteLearnt", txn, "uncommittedReads:", fo.uncommittedReads, "uncommittedWrites:", fo.uncommittedWrites)
+		debugLog.Log(fo.frame, "WriteLearnt", txn, "uncommittedReads:", fo.uncommittedReads, "uncommittedWrites:", fo.uncommittedWrites)
 		fo.maybeCreateChild()
 		return true
 	} else {
@@ -719,7 +780,7 @@ func (fo *frameOpen) maybeCreateChild() {
 	fo.v.SetCurFrame(fo.child, winner, positions)
 	for _, action := range fo.learntFutureReads {
 		action.frame = nil
-		server.Log(fo.frame, "new frame learning future reads")
+		debugLog.Log(fo.frame, "new frame learning future reads")
 		if !fo.child.ReadLearnt(action) {
 			action.LocallyComplete()
 		}
@@ -734,7 +795,21 @@ func (fo *frameOpen) maybeCreateChild() {
 
 func (fo *frameOpen) basicRollCondition(rescheduling bool) bool {
 	return (rescheduling || fo.rollScheduled == nil) && !fo.rollActive && fo.currentState == fo && fo.child == nil && fo.writes.Len() == 0 && fo.v.positions != nil && fo.v.curFrame == fo.frame &&
-		(fo.reads.Len() > fo.uncommittedReads || (fo.frameTxnClock.Len() > fo.frameTxnActions.Actions().Len() && fo.parent == nil && fo.reads.Len() == 0 && len(fo.learntFutureReads) == 0))
+		(fo.reads.Len() > fo.uncommittedReads || (fo.parent == nil && fo.reads.Len() == 0 && len(fo.learntFutureReads) == 0 && fo.frameTxnClock.Len() > fo.frameTxnActions.Actions().Len()+fo.rollClockSlack()))
+}
+
+// rollClockSlack is how much bigger than its minimum we allow the
+// clock of an unread frame to be before we roll to compact it. A cold
+// var is compacted as soon as possible. But for a var with lots of
+// concurrent txns, the next txns will only grow the clock straight
+// back again, so the roll would just be wasted work: we allow roughly
+// one elem per txn expected before we would next consider rolling.
+func (fo *frameOpen) rollClockSlack() int {
+	expected := fo.v.poisson.Expected(server.VarRollDelayMax, time.Now())
+	if slack := int(expected); slack < server.VarRollClockSlackMax {
+		return slack
+	}
+	return server.VarRollClockSlackMax
 }
 
 func (fo *frameOpen) maybeStartRoll() {
@@ -773,7 +848,7 @@ func (fo *frameOpen) maybeStartRollFrom(rescheduling bool) {
 }
 
 func (fo *frameOpen) scheduleRoll() {
-	server.Log(fo.frame, "Roll callback scheduled")
+	debugLog.Log(fo.frame, "Roll callback scheduled")
 	// fmt.Printf("s%v(%v|%v)\n", fo.v.UUId, probOfZero, fo.scheduleBackoff.Cur)
 	fo.v.vm.ScheduleCallback(fo.scheduleBackoff.Advance(), func(*time.Time) {
 		fo.v.applyToVar(func() {
@@ -786,7 +861,18 @@ func (fo *frameOpen) startRoll(rollCB rollCallback) {
 	fo.rollActive = true
 	// must do roll txn creation in the main go-routine
 	ctxn, varPosMap := fo.createRollClientTxn()
-	server.Log(fo.frame, "Starting roll")
+	// The roll gets a fresh txn id each time, rather than one derived
+	// from the var and frame version, even though that would let the
+	// proposers recognise duplicate rolls. Only the first RM in the
+	// var's permutation rolls (see rollTranslationCallback), so
+	// duplicates only come from RMs which disagree about who is
+	// connected. Their roll txns differ (submitter, allocations), and
+	// the proposers and acceptors would each keep whichever copy
+	// reached them first, voting on different txns under the same
+	// id. Outcomes also only go to the submitter of the copy which
+	// won, leaving the other roll waiting forever, and a retry at the
+	// same version would be ignored as already finished.
+	debugLog.Log(fo.frame, "Starting roll")
 	go func() {
 		_, outcome, err := fo.v.vm.RunClientTransaction(ctxn, varPosMap, rollCB.rollTranslationCallback)
 		ow := ""
@@ -798,7 +884,7 @@ func (fo *frameOpen) startRoll(rollCB rollCallback) {
 		}
 		// fmt.Printf("%v r%v (%v)\n", fo.v.UUId, ow, err == AbortRollNotFirst)
 		fo.v.applyToVar(func() {
-			server.Log(fo.frame, "Roll finished: outcome", ow, "; err:", err)
+			debugLog.Log(fo.frame, "Roll finished: outcome", ow, "; err:", err)
 			if fo.v.curFrame != fo.frame {
 				return
 			}
@@ -950,7 +1036,7 @@ func (fc *frameClosed) String() string            { return "frameClosed" }
 
 func (fc *frameClosed) DescendentOnDisk() bool {
 	if !fc.onDisk {
-		server.Log(fc.frame, "DescendentOnDisk")
+		debugLog.Log(fc.frame, "DescendentOnDisk")
 		fc.onDisk = true
 		fc.MaybeCompleteTxns()
 		return true
@@ -960,7 +1046,7 @@ func (fc *frameClosed) DescendentOnDisk() bool {
 
 func (fc *frameClosed) MaybeCompleteTxns() {
 	if fc.currentState == fc && fc.onDisk && fc.parent == nil {
-		server.Log(fc.frame, "MaybeCompleteTxns")
+		debugLog.Log(fc.frame, "MaybeCompleteTxns")
 		fc.nextState()
 		for node := fc.reads.First(); node != nil; node = node.Next() {
 			if node.Value == committed {
@@ -999,7 +1085,7 @@ func (fe *frameErase) String() string            { return "frameErase" }
 
 func (fe *frameErase) ReadGloballyComplete(action *localAction) {
 	txn := action.Txn
-	server.Log(fe.frame, "ReadGloballyComplete", txn)
+	debugLog.Log(fe.frame, "ReadGloballyComplete", txn)
 	if fe.currentState != fe {
 		panic(fmt.Sprintf("%v ReadGloballyComplete called for %v with frame in state %v", fe.v, txn, fe.currentState))
 	}
@@ -1014,7 +1100,7 @@ func (fe *frameErase) ReadGloballyComplete(action *localAction) {
 
 func (fe *frameErase) WriteGloballyComplete(action *localAction) {
 	txn := action.Txn
-	server.Log(fe.frame, "WriteGloballyComplete", txn)
+	debugLog.Log(fe.frame, "WriteGloballyComplete", txn)
 	if fe.currentState != fe {
 		panic(fmt.Sprintf("%v WriteGloballyComplete called for %v with frame in state %v", fe.v, txn, fe.currentState))
 	}
@@ -1030,7 +1116,7 @@ func (fe *frameErase) WriteGloballyComplete(action *localAction) {
 func (fe *frameErase) maybeErase() {
 	// (we won't receive TGCs for learnt writes)
 	if fe.reads.Len() == 0 && fe.writes.Len() == 0 {
-		server.Log(fe.frame, "maybeErase")
+		debugLog.Log(fe.frame, "maybeErase")
 		child := fe.child
 		child.parent = nil
 		child.MaybeCompleteTxns() // child may be in frame open!
@@ -0,0 +1,131 @@
+package txnengine
+
+import (
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	"sort"
+	"time"
+)
+
+// varHeat counts the txns arriving at a var, and how they end, over a
+// sliding window of between one and two HotVarWindows. It also spots
+// deadlock loops: a var on which txns have been voted to deadlock in
+// every one of the last HotVarDeadlockLoopWindows windows.
+type varHeat struct {
+	cur             heatWindow
+	prev            heatWindow
+	deadlockWindows int
+}
+
+type heatWindow struct {
+	start     time.Time
+	arrivals  uint64
+	commits   uint64
+	aborts    uint64
+	deadlocks uint64
+}
+
+func (vh *varHeat) roll(now time.Time) *heatWindow {
+	switch elapsed := now.Sub(vh.cur.start); {
+	case elapsed < server.HotVarWindow:
+		return &vh.cur
+	case elapsed < 2*server.HotVarWindow:
+		vh.prev = vh.cur
+	default:
+		vh.prev = heatWindow{}
+	}
+	if vh.prev.deadlocks == 0 {
+		vh.deadlockWindows = 0
+	} else {
+		vh.deadlockWindows++
+	}
+	vh.cur = heatWindow{start: now}
+	return &vh.cur
+}
+
+func (vh *varHeat) arrived() { vh.roll(time.Now()).arrivals++ }
+
+func (vh *varHeat) committed() { vh.roll(time.Now()).commits++ }
+
+func (vh *varHeat) aborted() { vh.roll(time.Now()).aborts++ }
+
+func (vh *varHeat) deadlocked() { vh.roll(time.Now()).deadlocks++ }
+
+// HotVar describes how contended an active var has recently been.
+type HotVar struct {
+	VarUUId      *common.VarUUId
+	Arrivals     uint64
+	Commits      uint64
+	Aborts       uint64
+	Deadlocks    uint64
+	Rate         float64 // arrivals per second
+	AbortRatio   float64
+	DeadlockLoop bool
+	Advice       string
+}
+
+func (v *Var) hotVar(now time.Time) *HotVar {
+	vh := &v.heat
+	vh.roll(now)
+	start := vh.cur.start
+	if !vh.prev.start.IsZero() {
+		start = vh.prev.start
+	}
+	hv := &HotVar{
+		VarUUId:      v.UUId,
+		Arrivals:     vh.cur.arrivals + vh.prev.arrivals,
+		Commits:      vh.cur.commits + vh.prev.commits,
+		Aborts:       vh.cur.aborts + vh.prev.aborts,
+		Deadlocks:    vh.cur.deadlocks + vh.prev.deadlocks,
+		DeadlockLoop: vh.deadlockWindows >= server.HotVarDeadlockLoopWindows,
+	}
+	if elapsed := now.Sub(start); elapsed > 0 {
+		hv.Rate = float64(hv.Arrivals) / elapsed.Seconds()
+	}
+	if outcomes := hv.Commits + hv.Aborts; outcomes != 0 {
+		hv.AbortRatio = float64(hv.Aborts) / float64(outcomes)
+	}
+	switch {
+	case hv.DeadlockLoop:
+		hv.Advice = "Txns keep deadlocking on this var: avoid touching it alongside other hot vars in the same txn."
+	case hv.Arrivals >= server.HotVarMinArrivals && hv.AbortRatio >= server.HotVarAbortRatio:
+		hv.Advice = "Most txns on this var abort: consider splitting it into several vars."
+	}
+	return hv
+}
+
+type hotVars []*HotVar
+
+func (hvs hotVars) Len() int           { return len(hvs) }
+func (hvs hotVars) Less(i, j int) bool { return hvs[i].Rate > hvs[j].Rate }
+func (hvs hotVars) Swap(i, j int)      { hvs[i], hvs[j] = hvs[j], hvs[i] }
+
+// HotVars returns the active vars which have seen any txns in the
+// window, hottest first.
+func (vm *VarManager) HotVars() []*HotVar {
+	now := time.Now()
+	hvs := []*HotVar{}
+	for _, v := range vm.active {
+		if hv := v.hotVar(now); hv.Arrivals != 0 {
+			hvs = append(hvs, hv)
+		}
+	}
+	sort.Sort(hotVars(hvs))
+	return hvs
+}
+
+// HotVars returns up to limit of the hottest active vars across every
+// var manager. If limit is 0, every var seen in the window is
+// returned.
+func (vd *VarDispatcher) HotVars(limit int) []*HotVar {
+	hvs := []*HotVar{}
+	for idx, executor := range vd.Executors {
+		manager := vd.varmanagers[idx]
+		executor.EnqueueSync(func() { hvs = append(hvs, manager.HotVars()...) })
+	}
+	sort.Sort(hotVars(hvs))
+	if limit > 0 && len(hvs) > limit {
+		hvs = hvs[:limit]
+	}
+	return hvs
+}
@@ -74,6 +74,15 @@ func (p *Poisson) P(t time.Duration, k int64, now time.Time) float64 {
 	return (math.Pow(λt, float64(k)) * math.Exp(-λt)) / float64(fac(k))
 }
 
+// Expected returns the number of events expected within t.
+func (p *Poisson) Expected(t time.Duration, now time.Time) float64 {
+	λt := p.λ(now) * float64(t)
+	if math.IsNaN(λt) || math.IsInf(λt, 0) {
+		return 0
+	}
+	return λt
+}
+
 func fac(n int64) int64 {
 	acc := int64(1)
 	for ; n > 1; n-- {
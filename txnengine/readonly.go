@@ -0,0 +1,108 @@
+package txnengine
+
+import (
+	"goshawkdb.io/common"
+	msgs "goshawkdb.io/server/capnp"
+	"log"
+	"sync"
+)
+
+// A LocalRead is the state of a var as found in the current frame of
+// this RM's copy of it.
+type LocalRead struct {
+	VarUUId    *common.VarUUId
+	TxnId      *common.TxnId
+	Value      []byte
+	References *msgs.VarIdPos_List
+	clock      *VectorClockMutable
+	quiet      bool
+}
+
+// localRead returns nil if the var can't be read locally, in which
+// case the read-only txn goes through consensus.
+func (v *Var) localRead() *LocalRead {
+	f := v.curFrame
+	txnId, value, references, err := v.RelaxedRead()
+	if err != nil {
+		log.Printf("Error: Unable to read %v locally: %v", v.UUId, err)
+		return nil
+	}
+	return &LocalRead{
+		VarUUId:    v.UUId,
+		TxnId:      txnId,
+		Value:      value,
+		References: references,
+		clock:      f.frameTxnClock.Clone(),
+		quiet:      f.writes.Len() == 0,
+	}
+}
+
+// LocalReads asynchronously reads every var in vUUIds from this RM's
+// copies of them, for a read-only txn. The callback is invoked once
+// every var has been read, on the executor of the last var read. The
+// read of a var of which this RM has no copy is nil.
+func (vd *VarDispatcher) LocalReads(vUUIds []*common.VarUUId, callback func([]*LocalRead)) {
+	if len(vUUIds) == 0 {
+		callback(nil)
+		return
+	}
+	var lock sync.Mutex
+	reads := make([]*LocalRead, len(vUUIds))
+	remaining := len(vUUIds)
+	vd.ApplyToVars(func(idx int, v *Var) {
+		var read *LocalRead
+		if v != nil {
+			read = v.localRead()
+			v.maybeMakeInactive()
+		}
+		lock.Lock()
+		reads[idx] = read
+		remaining--
+		done := remaining == 0
+		lock.Unlock()
+		if done {
+			callback(reads)
+		}
+	}, false, vUUIds)
+}
+
+// LocalReadsConsistent reports whether reads can be served without
+// consensus. They can if no var has a write in progress, and the
+// reads form a snapshot (see LocalReadsSnapshot). Reads served this
+// way carry the same guarantee as RelaxedRead: they may miss txns this
+// RM is yet to learn of. A write can't commit without the vote of
+// every RM active for it, and voting puts the write in progress, so
+// the only txns missed are those this RM was passive for: the
+// submitter picks the active RMs from those it is connected to, so
+// this is not always the same set of RMs.
+func LocalReadsConsistent(reads []*LocalRead) bool {
+	for _, read := range reads {
+		if read == nil || !read.quiet {
+			return false
+		}
+	}
+	return LocalReadsSnapshot(reads)
+}
+
+// LocalReadsSnapshot reports whether reads form a causally consistent
+// snapshot: every var has been written, and no var was written by a
+// txn which had seen a later version of another var than the one
+// read. Unlike LocalReadsConsistent, writes in progress don't matter:
+// the reads are of the versions before them. So a snapshot is
+// serializable, but may be stale.
+func LocalReadsSnapshot(reads []*LocalRead) bool {
+	for _, read := range reads {
+		if read == nil || read.TxnId == nil {
+			return false
+		}
+	}
+	for _, a := range reads {
+		elem := a.clock.At(a.VarUUId)
+		for _, b := range reads {
+			if a != b && b.clock.At(a.VarUUId) > elem {
+				return false
+			}
+		}
+	}
+	return true
+}
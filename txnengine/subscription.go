@@ -0,0 +1,81 @@
+package txnengine
+
+import (
+	"bytes"
+	"goshawkdb.io/common"
+)
+
+// A VarSubscription streams every committed write to a set of vars to
+// a single deliver function, batched per txn by a VarWriteBatcher. It
+// only sees the writes of vars of which this RM has a copy, and only
+// as this RM learns of them. Subscribed vars stay active (and so in
+// memory) until the subscription is cancelled, or the var is moved
+// off this RM, at which point it is dropped from the subscription.
+type VarSubscription struct {
+	Id      *common.TxnId
+	vd      *VarDispatcher
+	vUUIds  []*common.VarUUId
+	batcher *VarWriteBatcher
+}
+
+// A VarWriteFilter decides whether an update is of interest to a
+// subscription. It is called from the executor of the update's var,
+// so must not block.
+type VarWriteFilter func(*VarWriteUpdate) bool
+
+// ValueSliceEquals is a VarWriteFilter which accepts updates in which
+// the value contains expected at offset.
+func ValueSliceEquals(offset int, expected []byte) VarWriteFilter {
+	return func(update *VarWriteUpdate) bool {
+		end := offset + len(expected)
+		return offset >= 0 && end <= len(update.Value) && bytes.Equal(update.Value[offset:end], expected)
+	}
+}
+
+// Subscribe registers a subscription to vUUIds, identified by subId,
+// which must not be the id of any txn. deliver is called with the
+// new value and clock of each var written by a txn, once the txn has
+// been applied to every subscribed var it writes. If filter is not
+// nil, updates it rejects are dropped before deliver is called, and
+// deliver is not called at all for a txn with no accepted
+// updates. deliver may be called from any var executor, so it must
+// not block.
+func (vd *VarDispatcher) Subscribe(subId *common.TxnId, vUUIds []*common.VarUUId, filter VarWriteFilter, deliver func(*Txn, []*VarWriteUpdate)) *VarSubscription {
+	if filter != nil {
+		unfiltered := deliver
+		deliver = func(txn *Txn, updates []*VarWriteUpdate) {
+			accepted := make([]*VarWriteUpdate, 0, len(updates))
+			for _, update := range updates {
+				if filter(update) {
+					accepted = append(accepted, update)
+				}
+			}
+			if len(accepted) != 0 {
+				unfiltered(txn, accepted)
+			}
+		}
+	}
+	vs := &VarSubscription{
+		Id:      subId,
+		vd:      vd,
+		vUUIds:  vUUIds,
+		batcher: NewVarWriteBatcher(deliver),
+	}
+	vd.ApplyToVars(func(idx int, v *Var) {
+		if v != nil {
+			v.AddWriteSubscriber(subId, vs.batcher.Subscriber(v.UUId))
+		}
+	}, false, vUUIds)
+	return vs
+}
+
+// Cancel removes the subscription from every var. It is
+// asynchronous: deliver may still be called for a while after Cancel
+// returns.
+func (vs *VarSubscription) Cancel() {
+	vs.vd.ApplyToVars(func(idx int, v *Var) {
+		if v != nil {
+			v.RemoveWriteSubscriber(vs.Id)
+		}
+	}, false, vs.vUUIds)
+}
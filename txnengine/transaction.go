@@ -33,6 +33,11 @@ type Txn struct {
 	txnAwaitLocallyComplete
 	txnReceiveCompletion
 	currentState txnStateMachineComponent
+	span         server.Span
+	// log is debug logging for the txn: each line carries the txn's
+	// id, the RM and the txn's current state. It must only be called
+	// from the txn's executor.
+	log server.LogFunc
 }
 
 func (txnA *Txn) Compare(txnB *Txn) common.Cmp {
@@ -198,6 +203,9 @@ func TxnFromReader(exe *dispatcher.Executor, vd *VarDispatcher, stateChange TxnL
 		vd:          vd,
 		stateChange: stateChange,
 	}
+	txn.log = debugLog.Prefixed(func() []interface{} {
+		return []interface{}{"Txn", txnId, "at", ourRMId, "in", txn.currentState}
+	})
 
 	allocations := txnCap.Allocations()
 	for idx, l := 0, allocations.Len(); idx < l; idx++ {
@@ -212,9 +220,14 @@ func TxnFromReader(exe *dispatcher.Executor, vd *VarDispatcher, stateChange TxnL
 	return txn
 }
 
+// populate builds the txn's localActions. Vars keep hold of
+// localActions (in their frames) after the txn has finished, so they
+// can't be recycled; instead, the ids of every var in the txn are
+// allocated together, rather than one at a time.
 func (txn *Txn) populate(actionIndices capn.UInt16List, actionsList *msgs.Action_List, actions *TxnActions) {
 	localActions := make([]localAction, actionIndices.Len())
 	txn.localActions = localActions
+	vUUIds := make([]common.VarUUId, actionsList.Len())
 	var action *localAction
 
 	actionIndicesIdx := 0
@@ -226,10 +239,12 @@ func (txn *Txn) populate(actionIndices capn.UInt16List, actionsList *msgs.Action
 
 	for idx, l := 0, actionsList.Len(); idx < l; idx++ {
 		actionCap := actionsList.At(idx)
+		vUUId := &vUUIds[idx]
+		copy(vUUId[:], actionCap.VarId())
 
 		if idx == actionIndex {
 			action.Txn = txn
-			action.vUUId = common.MakeVarUUId(actionCap.VarId())
+			action.vUUId = vUUId
 		}
 
 		switch actionCap.Which() {
@@ -246,7 +261,7 @@ func (txn *Txn) populate(actionIndices capn.UInt16List, actionsList *msgs.Action
 				action.writeAction = &actionCap
 				txn.writes = append(txn.writes, action.vUUId)
 			} else {
-				txn.writes = append(txn.writes, common.MakeVarUUId(actionCap.VarId()))
+				txn.writes = append(txn.writes, vUUId)
 			}
 
 		case msgs.ACTION_READWRITE:
@@ -258,7 +273,7 @@ func (txn *Txn) populate(actionIndices capn.UInt16List, actionsList *msgs.Action
 				action.writeAction = &actionCap
 				txn.writes = append(txn.writes, action.vUUId)
 			} else {
-				txn.writes = append(txn.writes, common.MakeVarUUId(actionCap.VarId()))
+				txn.writes = append(txn.writes, vUUId)
 			}
 
 		case msgs.ACTION_CREATE:
@@ -270,7 +285,7 @@ func (txn *Txn) populate(actionIndices capn.UInt16List, actionsList *msgs.Action
 				action.createPositions = &positions
 				txn.writes = append(txn.writes, action.vUUId)
 			} else {
-				txn.writes = append(txn.writes, common.MakeVarUUId(actionCap.VarId()))
+				txn.writes = append(txn.writes, vUUId)
 			}
 
 		case msgs.ACTION_ROLL:
@@ -283,7 +298,7 @@ func (txn *Txn) populate(actionIndices capn.UInt16List, actionsList *msgs.Action
 				action.roll = true
 				txn.writes = append(txn.writes, action.vUUId)
 			} else {
-				txn.writes = append(txn.writes, common.MakeVarUUId(actionCap.VarId()))
+				txn.writes = append(txn.writes, vUUId)
 			}
 
 		default:
@@ -318,6 +333,9 @@ func (txn *Txn) Start(voter bool) {
 	} else {
 		txn.currentState = &txn.txnReceiveOutcome
 	}
+	txn.span = server.StartSpan("txn", txn.Id, txn.TxnReader.Txn.TraceContext())
+	txn.span.LogEvent(fmt.Sprint(txn.currentState))
+	txn.log("Started")
 	txn.currentState.start()
 }
 
@@ -333,10 +351,13 @@ func (txn *Txn) nextState() {
 		txn.currentState = &txn.txnReceiveCompletion
 	case &txn.txnReceiveCompletion:
 		txn.currentState = nil
+		txn.span.Finish()
 		return
 	default:
 		panic(fmt.Sprintf("%v Next state called on txn with txn in terminal state: %v\n", txn.Id, txn.currentState))
 	}
+	txn.span.LogEvent(fmt.Sprint(txn.currentState))
+	txn.log("Entered state")
 	txn.currentState.start()
 }
 
@@ -346,14 +367,14 @@ func (txn *Txn) String() string {
 
 func (txn *Txn) Status(sc *server.StatusConsumer) {
 	sc.Emit(txn.Id.String())
-	sc.Emit(fmt.Sprintf("- Local Actions: %v", txn.localActions))
-	sc.Emit(fmt.Sprintf("- Current State: %v", txn.currentState))
-	sc.Emit(fmt.Sprintf("- Retry? %v", txn.Retry))
-	sc.Emit(fmt.Sprintf("- PreAborted? %v", txn.preAbortedBool))
-	sc.Emit(fmt.Sprintf("- Aborted? %v", txn.aborted))
-	sc.Emit(fmt.Sprintf("- Outcome Clock: %v", txn.outcomeClock))
-	sc.Emit(fmt.Sprintf("- Active Frames Count: %v", atomic.LoadInt32(&txn.activeFramesCount)))
-	sc.Emit(fmt.Sprintf("- Completed? %v", txn.completed))
+	sc.EmitKV("Local Actions", txn.localActions)
+	sc.EmitKV("Current State", txn.currentState)
+	sc.EmitKV("Retry", txn.Retry)
+	sc.EmitKV("PreAborted", txn.preAbortedBool)
+	sc.EmitKV("Aborted", txn.aborted)
+	sc.EmitKV("Outcome Clock", txn.outcomeClock)
+	sc.EmitKV("Active Frames Count", atomic.LoadInt32(&txn.activeFramesCount))
+	sc.EmitKV("Completed", txn.completed)
 	sc.Join()
 }
 
@@ -383,17 +404,18 @@ func (tdb *txnDetermineLocalBallots) init(txn *Txn) {
 
 func (tdb *txnDetermineLocalBallots) start() {
 	tdb.nextState() // advance state FIRST!
-	for idx := 0; idx < len(tdb.localActions); idx++ {
+	vUUIds := make([]*common.VarUUId, len(tdb.localActions))
+	for idx := range tdb.localActions {
+		vUUIds[idx] = tdb.localActions[idx].vUUId
+	}
+	tdb.vd.ApplyToVars(func(idx int, v *Var) {
 		action := &tdb.localActions[idx]
-		f := func(v *Var) {
-			if v == nil {
-				panic(fmt.Sprintf("%v error (%v): %v Unable to create var!", tdb.Id, tdb, action.vUUId))
-			} else {
-				v.ReceiveTxn(action)
-			}
+		if v == nil {
+			panic(fmt.Sprintf("%v error (%v): %v Unable to create var!", tdb.Id, tdb, action.vUUId))
+		} else {
+			v.ReceiveTxn(action)
 		}
-		tdb.vd.ApplyToVar(f, true, action.vUUId)
-	}
+	}, true, vUUIds)
 }
 
 // Await Local Ballots
@@ -569,7 +591,7 @@ func (talc *txnAwaitLocallyComplete) start() {
 // Callback (from var-dispatcher (frames) back into txn)
 func (talc *txnAwaitLocallyComplete) LocallyComplete() {
 	result := atomic.AddInt32(&talc.activeFramesCount, -1)
-	server.Log(talc.Id, "LocallyComplete called, pending frame count:", result)
+	debugLog.Log(talc.Id, "LocallyComplete called, pending frame count:", result)
 	if result == 0 {
 		talc.exe.Enqueue(talc.locallyComplete)
 	} else if result < 0 {
@@ -601,7 +623,7 @@ func (trc *txnReceiveCompletion) start() {}
 
 // Callback (from network/paxos)
 func (trc *txnReceiveCompletion) CompletionReceived() {
-	server.Log(trc.Id, "CompletionReceived; already completed?", trc.completed, "state:", trc.currentState, "aborted?", trc.aborted)
+	trc.log("CompletionReceived; already completed?", trc.completed, "aborted?", trc.aborted)
 	if trc.completed {
 		// Be silent in this case.
 		return
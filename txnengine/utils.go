@@ -1,19 +1,30 @@
 package txnengine
 
 import (
+	"encoding/binary"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
 	"goshawkdb.io/common"
+	cmsgs "goshawkdb.io/common/capnp"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
+	"hash"
+	"hash/crc64"
+	"log"
+	"sync"
+	"sync/atomic"
 )
 
+var debugLog = server.NewSubsystem("txnengine")
+
 type TxnReader struct {
-	Id       *common.TxnId
-	actions  *TxnActions
-	Data     []byte
-	Txn      msgs.Txn
-	deflated *TxnReader
+	Id           *common.TxnId
+	actions      *TxnActions
+	Data         []byte
+	Txn          msgs.Txn
+	deflated     *TxnReader
+	checksumOnce sync.Once
+	checksumErr  error
 }
 
 func TxnReaderFromData(data []byte) *TxnReader {
@@ -43,6 +54,165 @@ func (tr *TxnReader) Actions(forceDecode bool) *TxnActions {
 	return tr.actions
 }
 
+// ValidateActions checks that every action in the txn is of a type
+// we understand. A txn from a newer server could contain action
+// types we don't know about, and we must not try to apply them.
+func ValidateActions(txn *TxnReader) error {
+	actions := txn.Actions(true).Actions()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		switch which := actions.At(idx).Which(); which {
+		case msgs.ACTION_READ, msgs.ACTION_WRITE, msgs.ACTION_READWRITE, msgs.ACTION_CREATE, msgs.ACTION_ROLL:
+		default:
+			return fmt.Errorf("%v: unknown action type %v", txn.Id, which)
+		}
+	}
+	return nil
+}
+
+var (
+	actionsChecksumTable    = crc64.MakeTable(crc64.ECMA)
+	actionsChecksumFailures uint64
+)
+
+// The kinds of action, as covered by the actions checksum.
+const (
+	checksumRead byte = iota + 1
+	checksumWrite
+	checksumReadWrite
+	checksumCreate
+	checksumRoll
+)
+
+// actionsChecksum covers what a client asked of each action of a txn:
+// the var, the kind of action, the version read, the value written,
+// and the vars referenced. It is the same whether computed from the
+// client's actions, as its RM received them, or from the actions the
+// RM translated them into, so it is computed from the former and
+// verified against the latter.
+type actionsChecksum struct {
+	hash hash.Hash64
+	len  [4]byte
+}
+
+func newActionsChecksum() *actionsChecksum {
+	return &actionsChecksum{hash: crc64.New(actionsChecksumTable)}
+}
+
+func (ac *actionsChecksum) bytes(bites []byte) {
+	binary.BigEndian.PutUint32(ac.len[:], uint32(len(bites)))
+	ac.hash.Write(ac.len[:])
+	ac.hash.Write(bites)
+}
+
+func (ac *actionsChecksum) action(kind byte, varId, version, value []byte, refCount int, refVarId func(int) []byte) {
+	ac.hash.Write([]byte{kind})
+	ac.bytes(varId)
+	ac.bytes(version)
+	ac.bytes(value)
+	binary.BigEndian.PutUint32(ac.len[:], uint32(refCount))
+	ac.hash.Write(ac.len[:])
+	for idx := 0; idx < refCount; idx++ {
+		ac.bytes(refVarId(idx))
+	}
+}
+
+// sum returns the checksum, which is never 0: 0 means a txn has not
+// been checksummed.
+func (ac *actionsChecksum) sum() uint64 {
+	if sum := ac.hash.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+// ClientActionsChecksum is set on a client's txn by its submitter,
+// from the actions as the client submitted them. See actionsChecksum.
+func ClientActionsChecksum(actions *cmsgs.ClientAction_List) uint64 {
+	ac := newActionsChecksum()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		action := actions.At(idx)
+		var refs cmsgs.ClientVarIdPos_List
+		switch action.Which() {
+		case cmsgs.CLIENTACTION_READ:
+			ac.action(checksumRead, action.VarId(), action.Read().Version(), nil, 0, nil)
+			continue
+		case cmsgs.CLIENTACTION_WRITE:
+			write := action.Write()
+			refs = write.References()
+			ac.action(checksumWrite, action.VarId(), nil, write.Value(), refs.Len(), func(idx int) []byte { return refs.At(idx).VarId() })
+		case cmsgs.CLIENTACTION_READWRITE:
+			rw := action.Readwrite()
+			refs = rw.References()
+			ac.action(checksumReadWrite, action.VarId(), rw.Version(), rw.Value(), refs.Len(), func(idx int) []byte { return refs.At(idx).VarId() })
+		case cmsgs.CLIENTACTION_CREATE:
+			create := action.Create()
+			refs = create.References()
+			ac.action(checksumCreate, action.VarId(), nil, create.Value(), refs.Len(), func(idx int) []byte { return refs.At(idx).VarId() })
+		case cmsgs.CLIENTACTION_ROLL:
+			roll := action.Roll()
+			refs = roll.References()
+			ac.action(checksumRoll, action.VarId(), roll.Version(), roll.Value(), refs.Len(), func(idx int) []byte { return refs.At(idx).VarId() })
+		}
+	}
+	return ac.sum()
+}
+
+// ActionsChecksum is set on a txn built by the server itself, rather
+// than translated from a client's txn. See actionsChecksum.
+func ActionsChecksum(actions *msgs.Action_List) uint64 {
+	ac := newActionsChecksum()
+	for idx, l := 0, actions.Len(); idx < l; idx++ {
+		action := actions.At(idx)
+		var refs msgs.VarIdPos_List
+		switch action.Which() {
+		case msgs.ACTION_READ:
+			ac.action(checksumRead, action.VarId(), action.Read().Version(), nil, 0, nil)
+			continue
+		case msgs.ACTION_WRITE:
+			write := action.Write()
+			refs = write.References()
+			ac.action(checksumWrite, action.VarId(), nil, write.Value(), refs.Len(), func(idx int) []byte { return refs.At(idx).Id() })
+		case msgs.ACTION_READWRITE:
+			rw := action.Readwrite()
+			refs = rw.References()
+			ac.action(checksumReadWrite, action.VarId(), rw.Version(), rw.Value(), refs.Len(), func(idx int) []byte { return refs.At(idx).Id() })
+		case msgs.ACTION_CREATE:
+			create := action.Create()
+			refs = create.References()
+			ac.action(checksumCreate, action.VarId(), nil, create.Value(), refs.Len(), func(idx int) []byte { return refs.At(idx).Id() })
+		case msgs.ACTION_ROLL:
+			roll := action.Roll()
+			refs = roll.References()
+			ac.action(checksumRoll, action.VarId(), roll.Version(), roll.Value(), refs.Len(), func(idx int) []byte { return refs.At(idx).Id() })
+		}
+	}
+	return ac.sum()
+}
+
+// VerifyChecksum checks the txn's actions against the checksum set by
+// its submitter. A txn with a checksum of 0 has not been checksummed,
+// and a deflated txn has no values left to check; both always pass.
+// The txn is only checked once: the first failure is logged and
+// counted, and every later call returns the same error.
+func (tr *TxnReader) VerifyChecksum(from common.RMId) error {
+	tr.checksumOnce.Do(func() {
+		expected := tr.Txn.ActionsChecksum()
+		if expected == 0 || tr.IsDeflated() {
+			return
+		}
+		if actual := ActionsChecksum(tr.Actions(true).Actions()); actual != expected {
+			count := atomic.AddUint64(&actionsChecksumFailures, 1)
+			tr.checksumErr = fmt.Errorf("%v: actions checksum mismatch (expected %x, got %x)", tr.Id, expected, actual)
+			log.Printf("Error: Txn from %v rejected: %v. Total checksum mismatches: %v", from, tr.checksumErr, count)
+		}
+	})
+	return tr.checksumErr
+}
+
+func ActionsChecksumFailures() uint64 {
+	return atomic.LoadUint64(&actionsChecksumFailures)
+}
+
 func (a *TxnReader) Combine(b *TxnReader) *TxnReader {
 	a.Actions(true)
 	b.Actions(true)
@@ -66,6 +236,11 @@ func (tr *TxnReader) IsDeflated() bool {
 	return tr.Actions(true).deflated
 }
 
+// AsDeflated returns the txn with every action replaced by a Missing
+// action. The deflated txn keeps the checksum of the original: it
+// can't be verified, as the values it covered are gone, but nor is it
+// replaced with one which would vouch for whatever the txn held when
+// it was deflated. Deflate a txn only once it has been verified.
 func (tr *TxnReader) AsDeflated() *TxnReader {
 	if tr.deflated == nil {
 		if tr.IsDeflated() {
@@ -81,9 +256,13 @@ func (tr *TxnReader) AsDeflated() *TxnReader {
 		root.SetSubmitterBootCount(cap.SubmitterBootCount())
 		root.SetRetry(cap.Retry())
 		root.SetActions(actions.Data)
+		root.SetActionsChecksum(cap.ActionsChecksum())
 		root.SetAllocations(cap.Allocations())
 		root.SetFInc(cap.FInc())
 		root.SetTopologyVersion(cap.TopologyVersion())
+		if traceContext := cap.TraceContext(); len(traceContext) != 0 {
+			root.SetTraceContext(traceContext)
+		}
 
 		tr.deflated = &TxnReader{
 			Id:      tr.Id,
@@ -0,0 +1,70 @@
+package txnengine
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	cmsgs "goshawkdb.io/common/capnp"
+	msgs "goshawkdb.io/server/capnp"
+	"testing"
+)
+
+func TestActionsChecksum(t *testing.T) {
+	varA, varB, version := []byte("var a"), []byte("var b"), []byte("version")
+
+	// A client's read of varA and write to varB referencing varA ...
+	seg := capn.NewBuffer(nil)
+	clientActions := cmsgs.NewClientActionList(seg, 2)
+	clientRead := clientActions.At(0)
+	clientRead.SetVarId(varA)
+	clientRead.SetRead()
+	clientRead.Read().SetVersion(version)
+	clientWrite := clientActions.At(1)
+	clientWrite.SetVarId(varB)
+	clientWrite.SetWrite()
+	clientWrite.Write().SetValue([]byte("value"))
+	clientRefs := cmsgs.NewClientVarIdPosList(seg, 1)
+	clientRefs.At(0).SetVarId(varA)
+	clientWrite.Write().SetReferences(clientRefs)
+
+	// ... and the same, as translated by the submitter.
+	actions := msgs.NewActionList(seg, 2)
+	read := actions.At(0)
+	read.SetVarId(varA)
+	read.SetRead()
+	read.Read().SetVersion(version)
+	write := actions.At(1)
+	write.SetVarId(varB)
+	write.SetWrite()
+	write.Write().SetValue([]byte("value"))
+	refs := msgs.NewVarIdPosList(seg, 1)
+	refs.At(0).SetId(varA)
+	refs.At(0).SetPositions(seg.NewUInt8List(3))
+	write.Write().SetReferences(refs)
+
+	expected := ClientActionsChecksum(&clientActions)
+	if expected == 0 {
+		t.Fatal("Checksum of 0 means not checksummed.")
+	} else if actual := ActionsChecksum(&actions); actual != expected {
+		t.Fatalf("Translated actions have checksum %x; expected %x", actual, expected)
+	}
+
+	// A value changed after translation is caught ...
+	write.Write().SetValue([]byte("valuf"))
+	if ActionsChecksum(&actions) == expected {
+		t.Fatal("Changed value not detected.")
+	}
+	write.Write().SetValue([]byte("value"))
+
+	// ... as is a reference to another var ...
+	refs.At(0).SetId(varB)
+	if ActionsChecksum(&actions) == expected {
+		t.Fatal("Changed reference not detected.")
+	}
+	refs.At(0).SetId(varA)
+
+	// ... and bytes moved from one field to the next.
+	write.Write().SetValue([]byte("alue"))
+	write.SetVarId([]byte("var bv"))
+	if ActionsChecksum(&actions) == expected {
+		t.Fatal("Bytes moved between fields not detected.")
+	}
+}
@@ -1,9 +1,9 @@
 package txnengine
 
 import (
+	"bytes"
 	"fmt"
 	capn "github.com/glycerine/go-capnproto"
-	mdbs "github.com/msackman/gomdb/server"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
 	msgs "goshawkdb.io/server/capnp"
@@ -22,6 +22,7 @@ type Var struct {
 	UUId            *common.VarUUId
 	positions       *common.Positions
 	poisson         *Poisson
+	heat            varHeat
 	curFrame        *frame
 	curFrameOnDisk  *frame
 	writeInProgress func()
@@ -31,16 +32,24 @@ type Var struct {
 	vm              *VarManager
 	varCap          *msgs.Var
 	rng             *rand.Rand
+	// loading is not nil whilst the frame txn of a var recreated from
+	// disk is being read, and holds what's been applied to the var in
+	// the meantime. See VarManager.ApplyToVar.
+	loading []func(*Var)
 }
 
-func VarFromData(data []byte, exe *dispatcher.Executor, db *db.Databases, vm *VarManager) (*Var, error) {
-	seg, _, err := capn.ReadFromMemoryZeroCopy(data)
+// VarFromData recreates a var from its record in the Vars table. The
+// var's frame txn is read asynchronously, so as not to hold up the
+// executor; once it has been, loaded is called on the executor, with
+// any error from reading or verifying it.
+func VarFromData(data []byte, exe *dispatcher.Executor, dbs *db.Databases, vm *VarManager, loaded func(error)) (*Var, error) {
+	record, err := ReadVarRecord(data)
 	if err != nil {
 		return nil, err
 	}
-	varCap := msgs.ReadRootVar(seg)
+	varCap := record.Var
 
-	v := newVar(common.MakeVarUUId(varCap.Id()), exe, db, vm)
+	v := newVar(common.MakeVarUUId(varCap.Id()), exe, dbs, vm)
 	positions := varCap.Positions()
 	if positions.Len() != 0 {
 		v.positions = (*common.Positions)(&positions)
@@ -49,19 +58,34 @@ func VarFromData(data []byte, exe *dispatcher.Executor, db *db.Databases, vm *Va
 	writeTxnId := common.MakeTxnId(varCap.WriteTxnId())
 	writeTxnClock := VectorClockFromData(varCap.WriteTxnClock(), true).AsMutable()
 	writesClock := VectorClockFromData(varCap.WritesClock(), true).AsMutable()
-	server.Log(v.UUId, "Restored", writeTxnId)
-
-	if result, err := db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
-		return db.ReadTxnBytesFromDisk(rtxn, writeTxnId)
-	}).ResultError(); err == nil && result != nil {
-		txn := TxnReaderFromData(result.([]byte))
-		v.curFrame = NewFrame(nil, v, writeTxnId, txn.Actions(false), writeTxnClock, writesClock)
-		v.curFrameOnDisk = v.curFrame
-		v.varCap = &varCap
-		return v, nil
-	} else {
-		return nil, err
-	}
+	debugLog.Log(v.UUId, "Restored", writeTxnId)
+
+	actions := &TxnActions{}
+	v.loading = []func(*Var){}
+	future := dbs.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
+		return dbs.ReadTxnBytesFromDisk(rtxn, writeTxnId)
+	})
+	go func() {
+		result, err := future.ResultError()
+		if err == nil && result == nil { // shutdown
+			return
+		}
+		exe.Enqueue(func() {
+			if err != nil {
+				err = fmt.Errorf("Error when loading frame txn %v: %v", writeTxnId, err)
+			} else if bites, ok := result.([]byte); !ok || bites == nil {
+				err = fmt.Errorf("Unable to load frame txn %v", writeTxnId)
+			} else if err = record.VerifyTxn(bites); err == nil {
+				debugLog.Log(v.UUId, "Loaded frame txn", writeTxnId)
+				actions.Data = TxnReaderFromData(bites).Txn.Actions()
+			}
+			loaded(err)
+		})
+	}()
+	v.curFrame = NewFrame(nil, v, writeTxnId, actions, writeTxnClock, writesClock)
+	v.curFrameOnDisk = v.curFrame
+	v.varCap = &varCap
+	return v, nil
 }
 
 func NewVar(uuid *common.VarUUId, exe *dispatcher.Executor, db *db.Databases, vm *VarManager) *Var {
@@ -106,7 +130,8 @@ func (v *Var) RemoveWriteSubscriber(txnId *common.TxnId) {
 }
 
 func (v *Var) ReceiveTxn(action *localAction) {
-	server.Log(v.UUId, "ReceiveTxn", action)
+	debugLog.Log(v.UUId, "ReceiveTxn", action)
+	v.heat.arrived()
 	isRead, isWrite := action.IsRead(), action.IsWrite()
 
 	if isRead && action.Retry {
@@ -119,6 +144,7 @@ func (v *Var) ReceiveTxn(action *localAction) {
 						}
 					},
 					Cancel: func(v *Var) {
+						v.heat.deadlocked()
 						action.VoteDeadlock(v.curFrame.frameTxnClock)
 						v.RemoveWriteSubscriber(action.Id)
 					},
@@ -138,9 +164,18 @@ func (v *Var) ReceiveTxn(action *localAction) {
 }
 
 func (v *Var) ReceiveTxnOutcome(action *localAction) {
-	server.Log(v.UUId, "ReceiveTxnOutcome", action)
+	debugLog.Log(v.UUId, "ReceiveTxnOutcome", action)
 	isRead, isWrite := action.IsRead(), action.IsWrite()
 
+	if isWrite && !action.Retry && !action.aborted {
+		// The value is about to be applied, so it must be what the
+		// client submitted. If not, the copy in memory is corrupt, and
+		// the txn must be learnt again from the acceptors.
+		if err := action.Txn.TxnReader.VerifyChecksum(v.vm.RMId); err != nil {
+			panic(fmt.Sprintf("%v refusing to apply write: %v", v.UUId, err))
+		}
+	}
+
 	switch {
 	case action.Retry:
 		v.RemoveWriteSubscriber(action.Id)
@@ -156,6 +191,7 @@ func (v *Var) ReceiveTxnOutcome(action *localAction) {
 		panic(fmt.Sprintf("%v frame var has changed %p -> %p (%v)", v.UUId, action.frame.v, v, action))
 
 	case action.aborted:
+		v.heat.aborted()
 		switch {
 		case isRead && isWrite:
 			action.frame.ReadWriteAborted(action, true)
@@ -166,6 +202,7 @@ func (v *Var) ReceiveTxnOutcome(action *localAction) {
 		}
 
 	default:
+		v.heat.committed()
 		switch {
 		case isRead && isWrite:
 			action.frame.ReadWriteCommitted(action)
@@ -178,7 +215,7 @@ func (v *Var) ReceiveTxnOutcome(action *localAction) {
 }
 
 func (v *Var) SetCurFrame(f *frame, action *localAction, positions *common.Positions) {
-	server.Log(v.UUId, "SetCurFrame", action)
+	debugLog.Log(v.UUId, "SetCurFrame", action)
 	v.curFrame = f
 
 	if positions != nil {
@@ -248,41 +285,88 @@ func (v *Var) maybeWriteFrame(f *frame, action *localAction, positions *common.P
 	varCap.SetWriteTxnId(f.frameTxnId[:])
 	varCap.SetWriteTxnClock(f.frameTxnClock.AsData())
 	varCap.SetWritesClock(f.frameWritesClock.AsData())
-	varData := server.SegToBytes(varSeg)
-
 	txnBytes := action.TxnReader.Data
-
-	// to ensure correct order of writes, schedule the write from
-	// the current go-routine...
-	future := v.db.ReadWriteTransaction(false, func(rwtxn *mdbs.RWTxn) interface{} {
-		if err := v.db.WriteTxnToDisk(rwtxn, f.frameTxnId, txnBytes); err == nil {
-			if err = rwtxn.Put(v.db.Vars, v.UUId[:], varData, 0); err == nil {
-				if v.curFrameOnDisk != nil {
-					v.db.DeleteTxnFromDisk(rwtxn, v.curFrameOnDisk.frameTxnId)
+	varData := SealVarRecord(server.SegToBytes(varSeg), txnBytes)
+
+	// Writes caused by rolls and migrations can wait for writes which
+	// someone (e.g. a client subscribed to the var) may be waiting on.
+	foreground := !(action.IsRoll() || action.IsImmigrant()) || len(v.subscribers) != 0
+
+	v.vm.scheduleFrameWrite(foreground, func() {
+		// to ensure correct order of writes, schedule the write from
+		// the current go-routine...
+		future := v.db.ReadWriteTransaction(false, func(rwtxn db.ReadWriteTxn) interface{} {
+			if err := v.db.WriteTxnToDisk(rwtxn, f.frameTxnId, txnBytes); err == nil {
+				db.Stats.Vars.Wrote(varData)
+				if err = rwtxn.Put(v.db.Vars, v.UUId[:], varData); err == nil {
+					if v.curFrameOnDisk != nil {
+						v.db.DeleteTxnFromDisk(rwtxn, v.curFrameOnDisk.frameTxnId)
+					}
 				}
 			}
-		}
-		return true
+			return true
+		})
+		go func() {
+			// ... but process the result in a new go-routine to avoid blocking the executor.
+			if ran, err := future.ResultError(); err != nil {
+				panic(fmt.Sprintf("Var error when writing to disk: %v\n", err))
+			} else if ran != nil {
+				// Switch back to the right go-routine
+				v.exe.Enqueue(func() { v.vm.frameWriteFinished(foreground) })
+				v.applyToVar(func() {
+					debugLog.Log(v.UUId, "Wrote", f.frameTxnId)
+					if obs := v.vm.frameWriteObserver; obs != nil {
+						obs.FrameWritten(v.UUId, txnBytes, varData)
+					}
+					v.curFrameOnDisk = f
+					for ancestor := f.parent; ancestor != nil && ancestor.DescendentOnDisk(); ancestor = ancestor.parent {
+					}
+					v.writeInProgress()
+				})
+			}
+		}()
 	})
-	go func() {
-		// ... but process the result in a new go-routine to avoid blocking the executor.
-		if ran, err := future.ResultError(); err != nil {
-			panic(fmt.Sprintf("Var error when writing to disk: %v\n", err))
-		} else if ran != nil {
-			// Switch back to the right go-routine
-			v.applyToVar(func() {
-				server.Log(v.UUId, "Wrote", f.frameTxnId)
-				v.curFrameOnDisk = f
-				for ancestor := f.parent; ancestor != nil && ancestor.DescendentOnDisk(); ancestor = ancestor.parent {
-				}
-				v.writeInProgress()
-			})
+}
+
+// RelaxedRead returns the value and references written by the most
+// recent txn this RM has seen commit to the var, without consulting
+// any other RM. If the var has never been written, txnId is nil.
+func (v *Var) RelaxedRead() (txnId *common.TxnId, value []byte, references *msgs.VarIdPos_List, err error) {
+	f := v.curFrame
+	if f.frameTxnId == nil || f.frameTxnActions == nil {
+		return nil, nil, nil, nil
+	}
+	vUUIdBytes := v.UUId[:]
+	txnActions := f.frameTxnActions.Actions()
+	for idx, l := 0, txnActions.Len(); idx < l; idx++ {
+		action := txnActions.At(idx)
+		if !bytes.Equal(action.VarId(), vUUIdBytes) {
+			continue
 		}
-	}()
+		var refs msgs.VarIdPos_List
+		switch action.Which() {
+		case msgs.ACTION_WRITE:
+			write := action.Write()
+			value, refs = write.Value(), write.References()
+		case msgs.ACTION_READWRITE:
+			rw := action.Readwrite()
+			value, refs = rw.Value(), rw.References()
+		case msgs.ACTION_CREATE:
+			create := action.Create()
+			value, refs = create.Value(), create.References()
+		case msgs.ACTION_ROLL:
+			roll := action.Roll()
+			value, refs = roll.Value(), roll.References()
+		default:
+			return nil, nil, nil, fmt.Errorf("%v unexpected action type for frame txn %v: %v", v.UUId, f.frameTxnId, action.Which())
+		}
+		return f.frameTxnId, value, &refs, nil
+	}
+	return nil, nil, nil, fmt.Errorf("%v frame txn %v does not write to var", v.UUId, f.frameTxnId)
 }
 
 func (v *Var) TxnGloballyComplete(action *localAction) {
-	server.Log(v.UUId, "Txn globally complete", action)
+	debugLog.Log(v.UUId, "Txn globally complete", action)
 	if action.frame.v != v {
 		panic(fmt.Sprintf("%v frame var has changed %p -> %p (%v)", v.UUId, action.frame.v, v, action))
 	}
@@ -322,7 +406,7 @@ func (v *Var) applyToVar(fun func()) {
 			case v1 == nil:
 				panic(fmt.Sprintf("%v not found!", v.UUId))
 			case v1 != v:
-				server.Log(v.UUId, "ignoring callback as var object has changed")
+				debugLog.Log(v.UUId, "ignoring callback as var object has changed")
 				v1.maybeMakeInactive()
 			default:
 				fun()
@@ -332,16 +416,42 @@ func (v *Var) applyToVar(fun func()) {
 }
 
 func (v *Var) Status(sc *server.StatusConsumer) {
-	sc.Emit(v.UUId.String())
-	if v.positions == nil {
+	v.statusSnapshot().status(sc)
+}
+
+// varStatusSnapshot is a copy of the state of a var which can be
+// formatted off the executor. It must be taken on the executor.
+type varStatusSnapshot struct {
+	vUUId       *common.VarUUId
+	positions   *common.Positions
+	curFrame    *frameStatusSnapshot
+	subscribers int
+	idle        bool
+	onDisk      bool
+}
+
+func (v *Var) statusSnapshot() *varStatusSnapshot {
+	return &varStatusSnapshot{
+		vUUId:       v.UUId,
+		positions:   v.positions,
+		curFrame:    v.curFrame.statusSnapshot(),
+		subscribers: len(v.subscribers),
+		idle:        v.isIdle(),
+		onDisk:      v.isOnDisk(false),
+	}
+}
+
+func (vss *varStatusSnapshot) status(sc *server.StatusConsumer) {
+	sc.Emit(vss.vUUId.String())
+	if vss.positions == nil {
 		sc.Emit("- Positions: unknown")
 	} else {
-		sc.Emit(fmt.Sprintf("- Positions: %v", v.positions))
+		sc.Emit(fmt.Sprintf("- Positions: %v", vss.positions))
 	}
 	sc.Emit("- CurFrame:")
-	v.curFrame.Status(sc.Fork())
-	sc.Emit(fmt.Sprintf("- Subscribers: %v", len(v.subscribers)))
-	sc.Emit(fmt.Sprintf("- Idle? %v", v.isIdle()))
-	sc.Emit(fmt.Sprintf("- IsOnDisk? %v", v.isOnDisk(false)))
+	vss.curFrame.status(sc.Fork())
+	sc.Emit(fmt.Sprintf("- Subscribers: %v", vss.subscribers))
+	sc.Emit(fmt.Sprintf("- Idle? %v", vss.idle))
+	sc.Emit(fmt.Sprintf("- IsOnDisk? %v", vss.onDisk))
 	sc.Join()
 }
@@ -0,0 +1,237 @@
+package txnengine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/db"
+	"hash/crc64"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A var's record in the Vars table is its encoded Var, followed by a
+// trailer of two checksums: one of the encoded Var (and so its
+// clocks), and one of its frame txn (and so its value) as written to
+// the Transactions table. Readers which just decode the Var ignore
+// the trailer. Records written before checksums were added have no
+// trailer, and can't be verified until they are next written.
+const varChecksumTrailerLen = 16
+
+var (
+	varChecksumTable    = crc64.MakeTable(crc64.ECMA)
+	varChecksumFailures uint64
+)
+
+// SealVarRecord appends the checksum trailer to varData.
+func SealVarRecord(varData, txnBytes []byte) []byte {
+	record := make([]byte, len(varData)+varChecksumTrailerLen)
+	copy(record, varData)
+	binary.BigEndian.PutUint64(record[len(varData):], crc64.Checksum(varData, varChecksumTable))
+	binary.BigEndian.PutUint64(record[len(varData)+8:], crc64.Checksum(txnBytes, varChecksumTable))
+	return record
+}
+
+// VarRecord is a decoded record from the Vars table.
+type VarRecord struct {
+	Var         msgs.Var
+	Checksummed bool
+	txnChecksum uint64
+}
+
+// ReadVarRecord decodes a record from the Vars table, verifying the
+// checksum of the Var if it has one. Failures are counted.
+func ReadVarRecord(data []byte) (*VarRecord, error) {
+	seg, n, err := capn.ReadFromMemoryZeroCopy(data)
+	if err != nil {
+		return nil, err
+	}
+	record := &VarRecord{Var: msgs.ReadRootVar(seg)}
+	switch trailer := data[n:]; len(trailer) {
+	case 0:
+	case varChecksumTrailerLen:
+		record.Checksummed = true
+		record.txnChecksum = binary.BigEndian.Uint64(trailer[8:])
+		if expected, actual := binary.BigEndian.Uint64(trailer), crc64.Checksum(data[:n], varChecksumTable); expected != actual {
+			atomic.AddUint64(&varChecksumFailures, 1)
+			return nil, fmt.Errorf("%v: var checksum mismatch (expected %x, got %x)",
+				common.MakeVarUUId(record.Var.Id()), expected, actual)
+		}
+	default:
+		atomic.AddUint64(&varChecksumFailures, 1)
+		return nil, fmt.Errorf("%v: var record has a trailer of %v bytes",
+			common.MakeVarUUId(record.Var.Id()), len(trailer))
+	}
+	return record, nil
+}
+
+// VerifyTxn checks txnBytes, the var's frame txn, against the
+// record's checksum, if it has one. Failures are counted.
+func (vr *VarRecord) VerifyTxn(txnBytes []byte) error {
+	if !vr.Checksummed {
+		return nil
+	}
+	if actual := crc64.Checksum(txnBytes, varChecksumTable); actual != vr.txnChecksum {
+		atomic.AddUint64(&varChecksumFailures, 1)
+		return fmt.Errorf("%v: frame txn %v checksum mismatch (expected %x, got %x)",
+			common.MakeVarUUId(vr.Var.Id()), common.MakeTxnId(vr.Var.WriteTxnId()), vr.txnChecksum, actual)
+	}
+	return nil
+}
+
+func VarChecksumFailures() uint64 {
+	return atomic.LoadUint64(&varChecksumFailures)
+}
+
+// ScrubReport describes the most recent scrub.
+type ScrubReport struct {
+	Runs     uint64
+	LastRun  time.Time
+	Duration time.Duration
+	Checked  uint64
+	// Unchecksummed counts the records which have no checksums yet.
+	Unchecksummed uint64
+	// Corrupt lists (up to ScrubReportMaxCorrupt) the vars whose
+	// record or frame txn failed verification. CorruptCount is the
+	// number of them, which may exceed len(Corrupt).
+	Corrupt      []ScrubCorruption
+	CorruptCount uint64
+	LastError    string `json:",omitempty"`
+}
+
+type ScrubCorruption struct {
+	VarUUId string
+	Error   string
+}
+
+// A Scrubber walks the Vars table, verifying every var's record and
+// frame txn against their checksums, so that corruption on disk is
+// found before the var is next needed. It only reports corruption:
+// there is no means for one RM to fetch a var's record and frame txn
+// from another, so it can't repair them.
+type Scrubber struct {
+	db      *db.Databases
+	lock    sync.Mutex
+	running bool
+	report  ScrubReport
+}
+
+var ScrubInProgress = errors.New("Scrub already in progress")
+
+func NewScrubber(dbs *db.Databases) *Scrubber {
+	return &Scrubber{db: dbs}
+}
+
+// Scrub runs a single scrub, unless one is already in progress. It
+// is intended to be run as a scheduler job.
+func (s *Scrubber) Scrub() {
+	s.Run()
+}
+
+// Run runs a single scrub, and returns its report. Each chunk of
+// ScrubChunkVars vars is verified in its own read-only txn, so that
+// the scrub doesn't hold up the reclamation of old pages.
+func (s *Scrubber) Run() (*ScrubReport, error) {
+	s.lock.Lock()
+	if s.running {
+		s.lock.Unlock()
+		return nil, ScrubInProgress
+	}
+	s.running = true
+	s.lock.Unlock()
+
+	start := time.Now()
+	report := ScrubReport{LastRun: start, Corrupt: []ScrubCorruption{}}
+	var from []byte
+	var err error
+	for more := true; more && err == nil; {
+		more, from, err = s.scrubChunk(from, &report)
+	}
+	report.Duration = time.Now().Sub(start)
+	if err != nil {
+		report.LastError = err.Error()
+		log.Printf("Error: Scrub failed: %v", err)
+	} else if report.CorruptCount != 0 {
+		log.Printf("Error: Scrub found %v corrupt vars: %v", report.CorruptCount, report.Corrupt)
+	}
+	s.lock.Lock()
+	report.Runs = s.report.Runs + 1
+	s.report = report
+	s.running = false
+	s.lock.Unlock()
+	return &report, nil
+}
+
+func (s *Scrubber) scrubChunk(from []byte, report *ScrubReport) (more bool, next []byte, err error) {
+	result, err := s.db.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
+		count := 0
+		visit := func(vUUIdBytes, varBytes []byte) bool {
+			if count == server.ScrubChunkVars {
+				more = true
+				next = vUUIdBytes
+				return false
+			}
+			count++
+			db.Stats.Vars.Read(varBytes, nil)
+			report.Checked++
+			record, err := ReadVarRecord(varBytes)
+			if err == nil {
+				if !record.Checksummed {
+					report.Unchecksummed++
+					return true
+				}
+				txnId := common.MakeTxnId(record.Var.WriteTxnId())
+				if txnBytes := s.db.ReadTxnBytesFromDisk(rtxn, txnId); txnBytes == nil {
+					err = fmt.Errorf("frame txn %v missing", txnId)
+				} else {
+					err = record.VerifyTxn(txnBytes)
+				}
+			}
+			if err != nil {
+				report.CorruptCount++
+				if len(report.Corrupt) < server.ScrubReportMaxCorrupt {
+					report.Corrupt = append(report.Corrupt, ScrubCorruption{
+						VarUUId: common.MakeVarUUId(vUUIdBytes).String(),
+						Error:   err.Error(),
+					})
+				}
+			}
+			return true
+		}
+		if from == nil {
+			rtxn.Iterate(s.db.Vars, visit)
+		} else {
+			rtxn.IterateFrom(s.db.Vars, from, visit)
+		}
+		return true
+	}).ResultError()
+	if err == nil && result == nil {
+		err = errors.New("Shutting down.")
+	}
+	return
+}
+
+// Report returns the report of the most recent scrub.
+func (s *Scrubber) Report() ScrubReport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	report := s.report
+	report.Corrupt = append([]ScrubCorruption{}, report.Corrupt...)
+	return report
+}
+
+func (s *Scrubber) Status(sc *server.StatusConsumer) {
+	report := s.Report()
+	sc.Emit(fmt.Sprintf("Scrubber: runs: %v; last run: %v (took %v); checked: %v; unchecksummed: %v; corrupt: %v; last error: %v",
+		report.Runs, report.LastRun, report.Duration, report.Checked, report.Unchecksummed, report.CorruptCount, report.LastError))
+	for _, corruption := range report.Corrupt {
+		sc.Emit(fmt.Sprintf("- %v: %v", corruption.VarUUId, corruption.Error))
+	}
+	sc.Join()
+}
@@ -0,0 +1,80 @@
+package txnengine
+
+import (
+	capn "github.com/glycerine/go-capnproto"
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"goshawkdb.io/server/db"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestScrub(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scrub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbs, err := db.Open(db.DefaultStorageEngine, dir, &db.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbs.Shutdown()
+
+	// Four vars, each with its own frame txn. The third var's frame
+	// txn is not the one its checksum was taken of, and the fourth
+	// has no checksums.
+	result, err := dbs.ReadWriteTransaction(true, func(rwtxn db.ReadWriteTxn) interface{} {
+		for idx := 0; idx < 4; idx++ {
+			key := make([]byte, common.KeyLen)
+			key[0] = byte(idx + 1)
+			vUUId, txnId := common.MakeVarUUId(key), common.MakeTxnId(key)
+			seg := capn.NewBuffer(nil)
+			varCap := msgs.NewRootVar(seg)
+			varCap.SetId(vUUId[:])
+			varCap.SetWriteTxnId(txnId[:])
+			varData := server.SegToBytes(seg)
+			txnBytes := []byte{byte(idx), 1, 2, 3}
+			record := SealVarRecord(varData, txnBytes)
+			switch idx {
+			case 2:
+				txnBytes = []byte{byte(idx), 3, 2, 1}
+			case 3:
+				record = varData
+			}
+			if err := dbs.WriteTxnToDisk(rwtxn, txnId, txnBytes); err != nil {
+				rwtxn.Error(err)
+				return nil
+			}
+			if err := rwtxn.Put(dbs.Vars, vUUId[:], record); err != nil {
+				rwtxn.Error(err)
+				return nil
+			}
+		}
+		return true
+	}).ResultError()
+	if err != nil {
+		t.Fatal(err)
+	} else if result == nil {
+		t.Fatal("Unable to write vars")
+	}
+
+	report, err := NewScrubber(dbs).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.LastError != "" {
+		t.Fatalf("Scrub failed: %v", report.LastError)
+	}
+	if report.Checked != 4 || report.Unchecksummed != 1 || report.CorruptCount != 1 {
+		t.Fatalf("Expected 4 checked, 1 unchecksummed and 1 corrupt; got %v, %v and %v",
+			report.Checked, report.Unchecksummed, report.CorruptCount)
+	}
+	key := make([]byte, common.KeyLen)
+	key[0] = 3
+	if len(report.Corrupt) != 1 || report.Corrupt[0].VarUUId != common.MakeVarUUId(key).String() {
+		t.Fatalf("Expected var 3 to be corrupt; got %v", report.Corrupt)
+	}
+}
@@ -41,22 +41,79 @@ func NewVarDispatcher(count uint8, rmId common.RMId, cm TopologyPublisher, db *d
 	vd := &VarDispatcher{
 		varmanagers: make([]*VarManager, count),
 	}
-	vd.Dispatcher.Init(count)
+	vd.Dispatcher.Init("Var", count)
 	for idx, exe := range vd.Executors {
 		vd.varmanagers[idx] = NewVarManager(exe, rmId, cm, db, lc)
 	}
 	return vd
 }
 
+// A FrameWriteObserver is told of every frame a var writes to disk,
+// once the write is durable. varBytes is the var as written, and
+// txnBytes the frame's txn. It is called from the var's executor, so
+// must not block.
+type FrameWriteObserver interface {
+	FrameWritten(vUUId *common.VarUUId, txnBytes, varBytes []byte)
+}
+
+// SetFrameWriteObserver installs obs on every var manager, replacing
+// any existing observer. If obs is nil, the existing observer is
+// removed.
+func (vd *VarDispatcher) SetFrameWriteObserver(obs FrameWriteObserver) {
+	for idx, exe := range vd.Executors {
+		manager := vd.varmanagers[idx]
+		exe.Enqueue(func() { manager.frameWriteObserver = obs })
+	}
+}
+
 func (vd *VarDispatcher) ApplyToVar(fun func(*Var), createIfMissing bool, vUUId *common.VarUUId) {
 	vd.withVarManager(vUUId, func(vm *VarManager) { vm.ApplyToVar(fun, createIfMissing, vUUId) })
 }
 
+// ApplyToVars is equivalent to calling ApplyToVar for every var in
+// vUUIds, but enqueues only one closure per executor rather than one
+// per var. fun is invoked with the index into vUUIds of each var.
+func (vd *VarDispatcher) ApplyToVars(fun func(int, *Var), createIfMissing bool, vUUIds []*common.VarUUId) {
+	if len(vUUIds) == 1 {
+		vd.ApplyToVar(func(v *Var) { fun(0, v) }, createIfMissing, vUUIds[0])
+		return
+	}
+	buckets := make([][]int, vd.ExecutorCount)
+	for idx, vUUId := range vUUIds {
+		exeIdx := uint8(vUUId[server.MostRandomByteIndex]) % vd.ExecutorCount
+		buckets[exeIdx] = append(buckets[exeIdx], idx)
+	}
+	for exeIdx, bucket := range buckets {
+		if len(bucket) == 0 {
+			continue
+		}
+		bucketCopy := bucket
+		manager := vd.varmanagers[exeIdx]
+		vd.Executors[exeIdx].Enqueue(func() {
+			for _, idx := range bucketCopy {
+				vUUId := vUUIds[idx]
+				manager.ApplyToVar(func(v *Var) { fun(idx, v) }, createIfMissing, vUUId)
+			}
+		})
+	}
+}
+
+// ListVars returns a summary of every active var. Vars which are
+// only on disk are not included.
+func (vd *VarDispatcher) ListVars() []VarSummary {
+	summaries := []VarSummary{}
+	for idx, executor := range vd.Executors {
+		manager := vd.varmanagers[idx]
+		executor.EnqueueSync(func() { summaries = append(summaries, manager.ListVars()...) })
+	}
+	return summaries
+}
+
 func (vd *VarDispatcher) Status(sc *server.StatusConsumer) {
 	sc.Emit("Vars")
 	for idx, executor := range vd.Executors {
 		s := sc.Fork()
-		s.Emit(fmt.Sprintf("Var Manager %v", idx))
+		s.Emit(fmt.Sprintf("Var Manager %v (%v)", idx, executor.Metrics()))
 		manager := vd.varmanagers[idx]
 		executor.Enqueue(func() { manager.Status(s) })
 	}
@@ -67,7 +124,7 @@ func (vd *VarDispatcher) withVarManager(vUUId *common.VarUUId, fun func(*VarMana
 	idx := uint8(vUUId[server.MostRandomByteIndex]) % vd.ExecutorCount
 	executor := vd.Executors[idx]
 	manager := vd.varmanagers[idx]
-	return executor.Enqueue(func() { fun(manager) })
+	return executor.EnqueueFor(vUUId, func() { fun(manager) })
 }
 
 type TranslationCallback func(*cmsgs.ClientAction, *msgs.Action, []common.RMId, map[common.RMId]bool) error
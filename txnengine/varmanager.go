@@ -2,8 +2,6 @@ package txnengine
 
 import (
 	"fmt"
-	mdb "github.com/msackman/gomdb"
-	mdbs "github.com/msackman/gomdb/server"
 	tw "github.com/msackman/gotimerwheel"
 	"goshawkdb.io/common"
 	"goshawkdb.io/server"
@@ -15,7 +13,7 @@ import (
 
 type VarManager struct {
 	LocalConnection
-	Topology         *configuration.Topology
+	Topology         configuration.AtomicTopology
 	RMId             common.RMId
 	db               *db.Databases
 	active           map[common.VarUUId]*Var
@@ -24,10 +22,17 @@ type VarManager struct {
 	tw               *tw.TimerWheel
 	beaterTerminator chan struct{}
 	exe              *dispatcher.Executor
+	// foregroundWrites is the number of foreground frame writes in
+	// progress; whilst there are any, background frame writes are
+	// held in backgroundWrites.
+	foregroundWrites     int
+	backgroundWrites     []func()
+	backgroundWritesTick bool
+	frameWriteObserver   FrameWriteObserver
 }
 
 func init() {
-	db.DB.Vars = &mdbs.DBISettings{Flags: mdb.CREATE}
+	db.DB.Vars = db.DeclareTable("Vars")
 }
 
 func NewVarManager(exe *dispatcher.Executor, rmId common.RMId, tp TopologyPublisher, db *db.Databases, lc LocalConnection) *VarManager {
@@ -41,8 +46,9 @@ func NewVarManager(exe *dispatcher.Executor, rmId common.RMId, tp TopologyPublis
 		exe:             exe,
 	}
 	exe.Enqueue(func() {
-		vm.Topology = tp.AddTopologySubscriber(VarSubscriber, vm)
-		vm.RollAllowed = vm.Topology == nil || !vm.Topology.NextBarrierReached1(rmId)
+		topology := tp.AddTopologySubscriber(VarSubscriber, vm)
+		vm.Topology.Store(topology)
+		vm.RollAllowed = topology == nil || !topology.NextBarrierReached1(rmId)
 	})
 	return vm
 }
@@ -54,12 +60,12 @@ func (vm *VarManager) TopologyChanged(topology *configuration.Topology, done fun
 			vm.onDisk = nil
 			od(false)
 		}
-		vm.Topology = topology
+		vm.Topology.Store(topology)
 		oldRollAllowed := vm.RollAllowed
 		if !vm.RollAllowed {
 			vm.RollAllowed = topology == nil || !topology.NextBarrierReached1(vm.RMId)
 		}
-		server.Log("VarManager", fmt.Sprintf("%p", vm), "rollAllowed:", oldRollAllowed, "->", vm.RollAllowed, fmt.Sprintf("%p", topology))
+		debugLog.Log("VarManager", fmt.Sprintf("%p", vm), "rollAllowed:", oldRollAllowed, "->", vm.RollAllowed, fmt.Sprintf("%p", topology))
 
 		goingToDisk := topology != nil && topology.NextBarrierReached1(vm.RMId) && !topology.NextBarrierReached2(vm.RMId)
 
@@ -69,9 +75,10 @@ func (vm *VarManager) TopologyChanged(topology *configuration.Topology, done fun
 		}
 		if goingToDisk {
 			vm.onDisk = doneWrapped
+			vm.releaseBackgroundWrites()
 			vm.checkAllDisk()
 		} else {
-			server.Log("VarManager", fmt.Sprintf("%p", vm), "calling done", fmt.Sprintf("%p", topology))
+			debugLog.Log("VarManager", fmt.Sprintf("%p", vm), "calling done", fmt.Sprintf("%p", topology))
 			doneWrapped(true)
 		}
 	})
@@ -96,11 +103,14 @@ func (vm *VarManager) ApplyToVar(fun func(*Var), createIfMissing bool, uuid *com
 	v, shutdown := vm.find(uuid)
 	if shutdown {
 		return
+	} else if v != nil && v.loading != nil {
+		v.loading = append(v.loading, fun)
+		return
 	}
 	if v == nil && createIfMissing {
 		v = NewVar(uuid, vm.exe, vm.db, vm)
 		vm.active[*v.UUId] = v
-		server.Log(uuid, "New var")
+		debugLog.Log(uuid, "New var")
 	}
 	fun(v)
 	if _, found := vm.active[*uuid]; v != nil && !found && !v.isIdle() {
@@ -115,21 +125,21 @@ func (vm *VarManager) checkAllDisk() {
 		for _, v := range vm.active {
 			if v.UUId.Compare(configuration.TopologyVarUUId) != common.EQ && !v.isOnDisk(true) {
 				if !vm.RollAllowed {
-					server.Log("VarManager", fmt.Sprintf("%p", vm), "WTF?! rolls are banned, but have var", v.UUId, "not on disk!")
+					debugLog.Log("VarManager", fmt.Sprintf("%p", vm), "WTF?! rolls are banned, but have var", v.UUId, "not on disk!")
 				}
 				return
 			}
 		}
 		vm.onDisk = nil
 		vm.RollAllowed = false
-		server.Log("VarManager", fmt.Sprintf("%p", vm), "Rolls banned; calling done", fmt.Sprintf("%p", od))
+		debugLog.Log("VarManager", fmt.Sprintf("%p", vm), "Rolls banned; calling done", fmt.Sprintf("%p", od))
 		od(true)
 	}
 }
 
 // var.VarLifecycle interface
 func (vm *VarManager) SetInactive(v *Var) {
-	server.Log(v.UUId, "is now inactive")
+	debugLog.Log(v.UUId, "is now inactive")
 	v1, found := vm.active[*v.UUId]
 	switch {
 	case !found:
@@ -147,10 +157,12 @@ func (vm *VarManager) find(uuid *common.VarUUId) (*Var, bool) {
 		return v, false
 	}
 
-	result, err := vm.db.ReadonlyTransaction(func(rtxn *mdbs.RTxn) interface{} {
+	result, err := vm.db.ReadonlyTransaction(func(rtxn db.ReadTxn) interface{} {
 		// rtxn.Get returns a copy of the data, so we don't need to
 		// worry about pointers into the db
-		if bites, err := rtxn.Get(vm.db.Vars, uuid[:]); err == nil {
+		bites, err := rtxn.Get(vm.db.Vars, uuid[:])
+		db.Stats.Vars.Read(bites, err)
+		if err == nil {
 			return bites
 		} else {
 			return true
@@ -162,7 +174,8 @@ func (vm *VarManager) find(uuid *common.VarUUId) (*Var, bool) {
 	} else if result == nil { // shutdown
 		return nil, true
 	} else if bites, ok := result.([]byte); ok {
-		v, err := VarFromData(bites, vm.exe, vm.db, vm)
+		var v *Var
+		v, err := VarFromData(bites, vm.exe, vm.db, vm, func(err error) { vm.frameTxnLoaded(v, err) })
 		if err != nil {
 			panic(fmt.Sprintf("Error when recreating %v: %v", uuid, err))
 		} else if v == nil { // shutdown
@@ -176,15 +189,111 @@ func (vm *VarManager) find(uuid *common.VarUUId) (*Var, bool) {
 	}
 }
 
+// frameTxnLoaded is called once the frame txn of v, recreated from
+// disk, has been read. Everything applied to v whilst it was being
+// read is applied now.
+func (vm *VarManager) frameTxnLoaded(v *Var, err error) {
+	if err != nil {
+		panic(fmt.Sprintf("Error when recreating %v: %v", v.UUId, err))
+	}
+	pending := v.loading
+	v.loading = nil
+	for _, fun := range pending {
+		vm.ApplyToVar(fun, false, v.UUId)
+	}
+}
+
+// VarSummary describes an active var.
+type VarSummary struct {
+	VarUUId     *common.VarUUId
+	FrameTxnId  *common.TxnId
+	Subscribers int
+	Idle        bool
+	OnDisk      bool
+}
+
+func (vm *VarManager) ListVars() []VarSummary {
+	summaries := make([]VarSummary, 0, len(vm.active))
+	for _, v := range vm.active {
+		summaries = append(summaries, VarSummary{
+			VarUUId:     v.UUId,
+			FrameTxnId:  v.curFrame.frameTxnId,
+			Subscribers: len(v.subscribers),
+			Idle:        v.isIdle(),
+			OnDisk:      v.isOnDisk(false),
+		})
+	}
+	return summaries
+}
+
 func (vm *VarManager) Status(sc *server.StatusConsumer) {
 	sc.Emit(fmt.Sprintf("- Active Vars: %v", len(vm.active)))
 	sc.Emit(fmt.Sprintf("- Callbacks: %v", vm.tw.Length()))
 	sc.Emit(fmt.Sprintf("- Beater live? %v", vm.beaterTerminator != nil))
 	sc.Emit(fmt.Sprintf("- Roll allowed? %v", vm.RollAllowed))
+	sc.Emit(fmt.Sprintf("- Frame writes in progress (foreground): %v", vm.foregroundWrites))
+	sc.Emit(fmt.Sprintf("- Frame writes waiting (background): %v", len(vm.backgroundWrites)))
+	contended := 0
+	for _, hv := range vm.HotVars() {
+		if hv.Advice != "" {
+			contended++
+		}
+	}
+	sc.Emit(fmt.Sprintf("- Contended vars: %v", contended))
+	// Only take snapshots here, on the executor: formatting the status
+	// of every active var can take a long time on a big node, and
+	// would stall txn processing.
+	snapshots := make([]*varStatusSnapshot, 0, len(vm.active))
 	for _, v := range vm.active {
-		v.Status(sc.Fork())
+		snapshots = append(snapshots, v.statusSnapshot())
+	}
+	go func() {
+		for _, vss := range snapshots {
+			vss.status(sc.Fork())
+		}
+		sc.Join()
+	}()
+}
+
+// scheduleFrameWrite runs write now, unless it's a background write
+// and there are foreground writes in progress. In that case, it's run
+// once they've all finished, or after VarBackgroundWriteMaxDelay,
+// whichever is sooner. Only the order of writes to different vars can
+// change: a var never has more than one write in progress.
+func (vm *VarManager) scheduleFrameWrite(foreground bool, write func()) {
+	switch {
+	case foreground:
+		vm.foregroundWrites++
+		write()
+	case vm.foregroundWrites == 0 || vm.onDisk != nil:
+		write()
+	default:
+		vm.backgroundWrites = append(vm.backgroundWrites, write)
+		if !vm.backgroundWritesTick {
+			vm.backgroundWritesTick = true
+			vm.ScheduleCallback(server.VarBackgroundWriteMaxDelay, func(*time.Time) {
+				vm.backgroundWritesTick = false
+				vm.releaseBackgroundWrites()
+			})
+		}
+	}
+}
+
+func (vm *VarManager) frameWriteFinished(foreground bool) {
+	if foreground {
+		vm.foregroundWrites--
+		if vm.foregroundWrites == 0 {
+			vm.releaseBackgroundWrites()
+		}
+	}
+}
+
+func (vm *VarManager) releaseBackgroundWrites() {
+	writes := vm.backgroundWrites
+	vm.backgroundWrites = nil
+	for _, write := range writes {
+		write()
 	}
-	sc.Join()
 }
 
 func (vm *VarManager) ScheduleCallback(interval time.Duration, fun tw.Event) {
@@ -0,0 +1,116 @@
+package txnengine
+
+import (
+	"goshawkdb.io/common"
+	"goshawkdb.io/server"
+	msgs "goshawkdb.io/server/capnp"
+	"sync"
+)
+
+type VarWriteUpdate struct {
+	VarUUId    *common.VarUUId
+	Value      []byte
+	References *msgs.VarIdPos_List
+	Clock      *VectorClockMutable
+}
+
+// VarWriteBatcher allows a single destination (for example, a
+// connection) to subscribe to many vars, but to be told about a txn's
+// writes to those vars all at once, rather than once per var. The
+// batch for a txn is delivered once every local write in that txn to
+// a subscribed var has been observed. Vars live on different
+// executors, so deliver is called from whichever executor observed
+// the last write.
+type VarWriteBatcher struct {
+	lock    sync.Mutex
+	vUUIds  map[common.VarUUId]server.EmptyStruct
+	pending map[common.TxnId]*varWriteBatch
+	deliver func(*Txn, []*VarWriteUpdate)
+}
+
+type varWriteBatch struct {
+	txn       *Txn
+	remaining map[common.VarUUId]server.EmptyStruct
+	updates   []*VarWriteUpdate
+}
+
+func NewVarWriteBatcher(deliver func(*Txn, []*VarWriteUpdate)) *VarWriteBatcher {
+	return &VarWriteBatcher{
+		vUUIds:  make(map[common.VarUUId]server.EmptyStruct),
+		pending: make(map[common.TxnId]*varWriteBatch),
+		deliver: deliver,
+	}
+}
+
+// Subscriber registers interest in vUUId and returns the
+// VarWriteSubscriber which should be added to that var.
+func (vwb *VarWriteBatcher) Subscriber(vUUId *common.VarUUId) *VarWriteSubscriber {
+	vwb.lock.Lock()
+	vwb.vUUIds[*vUUId] = server.EmptyStructVal
+	vwb.lock.Unlock()
+	return &VarWriteSubscriber{
+		Observe: vwb.observe,
+		Cancel:  vwb.cancel,
+	}
+}
+
+func (vwb *VarWriteBatcher) observe(v *Var, value []byte, references *msgs.VarIdPos_List, txn *Txn) {
+	vwb.lock.Lock()
+	batch, found := vwb.pending[*txn.Id]
+	if !found {
+		batch = &varWriteBatch{
+			txn:       txn,
+			remaining: make(map[common.VarUUId]server.EmptyStruct),
+		}
+		for idx := range txn.localActions {
+			action := &txn.localActions[idx]
+			if _, found := vwb.vUUIds[*action.vUUId]; found && action.IsWrite() {
+				batch.remaining[*action.vUUId] = server.EmptyStructVal
+			}
+		}
+		vwb.pending[*txn.Id] = batch
+	}
+	delete(batch.remaining, *v.UUId)
+	batch.updates = append(batch.updates, &VarWriteUpdate{
+		VarUUId:    v.UUId,
+		Value:      value,
+		References: references,
+		Clock:      v.curFrame.frameTxnClock.Clone(),
+	})
+	ready := vwb.maybeCompleteBatch(batch)
+	vwb.lock.Unlock()
+
+	if ready {
+		vwb.deliver(batch.txn, batch.updates)
+	}
+}
+
+// If a var goes away, we must not wait for it in any pending
+// batches, otherwise they would never be delivered.
+func (vwb *VarWriteBatcher) cancel(v *Var) {
+	vwb.lock.Lock()
+	delete(vwb.vUUIds, *v.UUId)
+	ready := []*varWriteBatch{}
+	for _, batch := range vwb.pending {
+		if _, found := batch.remaining[*v.UUId]; found {
+			delete(batch.remaining, *v.UUId)
+			if vwb.maybeCompleteBatch(batch) {
+				ready = append(ready, batch)
+			}
+		}
+	}
+	vwb.lock.Unlock()
+
+	for _, batch := range ready {
+		vwb.deliver(batch.txn, batch.updates)
+	}
+}
+
+// lock must be held.
+func (vwb *VarWriteBatcher) maybeCompleteBatch(batch *varWriteBatch) bool {
+	if len(batch.remaining) == 0 {
+		delete(vwb.pending, *batch.txn.Id)
+		return len(batch.updates) != 0
+	}
+	return false
+}
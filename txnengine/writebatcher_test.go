@@ -0,0 +1,65 @@
+package txnengine
+
+import (
+	"goshawkdb.io/common"
+	"testing"
+)
+
+func TestVarWriteBatcher(t *testing.T) {
+	makeKey := func(b byte) []byte {
+		key := make([]byte, common.KeyLen)
+		key[0] = b
+		return key
+	}
+	vUUIdA, vUUIdB, vUUIdC := common.MakeVarUUId(makeKey(1)), common.MakeVarUUId(makeKey(2)), common.MakeVarUUId(makeKey(3))
+	makeVar := func(vUUId *common.VarUUId) *Var {
+		return &Var{UUId: vUUId, curFrame: &frame{frameTxnClock: NewVectorClock().AsMutable()}}
+	}
+	varA, varB := makeVar(vUUIdA), makeVar(vUUIdB)
+	makeTxn := func(id byte, writes ...*common.VarUUId) *Txn {
+		txn := &Txn{Id: common.MakeTxnId(makeKey(id))}
+		for _, vUUId := range writes {
+			txn.localActions = append(txn.localActions, localAction{Txn: txn, vUUId: vUUId, writeTxnActions: &TxnActions{}})
+		}
+		return txn
+	}
+
+	delivered := [][]*VarWriteUpdate{}
+	vwb := NewVarWriteBatcher(func(txn *Txn, updates []*VarWriteUpdate) {
+		delivered = append(delivered, updates)
+	})
+	subA, subB := vwb.Subscriber(vUUIdA), vwb.Subscriber(vUUIdB)
+
+	// A txn writing both subscribed vars (and one which isn't) is
+	// delivered once, after both writes have been observed.
+	txn := makeTxn(1, vUUIdA, vUUIdB, vUUIdC)
+	subA.Observe(varA, []byte("a1"), nil, txn)
+	if len(delivered) != 0 {
+		t.Fatalf("Delivered %v updates before all writes observed.", len(delivered[0]))
+	}
+	subB.Observe(varB, []byte("b1"), nil, txn)
+	if len(delivered) != 1 || len(delivered[0]) != 2 {
+		t.Fatalf("Expected one batch of 2 updates; got %v", delivered)
+	} else if a, b := delivered[0][0], delivered[0][1]; a.VarUUId != vUUIdA || string(a.Value) != "a1" || b.VarUUId != vUUIdB || string(b.Value) != "b1" {
+		t.Fatalf("Unexpected updates: %v=%q, %v=%q", a.VarUUId, a.Value, b.VarUUId, b.Value)
+	}
+
+	// A txn writing just one subscribed var is delivered straight
+	// away.
+	subA.Observe(varA, []byte("a2"), nil, makeTxn(2, vUUIdA))
+	if len(delivered) != 2 || len(delivered[1]) != 1 {
+		t.Fatalf("Expected a second batch of 1 update; got %v", delivered)
+	}
+
+	// If a var goes away, a batch waiting for it is delivered without
+	// it, and later txns don't wait for it.
+	subA.Observe(varA, []byte("a3"), nil, makeTxn(3, vUUIdA, vUUIdB))
+	subB.Cancel(varB)
+	if len(delivered) != 3 || len(delivered[2]) != 1 {
+		t.Fatalf("Expected a third batch of 1 update; got %v", delivered)
+	}
+	subA.Observe(varA, []byte("a4"), nil, makeTxn(4, vUUIdA, vUUIdB))
+	if len(delivered) != 4 {
+		t.Fatalf("Expected a fourth batch; got %v", delivered)
+	}
+}
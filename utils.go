@@ -5,6 +5,7 @@ import (
 	capn "github.com/glycerine/go-capnproto"
 	"log"
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -26,15 +27,27 @@ type LogFunc func(...interface{})
 
 var Log LogFunc = LogFunc(func(elems ...interface{}) {})
 
+var segBufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// SegToBytes serializes seg. The segment is written into a pooled
+// buffer, and then copied out, so the result is exactly sized and
+// the buffer's growth is paid for only once. Buffers which have grown
+// beyond SegBufferPoolMaxCap are left to the GC.
 func SegToBytes(seg *capn.Segment) []byte {
 	if seg == nil {
 		log.Fatal("SegToBytes called with nil segment!")
 	}
-	buf := new(bytes.Buffer)
+	buf := segBufferPool.Get().(*bytes.Buffer)
 	if _, err := seg.WriteTo(buf); err != nil {
 		log.Fatal("Error when writing segment to bytes:", err)
 	}
-	return buf.Bytes()
+	bites := make([]byte, buf.Len())
+	copy(bites, buf.Bytes())
+	if buf.Cap() <= SegBufferPoolMaxCap {
+		buf.Reset()
+		segBufferPool.Put(buf)
+	}
+	return bites
 }
 
 type EmptyStruct struct{}